@@ -0,0 +1,75 @@
+package ddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/danielfsousa/ddb/internal/config"
+)
+
+// Backend is the storage engine records are stored with.
+type Backend = config.Backend
+
+const (
+	// BackendBitcask appends records to segments and keeps every key in an
+	// index in memory, so reads take a single disk access. This is the default.
+	BackendBitcask = config.BackendBitcask
+	// BackendLSM keeps records sorted in a log-structured merge-tree of
+	// tables compacted in the background, which needs little memory for the
+	// keys and scans them in order. It does not support encryption or
+	// backups, and ignores WithKeyHashing and WithOrderedKeys.
+	BackendLSM = config.BackendLSM
+	// BackendMemory keeps records in memory, for tests and ephemeral caches.
+	// If the database is opened with a directory, its records are saved to a
+	// snapshot there when it is closed, and loaded back when it is opened;
+	// records written since are lost on crash. It does not support
+	// encryption or backups.
+	BackendMemory = config.BackendMemory
+)
+
+// manifestFile is the file the persistent backends list their files in,
+// along with the backend that wrote it.
+const manifestFile = "MANIFEST"
+
+// ParseBackend parses a backend from one of: bitcask, lsm or memory.
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(s); b {
+	case BackendBitcask, BackendLSM, BackendMemory:
+		return b, nil
+	}
+	return "", fmt.Errorf("invalid backend %q: must be bitcask, lsm or memory", s)
+}
+
+// detectBackend returns the backend the database in dir was created with, or
+// an empty backend if there is no database there yet. Directories of bitcask
+// logs written before the manifest are recognized by their store files.
+func detectBackend(dir string) (Backend, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err == nil {
+		var m struct {
+			Engine string `json:"engine"`
+		}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return "", fmt.Errorf("%s: %w", manifestFile, err)
+		}
+		return Backend(m.Engine), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, memorySnapshotFile)); err == nil {
+		return BackendMemory, nil
+	}
+	stores, err := filepath.Glob(filepath.Join(dir, "*.store"))
+	if err != nil {
+		return "", err
+	}
+	if len(stores) > 0 {
+		return BackendBitcask, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,157 @@
+package ddb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackend(t *testing.T) {
+	for s, want := range map[string]Backend{"bitcask": BackendBitcask, "lsm": BackendLSM, "memory": BackendMemory} {
+		got, err := ParseBackend(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := ParseBackend("btree")
+	require.Error(t, err)
+	_, err = Open(t.TempDir(), WithBackend("btree"))
+	require.Error(t, err)
+}
+
+func TestWrongBackend(t *testing.T) {
+	backends := []Backend{BackendBitcask, BackendLSM, BackendMemory}
+	for _, created := range backends {
+		t.Run(string(created), func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(dir, WithBackend(created))
+			require.NoError(t, err)
+			require.NoError(t, db.Set(context.Background(), "key", []byte("value")))
+			require.NoError(t, db.Close())
+
+			for _, other := range backends {
+				if other == created {
+					continue
+				}
+				_, err := Open(dir, WithBackend(other))
+				require.ErrorIs(t, err, ErrWrongBackend, other)
+			}
+			db, err = Open(dir, WithBackend(created))
+			require.NoError(t, err)
+			got, err := db.Get(context.Background(), "key")
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), got)
+			require.NoError(t, db.Close())
+		})
+	}
+}
+
+func TestLSMBackend(t *testing.T) {
+	tests := map[string]func(t *testing.T, db *Ddb){
+		"write, read and delete records": testLSMReadWriteDelete,
+		"stores large values in chunks":  testLSMChunks,
+		"merges overwrites and deletes":  testLSMMerge,
+		"refuses encryption":             testLSMEncryption,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			db, err := Open(t.TempDir(), WithBackend(BackendLSM), WithChunkSize(100))
+			require.NoError(t, err)
+			fn(t, db)
+		})
+	}
+}
+
+func testLSMReadWriteDelete(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set(ctx, fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, db.Delete(ctx, "key-07"))
+	require.False(t, db.Has("key-07"))
+	_, err := db.Get(ctx, "key-07")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, db.Close())
+	db, err = Open(db.dir, WithBackend(BackendLSM))
+	require.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.Get(ctx, "key-42")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-42"), got)
+	require.False(t, db.Has("key-07"))
+	keys, err := db.Scan(ctx, ScanOptions{Prefix: "key-", Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"key-00", "key-01", "key-02"}, keys)
+}
+
+func testLSMChunks(t *testing.T, db *Ddb) {
+	defer db.Close()
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("0123456789"), 100)
+	require.NoError(t, db.Set(ctx, "large", value))
+	got, err := db.Get(ctx, "large")
+	require.NoError(t, err)
+	require.Equal(t, value, got)
+}
+
+func testLSMMerge(t *testing.T, db *Ddb) {
+	defer db.Close()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set(ctx, "key", []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, db.Set(ctx, "deleted", []byte("value")))
+	require.NoError(t, db.Delete(ctx, "deleted"))
+	require.NoError(t, db.Merge(ctx))
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Keys)
+	require.Zero(t, stats.Tombstones)
+	require.False(t, stats.LastMerge.IsZero())
+	got, err := db.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-9"), got)
+}
+
+func testLSMEncryption(t *testing.T, db *Ddb) {
+	defer db.Close()
+	keys := &KeyFile{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	_, err := Open(t.TempDir(), WithBackend(BackendLSM), WithEncryption(keys))
+	require.Error(t, err)
+}
+
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open("", WithBackend(BackendMemory))
+	require.NoError(t, err)
+	require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+	got, err := db.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), got)
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Keys)
+	require.Zero(t, stats.Size)
+	require.NoError(t, db.Close())
+
+	dir := t.TempDir()
+	db, err = Open(dir, WithBackend(BackendMemory))
+	require.NoError(t, err)
+	require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+	require.NoError(t, db.Delete(ctx, "foo"))
+	require.NoError(t, db.Set(ctx, "baz", []byte("qux")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, WithBackend(BackendMemory))
+	require.NoError(t, err)
+	defer db.Close()
+	require.False(t, db.Has("foo"))
+	got, err = db.Get(ctx, "baz")
+	require.NoError(t, err)
+	require.Equal(t, []byte("qux"), got)
+}
@@ -0,0 +1,169 @@
+package ddb
+
+import (
+	"bytes"
+	"hash/maphash"
+	"math"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "cache",
+		Name:      "hits_total",
+		Help:      "Number of reads served by the value cache.",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "cache",
+		Name:      "misses_total",
+		Help:      "Number of reads that were not in the value cache.",
+	})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Number of values evicted from the value cache to make room for others.",
+	})
+	cacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ddb",
+		Subsystem: "cache",
+		Name:      "bytes",
+		Help:      "Approximate memory taken by the values in the value cache.",
+	})
+)
+
+const (
+	// cacheStripes is the number of generations tracking writes, each
+	// shared by the keys hashing to it.
+	cacheStripes = 64
+	// cacheEntryOverhead approximates the memory taken by a cached record
+	// besides its key and value.
+	cacheEntryOverhead = 64
+)
+
+// valueCache is an LRU cache of the records of recently read keys, bounded by
+// their size. Writes invalidate the record of their key. Reads only fill the
+// cache if no key of the same stripe was written since the read started, so a
+// read that raced with a write does not cache the previous version of a value.
+//
+// A nil *valueCache caches nothing.
+type valueCache struct {
+	mu      sync.Mutex
+	lru     *simplelru.LRU
+	size    uint64
+	maxSize uint64
+	seed    maphash.Seed
+	gens    [cacheStripes]uint64
+}
+
+// newValueCache returns a cache holding up to maxSize bytes, or nil if maxSize is zero.
+func newValueCache(maxSize uint64) *valueCache {
+	if maxSize == 0 {
+		return nil
+	}
+	c := &valueCache{maxSize: maxSize, seed: maphash.MakeSeed()}
+	// entries are bounded by their size rather than their number
+	c.lru, _ = simplelru.NewLRU(math.MaxInt, c.evicted)
+	return c
+}
+
+func (c *valueCache) stripe(key string) int {
+	return int(maphash.String(c.seed, key) % cacheStripes)
+}
+
+// get returns a copy of the cached record of key. On a miss, it returns the
+// generation to pass to add once the record was read from the backend.
+func (c *valueCache) get(key string) (rec *ddbv1.Record, gen uint64, ok bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.lru.Get(key)
+	if !ok {
+		cacheMisses.Inc()
+		return nil, c.gens[c.stripe(key)], false
+	}
+	cacheHits.Inc()
+	return cloneRecord(v.(*ddbv1.Record)), 0, true
+}
+
+// add caches a copy of a record read from the backend, unless a key of its
+// stripe was written since the generation returned by get. Records larger
+// than the cache are not cached.
+func (c *valueCache) add(rec *ddbv1.Record, gen uint64) {
+	if c == nil {
+		return
+	}
+	size := entrySize(rec)
+	if size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[c.stripe(rec.Key)] != gen {
+		return
+	}
+	c.lru.Remove(rec.Key)
+	c.lru.Add(rec.Key, cloneRecord(rec))
+	c.size += size
+	cacheBytes.Add(float64(size))
+	for c.size > c.maxSize {
+		c.lru.RemoveOldest()
+		cacheEvictions.Inc()
+	}
+}
+
+// invalidate removes the record of a key that was written.
+func (c *valueCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[c.stripe(key)]++
+	c.lru.Remove(key)
+}
+
+// purge removes every record, after writes that bypass invalidate.
+func (c *valueCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.gens {
+		c.gens[i]++
+	}
+	c.lru.Purge()
+}
+
+// evicted accounts the size of a record leaving the LRU. The cache lock is held.
+func (c *valueCache) evicted(_, value interface{}) {
+	size := entrySize(value.(*ddbv1.Record))
+	c.size -= size
+	cacheBytes.Sub(float64(size))
+}
+
+func entrySize(rec *ddbv1.Record) uint64 {
+	return uint64(len(rec.Key)+len(rec.Value)) + cacheEntryOverhead
+}
+
+// cloneRecord copies a record of a live key, so cached values are not
+// modified by the callers they are returned to.
+func cloneRecord(rec *ddbv1.Record) *ddbv1.Record {
+	return &ddbv1.Record{
+		Timestamp: rec.Timestamp,
+		Key:       rec.Key,
+		Value:     bytes.Clone(rec.Value),
+		Chunked:   rec.Chunked,
+	}
+}
@@ -0,0 +1,125 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+func TestCache(t *testing.T) {
+	tests := map[string]func(t *testing.T, db *Ddb){
+		"serves repeated reads from memory":       testCacheHits,
+		"invalidates written and deleted keys":    testCacheInvalidation,
+		"is purged by merges and imports":         testCachePurge,
+		"evicts the least recently read values":   testCacheEviction,
+		"does not cache reads racing with writes": testCacheRace,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			db, err := Open(t.TempDir(), WithCacheSize(1024), WithChunkSize(100))
+			require.NoError(t, err)
+			defer db.Close()
+			fn(t, db)
+		})
+	}
+}
+
+func testCacheHits(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+	hits, misses := testutil.ToFloat64(cacheHits), testutil.ToFloat64(cacheMisses)
+
+	for i := 0; i < 3; i++ {
+		got, err := db.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, "bar", string(got))
+		// callers cannot modify the cached value
+		got[0] = 'x'
+	}
+	require.Equal(t, hits+2, testutil.ToFloat64(cacheHits))
+	require.Equal(t, misses+1, testutil.ToFloat64(cacheMisses))
+
+	// only the record describing the chunks of large values is cached
+	require.NoError(t, db.Set(ctx, "large", blob(500, 1)))
+	for i := 0; i < 2; i++ {
+		got, err := db.Get(ctx, "large")
+		require.NoError(t, err)
+		require.Equal(t, blob(500, 1), got)
+	}
+	require.Less(t, db.cache.size, uint64(200))
+}
+
+func testCacheInvalidation(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "foo", []byte("v1")))
+	_, err := db.Get(ctx, "foo")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set(ctx, "foo", []byte("v2")))
+	got, err := db.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(got))
+
+	require.NoError(t, db.Delete(ctx, "foo"))
+	_, err = db.Get(ctx, "foo")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func testCachePurge(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "foo", []byte("v1")))
+	_, err := db.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.NoError(t, db.Merge(ctx))
+	require.Zero(t, db.cache.lru.Len())
+
+	_, err = db.Get(ctx, "foo")
+	require.NoError(t, err)
+	src, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, src.Set(ctx, "foo", []byte("v2")))
+	transfer(t, src, db, ExportOptions{Format: FormatJSONLines}, ImportOptions{Format: FormatJSONLines})
+	got, err := db.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(got))
+}
+
+func testCacheEviction(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	evictions := testutil.ToFloat64(cacheEvictions)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		require.NoError(t, db.Set(ctx, key, blob(90, byte(i))))
+		_, err := db.Get(ctx, key)
+		require.NoError(t, err)
+		require.LessOrEqual(t, db.cache.size, db.cache.maxSize)
+	}
+	require.Greater(t, testutil.ToFloat64(cacheEvictions), evictions)
+
+	_, _, cached := db.cache.get("key-19")
+	require.True(t, cached)
+	_, _, cached = db.cache.get("key-0")
+	require.False(t, cached)
+}
+
+func testCacheRace(t *testing.T, db *Ddb) {
+	rec := &ddbv1.Record{Key: "foo", Value: []byte("v1")}
+	_, gen, cached := db.cache.get(rec.Key)
+	require.False(t, cached)
+	// the key is written after the previous version was read from the backend
+	db.cache.invalidate(rec.Key)
+	db.cache.add(rec, gen)
+	_, _, cached = db.cache.get(rec.Key)
+	require.False(t, cached)
+
+	_, gen, _ = db.cache.get(rec.Key)
+	db.cache.add(rec, gen)
+	_, _, cached = db.cache.get(rec.Key)
+	require.True(t, cached)
+}
@@ -0,0 +1,297 @@
+package ddb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/config"
+)
+
+// Values larger than the chunk size are split into chunk records stored under
+// reserved keys, and the record of their key only describes the chunks. Each
+// version of a value has its own chunk id, so overwriting a value does not
+// touch the chunks of the previous version, which are deleted once the new
+// version is written.
+
+var (
+	// ErrKeyReserved is the error returned when a key is in the namespace of internal records.
+	ErrKeyReserved = errors.New("keys starting with a null byte are reserved")
+
+	// ErrValueChunked is the error returned when a record to store describes
+	// chunks, which are only created by splitting large values.
+	ErrValueChunked = errors.New("records cannot describe chunks")
+
+	// ErrValueChanged is the error returned when a large value is overwritten
+	// or deleted while it is being read.
+	ErrValueChanged = errors.New("value changed while it was being read")
+)
+
+const (
+	// reservedPrefix starts the keys of internal records, which cannot be set by users.
+	reservedPrefix = "\x00"
+	chunkKeyPrefix = reservedPrefix + "chunk/"
+	chunkIDSize    = 16
+	// chunkIndexSize is the size of the chunk index in a chunk key, including its separators.
+	chunkIndexSize = 10
+)
+
+// chunkKey returns the key of the i-th chunk of the version of the value of
+// key with the given chunk id.
+func chunkKey(id string, i uint32, key string) string {
+	return fmt.Sprintf("%s%s/%08x/%s", chunkKeyPrefix, id, i, key)
+}
+
+// parseChunkKey returns the chunk id and the key of the value of a chunk key.
+func parseChunkKey(k string) (id, key string, ok bool) {
+	rest, ok := strings.CutPrefix(k, chunkKeyPrefix)
+	if !ok || len(rest) < chunkIDSize+chunkIndexSize {
+		return "", "", false
+	}
+	return rest[:chunkIDSize], rest[chunkIDSize+chunkIndexSize:], true
+}
+
+func newChunkID() (string, error) {
+	b := make([]byte, chunkIDSize/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetReader returns a reader of the value of the given key, and its size.
+// Large values are read one chunk at a time, so they do not need to fit in
+// memory. Reading fails with ErrValueChanged if the value is overwritten or
+// deleted meanwhile.
+func (d *Ddb) GetReader(ctx context.Context, key string) (io.Reader, int64, error) {
+	rec, err := d.get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rec.Chunked == nil {
+		return bytes.NewReader(rec.Value), int64(len(rec.Value)), nil
+	}
+	return &valueReader{ctx: ctx, backend: d.backend, key: key, chunked: rec.Chunked}, int64(rec.Chunked.Size), nil
+}
+
+// value returns the value of a record, reassembling it if it is chunked.
+func (d *Ddb) value(ctx context.Context, rec *ddbv1.Record) ([]byte, error) {
+	if rec.Chunked == nil {
+		return rec.Value, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, rec.Chunked.Size))
+	r := &valueReader{ctx: ctx, backend: d.backend, key: rec.Key, chunked: rec.Chunked}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// valueReader reads the chunks of a value in order.
+type valueReader struct {
+	ctx     context.Context
+	backend backend.Backend
+	key     string
+	chunked *ddbv1.ChunkedValue
+	next    uint32
+	chunk   []byte
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.next == r.chunked.Chunks {
+			return 0, io.EOF
+		}
+		rec, exists, err := r.backend.Get(r.ctx, chunkKey(r.chunked.Id, r.next, r.key))
+		if err != nil {
+			return 0, err
+		}
+		if !exists || rec.DeletedAt != nil {
+			return 0, fmt.Errorf("%w: %s", ErrValueChanged, r.key)
+		}
+		r.chunk = rec.Value
+		r.next++
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// SetFromReader sets the value of the given key to the data read from r
+// until EOF, returning its size. Large values are written one chunk at a
+// time, so they do not need to fit in memory. The value is only replaced once
+// it was read entirely. It returns once the write is as durable as configured
+// by WithDurability.
+func (d *Ddb) SetFromReader(ctx context.Context, key string, r io.Reader) (int64, error) {
+	return d.setFromReader(ctx, key, r, d.config.Durability.Mode == config.SyncEveryWrite)
+}
+
+// SetDurableFromReader is like SetFromReader, but waits until the value is
+// synced to disk, regardless of the configured durability.
+func (d *Ddb) SetDurableFromReader(ctx context.Context, key string, r io.Reader) (int64, error) {
+	return d.setFromReader(ctx, key, r, true)
+}
+
+func (d *Ddb) setFromReader(ctx context.Context, key string, r io.Reader, durable bool) (int64, error) {
+	if err := d.validate(&ddbv1.Record{Key: key}); err != nil {
+		return 0, err
+	}
+	// peek past the chunk size to know if the value must be chunked
+	br := bufio.NewReaderSize(r, int(d.config.ChunkSize)+1)
+	head, err := br.Peek(int(d.config.ChunkSize) + 1)
+	if errors.Is(err, io.EOF) {
+		return int64(len(head)), d.set(ctx, key, bytes.Clone(head), durable)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return d.setChunked(ctx, key, br, durable)
+}
+
+// setChunked writes the value read from r as chunk records, then the record
+// of the key describing them, and finally deletes the chunks of the previous
+// version of the value.
+func (d *Ddb) setChunked(ctx context.Context, key string, r io.Reader, durable bool) (int64, error) {
+	id, err := newChunkID()
+	if err != nil {
+		return 0, err
+	}
+	d.pendingChunks.Store(id, true)
+	defer d.pendingChunks.Delete(id)
+
+	rec := &ddbv1.Record{Key: key, Chunked: &ddbv1.ChunkedValue{Id: id}}
+	for err == nil {
+		chunk := make([]byte, d.config.ChunkSize)
+		var n int
+		n, err = io.ReadFull(r, chunk)
+		if n == 0 {
+			break
+		}
+		rec.Chunked.Size += uint64(n)
+		if rec.Chunked.Size > d.config.MaxValueSize {
+			err = ErrValueTooLarge
+			break
+		}
+		werr := d.write(ctx, &ddbv1.Record{
+			Timestamp: time.Now().Unix(),
+			Key:       chunkKey(id, rec.Chunked.Chunks, key),
+			Value:     chunk[:n],
+		}, false)
+		if werr != nil {
+			err = werr
+			break
+		}
+		rec.Chunked.Chunks++
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, errors.Join(err, d.deleteChunks(ctx, key, rec.Chunked))
+	}
+
+	prev, err := d.get(ctx, key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return 0, err
+	}
+	rec.Timestamp = time.Now().Unix()
+	if err := d.write(ctx, rec, durable); err != nil {
+		return 0, err
+	}
+	if prev != nil && prev.Chunked != nil {
+		return int64(rec.Chunked.Size), d.deleteChunks(ctx, key, prev.Chunked)
+	}
+	return int64(rec.Chunked.Size), nil
+}
+
+// deleteChunks deletes the chunks of a version of the value of key.
+func (d *Ddb) deleteChunks(ctx context.Context, key string, chunked *ddbv1.ChunkedValue) error {
+	for i := uint32(0); i < chunked.Chunks; i++ {
+		if err := d.deleteChunk(ctx, chunkKey(chunked.Id, i, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Ddb) deleteChunk(ctx context.Context, chunkKey string) error {
+	t := time.Now().Unix()
+	return d.write(ctx, &ddbv1.Record{Timestamp: t, Key: chunkKey, DeletedAt: &t}, false)
+}
+
+// splitChunks returns the chunk records of a large record followed by the
+// record of its key describing them.
+func (d *Ddb) splitChunks(rec *ddbv1.Record) ([]*ddbv1.Record, error) {
+	id, err := newChunkID()
+	if err != nil {
+		return nil, err
+	}
+	chunked := &ddbv1.ChunkedValue{Id: id, Size: uint64(len(rec.Value))}
+	var recs []*ddbv1.Record
+	for value := rec.Value; len(value) > 0; chunked.Chunks++ {
+		n := len(value)
+		if uint64(n) > d.config.ChunkSize {
+			n = int(d.config.ChunkSize)
+		}
+		recs = append(recs, &ddbv1.Record{
+			Timestamp: rec.Timestamp,
+			Key:       chunkKey(id, chunked.Chunks, rec.Key),
+			Value:     value[:n],
+		})
+		value = value[n:]
+	}
+	return append(recs, &ddbv1.Record{Timestamp: rec.Timestamp, Key: rec.Key, Chunked: chunked}), nil
+}
+
+// dropOrphanChunks deletes the chunks that do not belong to the latest
+// version of the value of their key, which are left behind by chunked writes
+// that were interrupted and by imports overwriting chunked values.
+func (d *Ddb) dropOrphanChunks(ctx context.Context) (dropped int, err error) {
+	current := make(map[string]string)
+	err = d.rangeKeys(chunkKeyPrefix, "", "", false, func(k string) (err error) {
+		id, key, ok := parseChunkKey(k)
+		if !ok {
+			return nil
+		}
+		if meta, exists := d.backend.GetMetadata(k); !exists || meta.DeletedAt != nil {
+			return nil
+		}
+		if _, pending := d.pendingChunks.Load(id); pending {
+			return nil
+		}
+		// the value may have been overwritten since its chunk id was looked
+		// up, and its new chunks must not be mistaken for orphans.
+		if live, seen := current[key]; !seen || live != id {
+			if current[key], err = d.chunkID(ctx, key); err != nil {
+				return err
+			}
+		}
+		if current[key] == id {
+			return nil
+		}
+		if err := d.deleteChunk(ctx, k); err != nil {
+			return err
+		}
+		dropped++
+		return nil
+	})
+	return dropped, err
+}
+
+// chunkID returns the chunk id of the value of key, or an empty string if it is not chunked.
+func (d *Ddb) chunkID(ctx context.Context, key string) (string, error) {
+	rec, err := d.get(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil || rec.Chunked == nil {
+		return "", err
+	}
+	return rec.Chunked.Id, nil
+}
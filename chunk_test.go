@@ -0,0 +1,185 @@
+package ddb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+func TestChunking(t *testing.T) {
+	tests := map[string]func(t *testing.T, db *Ddb){
+		"splits large values into chunks":         testChunkedValues,
+		"streams values from and to readers":      testChunkedStreams,
+		"deletes the chunks of replaced values":   testChunkedOverwrite,
+		"fails reads of values changed meanwhile": testChunkedChanged,
+		"rejects values and keys it cannot store": testChunkedInvalid,
+		"drops chunks of interrupted writes":      testChunkedOrphans,
+		"exports and imports reassembled values":  testChunkedExport,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			db, err := Open(t.TempDir(), WithChunkSize(100), WithMaxValueSize(10_000))
+			require.NoError(t, err)
+			defer db.Close()
+			fn(t, db)
+		})
+	}
+}
+
+// blob returns a value of the given size that differs for every version.
+func blob(size int, version byte) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i) + version
+	}
+	return b
+}
+
+// liveChunks returns the number of chunk records that were not deleted.
+func liveChunks(db *Ddb) int {
+	n := 0
+	for _, key := range db.backend.Keys() {
+		if _, _, ok := parseChunkKey(key); !ok {
+			continue
+		}
+		if meta, _ := db.backend.GetMetadata(key); meta.DeletedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func testChunkedValues(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	value := blob(550, 0)
+	require.NoError(t, db.Set(ctx, "blob", value))
+	require.NoError(t, db.Set(ctx, "small", []byte("hello")))
+	require.Equal(t, 6, liveChunks(db))
+
+	got, err := db.Get(ctx, "blob")
+	require.NoError(t, err)
+	require.Equal(t, value, got)
+	got, err = db.Get(ctx, "small")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+}
+
+func testChunkedStreams(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	for _, size := range []int{0, 99, 100, 101, 1000} {
+		value := blob(size, 1)
+		n, err := db.SetFromReader(ctx, "blob", bytes.NewReader(value))
+		require.NoError(t, err, size)
+		require.Equal(t, int64(size), n)
+
+		r, n, err := db.GetReader(ctx, "blob")
+		require.NoError(t, err, size)
+		require.Equal(t, int64(size), n)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, size)
+		require.Equal(t, value, got, size)
+	}
+	require.Equal(t, 10, liveChunks(db))
+}
+
+func testChunkedOverwrite(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "blob", blob(1000, 1)))
+	require.Equal(t, 10, liveChunks(db))
+	require.NoError(t, db.Set(ctx, "blob", blob(300, 2)))
+	require.Equal(t, 3, liveChunks(db))
+	got, err := db.Get(ctx, "blob")
+	require.NoError(t, err)
+	require.Equal(t, blob(300, 2), got)
+
+	require.NoError(t, db.Set(ctx, "blob", []byte("small")))
+	require.Equal(t, 3, liveChunks(db))
+	require.NoError(t, db.Merge(ctx))
+	require.Zero(t, liveChunks(db))
+
+	require.NoError(t, db.Set(ctx, "blob", blob(300, 3)))
+	require.NoError(t, db.Delete(ctx, "blob"))
+	require.Zero(t, liveChunks(db))
+	require.False(t, db.Has("blob"))
+}
+
+func testChunkedChanged(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "blob", blob(1000, 1)))
+	r, _, err := db.GetReader(ctx, "blob")
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, make([]byte, 150))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set(ctx, "blob", blob(1000, 2)))
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrValueChanged)
+}
+
+func testChunkedInvalid(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "blob", blob(1000, 1)))
+	_, err := db.SetFromReader(ctx, "blob", bytes.NewReader(blob(10_001, 2)))
+	require.ErrorIs(t, err, ErrValueTooLarge)
+	require.Equal(t, 10, liveChunks(db))
+	got, err := db.Get(ctx, "blob")
+	require.NoError(t, err)
+	require.Equal(t, blob(1000, 1), got)
+
+	require.ErrorIs(t, db.Set(ctx, "blob", blob(10_001, 2)), ErrValueTooLarge)
+	require.ErrorIs(t, db.Set(ctx, "\x00chunk/key", []byte("v")), ErrKeyReserved)
+	_, err = db.SetFromReader(ctx, "\x00key", strings.NewReader("v"))
+	require.ErrorIs(t, err, ErrKeyReserved)
+}
+
+func testChunkedOrphans(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "blob", blob(300, 1)))
+	// chunks written before a crash, without the record of their key
+	for i := uint32(0); i < 2; i++ {
+		require.NoError(t, db.backend.Set(ctx, &ddbv1.Record{
+			Key:   chunkKey("0123456789abcdef", i, "blob"),
+			Value: blob(100, 2),
+		}))
+	}
+	require.Equal(t, 5, liveChunks(db))
+
+	dropped, err := db.dropOrphanChunks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, dropped)
+	require.Equal(t, 3, liveChunks(db))
+	got, err := db.Get(ctx, "blob")
+	require.NoError(t, err)
+	require.Equal(t, blob(300, 1), got)
+}
+
+func testChunkedExport(t *testing.T, src *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, src.Set(ctx, "blob", blob(1000, 1)))
+	require.NoError(t, src.Set(ctx, "small", []byte("hello")))
+	dst, err := Open(t.TempDir(), WithChunkSize(100), WithMaxValueSize(10_000))
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.Set(ctx, "blob", blob(500, 2)))
+
+	exported, imported := transfer(t, src, dst,
+		ExportOptions{Format: FormatJSONLines},
+		ImportOptions{Format: FormatJSONLines},
+	)
+	require.Equal(t, 2, exported)
+	require.Equal(t, 2, imported)
+	got, err := dst.Get(ctx, "blob")
+	require.NoError(t, err)
+	require.Equal(t, blob(1000, 1), got)
+
+	// the chunks of the overwritten value are dropped by the next merge
+	require.Equal(t, 15, liveChunks(dst))
+	require.NoError(t, dst.Merge(ctx))
+	require.Equal(t, 10, liveChunks(dst))
+}
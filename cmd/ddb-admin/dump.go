@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+)
+
+func newDumpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Prints the records of every segment as JSON, one per line",
+		Args:  cobra.NoArgs,
+		RunE:  runDump,
+	}
+	cmd.Flags().Uint64("segment", 0, "Only dump the segment with this id.")
+	cmd.Flags().Bool("values", false, "Include the values of the records, base64 encoded.")
+	return cmd
+}
+
+// dumpRecord is the JSON representation of a record printed by dump.
+type dumpRecord struct {
+	Segment   uint64     `json:"segment"`
+	Offset    uint64     `json:"offset"`
+	Size      uint64     `json:"size"`
+	Key       string     `json:"key"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Tombstone bool       `json:"tombstone"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Value     []byte     `json:"value,omitempty"`
+}
+
+func runDump(cmd *cobra.Command, _ []string) error {
+	dir := dataDir(cmd)
+	c, err := config(cmd)
+	if err != nil {
+		return err
+	}
+	segment, _ := cmd.Flags().GetUint64("segment")
+	values, _ := cmd.Flags().GetBool("values")
+
+	ids, err := bitcask.SegmentIDs(dir)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	for _, id := range ids {
+		if segment != 0 && id != segment {
+			continue
+		}
+		err := bitcask.ScanSegment(dir, id, c, func(e bitcask.Entry) error {
+			rec := dumpRecord{
+				Segment:   e.Segment,
+				Offset:    e.Offset,
+				Size:      e.Size,
+				Key:       e.Record.Key,
+				Tombstone: e.Record.DeletedAt != nil,
+			}
+			if e.Record.Timestamp != 0 {
+				rec.Timestamp = unixTime(e.Record.Timestamp)
+			}
+			if e.Record.DeletedAt != nil {
+				rec.DeletedAt = unixTime(*e.Record.DeletedAt)
+			}
+			if values {
+				rec.Value = e.Record.Value
+			}
+			return enc.Encode(rec)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unixTime(sec int64) *time.Time {
+	t := time.Unix(sec, 0).UTC()
+	return &t
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+	"github.com/danielfsousa/ddb/internal/encryption"
+)
+
+var logger *zerolog.Logger
+
+func main() {
+	logger = setupGlobalLogger()
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to get user home directory")
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ddb-admin",
+		Short: "Offline tooling for ddb data directories",
+		Long: "Offline tooling for ddb data directories.\n\n" +
+			"Commands operate directly on the files of a data directory, which must not be in use by a ddb-server.",
+		Version:      "0.1.0",
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringP("data-dir", "d", path.Join(homeDir, ".ddb", "data"), "Directory where the database stores its data.")
+	cmd.PersistentFlags().String("key-file", "", "Key file to decrypt an encrypted data directory, and to encrypt the segments it writes.")
+	cmd.AddCommand(
+		newVerifyCmd(),
+		newDumpCmd(),
+		newRepairCmd(),
+		newRebuildHintsCmd(),
+		newMergeCmd(),
+		newRestoreCmd(),
+	)
+
+	if err := cmd.Execute(); err != nil {
+		logger.Fatal().Err(err).Msg("failed to execute command")
+	}
+}
+
+func setupGlobalLogger() *zerolog.Logger {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	logger := log.With().Str("component", "main").Logger()
+	return &logger
+}
+
+func dataDir(cmd *cobra.Command) string {
+	dir, _ := cmd.Flags().GetString("data-dir")
+	return dir
+}
+
+// config returns the configuration of the log in the data directory, with
+// the keys of the key file, if any.
+func config(cmd *cobra.Command) (bitcask.Config, error) {
+	c := bitcask.Config{}
+	name, _ := cmd.Flags().GetString("key-file")
+	if name == "" {
+		return c, nil
+	}
+	keys, err := encryption.LoadKeyFile(name)
+	if err != nil {
+		return c, err
+	}
+	c.Keyring = encryption.NewKeyring(keys)
+	return c, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+)
+
+func newMergeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "merge",
+		Short: "Rewrites the immutable segments without overwritten records and tombstones",
+		Args:  cobra.NoArgs,
+		RunE:  runMerge,
+	}
+}
+
+func runMerge(cmd *cobra.Command, _ []string) error {
+	c, err := config(cmd)
+	if err != nil {
+		return err
+	}
+	b, err := bitcask.NewBitcaskBackend(dataDir(cmd), c)
+	if err != nil {
+		return err
+	}
+	before := size(b.Stats())
+	if err := b.Merge(cmd.Context()); err != nil {
+		_ = b.Close()
+		return err
+	}
+	after := size(b.Stats())
+	if err := b.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "reclaimed %d bytes (%d -> %d)\n", before-after, before, after)
+	return err
+}
+
+func size(stats backend.Stats) (size uint64) {
+	for _, s := range stats.Segments {
+		size += s.Size
+	}
+	return size
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+)
+
+func newRepairCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair",
+		Short: "Truncates corrupted segments at their first unreadable record",
+		Long: "Truncates corrupted segments at their first unreadable record.\n\n" +
+			"The discarded bytes of each segment are kept in a .corrupt file next to its store.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := config(cmd)
+			if err != nil {
+				return err
+			}
+			c.Repair = true
+			b, err := bitcask.NewBitcaskBackend(dataDir(cmd), c)
+			if err != nil {
+				return err
+			}
+			return b.Close()
+		},
+	}
+}
+
+func newRebuildHintsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rebuild-hints",
+		Short: "Rewrites the hint and Bloom filter files of every segment from its store",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := config(cmd)
+			if err != nil {
+				return err
+			}
+			return bitcask.RebuildHints(dataDir(cmd), c)
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+)
+
+func newRestoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore ARCHIVE...",
+		Short: "Restores backup archives to the data directory",
+		Long: "Restores backup archives, written by ddb backup, to the data directory.\n\n" +
+			"Archives are restored in order, so a full backup can be followed by the incremental backups taken after it.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: runRestore,
+	}
+}
+
+func runRestore(cmd *cobra.Command, archives []string) error {
+	dir := dataDir(cmd)
+	for _, name := range archives {
+		if err := restore(cmd, dir, name); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func restore(cmd *cobra.Command, dir, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, err := bitcask.Restore(dir, f)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "restored %s taken at %s: %d files\n", name, manifest.CreatedAt.Local().Format(time.RFC3339), len(manifest.Files))
+	return err
+}
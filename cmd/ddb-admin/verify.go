@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+)
+
+func newVerifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Checks the checksum of every record and compares hint files to their stores",
+		Args:  cobra.NoArgs,
+		RunE:  runVerify,
+	}
+}
+
+func runVerify(cmd *cobra.Command, _ []string) error {
+	dir := dataDir(cmd)
+	c, err := config(cmd)
+	if err != nil {
+		return err
+	}
+	ids, err := bitcask.SegmentIDs(dir)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEGMENT\tRECORDS\tSTORE\tHINT")
+	for _, id := range ids {
+		records := 0
+		store, hint := "ok", "-"
+		err := bitcask.ScanSegment(dir, id, c, func(bitcask.Entry) error {
+			records++
+			return nil
+		})
+		var corrupt *bitcask.CorruptionError
+		switch {
+		case errors.As(err, &corrupt):
+			problems++
+			store = fmt.Sprintf("corrupted at offset %d: %v", corrupt.Offset, corrupt.Reason)
+		case err != nil:
+			return err
+		default:
+			exists, err := bitcask.VerifyHint(dir, id, c)
+			switch {
+			case errors.Is(err, bitcask.ErrHintMismatch):
+				problems++
+				hint = err.Error()
+			case err != nil:
+				return err
+			case exists:
+				hint = "ok"
+			}
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", id, records, store, hint)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %d problems, run repair or rebuild-hints to fix them", problems)
+	}
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"path"
 	"syscall"
 
+	"github.com/danielfsousa/ddb"
 	"github.com/danielfsousa/ddb/internal/agent"
+	"github.com/danielfsousa/ddb/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -25,7 +27,7 @@ func main() {
 		Use:     "ddb-server",
 		Short:   "A distributed key-value database",
 		Version: "0.1.0",
-		PreRun:  cli.setup,
+		PreRunE: cli.setup,
 		RunE:    cli.run,
 	}
 
@@ -42,6 +44,21 @@ func main() {
 	cmd.Flags().StringP("bind-addr", "a", def.BindAddr, "Address to bind Serf on.")
 	cmd.Flags().StringP("data-dir", "d", path.Join(homeDir, ".ddb", "data"), "Directory to store database internal data.")
 	cmd.Flags().IntP("rpc-port", "p", def.RPCPort, "Port for RPC clients (and Raft) connections.")
+	cmd.Flags().Duration("shutdown-timeout", def.ShutdownTimeout, "How long in-flight requests are given to finish on shutdown.")
+	cmd.Flags().Uint64("min-free-disk-bytes", def.MinFreeDiskBytes, "Free space the data directory needs for the node to be ready.")
+	cmd.Flags().String("backend", string(def.Backend), "Storage engine of the database: bitcask, lsm or memory.")
+	cmd.Flags().String("durability", "never", "When writes are synced to disk: never, always or an interval such as 100ms.")
+	cmd.Flags().String("compression", "none", "Codec records are compressed with: none, snappy or zstd.")
+	cmd.Flags().StringToString("namespace-compression", nil, "Codec of the keys starting with a prefix, overriding --compression, such as logs:=zstd.")
+	cmd.Flags().String("key-file", "", "Key file to encrypt data at rest with. Data is not encrypted if empty.")
+	cmd.Flags().Uint64("cache-size", 0, "Bytes of recently read values to cache in memory. Disabled if zero.")
+	cmd.Flags().Bool("hash-keys", false, "Keep only a hash of each key in memory, reading keys back from disk to confirm them.")
+	cmd.Flags().Bool("ordered-keys", false, "Keep the keys sorted in memory, so scans do not sort all keys.")
+	cmd.Flags().Bool("repair", false, "Truncate corrupted segments on startup instead of refusing to start.")
+	cmd.Flags().Duration("scrub-interval", def.ScrubInterval, "Time between scrubs verifying the checksum of every record. Disabled if zero.")
+	cmd.Flags().String("trace-exporter", "", "Exporter for tracing spans, one of: otlp, stdout. Tracing is disabled if empty.")
+	cmd.Flags().String("otlp-endpoint", "localhost:4318", "Host and port of the OpenTelemetry collector.")
+	cmd.Flags().Bool("otlp-insecure", false, "Disable TLS when exporting spans to the OpenTelemetry collector.")
 
 	err = viper.BindPFlags(cmd.Flags())
 	if err != nil {
@@ -83,19 +100,55 @@ type ddbServerCli struct {
 	logger *zerolog.Logger
 }
 
-func (cli *ddbServerCli) setup(cmd *cobra.Command, args []string) {
+func (cli *ddbServerCli) setup(cmd *cobra.Command, args []string) error {
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 	logger := log.With().Str("component", "main").Logger()
 	cli.logger = &logger
+	backend, err := ddb.ParseBackend(viper.GetString("backend"))
+	if err != nil {
+		return err
+	}
+	durability, err := ddb.ParseDurability(viper.GetString("durability"))
+	if err != nil {
+		return err
+	}
+	compression, err := ddb.ParseCompression(viper.GetString("compression"))
+	if err != nil {
+		return err
+	}
+	namespaceCompression := make(map[string]ddb.Compression)
+	for prefix, name := range viper.GetStringMapString("namespace-compression") {
+		if namespaceCompression[prefix], err = ddb.ParseCompression(name); err != nil {
+			return err
+		}
+	}
 	cli.config = &agent.Config{
-		DataDir:        viper.GetString("data-dir"),
-		NodeName:       viper.GetString("node-name"),
-		BindAddr:       viper.GetString("bind-addr"),
-		RPCPort:        viper.GetInt("rpc-port"),
-		StartJoinAddrs: viper.GetStringSlice("start-join-addrs"),
-		Bootstrap:      viper.GetBool("bootstrap"),
+		DataDir:              viper.GetString("data-dir"),
+		NodeName:             viper.GetString("node-name"),
+		BindAddr:             viper.GetString("bind-addr"),
+		RPCPort:              viper.GetInt("rpc-port"),
+		StartJoinAddrs:       viper.GetStringSlice("start-join-addrs"),
+		Bootstrap:            viper.GetBool("bootstrap"),
+		ShutdownTimeout:      viper.GetDuration("shutdown-timeout"),
+		MinFreeDiskBytes:     viper.GetUint64("min-free-disk-bytes"),
+		Backend:              backend,
+		Durability:           durability,
+		Repair:               viper.GetBool("repair"),
+		Compression:          compression,
+		NamespaceCompression: namespaceCompression,
+		KeyFile:              viper.GetString("key-file"),
+		CacheSize:            viper.GetUint64("cache-size"),
+		HashKeys:             viper.GetBool("hash-keys"),
+		OrderedKeys:          viper.GetBool("ordered-keys"),
+		ScrubInterval:        viper.GetDuration("scrub-interval"),
+		Tracing: telemetry.TracingConfig{
+			Exporter: viper.GetString("trace-exporter"),
+			Endpoint: viper.GetString("otlp-endpoint"),
+			Insecure: viper.GetBool("otlp-insecure"),
+		},
 	}
+	return nil
 }
 
 func (cli *ddbServerCli) run(cmd *cobra.Command, args []string) error {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb"
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+func newBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Writes a backup archive of the database of a node",
+		Long: "Writes a backup archive of the database of a node.\n\n" +
+			"The archive can be restored to a data directory with ddb-admin restore.",
+		Args: cobra.NoArgs,
+		RunE: runBackup,
+	}
+	cmd.Flags().StringP("output", "o", "", "Path of the archive to write.")
+	cmd.Flags().String("since", "", "Archive of a previous backup to take an incremental backup from.")
+	_ = cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+func runBackup(cmd *cobra.Command, _ []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	since, _ := cmd.Flags().GetString("since")
+
+	req := &ddbv1.BackupRequest{}
+	if since != "" {
+		manifest, err := readBackupManifest(since)
+		if err != nil {
+			return err
+		}
+		if req.SinceManifest, err = json.Marshal(manifest); err != nil {
+			return err
+		}
+	}
+
+	stream, err := newAdminClient(cmd).Backup(cmd.Context(), connect.NewRequest(req))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// write to a temporary file so a failed backup does not leave a partial archive behind
+	f, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	for stream.Receive() {
+		if _, err := f.Write(stream.Msg().GetChunk()); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), output); err != nil {
+		return err
+	}
+
+	manifest, err := readBackupManifest(output)
+	if err != nil {
+		return err
+	}
+	return printBackupManifest(cmd, output, manifest)
+}
+
+func readBackupManifest(name string) (*ddb.BackupManifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ddb.ReadBackupManifest(f)
+}
+
+func printBackupManifest(cmd *cobra.Command, name string, manifest *ddb.BackupManifest) error {
+	included := 0
+	for _, file := range manifest.Files {
+		if file.Included {
+			included++
+		}
+	}
+	kind := "full"
+	if manifest.Incremental {
+		kind = "incremental"
+	}
+	_, err := fmt.Fprintf(cmd.OutOrStdout(), "wrote %s backup to %s: %d of %d files\n", kind, name, included, len(manifest.Files))
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb"
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+// importChunkSize is the size of the chunks imports and values are streamed in.
+const importChunkSize = 64 << 10 // 64KB
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Exports the records of the database of a node",
+		Long: "Exports the records of the database of a node, sorted by key.\n\n" +
+			"Values are base64 encoded in the jsonl and csv formats. " +
+			"The proto format is a stream of ddb.v1.Record messages, each prefixed by its size as a varint.",
+		Args: cobra.NoArgs,
+		RunE: runExport,
+	}
+	cmd.Flags().StringP("output", "o", "-", "Path of the file to write, or - for the standard output.")
+	addFormatFlags(cmd, "export")
+	return cmd
+}
+
+func newImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Imports records into the database of a node",
+		Long: "Imports records, in one of the formats written by export, into the database of a node.\n\n" +
+			"Records are written to new segments in bulk, and none are imported if any of them is invalid. " +
+			"Use - as the file to read from the standard input.",
+		Args: cobra.ExactArgs(1),
+		RunE: runImport,
+	}
+	addFormatFlags(cmd, "import")
+	return cmd
+}
+
+func addFormatFlags(cmd *cobra.Command, verb string) {
+	cmd.Flags().String("format", "jsonl", "Format of the records: jsonl, csv or proto.")
+	cmd.Flags().String("prefix", "", "Only "+verb+" the keys starting with this prefix.")
+	cmd.Flags().Bool("tombstones", false, "Also "+verb+" deleted keys.")
+}
+
+func formatFlag(cmd *cobra.Command) (ddbv1.Format, error) {
+	name, _ := cmd.Flags().GetString("format")
+	format, err := ddb.ParseFormat(name)
+	if err != nil {
+		return 0, err
+	}
+	switch format {
+	case ddb.FormatJSONLines:
+		return ddbv1.Format_FORMAT_JSONL, nil
+	case ddb.FormatCSV:
+		return ddbv1.Format_FORMAT_CSV, nil
+	default:
+		return ddbv1.Format_FORMAT_PROTO, nil
+	}
+}
+
+func runExport(cmd *cobra.Command, _ []string) error {
+	format, err := formatFlag(cmd)
+	if err != nil {
+		return err
+	}
+	output, _ := cmd.Flags().GetString("output")
+	prefix, _ := cmd.Flags().GetString("prefix")
+	tombstones, _ := cmd.Flags().GetBool("tombstones")
+
+	stream, err := newAdminClient(cmd).Export(cmd.Context(), connect.NewRequest(&ddbv1.ExportRequest{
+		Format:     format,
+		Prefix:     prefix,
+		Tombstones: tombstones,
+	}))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	w := cmd.OutOrStdout()
+	var f *os.File
+	if output != "-" {
+		if f, err = os.Create(output); err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	for stream.Receive() {
+		if _, err := bw.Write(stream.Msg().GetChunk()); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if f != nil {
+		return f.Close()
+	}
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	format, err := formatFlag(cmd)
+	if err != nil {
+		return err
+	}
+	prefix, _ := cmd.Flags().GetString("prefix")
+	tombstones, _ := cmd.Flags().GetBool("tombstones")
+
+	var r io.Reader = cmd.InOrStdin()
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stream := newAdminClient(cmd).Import(cmd.Context())
+	req := &ddbv1.ImportRequest{Format: format, Prefix: prefix, Tombstones: tombstones}
+	buf := make([]byte, importChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || req.Format != ddbv1.Format_FORMAT_UNSPECIFIED {
+			req.Chunk = buf[:n]
+			if err := stream.Send(req); err != nil {
+				// the server closed the stream, its error is returned below
+				break
+			}
+			req = &ddbv1.ImportRequest{}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res, err := stream.CloseAndReceive()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "imported %d records\n", res.Msg.GetRecords())
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/danielfsousa/ddb/gen/ddb/v1/ddbv1connect"
+)
+
+var logger *zerolog.Logger
+
+func main() {
+	logger = setupGlobalLogger()
+	cmd := &cobra.Command{
+		Use:          "ddb",
+		Short:        "A client for the ddb distributed key-value database",
+		Version:      "0.1.0",
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringP("addr", "a", "localhost:9191", "Address of the ddb server.")
+	cmd.AddCommand(newGetCmd(), newPutCmd(), newStatsCmd(), newBackupCmd(), newExportCmd(), newImportCmd())
+
+	if err := cmd.Execute(); err != nil {
+		logger.Fatal().Err(err).Msg("failed to execute command")
+	}
+}
+
+func setupGlobalLogger() *zerolog.Logger {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	logger := log.With().Str("component", "main").Logger()
+	return &logger
+}
+
+func serverURL(cmd *cobra.Command) string {
+	addr, _ := cmd.Flags().GetString("addr")
+	return "http://" + addr
+}
+
+func newAdminClient(cmd *cobra.Command) ddbv1connect.AdminServiceClient {
+	return ddbv1connect.NewAdminServiceClient(http.DefaultClient, serverURL(cmd))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+func newStatsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Prints statistics about the database of a node",
+		Args:  cobra.NoArgs,
+		RunE:  runStats,
+	}
+	cmd.Flags().Bool("json", false, "Print the statistics as JSON.")
+	return cmd
+}
+
+func runStats(cmd *cobra.Command, _ []string) error {
+	res, err := newAdminClient(cmd).Stats(cmd.Context(), connect.NewRequest(&ddbv1.StatsRequest{}))
+	if err != nil {
+		return err
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		b, err := protojson.MarshalOptions{Multiline: true, EmitUnpopulated: true}.Marshal(res.Msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		return err
+	}
+	return printStats(cmd.OutOrStdout(), res.Msg)
+}
+
+func printStats(out io.Writer, stats *ddbv1.StatsResponse) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "segments:\t%d\n", stats.Segments)
+	fmt.Fprintf(w, "keys:\t%d\n", stats.Keys)
+	fmt.Fprintf(w, "tombstones:\t%d\n", stats.Tombstones)
+	fmt.Fprintf(w, "size:\t%d\n", stats.Size)
+	fmt.Fprintf(w, "live bytes:\t%d\n", stats.LiveBytes)
+	fmt.Fprintf(w, "dead bytes:\t%d (%.1f%%)\n", stats.DeadBytes, percent(stats.DeadBytes, stats.LiveBytes+stats.DeadBytes))
+	fmt.Fprintf(w, "hinted segments:\t%d/%d\n", stats.HintedSegments, stats.Segments)
+	fmt.Fprintf(w, "open files:\t%d\n", stats.OpenFiles)
+	if stats.CompressionRatio > 0 {
+		fmt.Fprintf(w, "compression ratio:\t%.2fx\n", stats.CompressionRatio)
+	} else {
+		fmt.Fprintln(w, "compression ratio:\tn/a")
+	}
+	if stats.LastMerge != nil {
+		fmt.Fprintf(w, "last merge:\t%s\n", stats.LastMerge.AsTime().Local().Format(time.RFC3339))
+	} else {
+		fmt.Fprintln(w, "last merge:\tnever")
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "SEGMENT\tKEYS\tTOMBSTONES\tSIZE\tLIVE\tDEAD\tHINT")
+	for _, s := range stats.SegmentStats {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%t\n",
+			s.Id, s.Keys, s.Tombstones, s.Size, s.LiveBytes, s.DeadBytes, s.Hinted)
+	}
+	return w.Flush()
+}
+
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100 //nolint:gomnd
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/spf13/cobra"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/gen/ddb/v1/ddbv1connect"
+)
+
+func newGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get KEY",
+		Short: "Prints the value of a key",
+		Long:  "Prints the value of a key. The value is streamed, so it can be larger than the memory of the client.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runGet,
+	}
+	cmd.Flags().StringP("output", "o", "-", "Path of the file to write, or - for the standard output.")
+	return cmd
+}
+
+func newPutCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "put KEY FILE",
+		Short: "Sets the value of a key to the contents of a file",
+		Long: "Sets the value of a key to the contents of a file, which is streamed to the server. " +
+			"Use - as the file to read from the standard input.",
+		Args: cobra.ExactArgs(2),
+		RunE: runPut,
+	}
+	cmd.Flags().Bool("durable", false, "Wait until the value is synced to disk, regardless of the durability of the server.")
+	return cmd
+}
+
+func newClient(cmd *cobra.Command) ddbv1connect.DdbServiceClient {
+	return ddbv1connect.NewDdbServiceClient(http.DefaultClient, serverURL(cmd))
+}
+
+func runGet(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	stream, err := newClient(cmd).GetStream(cmd.Context(), connect.NewRequest(&ddbv1.GetStreamRequest{Key: args[0]}))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	w := cmd.OutOrStdout()
+	var f *os.File
+	if output != "-" {
+		if f, err = os.Create(output); err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	for stream.Receive() {
+		if _, err := w.Write(stream.Msg().GetChunk()); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if f != nil {
+		return f.Close()
+	}
+	return nil
+}
+
+func runPut(cmd *cobra.Command, args []string) error {
+	durable, _ := cmd.Flags().GetBool("durable")
+	var r io.Reader = cmd.InOrStdin()
+	if args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stream := newClient(cmd).PutStream(cmd.Context())
+	req := &ddbv1.PutStreamRequest{Key: args[0], Durable: durable}
+	buf := make([]byte, importChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || req.Key != "" {
+			req.Chunk = buf[:n]
+			if err := stream.Send(req); err != nil {
+				// the server closed the stream, its error is returned below
+				break
+			}
+			req = &ddbv1.PutStreamRequest{}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res, err := stream.CloseAndReceive()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "wrote %d bytes to %s\n", res.Msg.GetSize(), args[0])
+	return err
+}
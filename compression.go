@@ -0,0 +1,20 @@
+package ddb
+
+import "github.com/danielfsousa/ddb/internal/compress"
+
+// Compression is the codec records are compressed with on disk.
+type Compression = compress.Codec
+
+const (
+	// CompressionNone stores records uncompressed.
+	CompressionNone = compress.None
+	// CompressionSnappy compresses records with snappy, which favors speed.
+	CompressionSnappy = compress.Snappy
+	// CompressionZstd compresses records with zstd, which favors compression ratio.
+	CompressionZstd = compress.Zstd
+)
+
+// ParseCompression parses a compression from one of: none, snappy or zstd.
+func ParseCompression(s string) (Compression, error) {
+	return compress.Parse(s)
+}
@@ -2,13 +2,24 @@
 package ddb
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
 	"github.com/danielfsousa/ddb/internal/backend"
 	"github.com/danielfsousa/ddb/internal/backend/bitcask"
+	"github.com/danielfsousa/ddb/internal/backend/lsm"
+	"github.com/danielfsousa/ddb/internal/backend/memory"
 	"github.com/danielfsousa/ddb/internal/config"
+	"github.com/danielfsousa/ddb/internal/encryption"
 )
 
 var (
@@ -23,16 +34,39 @@ var (
 
 	// ErrValueTooLarge is the error returned when a value is too large.
 	ErrValueTooLarge = errors.New("value is too large")
+
+	// ErrClosed is the error returned when writing to a closed database.
+	ErrClosed = errors.New("database is closed")
+
+	// ErrCorrupted is the error returned when stored data fails its checksum.
+	ErrCorrupted = backend.ErrCorrupted
+
+	// ErrWrongBackend is the error returned when opening a database with
+	// another backend than the one it was created with.
+	ErrWrongBackend = backend.ErrWrongBackend
 )
 
 // Ddb is a distributed key-value store consisting of a commit log and an in-memory index hash map.
 type Ddb struct {
-	config  *config.Config
-	backend backend.Backend
-	dir     string
+	config    *config.Config
+	backend   backend.Backend
+	committer *committer
+	cache     *valueCache
+	dir       string
+	// pendingChunks holds the ids of the chunked values being written, whose
+	// chunks are not referenced by their key yet.
+	pendingChunks sync.Map
+	// closeOnce closes the database once, keeping the result in closeErr.
+	closeOnce sync.Once
+	closeErr  error
 }
 
-// Open opens a new Ddb instance at the given directory.
+// memorySnapshotFile is the file, inside the database directory, the records
+// of the memory backend are saved to.
+const memorySnapshotFile = "memory.snapshot"
+
+// Open opens a new Ddb instance at the given directory. The memory backend
+// can be opened without one.
 func Open(dir string, options ...Option) (*Ddb, error) {
 	cfg := config.NewDefaultConfig()
 	for _, option := range options {
@@ -41,18 +75,67 @@ func Open(dir string, options ...Option) (*Ddb, error) {
 		}
 	}
 
-	back, err := bitcask.NewBitcaskBackend(dir, bitcask.Config{})
+	back, err := openBackend(dir, cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Ddb{
-		config:  cfg,
-		backend: back,
-		dir:     dir,
+		config:    cfg,
+		backend:   back,
+		committer: newCommitter(back.Sync, cfg.Durability),
+		cache:     newValueCache(cfg.CacheSize),
+		dir:       dir,
 	}, nil
 }
 
+// openBackend opens the backend chosen by the config, refusing a database
+// created with another backend.
+func openBackend(dir string, cfg *config.Config) (backend.Backend, error) {
+	if dir != "" {
+		created, err := detectBackend(dir)
+		if err != nil {
+			return nil, err
+		}
+		if created != "" && created != cfg.Backend {
+			return nil, fmt.Errorf("%w: %s was created with the %s backend, not %s",
+				ErrWrongBackend, dir, created, cfg.Backend)
+		}
+	}
+	switch cfg.Backend {
+	case config.BackendBitcask:
+		backConfig := bitcask.Config{
+			Repair:      cfg.Repair,
+			Compression: cfg.Compression,
+			HashKeys:    cfg.HashKeys,
+			OrderedKeys: cfg.OrderedKeys,
+		}
+		if cfg.Keys != nil {
+			backConfig.Keyring = encryption.NewKeyring(cfg.Keys)
+		}
+		return bitcask.NewBitcaskBackend(dir, backConfig)
+	case config.BackendLSM:
+		if cfg.Keys != nil {
+			return nil, fmt.Errorf("the %s backend does not support encryption", cfg.Backend)
+		}
+		return lsm.NewLSMBackend(dir, lsm.Config{
+			Repair:      cfg.Repair,
+			Compression: cfg.Compression,
+		})
+	case config.BackendMemory:
+		if cfg.Keys != nil {
+			return nil, fmt.Errorf("the %s backend does not support encryption", cfg.Backend)
+		}
+		var memConfig memory.Config
+		if dir != "" {
+			memConfig.SnapshotPath = filepath.Join(dir, memorySnapshotFile)
+		}
+		return memory.NewMemoryBackend(memConfig)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
 // Has returns true if the given key exists in the database.
 func (d *Ddb) Has(key string) bool {
 	meta, exists := d.backend.GetMetadata(key)
@@ -60,71 +143,281 @@ func (d *Ddb) Has(key string) bool {
 }
 
 // Get retrieves the value for the given key.
-func (d *Ddb) Get(key string) ([]byte, error) {
+func (d *Ddb) Get(ctx context.Context, key string) ([]byte, error) {
+	rec, err := d.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.value(ctx, rec)
+}
+
+// get returns the record of the given key, unless it was deleted.
+func (d *Ddb) get(ctx context.Context, key string) (*ddbv1.Record, error) {
 	if !d.Has(key) {
 		return nil, ErrKeyNotFound
 	}
-	rec, exists, err := d.backend.Get(key)
+	rec, gen, cached := d.cache.get(key)
+	if cached {
+		return rec, nil
+	}
+	rec, exists, err := d.backend.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
+	if !exists || rec.DeletedAt != nil {
 		return nil, ErrKeyNotFound
 	}
-	return rec.Value, nil
+	d.cache.add(rec, gen)
+	return rec, nil
+}
+
+// Set sets the value for the given key. It returns once the write is as
+// durable as configured by WithDurability.
+func (d *Ddb) Set(ctx context.Context, key string, val []byte) error {
+	return d.set(ctx, key, val, d.config.Durability.Mode == config.SyncEveryWrite)
+}
+
+// SetDurable sets the value for the given key and waits until it is synced
+// to disk, regardless of the configured durability.
+func (d *Ddb) SetDurable(ctx context.Context, key string, val []byte) error {
+	return d.set(ctx, key, val, true)
 }
 
-// Set sets the value for the given key.
-func (d *Ddb) Set(key string, val []byte) error {
-	if key == "" {
+func (d *Ddb) set(ctx context.Context, key string, val []byte, durable bool) error {
+	rec := &ddbv1.Record{
+		Timestamp: time.Now().Unix(),
+		Key:       key,
+		Value:     val,
+	}
+	if err := d.validate(rec); err != nil {
+		return err
+	}
+	if uint64(len(val)) > d.config.ChunkSize {
+		_, err := d.setChunked(ctx, key, bytes.NewReader(val), durable)
+		return err
+	}
+	// small values do not look up the previous version, so the chunks of a
+	// large value they replace are only deleted by the next merge.
+	return d.write(ctx, rec, durable)
+}
+
+// validate checks a record can be stored.
+func (d *Ddb) validate(rec *ddbv1.Record) error {
+	if rec.Key == "" {
 		return ErrKeyEmpty
 	}
-	if uint64(len(key)) > d.config.MaxKeySize {
+	if strings.HasPrefix(rec.Key, reservedPrefix) {
+		return ErrKeyReserved
+	}
+	if uint64(len(rec.Key)) > d.config.MaxKeySize {
 		return ErrKeyTooLarge
 	}
-	if uint64(len(val)) > d.config.MaxValueSize {
+	if uint64(len(rec.Value)) > d.config.MaxValueSize {
 		return ErrValueTooLarge
 	}
-
-	rec := &ddbv1.Record{
-		Key:   key,
-		Value: val,
+	if rec.Chunked != nil {
+		return ErrValueChunked
 	}
-	return d.backend.Set(rec)
+	return nil
 }
 
 // Delete deletes the value for the given key.
-func (d *Ddb) Delete(key string) error {
-	if !d.Has(key) {
-		return ErrKeyNotFound
+func (d *Ddb) Delete(ctx context.Context, key string) error {
+	prev, err := d.get(ctx, key)
+	if err != nil {
+		return err
 	}
 	t := time.Now().Unix()
 	rec := &ddbv1.Record{
+		Timestamp: t,
 		Key:       key,
 		DeletedAt: &t,
 	}
-	return d.backend.Set(rec)
+	if err := d.write(ctx, rec, d.config.Durability.Mode == config.SyncEveryWrite); err != nil {
+		return err
+	}
+	if prev.Chunked != nil {
+		return d.deleteChunks(ctx, key, prev.Chunked)
+	}
+	return nil
+}
+
+// write appends a record to the backend and, if durable, waits for it to be synced.
+func (d *Ddb) write(ctx context.Context, rec *ddbv1.Record, durable bool) error {
+	if err := d.backend.Set(ctx, rec); err != nil {
+		return err
+	}
+	d.cache.invalidate(rec.Key)
+	if durable {
+		return d.committer.wait(ctx)
+	}
+	d.committer.written()
+	return nil
 }
 
 // Sync flushes all buffers to disk, ensuring that all writes persisted.
-// func (s *Ddb) Sync() error {
-// 	return s.log.Sync()
-// }
+func (d *Ddb) Sync(ctx context.Context) error {
+	return d.committer.wait(ctx)
+}
 
-// Close closes the Ddb instance.
+// Close syncs pending writes and closes the Ddb instance. Calling it again
+// returns the result of the first call.
 func (d *Ddb) Close() error {
-	return d.backend.Close()
+	d.closeOnce.Do(func() {
+		d.committer.Close()
+		d.closeErr = d.backend.Close()
+	})
+	return d.closeErr
 }
 
 // Statistics represents statistics about the Ddb instance.
 type Statistics struct {
+	// Segments is the number of segments in the backend.
 	Segments int
-	Keys     int
-	Size     int
+	// Keys is the number of entries in the in-memory index.
+	Keys int
+	// Tombstones is the number of tombstones that were not merged yet.
+	Tombstones int
+	// Size is the size in bytes of the data directory.
+	Size uint64
+	// LiveBytes is the number of bytes occupied by the latest version of each key.
+	LiveBytes uint64
+	// DeadBytes is the number of bytes occupied by tombstones and overwritten records.
+	DeadBytes uint64
+	// HintedSegments is the number of segments whose index is loaded from a hint file.
+	HintedSegments int
+	// OpenFiles is the number of file handles held by the backend.
+	OpenFiles int
+	// SegmentStats contains the statistics of each segment, from oldest to newest.
+	SegmentStats []SegmentStatistics
+	// LastMerge is the time the last merge completed, zero if there was none
+	// since the database was opened.
+	LastMerge time.Time
+	// CompressionRatio is the size of the records written since the database
+	// was opened divided by the size they take compressed. It is zero if
+	// nothing was written.
+	CompressionRatio float64
+}
+
+// SegmentStatistics represents statistics about a single segment.
+type SegmentStatistics struct {
+	ID         uint64
+	Keys       int
+	Tombstones int
+	Size       uint64
+	LiveBytes  uint64
+	DeadBytes  uint64
+	Hinted     bool
+}
+
+// Stats returns statistics about the Ddb instance.
+func (d *Ddb) Stats() (*Statistics, error) {
+	var size uint64
+	if d.dir != "" {
+		var err error
+		if size, err = dirSize(d.dir); err != nil {
+			return nil, err
+		}
+	}
+	s := d.backend.Stats()
+	stats := &Statistics{
+		Segments:     len(s.Segments),
+		Size:         size,
+		OpenFiles:    s.OpenFiles,
+		SegmentStats: make([]SegmentStatistics, len(s.Segments)),
+		LastMerge:    s.LastMerge,
+	}
+	if s.StoredBytes > 0 {
+		stats.CompressionRatio = float64(s.RawBytes) / float64(s.StoredBytes)
+	}
+	for i, seg := range s.Segments {
+		stats.Keys += seg.Keys
+		stats.Tombstones += seg.Tombstones
+		stats.LiveBytes += seg.LiveBytes
+		stats.DeadBytes += seg.DeadBytes
+		if seg.Hinted {
+			stats.HintedSegments++
+		}
+		stats.SegmentStats[i] = SegmentStatistics(seg)
+	}
+	return stats, nil
+}
+
+// Merge reclaims the space occupied by overwritten records and tombstones,
+// after deleting the chunks of large values that were left behind by
+// interrupted writes. Backends that cannot merge their data do nothing.
+func (d *Ddb) Merge(ctx context.Context) error {
+	merger, ok := d.backend.(backend.Merger)
+	if !ok {
+		return nil
+	}
+	if _, err := d.dropOrphanChunks(ctx); err != nil {
+		return err
+	}
+	defer d.cache.purge()
+	return merger.Merge(ctx)
+}
+
+// BackupManifest describes the files of a backup archive.
+type BackupManifest = backend.BackupManifest
+
+// ErrBackupUnsupported is the error returned when the backend cannot be backed up.
+var ErrBackupUnsupported = errors.New("backend does not support backups")
+
+// Backup writes a consistent backup archive of the database to w while it
+// keeps serving requests. If since is not nil, the backup is incremental and
+// only contains the data that changed after that backup.
+func (d *Ddb) Backup(ctx context.Context, w io.Writer, since *BackupManifest) (*BackupManifest, error) {
+	backuper, ok := d.backend.(backend.Backuper)
+	if !ok {
+		return nil, ErrBackupUnsupported
+	}
+	return backuper.Backup(ctx, w, since)
 }
 
-// func (d *Ddb) Stats() (stats *Statistics, err error) {
-// 	// TODO: implement function to return the size of the datafiles directory
-// 	stats.Segments =
-// 	stats.Keys =
-// }
+// ReadBackupManifest returns the manifest of a backup archive written by Backup.
+func ReadBackupManifest(r io.Reader) (*BackupManifest, error) {
+	return bitcask.ReadBackupManifest(r)
+}
+
+// Corruption describes a range of a segment that failed its checksum.
+type Corruption struct {
+	Segment uint64
+	Offset  uint64
+	Size    uint64
+	Err     error
+}
+
+// Scrub reads back all the data stored by the Ddb instance and verifies its
+// checksums, returning the corrupted ranges found. Backends that cannot
+// verify their data report no corruption.
+func (d *Ddb) Scrub(ctx context.Context) ([]Corruption, error) {
+	scrubber, ok := d.backend.(backend.Scrubber)
+	if !ok {
+		return nil, nil
+	}
+	found, err := scrubber.Scrub(ctx)
+	corruptions := make([]Corruption, len(found))
+	for i, c := range found {
+		corruptions[i] = Corruption(c)
+	}
+	return corruptions, err
+}
+
+func dirSize(dir string) (size uint64, err error) {
+	err = filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	return size, err
+}
@@ -1,6 +1,11 @@
 package ddb
 
 import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
@@ -11,6 +16,10 @@ func TestCommitLog(t *testing.T) {
 	tests := map[string]func(t *testing.T, log *Ddb){
 		"write, read and delete a record succeeds": testReadWriteDelete,
 		"init with existing segments":              testInitExisting,
+		"stats":                                    testStats,
+		"detects corrupted records":                testCorrupted,
+		"compresses records":                       testCompression,
+		"encrypts records":                         testEncryption,
 	}
 	for scenario, fn := range tests {
 		t.Run(scenario, func(t *testing.T) {
@@ -27,6 +36,7 @@ func newDdb(dir string) (*Ddb, error) {
 }
 
 func testReadWriteDelete(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
 	want := &ddbv1.Record{
 		Key:   "foo",
 		Value: []byte("hello world"),
@@ -34,26 +44,27 @@ func testReadWriteDelete(t *testing.T, ddb *Ddb) {
 
 	require.False(t, ddb.Has(want.Key))
 
-	err := ddb.Set(want.Key, want.Value)
+	err := ddb.Set(ctx, want.Key, want.Value)
 	require.NoError(t, err)
 
 	require.True(t, ddb.Has(want.Key))
 
-	got, err := ddb.Get(want.Key)
+	got, err := ddb.Get(ctx, want.Key)
 	require.NoError(t, err)
 	require.Equal(t, want.Value, got)
 
-	err = ddb.Delete(want.Key)
+	err = ddb.Delete(ctx, want.Key)
 	require.NoError(t, err)
 
 	require.False(t, ddb.Has(want.Key))
 
-	got, err = ddb.Get(want.Key)
+	got, err = ddb.Get(ctx, want.Key)
 	require.Error(t, err, ErrKeyNotFound)
 	require.Nil(t, got)
 }
 
 func testInitExisting(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
 	recs := []*ddbv1.Record{
 		{
 			Key:   "foo",
@@ -70,7 +81,7 @@ func testInitExisting(t *testing.T, ddb *Ddb) {
 	}
 
 	for _, want := range recs {
-		err := ddb.Set(want.Key, want.Value)
+		err := ddb.Set(ctx, want.Key, want.Value)
 		require.NoError(t, err)
 	}
 
@@ -79,8 +90,126 @@ func testInitExisting(t *testing.T, ddb *Ddb) {
 	require.NoError(t, err)
 
 	for _, want := range recs {
-		got, err := ddb.Get(want.Key)
+		got, err := ddb.Get(ctx, want.Key)
 		require.NoError(t, err)
 		require.Equal(t, want.Value, got)
 	}
 }
+
+func testStats(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
+
+	stats, err := ddb.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Segments)
+	require.Zero(t, stats.Keys)
+	// an empty segment only holds its headers
+	headerBytes := stats.SegmentStats[0].Size
+	require.Zero(t, stats.SegmentStats[0].LiveBytes+stats.SegmentStats[0].DeadBytes)
+
+	require.NoError(t, ddb.Set(ctx, "foo", []byte("hello world")))
+	require.NoError(t, ddb.Set(ctx, "foo", []byte("hello again")))
+	require.NoError(t, ddb.Delete(ctx, "foo"))
+	require.NoError(t, ddb.Close())
+
+	ddb, err = newDdb(ddb.dir)
+	require.NoError(t, err)
+	stats, err = ddb.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Keys)
+	require.Equal(t, 1, stats.Tombstones)
+	require.Zero(t, stats.LiveBytes)
+	require.Equal(t, 1, stats.OpenFiles)
+	require.Equal(t, 1, stats.HintedSegments)
+	require.Len(t, stats.SegmentStats, 1)
+	// the segment only holds its headers and dead records
+	require.Equal(t, stats.SegmentStats[0].Size-headerBytes, stats.DeadBytes)
+	require.NotZero(t, stats.DeadBytes)
+	require.Greater(t, stats.Size, stats.DeadBytes)
+}
+
+func testCorrupted(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
+
+	require.NoError(t, ddb.Set(ctx, "foo", []byte("hello world")))
+	require.NoError(t, ddb.Set(ctx, "bar", []byte("hi world")))
+	corruptions, err := ddb.Scrub(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+	require.NoError(t, ddb.Close())
+
+	// flip the last byte of the store, which belongs to the value of "bar"
+	name := filepath.Join(ddb.dir, "1.store")
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(name, b, 0o644))
+
+	// the index is loaded from the hint file, so the corruption is only found on read
+	ddb, err = newDdb(ddb.dir)
+	require.NoError(t, err)
+	defer ddb.Close()
+
+	_, err = ddb.Get(ctx, "foo")
+	require.NoError(t, err)
+	_, err = ddb.Get(ctx, "bar")
+	require.ErrorIs(t, err, ErrCorrupted)
+
+	corruptions, err = ddb.Scrub(ctx)
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, uint64(1), corruptions[0].Segment)
+	require.Equal(t, uint64(len(b))-corruptions[0].Offset, corruptions[0].Size)
+	require.ErrorIs(t, corruptions[0].Err, ErrCorrupted)
+}
+
+func testCompression(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
+	value := []byte(strings.Repeat(`{"compressible":true}`, 100))
+
+	// written uncompressed
+	require.NoError(t, ddb.Set(ctx, "none", value))
+	stats, err := ddb.Stats()
+	require.NoError(t, err)
+	require.InDelta(t, 1, stats.CompressionRatio, 0.01)
+	require.NoError(t, ddb.Close())
+
+	ddb, err = Open(ddb.dir, WithCompression(CompressionZstd), WithNamespaceCompression("logs:", CompressionSnappy))
+	require.NoError(t, err)
+	defer ddb.Close()
+	require.NoError(t, ddb.Set(ctx, "zstd", value))
+	require.NoError(t, ddb.Set(ctx, "logs:snappy", value))
+	stats, err = ddb.Stats()
+	require.NoError(t, err)
+	require.Greater(t, stats.CompressionRatio, 10.0)
+
+	for _, key := range []string{"none", "zstd", "logs:snappy"} {
+		got, err := ddb.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+	}
+}
+
+func testEncryption(t *testing.T, ddb *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, ddb.Set(ctx, "plaintext", []byte("hello world")))
+	require.NoError(t, ddb.Close())
+
+	keys := &KeyFile{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	ddb, err := Open(ddb.dir, WithEncryption(keys))
+	require.NoError(t, err)
+	require.NoError(t, ddb.Set(ctx, "secret", []byte("hello world")))
+	require.NoError(t, ddb.Close())
+
+	_, err = Open(ddb.dir)
+	require.Error(t, err)
+
+	ddb, err = Open(ddb.dir, WithEncryption(keys))
+	require.NoError(t, err)
+	defer ddb.Close()
+	for _, key := range []string{"plaintext", "secret"} {
+		got, err := ddb.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, "hello world", string(got))
+	}
+}
@@ -0,0 +1,162 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danielfsousa/ddb/internal/config"
+)
+
+// Durability configures when writes are synced to disk.
+type Durability = config.Durability
+
+var (
+	// SyncNever never syncs writes explicitly, leaving it to the operating system.
+	// Acknowledged writes can be lost on crash. This is the default.
+	SyncNever = Durability{Mode: config.SyncNever}
+
+	// SyncEveryWrite acknowledges a write only after it is synced to disk.
+	// Concurrent writes are batched into a single sync.
+	SyncEveryWrite = Durability{Mode: config.SyncEveryWrite}
+)
+
+// SyncInterval syncs pending writes every d. Writes acknowledged since the
+// last sync can be lost on crash.
+func SyncInterval(d time.Duration) Durability {
+	return Durability{Mode: config.SyncInterval, Interval: d}
+}
+
+// ParseDurability parses a durability as formatted by its String method:
+// "never", "always" or a sync interval such as "100ms".
+func ParseDurability(s string) (Durability, error) {
+	switch s {
+	case "never":
+		return SyncNever, nil
+	case "always":
+		return SyncEveryWrite, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return Durability{}, fmt.Errorf("invalid durability %q: must be never, always or a positive interval", s)
+	}
+	return SyncInterval(d), nil
+}
+
+// committer syncs writes to disk in batches. Every writer waiting for a sync
+// joins the pending batch, which is synced at once by a single fsync, so
+// concurrent writers share its cost.
+type committer struct {
+	sync     func(context.Context) error
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending *commitBatch
+	dirty   bool
+
+	kick     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type commitBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func newCommitter(sync func(context.Context) error, d Durability) *committer {
+	c := &committer{
+		sync: sync,
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if d.Mode == config.SyncInterval {
+		c.interval = d.Interval
+	}
+	go c.run()
+	return c
+}
+
+func (c *committer) run() {
+	defer close(c.done)
+	var tick <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-c.kick:
+			c.commit()
+		case <-tick:
+			c.commit()
+		case <-c.stop:
+			c.commit()
+			return
+		}
+	}
+}
+
+// commit syncs the writes made so far and acknowledges the pending batch.
+func (c *committer) commit() {
+	c.mu.Lock()
+	batch, dirty := c.pending, c.dirty
+	c.pending, c.dirty = nil, false
+	c.mu.Unlock()
+
+	if batch == nil && !dirty {
+		return
+	}
+	err := c.sync(context.Background())
+	if batch != nil {
+		batch.err = err
+		close(batch.done)
+	}
+}
+
+// written records that there are writes that were not synced yet.
+func (c *committer) written() {
+	c.mu.Lock()
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// wait blocks until every write made before it was called is synced to disk.
+func (c *committer) wait(ctx context.Context) error {
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = &commitBatch{done: make(chan struct{})}
+	}
+	batch := c.pending
+	c.mu.Unlock()
+
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-c.done:
+		select {
+		case <-batch.done:
+			return batch.err
+		default:
+			return ErrClosed
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close syncs the pending writes and stops the committer. It can be called
+// more than once.
+func (c *committer) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+}
@@ -0,0 +1,136 @@
+package ddb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDurability(t *testing.T) {
+	for s, want := range map[string]Durability{
+		"never":  SyncNever,
+		"always": SyncEveryWrite,
+		"100ms":  SyncInterval(100 * time.Millisecond),
+	} {
+		got, err := ParseDurability(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.Equal(t, s, got.String())
+	}
+	for _, s := range []string{"", "sometimes", "0s", "-1s"} {
+		_, err := ParseDurability(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestCommitterBatchesWaiters(t *testing.T) {
+	var syncs atomic.Int32
+	c := newCommitter(func(context.Context) error {
+		syncs.Add(1)
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}, SyncEveryWrite)
+	defer c.Close()
+
+	const writers = 50
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer wg.Done()
+			require.NoError(t, c.wait(context.Background()))
+		}()
+	}
+	wg.Wait()
+	require.Less(t, syncs.Load(), int32(writers))
+	require.Positive(t, syncs.Load())
+}
+
+func TestCommitterInterval(t *testing.T) {
+	synced := make(chan struct{}, 1)
+	c := newCommitter(func(context.Context) error {
+		synced <- struct{}{}
+		return nil
+	}, SyncInterval(time.Millisecond))
+
+	c.written()
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("pending writes were not synced")
+	}
+
+	c.Close()
+	require.ErrorIs(t, c.wait(context.Background()), ErrClosed)
+	// closing again does not panic
+	c.Close()
+}
+
+func TestDurability(t *testing.T) {
+	ctx := context.Background()
+	storeSize := func(t *testing.T, dir string) int64 {
+		files, err := filepath.Glob(filepath.Join(dir, "*.store"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		fi, err := os.Stat(files[0])
+		require.NoError(t, err)
+		return fi.Size()
+	}
+
+	t.Run("sync never buffers writes", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir, WithDurability(SyncNever))
+		require.NoError(t, err)
+		defer db.Close()
+
+		// the store only holds its header
+		empty := storeSize(t, dir)
+		require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+		require.Equal(t, empty, storeSize(t, dir))
+
+		require.NoError(t, db.SetDurable(ctx, "foo", []byte("baz")))
+		require.Greater(t, storeSize(t, dir), empty)
+	})
+
+	t.Run("sync every write persists acknowledged writes", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir, WithDurability(SyncEveryWrite))
+		require.NoError(t, err)
+		defer db.Close()
+
+		empty := storeSize(t, dir)
+		require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+		require.Greater(t, storeSize(t, dir), empty)
+	})
+
+	t.Run("sync flushes pending writes", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := Open(dir)
+		require.NoError(t, err)
+		defer db.Close()
+
+		empty := storeSize(t, dir)
+		require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+		require.NoError(t, db.Sync(ctx))
+		require.Greater(t, storeSize(t, dir), empty)
+	})
+
+	t.Run("close twice", func(t *testing.T) {
+		db, err := Open(t.TempDir(), WithDurability(SyncEveryWrite))
+		require.NoError(t, err)
+		require.NoError(t, db.Set(ctx, "foo", []byte("bar")))
+		require.NoError(t, db.Close())
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		_, err := Open(t.TempDir(), WithDurability(SyncInterval(0)))
+		require.Error(t, err)
+	})
+}
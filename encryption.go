@@ -0,0 +1,17 @@
+package ddb
+
+import "github.com/danielfsousa/ddb/internal/encryption"
+
+// KeyProvider supplies the keys records are encrypted with on disk, such as
+// a KeyFile or a KMS. Keys are identified by an id stored with the data they
+// encrypt, so the current key can be rotated while older keys stay readable.
+type KeyProvider = encryption.KeyProvider
+
+// KeyFile is a KeyProvider backed by a JSON file holding the current key id
+// and every key by id, base64 encoded.
+type KeyFile = encryption.KeyFile
+
+// LoadKeyFile reads a KeyFile from the given path.
+func LoadKeyFile(name string) (*KeyFile, error) {
+	return encryption.LoadKeyFile(name)
+}
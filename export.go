@@ -0,0 +1,168 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+)
+
+// ErrInvalidImport is the error returned when importing a record that cannot
+// be decoded or stored.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ExportOptions configures which records are exported and how.
+type ExportOptions struct {
+	Format Format
+	// Prefix only exports the keys starting with it.
+	Prefix string
+	// Tombstones also exports the deleted keys, as records with DeletedAt set.
+	Tombstones bool
+}
+
+// ImportOptions configures which records are imported and how.
+type ImportOptions struct {
+	Format Format
+	// Prefix only imports the keys starting with it.
+	Prefix string
+	// Tombstones also imports the deleted keys, deleting them.
+	Tombstones bool
+}
+
+// Export writes the records of the database to w, sorted by key, and returns
+// how many were written. Writes are not blocked while exporting, so records
+// written meanwhile may or may not be exported.
+func (d *Ddb) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	enc, err := newRecordEncoder(w, opts.Format)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = d.rangeKeys(opts.Prefix, "", "", false, func(key string) error {
+		if strings.HasPrefix(key, reservedPrefix) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, exists, err := d.backend.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !exists || (rec.DeletedAt != nil && !opts.Tombstones) {
+			return nil
+		}
+		if rec.Chunked != nil {
+			value, err := d.value(ctx, rec)
+			if err != nil {
+				return err
+			}
+			rec = &ddbv1.Record{Timestamp: rec.Timestamp, Key: rec.Key, Value: value}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, enc.Flush()
+}
+
+// Import reads records from r and stores them, returning how many were
+// imported. Backends that support it load the records in bulk, in which case
+// nothing is imported if any record is invalid; otherwise the records are
+// set one at a time and the ones before an invalid record are kept. Records
+// without a timestamp are given the current time, and large values are split
+// into chunks.
+func (d *Ddb) Import(ctx context.Context, r io.Reader, opts ImportOptions) (int, error) {
+	dec, err := newRecordDecoder(r, opts.Format)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	read := 0
+	// chunks are the records left to import of the last large value, and
+	// chunkRecords counts them, since they are not imported records. Their
+	// chunk ids stay pending until the import is done.
+	var chunks []*ddbv1.Record
+	var chunkIDs []string
+	chunkRecords := 0
+	defer func() {
+		for _, id := range chunkIDs {
+			d.pendingChunks.Delete(id)
+		}
+	}()
+	next := func() (*ddbv1.Record, error) {
+		if len(chunks) > 0 {
+			rec := chunks[0]
+			chunks = chunks[1:]
+			if rec.Chunked == nil {
+				chunkRecords++
+			}
+			return rec, nil
+		}
+		for {
+			rec, err := dec.Decode()
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			read++
+			if err == nil {
+				err = d.validate(rec)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: record %d: %w", ErrInvalidImport, read, err)
+			}
+			if !strings.HasPrefix(rec.Key, opts.Prefix) || (rec.DeletedAt != nil && !opts.Tombstones) {
+				continue
+			}
+			if rec.Timestamp == 0 {
+				rec.Timestamp = time.Now().Unix()
+			}
+			if uint64(len(rec.Value)) > d.config.ChunkSize {
+				if chunks, err = d.splitChunks(rec); err != nil {
+					return nil, err
+				}
+				id := chunks[len(chunks)-1].Chunked.Id
+				d.pendingChunks.Store(id, true)
+				chunkIDs = append(chunkIDs, id)
+				rec, chunks = chunks[0], chunks[1:]
+				chunkRecords++
+			}
+			return rec, nil
+		}
+	}
+
+	if importer, ok := d.backend.(backend.Importer); ok {
+		// bulk imports write segments directly, without invalidating the cache
+		defer d.cache.purge()
+		n, err := importer.Import(ctx, next)
+		if err != nil {
+			return 0, err
+		}
+		return n - chunkRecords, nil
+	}
+	n := 0
+	for {
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			return n, d.Sync(ctx)
+		}
+		if err == nil {
+			err = d.write(ctx, rec, false)
+		}
+		if err != nil {
+			return n, err
+		}
+		if !strings.HasPrefix(rec.Key, reservedPrefix) {
+			n++
+		}
+	}
+}
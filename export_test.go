@@ -0,0 +1,147 @@
+package ddb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	tests := map[string]func(t *testing.T, src, dst *Ddb){
+		"round trips records in every format":    testExportFormats,
+		"filters keys by prefix":                 testExportPrefix,
+		"only includes tombstones if asked":      testExportTombstones,
+		"imported records overwrite older ones":  testImportOverwrite,
+		"imports nothing if a record is invalid": testImportInvalid,
+		"rejects records describing chunks":      testImportChunked,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			src, err := newDdb(t.TempDir())
+			require.NoError(t, err)
+			defer src.Close()
+			dst, err := newDdb(t.TempDir())
+			require.NoError(t, err)
+			defer dst.Close()
+
+			ctx := context.Background()
+			for i := 0; i < 50; i++ {
+				require.NoError(t, src.Set(ctx, fmt.Sprintf("user:%d", i), []byte(fmt.Sprintf("value-%d", i))))
+				require.NoError(t, src.Set(ctx, fmt.Sprintf("item:%d", i), []byte{0, byte(i), 0xff}))
+			}
+			require.NoError(t, src.Delete(ctx, "user:0"))
+			fn(t, src, dst)
+		})
+	}
+}
+
+func transfer(t *testing.T, src, dst *Ddb, export ExportOptions, imp ImportOptions) (exported, imported int) {
+	t.Helper()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	exported, err := src.Export(ctx, &buf, export)
+	require.NoError(t, err)
+	imported, err = dst.Import(ctx, &buf, imp)
+	require.NoError(t, err)
+	return exported, imported
+}
+
+func testExportFormats(t *testing.T, src, _ *Ddb) {
+	for _, format := range []Format{FormatJSONLines, FormatCSV, FormatProto} {
+		dst, err := newDdb(t.TempDir())
+		require.NoError(t, err)
+		exported, imported := transfer(t, src, dst,
+			ExportOptions{Format: format, Tombstones: true},
+			ImportOptions{Format: format, Tombstones: true},
+		)
+		require.Equal(t, 100, exported, format)
+		require.Equal(t, 100, imported, format)
+
+		ctx := context.Background()
+		for i := 1; i < 50; i++ {
+			got, err := dst.Get(ctx, fmt.Sprintf("item:%d", i))
+			require.NoError(t, err, format)
+			require.Equal(t, []byte{0, byte(i), 0xff}, got, format)
+		}
+		require.False(t, dst.Has("user:0"), format)
+		meta, exists := dst.backend.GetMetadata("user:0")
+		require.True(t, exists, format)
+		require.NotNil(t, meta.DeletedAt, format)
+		require.NoError(t, dst.Close())
+	}
+}
+
+func testExportPrefix(t *testing.T, src, dst *Ddb) {
+	exported, imported := transfer(t, src, dst,
+		ExportOptions{Format: FormatJSONLines, Prefix: "user:"},
+		ImportOptions{Format: FormatJSONLines, Prefix: "user:1"},
+	)
+	require.Equal(t, 49, exported)
+	// user:1 and user:10 to user:19
+	require.Equal(t, 11, imported)
+	require.True(t, dst.Has("user:19"))
+	require.False(t, dst.Has("user:2"))
+	require.False(t, dst.Has("item:1"))
+}
+
+func testExportTombstones(t *testing.T, src, dst *Ddb) {
+	exported, imported := transfer(t, src, dst,
+		ExportOptions{Format: FormatCSV, Tombstones: true},
+		ImportOptions{Format: FormatCSV},
+	)
+	require.Equal(t, 100, exported)
+	require.Equal(t, 99, imported)
+	_, exists := dst.backend.GetMetadata("user:0")
+	require.False(t, exists)
+}
+
+func testImportOverwrite(t *testing.T, src, dst *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, dst.Set(ctx, "user:1", []byte("old")))
+	require.NoError(t, dst.Set(ctx, "other", []byte("kept")))
+	transfer(t, src, dst, ExportOptions{Format: FormatProto}, ImportOptions{Format: FormatProto})
+
+	got, err := dst.Get(ctx, "user:1")
+	require.NoError(t, err)
+	require.Equal(t, "value-1", string(got))
+	got, err = dst.Get(ctx, "other")
+	require.NoError(t, err)
+	require.Equal(t, "kept", string(got))
+
+	stats, err := dst.Stats()
+	require.NoError(t, err)
+	require.Positive(t, stats.DeadBytes)
+}
+
+func testImportInvalid(t *testing.T, _, dst *Ddb) {
+	input := `{"key":"a","value":"MQ=="}` + "\n" + `{"key":"","value":"Mg=="}` + "\n"
+	_, err := dst.Import(context.Background(), strings.NewReader(input), ImportOptions{Format: FormatJSONLines})
+	require.ErrorIs(t, err, ErrInvalidImport)
+	require.ErrorIs(t, err, ErrKeyEmpty)
+	require.ErrorContains(t, err, "record 2")
+	require.False(t, dst.Has("a"))
+
+	_, err = dst.Import(context.Background(), strings.NewReader("k,v\n"), ImportOptions{Format: FormatCSV})
+	require.ErrorIs(t, err, ErrInvalidImport)
+}
+
+func testImportChunked(t *testing.T, _, dst *Ddb) {
+	var buf bytes.Buffer
+	enc, err := newRecordEncoder(&buf, FormatProto)
+	require.NoError(t, err)
+	require.NoError(t, enc.Encode(&ddbv1.Record{Key: "a", Value: []byte("1")}))
+	require.NoError(t, enc.Encode(&ddbv1.Record{Key: "b", Chunked: &ddbv1.ChunkedValue{Id: "forged", Size: 1 << 20, Chunks: 16}}))
+	require.NoError(t, enc.Flush())
+
+	_, err = dst.Import(context.Background(), &buf, ImportOptions{Format: FormatProto})
+	require.ErrorIs(t, err, ErrInvalidImport)
+	require.ErrorIs(t, err, ErrValueChunked)
+	require.ErrorContains(t, err, "record 2")
+	require.False(t, dst.Has("a"))
+	require.False(t, dst.Has("b"))
+}
@@ -0,0 +1,232 @@
+package ddb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+// Format is an encoding of records used to export and import them.
+type Format int
+
+const (
+	// FormatJSONLines encodes each record as a JSON object on its own line,
+	// with the value base64 encoded.
+	FormatJSONLines Format = iota + 1
+	// FormatCSV encodes each record as a row of a CSV file with a
+	// key,value,timestamp,deleted_at header, with the value base64 encoded.
+	FormatCSV
+	// FormatProto encodes records as a stream of ddbv1.Record messages, each
+	// prefixed by its size as a varint.
+	FormatProto
+)
+
+// ErrUnknownFormat is the error returned when parsing an unknown format.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ParseFormat parses a format from one of: jsonl, csv or proto.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{FormatJSONLines, FormatCSV, FormatProto} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q, must be one of jsonl, csv or proto", ErrUnknownFormat, s)
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSONLines:
+		return "jsonl"
+	case FormatCSV:
+		return "csv"
+	case FormatProto:
+		return "proto"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// recordEncoder writes records in a format.
+type recordEncoder interface {
+	Encode(rec *ddbv1.Record) error
+	Flush() error
+}
+
+// recordDecoder reads records in a format, returning io.EOF after the last one.
+type recordDecoder interface {
+	Decode() (*ddbv1.Record, error)
+}
+
+func newRecordEncoder(w io.Writer, f Format) (recordEncoder, error) {
+	switch f {
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		return &jsonEncoder{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		enc := &csvEncoder{w: csv.NewWriter(w)}
+		return enc, enc.w.Write(csvHeader)
+	case FormatProto:
+		return &protoEncoder{w: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, f)
+	}
+}
+
+func newRecordDecoder(r io.Reader, f Format) (recordDecoder, error) {
+	switch f {
+	case FormatJSONLines:
+		return &jsonDecoder{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		dec := &csvDecoder{r: csv.NewReader(r)}
+		return dec, dec.readHeader()
+	case FormatProto:
+		return &protoDecoder{r: bufio.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, f)
+	}
+}
+
+// jsonRecord is the JSON representation of a record.
+type jsonRecord struct {
+	Key       string `json:"key"`
+	Value     []byte `json:"value"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	DeletedAt *int64 `json:"deleted_at,omitempty"`
+}
+
+type jsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonEncoder) Encode(rec *ddbv1.Record) error {
+	return e.enc.Encode(jsonRecord{
+		Key:       rec.Key,
+		Value:     rec.Value,
+		Timestamp: rec.Timestamp,
+		DeletedAt: rec.DeletedAt,
+	})
+}
+
+func (e *jsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonDecoder) Decode() (*ddbv1.Record, error) {
+	var rec jsonRecord
+	if err := d.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &ddbv1.Record{
+		Key:       rec.Key,
+		Value:     rec.Value,
+		Timestamp: rec.Timestamp,
+		DeletedAt: rec.DeletedAt,
+	}, nil
+}
+
+var csvHeader = []string{"key", "value", "timestamp", "deleted_at"}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(rec *ddbv1.Record) error {
+	var deletedAt string
+	if rec.DeletedAt != nil {
+		deletedAt = strconv.FormatInt(*rec.DeletedAt, 10)
+	}
+	return e.w.Write([]string{
+		rec.Key,
+		base64.StdEncoding.EncodeToString(rec.Value),
+		strconv.FormatInt(rec.Timestamp, 10),
+		deletedAt,
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+}
+
+func (d *csvDecoder) readHeader() error {
+	header, err := d.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(header, csvHeader) {
+		return fmt.Errorf("invalid CSV header %q, must be %q", header, csvHeader)
+	}
+	return nil
+}
+
+func (d *csvDecoder) Decode() (*ddbv1.Record, error) {
+	row, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec := &ddbv1.Record{Key: row[0]}
+	if rec.Value, err = base64.StdEncoding.DecodeString(row[1]); err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	if row[2] != "" {
+		if rec.Timestamp, err = strconv.ParseInt(row[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+	}
+	if row[3] != "" {
+		deletedAt, err := strconv.ParseInt(row[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deleted_at: %w", err)
+		}
+		rec.DeletedAt = &deletedAt
+	}
+	return rec, nil
+}
+
+type protoEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *protoEncoder) Encode(rec *ddbv1.Record) error {
+	_, err := protodelim.MarshalTo(e.w, rec)
+	return err
+}
+
+func (e *protoEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type protoDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *protoDecoder) Decode() (*ddbv1.Record, error) {
+	rec := &ddbv1.Record{}
+	if err := protodelim.UnmarshalFrom(d.r, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
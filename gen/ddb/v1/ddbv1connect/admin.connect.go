@@ -0,0 +1,176 @@
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: ddb/v1/admin.proto
+
+package ddbv1connect
+
+import (
+	context "context"
+	errors "errors"
+	connect_go "github.com/bufbuild/connect-go"
+	v1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect_go.IsAtLeastVersion0_1_0
+
+const (
+	// AdminServiceName is the fully-qualified name of the AdminService service.
+	AdminServiceName = "ddb.v1.AdminService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// AdminServiceStatsProcedure is the fully-qualified name of the AdminService's Stats RPC.
+	AdminServiceStatsProcedure = "/ddb.v1.AdminService/Stats"
+	// AdminServiceBackupProcedure is the fully-qualified name of the AdminService's Backup RPC.
+	AdminServiceBackupProcedure = "/ddb.v1.AdminService/Backup"
+	// AdminServiceExportProcedure is the fully-qualified name of the AdminService's Export RPC.
+	AdminServiceExportProcedure = "/ddb.v1.AdminService/Export"
+	// AdminServiceImportProcedure is the fully-qualified name of the AdminService's Import RPC.
+	AdminServiceImportProcedure = "/ddb.v1.AdminService/Import"
+)
+
+// AdminServiceClient is a client for the ddb.v1.AdminService service.
+type AdminServiceClient interface {
+	Stats(context.Context, *connect_go.Request[v1.StatsRequest]) (*connect_go.Response[v1.StatsResponse], error)
+	// Backup streams a consistent tar archive of the database.
+	Backup(context.Context, *connect_go.Request[v1.BackupRequest]) (*connect_go.ServerStreamForClient[v1.BackupResponse], error)
+	// Export streams the records of the database in a portable format.
+	Export(context.Context, *connect_go.Request[v1.ExportRequest]) (*connect_go.ServerStreamForClient[v1.ExportResponse], error)
+	// Import loads records in a portable format into the database in bulk.
+	Import(context.Context) *connect_go.ClientStreamForClient[v1.ImportRequest, v1.ImportResponse]
+}
+
+// NewAdminServiceClient constructs a client for the ddb.v1.AdminService service. By default, it
+// uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and sends
+// uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC() or
+// connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewAdminServiceClient(httpClient connect_go.HTTPClient, baseURL string, opts ...connect_go.ClientOption) AdminServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &adminServiceClient{
+		stats: connect_go.NewClient[v1.StatsRequest, v1.StatsResponse](
+			httpClient,
+			baseURL+AdminServiceStatsProcedure,
+			opts...,
+		),
+		backup: connect_go.NewClient[v1.BackupRequest, v1.BackupResponse](
+			httpClient,
+			baseURL+AdminServiceBackupProcedure,
+			opts...,
+		),
+		export: connect_go.NewClient[v1.ExportRequest, v1.ExportResponse](
+			httpClient,
+			baseURL+AdminServiceExportProcedure,
+			opts...,
+		),
+		_import: connect_go.NewClient[v1.ImportRequest, v1.ImportResponse](
+			httpClient,
+			baseURL+AdminServiceImportProcedure,
+			opts...,
+		),
+	}
+}
+
+// adminServiceClient implements AdminServiceClient.
+type adminServiceClient struct {
+	stats   *connect_go.Client[v1.StatsRequest, v1.StatsResponse]
+	backup  *connect_go.Client[v1.BackupRequest, v1.BackupResponse]
+	export  *connect_go.Client[v1.ExportRequest, v1.ExportResponse]
+	_import *connect_go.Client[v1.ImportRequest, v1.ImportResponse]
+}
+
+// Stats calls ddb.v1.AdminService.Stats.
+func (c *adminServiceClient) Stats(ctx context.Context, req *connect_go.Request[v1.StatsRequest]) (*connect_go.Response[v1.StatsResponse], error) {
+	return c.stats.CallUnary(ctx, req)
+}
+
+// Backup calls ddb.v1.AdminService.Backup.
+func (c *adminServiceClient) Backup(ctx context.Context, req *connect_go.Request[v1.BackupRequest]) (*connect_go.ServerStreamForClient[v1.BackupResponse], error) {
+	return c.backup.CallServerStream(ctx, req)
+}
+
+// Export calls ddb.v1.AdminService.Export.
+func (c *adminServiceClient) Export(ctx context.Context, req *connect_go.Request[v1.ExportRequest]) (*connect_go.ServerStreamForClient[v1.ExportResponse], error) {
+	return c.export.CallServerStream(ctx, req)
+}
+
+// Import calls ddb.v1.AdminService.Import.
+func (c *adminServiceClient) Import(ctx context.Context) *connect_go.ClientStreamForClient[v1.ImportRequest, v1.ImportResponse] {
+	return c._import.CallClientStream(ctx)
+}
+
+// AdminServiceHandler is an implementation of the ddb.v1.AdminService service.
+type AdminServiceHandler interface {
+	Stats(context.Context, *connect_go.Request[v1.StatsRequest]) (*connect_go.Response[v1.StatsResponse], error)
+	// Backup streams a consistent tar archive of the database.
+	Backup(context.Context, *connect_go.Request[v1.BackupRequest], *connect_go.ServerStream[v1.BackupResponse]) error
+	// Export streams the records of the database in a portable format.
+	Export(context.Context, *connect_go.Request[v1.ExportRequest], *connect_go.ServerStream[v1.ExportResponse]) error
+	// Import loads records in a portable format into the database in bulk.
+	Import(context.Context, *connect_go.ClientStream[v1.ImportRequest]) (*connect_go.Response[v1.ImportResponse], error)
+}
+
+// NewAdminServiceHandler builds an HTTP handler from the service implementation. It returns the
+// path on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewAdminServiceHandler(svc AdminServiceHandler, opts ...connect_go.HandlerOption) (string, http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle(AdminServiceStatsProcedure, connect_go.NewUnaryHandler(
+		AdminServiceStatsProcedure,
+		svc.Stats,
+		opts...,
+	))
+	mux.Handle(AdminServiceBackupProcedure, connect_go.NewServerStreamHandler(
+		AdminServiceBackupProcedure,
+		svc.Backup,
+		opts...,
+	))
+	mux.Handle(AdminServiceExportProcedure, connect_go.NewServerStreamHandler(
+		AdminServiceExportProcedure,
+		svc.Export,
+		opts...,
+	))
+	mux.Handle(AdminServiceImportProcedure, connect_go.NewClientStreamHandler(
+		AdminServiceImportProcedure,
+		svc.Import,
+		opts...,
+	))
+	return "/ddb.v1.AdminService/", mux
+}
+
+// UnimplementedAdminServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedAdminServiceHandler struct{}
+
+func (UnimplementedAdminServiceHandler) Stats(context.Context, *connect_go.Request[v1.StatsRequest]) (*connect_go.Response[v1.StatsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.AdminService.Stats is not implemented"))
+}
+
+func (UnimplementedAdminServiceHandler) Backup(context.Context, *connect_go.Request[v1.BackupRequest], *connect_go.ServerStream[v1.BackupResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.AdminService.Backup is not implemented"))
+}
+
+func (UnimplementedAdminServiceHandler) Export(context.Context, *connect_go.Request[v1.ExportRequest], *connect_go.ServerStream[v1.ExportResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.AdminService.Export is not implemented"))
+}
+
+func (UnimplementedAdminServiceHandler) Import(context.Context, *connect_go.ClientStream[v1.ImportRequest]) (*connect_go.Response[v1.ImportResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.AdminService.Import is not implemented"))
+}
@@ -41,6 +41,10 @@ const (
 	DdbServiceSetProcedure = "/ddb.v1.DdbService/Set"
 	// DdbServiceDeleteProcedure is the fully-qualified name of the DdbService's Delete RPC.
 	DdbServiceDeleteProcedure = "/ddb.v1.DdbService/Delete"
+	// DdbServiceGetStreamProcedure is the fully-qualified name of the DdbService's GetStream RPC.
+	DdbServiceGetStreamProcedure = "/ddb.v1.DdbService/GetStream"
+	// DdbServicePutStreamProcedure is the fully-qualified name of the DdbService's PutStream RPC.
+	DdbServicePutStreamProcedure = "/ddb.v1.DdbService/PutStream"
 )
 
 // DdbServiceClient is a client for the ddb.v1.DdbService service.
@@ -49,6 +53,11 @@ type DdbServiceClient interface {
 	Get(context.Context, *connect_go.Request[v1.GetRequest]) (*connect_go.Response[v1.GetResponse], error)
 	Set(context.Context, *connect_go.Request[v1.SetRequest]) (*connect_go.Response[v1.SetResponse], error)
 	Delete(context.Context, *connect_go.Request[v1.DeleteRequest]) (*connect_go.Response[v1.DeleteResponse], error)
+	// GetStream streams the value of a key in chunks, so large values do not
+	// need to fit in a single message.
+	GetStream(context.Context, *connect_go.Request[v1.GetStreamRequest]) (*connect_go.ServerStreamForClient[v1.GetStreamResponse], error)
+	// PutStream sets the value of a key from a stream of chunks.
+	PutStream(context.Context) *connect_go.ClientStreamForClient[v1.PutStreamRequest, v1.PutStreamResponse]
 }
 
 // NewDdbServiceClient constructs a client for the ddb.v1.DdbService service. By default, it uses
@@ -81,15 +90,27 @@ func NewDdbServiceClient(httpClient connect_go.HTTPClient, baseURL string, opts
 			baseURL+DdbServiceDeleteProcedure,
 			opts...,
 		),
+		getStream: connect_go.NewClient[v1.GetStreamRequest, v1.GetStreamResponse](
+			httpClient,
+			baseURL+DdbServiceGetStreamProcedure,
+			opts...,
+		),
+		putStream: connect_go.NewClient[v1.PutStreamRequest, v1.PutStreamResponse](
+			httpClient,
+			baseURL+DdbServicePutStreamProcedure,
+			opts...,
+		),
 	}
 }
 
 // ddbServiceClient implements DdbServiceClient.
 type ddbServiceClient struct {
-	has    *connect_go.Client[v1.HasRequest, v1.HasResponse]
-	get    *connect_go.Client[v1.GetRequest, v1.GetResponse]
-	set    *connect_go.Client[v1.SetRequest, v1.SetResponse]
-	delete *connect_go.Client[v1.DeleteRequest, v1.DeleteResponse]
+	has       *connect_go.Client[v1.HasRequest, v1.HasResponse]
+	get       *connect_go.Client[v1.GetRequest, v1.GetResponse]
+	set       *connect_go.Client[v1.SetRequest, v1.SetResponse]
+	delete    *connect_go.Client[v1.DeleteRequest, v1.DeleteResponse]
+	getStream *connect_go.Client[v1.GetStreamRequest, v1.GetStreamResponse]
+	putStream *connect_go.Client[v1.PutStreamRequest, v1.PutStreamResponse]
 }
 
 // Has calls ddb.v1.DdbService.Has.
@@ -112,12 +133,27 @@ func (c *ddbServiceClient) Delete(ctx context.Context, req *connect_go.Request[v
 	return c.delete.CallUnary(ctx, req)
 }
 
+// GetStream calls ddb.v1.DdbService.GetStream.
+func (c *ddbServiceClient) GetStream(ctx context.Context, req *connect_go.Request[v1.GetStreamRequest]) (*connect_go.ServerStreamForClient[v1.GetStreamResponse], error) {
+	return c.getStream.CallServerStream(ctx, req)
+}
+
+// PutStream calls ddb.v1.DdbService.PutStream.
+func (c *ddbServiceClient) PutStream(ctx context.Context) *connect_go.ClientStreamForClient[v1.PutStreamRequest, v1.PutStreamResponse] {
+	return c.putStream.CallClientStream(ctx)
+}
+
 // DdbServiceHandler is an implementation of the ddb.v1.DdbService service.
 type DdbServiceHandler interface {
 	Has(context.Context, *connect_go.Request[v1.HasRequest]) (*connect_go.Response[v1.HasResponse], error)
 	Get(context.Context, *connect_go.Request[v1.GetRequest]) (*connect_go.Response[v1.GetResponse], error)
 	Set(context.Context, *connect_go.Request[v1.SetRequest]) (*connect_go.Response[v1.SetResponse], error)
 	Delete(context.Context, *connect_go.Request[v1.DeleteRequest]) (*connect_go.Response[v1.DeleteResponse], error)
+	// GetStream streams the value of a key in chunks, so large values do not
+	// need to fit in a single message.
+	GetStream(context.Context, *connect_go.Request[v1.GetStreamRequest], *connect_go.ServerStream[v1.GetStreamResponse]) error
+	// PutStream sets the value of a key from a stream of chunks.
+	PutStream(context.Context, *connect_go.ClientStream[v1.PutStreamRequest]) (*connect_go.Response[v1.PutStreamResponse], error)
 }
 
 // NewDdbServiceHandler builds an HTTP handler from the service implementation. It returns the path
@@ -147,6 +183,16 @@ func NewDdbServiceHandler(svc DdbServiceHandler, opts ...connect_go.HandlerOptio
 		svc.Delete,
 		opts...,
 	))
+	mux.Handle(DdbServiceGetStreamProcedure, connect_go.NewServerStreamHandler(
+		DdbServiceGetStreamProcedure,
+		svc.GetStream,
+		opts...,
+	))
+	mux.Handle(DdbServicePutStreamProcedure, connect_go.NewClientStreamHandler(
+		DdbServicePutStreamProcedure,
+		svc.PutStream,
+		opts...,
+	))
 	return "/ddb.v1.DdbService/", mux
 }
 
@@ -168,3 +214,11 @@ func (UnimplementedDdbServiceHandler) Set(context.Context, *connect_go.Request[v
 func (UnimplementedDdbServiceHandler) Delete(context.Context, *connect_go.Request[v1.DeleteRequest]) (*connect_go.Response[v1.DeleteResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.DdbService.Delete is not implemented"))
 }
+
+func (UnimplementedDdbServiceHandler) GetStream(context.Context, *connect_go.Request[v1.GetStreamRequest], *connect_go.ServerStream[v1.GetStreamResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.DdbService.GetStream is not implemented"))
+}
+
+func (UnimplementedDdbServiceHandler) PutStream(context.Context, *connect_go.ClientStream[v1.PutStreamRequest]) (*connect_go.Response[v1.PutStreamResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ddb.v1.DdbService.PutStream is not implemented"))
+}
@@ -2,15 +2,20 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
 
 	"github.com/danielfsousa/ddb"
 	"github.com/danielfsousa/ddb/internal/discovery"
+	"github.com/danielfsousa/ddb/internal/health"
 	"github.com/danielfsousa/ddb/internal/server"
+	"github.com/danielfsousa/ddb/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type Agent struct {
@@ -19,6 +24,10 @@ type Agent struct {
 	database   *ddb.Ddb
 	server     *server.Server
 	membership *discovery.Membership
+	health     *health.Health
+	scrubber   *scrubber
+	registry   *prometheus.Registry
+	tracer     *sdktrace.TracerProvider
 
 	shutdown     bool
 	shutdowns    chan struct{}
@@ -42,22 +51,74 @@ func New(config *Config) (*Agent, error) {
 		logger:    &logger,
 	}
 
-	if err := agent.setupDatabase(); err != nil {
-		return nil, err
+	// The server starts before the database is opened so health checks can be
+	// answered while the index is being rebuilt.
+	setup := []func() error{
+		agent.setupTracing,
+		agent.setupMetrics,
+		agent.setupHealth,
+		agent.setupServer,
+		agent.setupDatabase,
+		agent.setupScrubber,
+		agent.setupMembership,
 	}
-	if err := agent.setupServer(); err != nil {
-		return nil, err
-	}
-	if err := agent.setupMembership(); err != nil {
-		return nil, err
+	for _, fn := range setup {
+		if err := fn(); err != nil {
+			// tear down the components that were already started; Shutdown
+			// logs its own failure
+			_ = agent.Shutdown()
+			return nil, err
+		}
 	}
 
 	return agent, nil
 }
 
+func (a *Agent) setupTracing() (err error) {
+	a.Config.Tracing.NodeName = a.Config.NodeName
+	a.tracer, err = telemetry.NewTracerProvider(context.Background(), a.Config.Tracing)
+	if err != nil || a.tracer == nil {
+		return err
+	}
+	telemetry.SetGlobal(a.tracer)
+	return nil
+}
+
 func (a *Agent) setupDatabase() (err error) {
-	a.database, err = ddb.Open(a.Config.DataDir)
-	return err
+	options := []ddb.Option{
+		ddb.WithDurability(a.Config.Durability),
+		ddb.WithCompression(a.Config.Compression),
+		ddb.WithCacheSize(a.Config.CacheSize),
+	}
+	for prefix, c := range a.Config.NamespaceCompression {
+		options = append(options, ddb.WithNamespaceCompression(prefix, c))
+	}
+	if a.Config.Backend != "" {
+		options = append(options, ddb.WithBackend(a.Config.Backend))
+	}
+	if a.Config.Repair {
+		options = append(options, ddb.WithRepair())
+	}
+	if a.Config.HashKeys {
+		options = append(options, ddb.WithKeyHashing())
+	}
+	if a.Config.OrderedKeys {
+		options = append(options, ddb.WithOrderedKeys())
+	}
+	if a.Config.KeyFile != "" {
+		keys, err := ddb.LoadKeyFile(a.Config.KeyFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, ddb.WithEncryption(keys))
+	}
+	a.database, err = ddb.Open(a.Config.DataDir, options...)
+	if err != nil {
+		return err
+	}
+	a.server.SetDdb(a.database)
+	a.health.AddReadinessCheck(checkDatabase, func(context.Context) error { return nil })
+	return a.registry.Register(newDatabaseCollector(a.database))
 }
 
 func (a *Agent) setupServer() error {
@@ -66,11 +127,17 @@ func (a *Agent) setupServer() error {
 		return err
 	}
 
-	a.server = server.New(&server.Config{
-		Host: host,
-		Port: a.Config.RPCPort,
-		Ddb:  a.database,
+	srv := server.New(&server.Config{
+		Host:            host,
+		Port:            a.Config.RPCPort,
+		ShutdownTimeout: a.Config.ShutdownTimeout,
+		Handlers:        a.handlers(),
 	})
+	// listen before returning, so a port in use fails the setup
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	a.server = srv
 	go func() {
 		if err := a.server.Start(); err != nil {
 			a.logger.Fatal().Err(err).Msg("failed to start server")
@@ -113,7 +180,11 @@ func (a *Agent) setupMembership() error {
 		},
 		StartJoinAddrs: a.Config.StartJoinAddrs,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	a.health.AddReadinessCheck(checkSerf, a.checkSerf)
+	return a.registerMembershipMetrics()
 }
 
 func (a *Agent) Shutdown() error {
@@ -125,11 +196,19 @@ func (a *Agent) Shutdown() error {
 	a.shutdown = true
 	a.logger.Info().Msg("shutting down")
 	close(a.shutdowns)
+	if a.health != nil {
+		a.health.AddReadinessCheck(checkShutdown, func(context.Context) error { return errShuttingDown })
+	}
 
+	// Stop serving requests before closing the database underneath them, and
+	// only leave the cluster once the node is no longer serving. Components
+	// that were not started, when New fails, are skipped.
 	shutdown := []func() error{
-		a.membership.Leave,
-		a.server.Stop,
-		a.database.Close,
+		a.stopServer,
+		a.stopScrubber,
+		a.closeDatabase,
+		a.leaveMembership,
+		a.shutdownTracing,
 	}
 	for _, fn := range shutdown {
 		if err := fn(); err != nil {
@@ -139,3 +218,35 @@ func (a *Agent) Shutdown() error {
 	}
 	return nil
 }
+
+// stopServer stops the server, if it was started.
+func (a *Agent) stopServer() error {
+	if a.server == nil {
+		return nil
+	}
+	return a.server.Stop()
+}
+
+// closeDatabase closes the database, if it was opened.
+func (a *Agent) closeDatabase() error {
+	if a.database == nil {
+		return nil
+	}
+	return a.database.Close()
+}
+
+// leaveMembership leaves the cluster, if the node joined it.
+func (a *Agent) leaveMembership() error {
+	if a.membership == nil {
+		return nil
+	}
+	return a.membership.Leave()
+}
+
+// shutdownTracing flushes the spans that were not exported yet.
+func (a *Agent) shutdownTracing() error {
+	if a.tracer == nil {
+		return nil
+	}
+	return a.tracer.Shutdown(context.Background())
+}
@@ -3,7 +3,10 @@ package agent_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -56,9 +59,85 @@ func TestAgent(t *testing.T) {
 	)
 	require.NoError(t, err)
 
+	stream, err := leaderClient.GetStream(
+		context.Background(),
+		connect.NewRequest(&ddbv1.GetStreamRequest{Key: "foo"}),
+	)
+	require.NoError(t, err)
+	for stream.Receive() {
+	}
+	require.NoError(t, stream.Err())
+	require.NoError(t, stream.Close())
+
+	stats, err := adminClient(t, agents[0]).Stats(
+		context.Background(),
+		connect.NewRequest(&ddbv1.StatsRequest{}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Msg.Keys)
+	require.Len(t, stats.Msg.SegmentStats, 1)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		code, body := httpGet(t, agents[0], path)
+		require.Equal(t, http.StatusOK, code, body)
+	}
+
+	code, metrics := httpGet(t, agents[0], "/metrics")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, metrics, `ddb_rpc_duration_seconds_count{code="ok",procedure="/ddb.v1.DdbService/Set"} 1`)
+	require.Contains(t, metrics, `ddb_rpc_duration_seconds_count{code="ok",procedure="/ddb.v1.DdbService/GetStream"} 1`)
+	require.Contains(t, metrics, "ddb_bitcask_segments 1")
+	require.Contains(t, metrics, "ddb_serf_members 3")
+
 	// TODO: test replication
 }
 
+func TestAgentSetupFailure(t *testing.T) {
+	ports := dynaport.Get(2)
+	config := func(dataDir string) *agent.Config {
+		return &agent.Config{
+			NodeName: "node",
+			BindAddr: fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			RPCPort:  ports[1],
+			DataDir:  dataDir,
+		}
+	}
+
+	// the database cannot be opened in a file, after the server started
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, err := agent.New(config(file))
+	require.Error(t, err)
+
+	// the server of the failed agent was stopped, releasing its port
+	a, err := agent.New(config(t.TempDir()))
+	require.NoError(t, err)
+	require.NoError(t, a.Shutdown())
+}
+
+func adminClient(t *testing.T, a *agent.Agent) ddbv1connect.AdminServiceClient {
+	addr, err := a.Config.RPCAddr()
+	require.NoError(t, err)
+	return ddbv1connect.NewAdminServiceClient(
+		http.DefaultClient,
+		"http://"+addr,
+	)
+}
+
+func httpGet(t *testing.T, a *agent.Agent, path string) (int, string) {
+	t.Helper()
+	addr, err := a.Config.RPCAddr()
+	require.NoError(t, err)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+addr+path, http.NoBody)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, string(b)
+}
+
 func client(t *testing.T, a *agent.Agent) ddbv1connect.DdbServiceClient {
 	addr, err := a.Config.RPCAddr()
 	require.NoError(t, err)
@@ -1,10 +1,23 @@
 package agent
 
+import (
+	"time"
+
+	"github.com/danielfsousa/ddb"
+	"github.com/danielfsousa/ddb/internal/telemetry"
+)
+
 const (
 	// DefaultBindAddr is the address to bind Serf on if one is not specified.
 	DefaultBindAddr = "localhost:8401"
 	// DefaultRPCPort is the port for RPC clients (and Raft) connections to bind to if one is not specified.
 	DefaultRPCPort = 9191
+	// DefaultShutdownTimeout is how long in-flight requests are given to finish on shutdown.
+	DefaultShutdownTimeout = 10 * time.Second
+	// DefaultMinFreeDiskBytes is the free space the data directory needs for the node to be ready.
+	DefaultMinFreeDiskBytes = 100 << 20 // 100MB
+	// DefaultScrubInterval is the time between scrubs of the database.
+	DefaultScrubInterval = 24 * time.Hour
 )
 
 type Config struct {
@@ -14,12 +27,45 @@ type Config struct {
 	NodeName       string
 	StartJoinAddrs []string
 	Bootstrap      bool
+	Tracing        telemetry.TracingConfig
+	// ShutdownTimeout is how long in-flight requests are given to finish on shutdown.
+	ShutdownTimeout time.Duration
+	// MinFreeDiskBytes is the free space the data directory needs for the node to be ready.
+	MinFreeDiskBytes uint64
+	// Backend is the storage engine of the database, bitcask if it is empty.
+	Backend ddb.Backend
+	// Durability configures when writes are synced to disk.
+	Durability ddb.Durability
+	// Repair truncates corrupted segments when opening the database instead of failing.
+	Repair bool
+	// ScrubInterval is the time between scrubs of the database, which verify the
+	// checksum of every record. Scrubbing is disabled if it is zero.
+	ScrubInterval time.Duration
+	// Compression is the codec records are compressed with.
+	Compression ddb.Compression
+	// NamespaceCompression overrides Compression for the keys starting with each prefix.
+	NamespaceCompression map[string]ddb.Compression
+	// KeyFile is the path of the key file data is encrypted with at rest.
+	// Data is not encrypted if it is empty.
+	KeyFile string
+	// CacheSize is the maximum size in bytes of the values cached in memory.
+	// Values are not cached if it is zero.
+	CacheSize uint64
+	// HashKeys keeps only a hash of each key in memory, reading keys back
+	// from disk to confirm them.
+	HashKeys bool
+	// OrderedKeys keeps the keys sorted in memory for scans.
+	OrderedKeys bool
 }
 
 // NewDefaultConfig creates a new Config with default settings.
 func NewDefaultConfig() *Config {
 	return &Config{
-		BindAddr: DefaultBindAddr,
-		RPCPort:  DefaultRPCPort,
+		Backend:          ddb.BackendBitcask,
+		BindAddr:         DefaultBindAddr,
+		RPCPort:          DefaultRPCPort,
+		ShutdownTimeout:  DefaultShutdownTimeout,
+		MinFreeDiskBytes: DefaultMinFreeDiskBytes,
+		ScrubInterval:    DefaultScrubInterval,
 	}
 }
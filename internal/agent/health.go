@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/serf/serf"
+
+	"github.com/danielfsousa/ddb/gen/ddb/v1/ddbv1connect"
+	"github.com/danielfsousa/ddb/internal/health"
+)
+
+const (
+	checkDatabase = "database"
+	checkDisk     = "disk"
+	checkSerf     = "serf"
+	checkShutdown = "shutdown"
+)
+
+var errShuttingDown = errors.New("agent is shutting down")
+
+// setupHealth registers the checks of the components that are not started
+// yet as not ready, they are replaced once each component starts.
+func (a *Agent) setupHealth() error {
+	a.health = health.New()
+	notReady := func(context.Context) error { return health.ErrNotReady }
+	a.health.AddReadinessCheck(checkDatabase, notReady)
+	a.health.AddReadinessCheck(checkSerf, notReady)
+	a.health.AddReadinessCheck(checkDisk, health.DiskSpace(a.Config.DataDir, a.Config.MinFreeDiskBytes))
+	return nil
+}
+
+func (a *Agent) checkSerf(context.Context) error {
+	if state := a.membership.State(); state != serf.SerfAlive {
+		return fmt.Errorf("serf is %s", state)
+	}
+	return nil
+}
+
+// handlers returns the HTTP handlers served by the agent alongside the RPC services.
+func (a *Agent) handlers() map[string]http.Handler {
+	grpcHealthPath, grpcHealthHandler := a.health.NewGRPCHandler(
+		ddbv1connect.DdbServiceName,
+		ddbv1connect.AdminServiceName,
+	)
+	return map[string]http.Handler{
+		"/metrics":     a.metricsHandler(),
+		"/healthz":     a.health.LivenessHandler(),
+		"/readyz":      a.health.ReadinessHandler(),
+		grpcHealthPath: grpcHealthHandler,
+	}
+}
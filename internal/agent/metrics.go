@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"net/http"
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+	gometrics "github.com/armon/go-metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/danielfsousa/ddb"
+)
+
+// bridgeOnce guards the go-metrics global sink, which is shared by every agent in the process.
+var bridgeOnce sync.Once
+
+// bridgeGoMetrics forwards the metrics emitted by serf, memberlist and raft
+// through armon/go-metrics to the default Prometheus registry.
+func bridgeGoMetrics() (err error) {
+	bridgeOnce.Do(func() {
+		var sink *gometrics.PrometheusSink
+		sink, err = gometrics.NewPrometheusSink()
+		if err != nil {
+			return
+		}
+		cfg := metrics.DefaultConfig("ddb")
+		cfg.EnableHostname = false
+		cfg.EnableRuntimeMetrics = false
+		_, err = metrics.NewGlobal(cfg, sink)
+	})
+	return err
+}
+
+func (a *Agent) setupMetrics() error {
+	if err := bridgeGoMetrics(); err != nil {
+		return err
+	}
+	a.registry = prometheus.NewRegistry()
+	return nil
+}
+
+func (a *Agent) registerMembershipMetrics() error {
+	return a.registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ddb",
+		Subsystem: "serf",
+		Name:      "members",
+		Help:      "Number of members known to this node, in any state.",
+	}, func() float64 {
+		return float64(len(a.membership.Members()))
+	}))
+}
+
+// metricsHandler serves the agent's metrics along with the process wide default registry.
+func (a *Agent) metricsHandler() http.Handler {
+	gatherers := prometheus.Gatherers{a.registry, prometheus.DefaultGatherer}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+}
+
+// databaseCollector exports the statistics of a database on every scrape.
+type databaseCollector struct {
+	database   *ddb.Ddb
+	segments   *prometheus.Desc
+	keys       *prometheus.Desc
+	tombstones *prometheus.Desc
+	size       *prometheus.Desc
+	liveBytes  *prometheus.Desc
+	deadBytes  *prometheus.Desc
+	ratio      *prometheus.Desc
+	errors     prometheus.Counter
+}
+
+func newDatabaseCollector(database *ddb.Ddb) *databaseCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("ddb", "bitcask", name), help, nil, nil)
+	}
+	return &databaseCollector{
+		database:   database,
+		segments:   desc("segments", "Number of segments."),
+		keys:       desc("keydir_keys", "Number of entries in the in-memory index."),
+		tombstones: desc("tombstones", "Number of tombstones that were not merged yet."),
+		size:       desc("size_bytes", "Size of the data directory."),
+		liveBytes:  desc("live_bytes", "Bytes occupied by the latest version of each key."),
+		deadBytes:  desc("dead_bytes", "Bytes occupied by tombstones and overwritten records."),
+		ratio:      desc("compression_ratio", "Size of the records written since opening divided by their compressed size."),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ddb",
+			Subsystem: "bitcask",
+			Name:      "stats_errors_total",
+			Help:      "Number of failures collecting database statistics.",
+		}),
+	}
+}
+
+func (c *databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.segments
+	ch <- c.keys
+	ch <- c.tombstones
+	ch <- c.size
+	ch <- c.liveBytes
+	ch <- c.deadBytes
+	ch <- c.ratio
+	c.errors.Describe(ch)
+}
+
+func (c *databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	defer c.errors.Collect(ch)
+	stats, err := c.database.Stats()
+	if err != nil {
+		c.errors.Inc()
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.segments, prometheus.GaugeValue, float64(stats.Segments))
+	ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(stats.Keys))
+	ch <- prometheus.MustNewConstMetric(c.tombstones, prometheus.GaugeValue, float64(stats.Tombstones))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(stats.Size))
+	ch <- prometheus.MustNewConstMetric(c.liveBytes, prometheus.GaugeValue, float64(stats.LiveBytes))
+	ch <- prometheus.MustNewConstMetric(c.deadBytes, prometheus.GaugeValue, float64(stats.DeadBytes))
+	ch <- prometheus.MustNewConstMetric(c.ratio, prometheus.GaugeValue, stats.CompressionRatio)
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/danielfsousa/ddb"
+)
+
+// scrubber periodically reads back the whole database to detect corrupted
+// records before clients do.
+type scrubber struct {
+	database *ddb.Ddb
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+	logger   *zerolog.Logger
+
+	runs           prometheus.Counter
+	errors         prometheus.Counter
+	corruptions    prometheus.Gauge
+	corruptedBytes prometheus.Gauge
+	lastRun        prometheus.Gauge
+}
+
+func newScrubber(database *ddb.Ddb, interval time.Duration) *scrubber {
+	logger := log.With().Str("component", "scrubber").Logger()
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: "ddb", Subsystem: "scrub", Name: name, Help: help}
+	}
+	return &scrubber{
+		database:       database,
+		interval:       interval,
+		done:           make(chan struct{}),
+		logger:         &logger,
+		runs:           prometheus.NewCounter(prometheus.CounterOpts(opts("runs_total", "Number of completed scrubs."))),
+		errors:         prometheus.NewCounter(prometheus.CounterOpts(opts("errors_total", "Number of scrubs that failed to complete."))),
+		corruptions:    prometheus.NewGauge(prometheus.GaugeOpts(opts("corrupted_ranges", "Number of corrupted ranges found by the last scrub."))),
+		corruptedBytes: prometheus.NewGauge(prometheus.GaugeOpts(opts("corrupted_bytes", "Bytes in corrupted ranges found by the last scrub."))),
+		lastRun:        prometheus.NewGauge(prometheus.GaugeOpts(opts("last_run_timestamp_seconds", "Time the last scrub completed."))),
+	}
+}
+
+func (s *scrubber) collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.runs, s.errors, s.corruptions, s.corruptedBytes, s.lastRun}
+}
+
+func (s *scrubber) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx)
+}
+
+func (s *scrubber) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.scrub(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *scrubber) scrub(ctx context.Context) {
+	start := time.Now()
+	corruptions, err := s.database.Scrub(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		s.errors.Inc()
+		s.logger.Error().Err(err).Msg("failed to scrub database")
+		return
+	}
+
+	var corruptedBytes uint64
+	for _, c := range corruptions {
+		corruptedBytes += c.Size
+		s.logger.Error().
+			Uint64("segment", c.Segment).
+			Uint64("offset", c.Offset).
+			Uint64("size", c.Size).
+			Err(c.Err).
+			Msg("found corrupted range")
+	}
+	s.runs.Inc()
+	s.corruptions.Set(float64(len(corruptions)))
+	s.corruptedBytes.Set(float64(corruptedBytes))
+	s.lastRun.SetToCurrentTime()
+	s.logger.Info().
+		Dur("duration", time.Since(start)).
+		Int("corruptions", len(corruptions)).
+		Msg("scrubbed database")
+}
+
+// Stop interrupts the scrub in progress and waits for the scrubber to exit.
+func (s *scrubber) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (a *Agent) setupScrubber() error {
+	if a.Config.ScrubInterval <= 0 {
+		return nil
+	}
+	a.scrubber = newScrubber(a.database, a.Config.ScrubInterval)
+	for _, c := range a.scrubber.collectors() {
+		if err := a.registry.Register(c); err != nil {
+			return err
+		}
+	}
+	a.scrubber.Start()
+	return nil
+}
+
+// stopScrubber stops the scrubber, if it was started.
+func (a *Agent) stopScrubber() error {
+	if a.scrubber == nil {
+		return nil
+	}
+	return a.scrubber.Stop()
+}
@@ -1,24 +1,125 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"io"
+	"time"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
 )
 
+// ErrCorrupted is the error returned when stored data fails its integrity checks.
+var ErrCorrupted = errors.New("data is corrupted")
+
+// ErrWrongBackend is the error returned when opening a data directory that
+// was created by another backend.
+var ErrWrongBackend = errors.New("data directory was created by another backend")
+
 // RecordMetadata contains metadata about a record.
 type RecordMetadata struct {
 	Pos       uint64
+	Size      uint64
 	DeletedAt *int64
 }
 
+// SegmentStats contains statistics about a single segment of a backend.
+type SegmentStats struct {
+	ID         uint64
+	Keys       int
+	Tombstones int
+	Size       uint64
+	LiveBytes  uint64
+	DeadBytes  uint64
+	// Hinted is true if the segment's index can be loaded from a hint file.
+	Hinted bool
+}
+
+// Stats contains statistics about the data stored by a backend.
+type Stats struct {
+	Segments  []SegmentStats
+	OpenFiles int
+	// LastMerge is the time the last merge completed, zero if there was none
+	// since the backend was opened.
+	LastMerge time.Time
+	// RawBytes and StoredBytes are the sizes of the records written since
+	// the backend was opened, before and after compression.
+	RawBytes    uint64
+	StoredBytes uint64
+}
+
 // Backend is an interface for a key-value store backend.
 type Backend interface {
 	Has(key string) bool
-	Get(key string) (rec *ddbv1.Record, exists bool, err error)
+	Get(ctx context.Context, key string) (rec *ddbv1.Record, exists bool, err error)
 	GetMetadata(key string) (RecordMetadata, bool)
-	Set(rec *ddbv1.Record) error
+	// Keys returns the sorted keys of every record, including deleted ones.
+	Keys() []string
+	Set(ctx context.Context, rec *ddbv1.Record) error
 	Reader() io.Reader
-	Sync() error
+	Stats() Stats
+	Sync(ctx context.Context) error
 	Close() error
 }
+
+// Corruption describes a range of a segment that cannot be read back.
+type Corruption struct {
+	Segment uint64
+	Offset  uint64
+	Size    uint64
+	Err     error
+}
+
+// Scrubber is implemented by backends that can verify the integrity of all
+// the data they store.
+type Scrubber interface {
+	Scrub(ctx context.Context) ([]Corruption, error)
+}
+
+// Ranger is implemented by backends that can iterate over their keys in order.
+type Ranger interface {
+	// RangeKeys calls fn for the keys from start, included, to end, excluded,
+	// in ascending order, or descending if reverse is true, until fn returns
+	// an error, which is returned. An empty end is no bound. Like Keys,
+	// deleted keys are included.
+	RangeKeys(start, end string, reverse bool, fn func(key string) error) error
+}
+
+// Merger is implemented by backends that can reclaim the space occupied by
+// overwritten records and tombstones.
+type Merger interface {
+	Merge(ctx context.Context) error
+}
+
+// BackupManifest describes the files of a backup archive.
+type BackupManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Incremental is true if some files were left out of the archive because
+	// they did not change since the backup the archive was based on.
+	Incremental bool         `json:"incremental"`
+	Files       []BackupFile `json:"files"`
+}
+
+// BackupFile describes a file of a backup.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Included is false if the file is not in the archive, since it is
+	// unchanged from the backup the archive was based on.
+	Included bool `json:"included"`
+}
+
+// Backuper is implemented by backends that can write a consistent backup of
+// their data while serving requests. If since is not nil, the files that did
+// not change since that backup are left out.
+type Backuper interface {
+	Backup(ctx context.Context, w io.Writer, since *BackupManifest) (*BackupManifest, error)
+}
+
+// Importer is implemented by backends that can load records in bulk faster
+// than setting them one at a time. Records are read from next until it
+// returns io.EOF, and none of them are imported if it fails.
+type Importer interface {
+	Import(ctx context.Context, next func() (*ddbv1.Record, error)) (int, error)
+}
@@ -0,0 +1,343 @@
+// Package backendtest is a conformance suite every implementation of
+// backend.Backend must pass, so the database behaves the same on all of them.
+package backendtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// Suite describes the backend under test.
+type Suite struct {
+	// Open opens the backend storing its data in dir, which is empty the
+	// first time and reused when the backend is reopened.
+	Open func(t *testing.T, dir string) backend.Backend
+	// Persistent is true if the records of a closed backend are read back
+	// when it is reopened.
+	Persistent bool
+	// OpenFS opens the backend like Open, storing its files in fsys. The
+	// backend is crashed in the middle of its operations through fsys, and
+	// must read back the records synced before each crash. Backends that do
+	// not store their files in a vfs.FS leave it nil, and are not crashed.
+	OpenFS func(t *testing.T, fsys vfs.FS, dir string) backend.Backend
+}
+
+// Run runs every scenario of the suite, each on a new backend. The optional
+// interfaces of package backend are tested if the backend implements them.
+func (s Suite) Run(t *testing.T) {
+	tests := map[string]func(t *testing.T, b backend.Backend){
+		"set and get records":               testSetGet,
+		"overwrite records":                 testOverwrite,
+		"keep tombstones of deleted keys":   testTombstones,
+		"list keys in order":                testKeys,
+		"range keys in both directions":     testRangeKeys,
+		"merge without changing reads":      testMerge,
+		"import records in bulk":            testImport,
+		"scrub finds no corruption":         testScrub,
+		"account written bytes":             testStats,
+		"serve concurrent reads and writes": testConcurrent,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			b := s.Open(t, t.TempDir())
+			defer b.Close()
+			fn(t, b)
+		})
+	}
+	t.Run("reopen with the same records", s.testReopen)
+	t.Run("random operations match a model", s.testRandomOps)
+	t.Run("recover from crashes", s.testCrashes)
+}
+
+func set(t *testing.T, b backend.Backend, key, value string) *ddbv1.Record {
+	t.Helper()
+	rec := &ddbv1.Record{Timestamp: 1, Key: key, Value: []byte(value)}
+	require.NoError(t, b.Set(context.Background(), rec))
+	return rec
+}
+
+func del(t *testing.T, b backend.Backend, key string) *ddbv1.Record {
+	t.Helper()
+	deletedAt := int64(42)
+	rec := &ddbv1.Record{Timestamp: 2, Key: key, DeletedAt: &deletedAt}
+	require.NoError(t, b.Set(context.Background(), rec))
+	return rec
+}
+
+func requireRecord(t *testing.T, b backend.Backend, want *ddbv1.Record) {
+	t.Helper()
+	got, exists, err := b.Get(context.Background(), want.Key)
+	require.NoError(t, err)
+	require.True(t, exists, want.Key)
+	require.True(t, proto.Equal(want, got), "%s: got %v, want %v", want.Key, got, want)
+}
+
+func requireLive(t *testing.T, b backend.Backend, key string, live bool) {
+	t.Helper()
+	meta, exists := b.GetMetadata(key)
+	require.Equal(t, live, exists && meta.DeletedAt == nil, key)
+}
+
+func fill(t *testing.T, b backend.Backend, n int) []*ddbv1.Record {
+	t.Helper()
+	recs := make([]*ddbv1.Record, n)
+	for i := range recs {
+		recs[i] = set(t, b, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+	return recs
+}
+
+func testSetGet(t *testing.T, b backend.Backend) {
+	want := set(t, b, "foo", "hello world")
+	requireRecord(t, b, want)
+	require.True(t, b.Has("foo"))
+	meta, exists := b.GetMetadata("foo")
+	require.True(t, exists)
+	require.NotZero(t, meta.Size)
+	require.Nil(t, meta.DeletedAt)
+
+	chunked := &ddbv1.Record{Key: "large", Chunked: &ddbv1.ChunkedValue{Id: "id", Size: 10, Chunks: 2}}
+	require.NoError(t, b.Set(context.Background(), chunked))
+	requireRecord(t, b, chunked)
+
+	got, exists, err := b.Get(context.Background(), "missing")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Nil(t, got)
+	require.False(t, b.Has("missing"))
+	_, exists = b.GetMetadata("missing")
+	require.False(t, exists)
+}
+
+func testOverwrite(t *testing.T, b backend.Backend) {
+	fill(t, b, 100)
+	for i := 0; i < 100; i += 3 {
+		set(t, b, fmt.Sprintf("key-%04d", i), "overwritten")
+	}
+	for i := 0; i < 100; i++ {
+		want := fmt.Sprintf("value-%d", i)
+		if i%3 == 0 {
+			want = "overwritten"
+		}
+		requireRecord(t, b, &ddbv1.Record{Timestamp: 1, Key: fmt.Sprintf("key-%04d", i), Value: []byte(want)})
+	}
+	require.Len(t, b.Keys(), 100)
+}
+
+func testTombstones(t *testing.T, b backend.Backend) {
+	set(t, b, "foo", "bar")
+	tombstone := del(t, b, "foo")
+
+	require.True(t, b.Has("foo"))
+	meta, exists := b.GetMetadata("foo")
+	require.True(t, exists)
+	require.NotNil(t, meta.DeletedAt)
+	require.Equal(t, int64(42), *meta.DeletedAt)
+	requireRecord(t, b, tombstone)
+	require.Equal(t, []string{"foo"}, b.Keys())
+
+	set(t, b, "foo", "again")
+	requireLive(t, b, "foo", true)
+}
+
+func testKeys(t *testing.T, b backend.Backend) {
+	require.Empty(t, b.Keys())
+	for _, key := range []string{"b", "a", "d", "c"} {
+		set(t, b, key, "value")
+	}
+	del(t, b, "c")
+	require.Equal(t, []string{"a", "b", "c", "d"}, b.Keys())
+}
+
+func rangeKeys(t *testing.T, r backend.Ranger, start, end string, reverse bool) []string {
+	t.Helper()
+	var keys []string
+	require.NoError(t, r.RangeKeys(start, end, reverse, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	return keys
+}
+
+func testRangeKeys(t *testing.T, b backend.Backend) {
+	r, ok := b.(backend.Ranger)
+	if !ok {
+		t.Skip("backend does not implement backend.Ranger")
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		set(t, b, key, "value")
+	}
+	del(t, b, "b")
+
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, rangeKeys(t, r, "", "", false))
+	require.Equal(t, []string{"e", "d", "c", "b", "a"}, rangeKeys(t, r, "", "", true))
+	require.Equal(t, []string{"b", "c"}, rangeKeys(t, r, "b", "d", false))
+	require.Equal(t, []string{"c", "b"}, rangeKeys(t, r, "b", "d", true))
+	require.Equal(t, []string{"c", "d", "e"}, rangeKeys(t, r, "bb", "", false))
+	require.Equal(t, []string{"e", "d", "c"}, rangeKeys(t, r, "bb", "", true))
+	require.Empty(t, rangeKeys(t, r, "f", "", false))
+	require.Empty(t, rangeKeys(t, r, "c", "c", true))
+
+	stop := errors.New("stop")
+	var calls int
+	err := r.RangeKeys("", "", false, func(string) error {
+		calls++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+
+	// writes while ranging do not deadlock
+	require.NoError(t, r.RangeKeys("", "", false, func(key string) error {
+		set(t, b, key+"-copy", "value")
+		return nil
+	}))
+}
+
+func testMerge(t *testing.T, b backend.Backend) {
+	m, ok := b.(backend.Merger)
+	if !ok {
+		t.Skip("backend does not implement backend.Merger")
+	}
+	recs := fill(t, b, 200)
+	for i := 0; i < 200; i += 2 {
+		recs[i] = set(t, b, fmt.Sprintf("key-%04d", i), "overwritten")
+	}
+	for i := 0; i < 200; i += 5 {
+		del(t, b, fmt.Sprintf("key-%04d", i))
+	}
+	require.NoError(t, m.Merge(context.Background()))
+	require.False(t, b.Stats().LastMerge.IsZero())
+
+	for i, rec := range recs {
+		if i%5 == 0 {
+			requireLive(t, b, rec.Key, false)
+			continue
+		}
+		requireRecord(t, b, rec)
+	}
+	set(t, b, "after-merge", "value")
+	requireLive(t, b, "after-merge", true)
+}
+
+func testImport(t *testing.T, b backend.Backend) {
+	imp, ok := b.(backend.Importer)
+	if !ok {
+		t.Skip("backend does not implement backend.Importer")
+	}
+	set(t, b, "key-0000", "old")
+	recs := make([]*ddbv1.Record, 50)
+	for i := range recs {
+		recs[i] = &ddbv1.Record{Timestamp: 3, Key: fmt.Sprintf("key-%04d", i), Value: []byte("imported")}
+	}
+	next := func(recs []*ddbv1.Record, err error) func() (*ddbv1.Record, error) {
+		return func() (*ddbv1.Record, error) {
+			if len(recs) == 0 {
+				return nil, err
+			}
+			rec := recs[0]
+			recs = recs[1:]
+			return rec, nil
+		}
+	}
+
+	failed := errors.New("failed")
+	_, err := imp.Import(context.Background(), next(recs[1:], failed))
+	require.ErrorIs(t, err, failed)
+	require.Len(t, b.Keys(), 1, "records of a failed import were imported")
+
+	n, err := imp.Import(context.Background(), next(recs, io.EOF))
+	require.NoError(t, err)
+	require.Equal(t, len(recs), n)
+	for _, rec := range recs {
+		requireRecord(t, b, rec)
+	}
+}
+
+func testScrub(t *testing.T, b backend.Backend) {
+	s, ok := b.(backend.Scrubber)
+	if !ok {
+		t.Skip("backend does not implement backend.Scrubber")
+	}
+	fill(t, b, 100)
+	corruptions, err := s.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+}
+
+func testStats(t *testing.T, b backend.Backend) {
+	fill(t, b, 10)
+	del(t, b, "key-0000")
+	stats := b.Stats()
+	require.NotEmpty(t, stats.Segments)
+	require.NotZero(t, stats.RawBytes)
+	require.NotZero(t, stats.StoredBytes)
+	require.True(t, stats.LastMerge.IsZero())
+	var size uint64
+	for _, s := range stats.Segments {
+		size += s.Size
+	}
+	require.NotZero(t, size)
+	require.NoError(t, b.Sync(context.Background()))
+}
+
+func testConcurrent(t *testing.T, b backend.Backend) {
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				rec := &ddbv1.Record{Key: fmt.Sprintf("key-%d-%d", w, i), Value: []byte("value")}
+				if err := b.Set(context.Background(), rec); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, _, err := b.Get(context.Background(), fmt.Sprintf("key-%d-%d", w, i)); err != nil {
+					t.Error(err)
+					return
+				}
+				b.Keys()
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.Len(t, b.Keys(), 400)
+}
+
+func (s Suite) testReopen(t *testing.T) {
+	if !s.Persistent {
+		t.Skip("backend is not persistent")
+	}
+	dir := t.TempDir()
+	b := s.Open(t, dir)
+	recs := fill(t, b, 100)
+	tombstone := del(t, b, "key-0007")
+	require.NoError(t, b.Sync(context.Background()))
+	require.NoError(t, b.Close())
+
+	b = s.Open(t, dir)
+	defer b.Close()
+	for i, rec := range recs {
+		if i == 7 {
+			rec = tombstone
+		}
+		requireRecord(t, b, rec)
+	}
+	require.Len(t, b.Keys(), 100)
+}
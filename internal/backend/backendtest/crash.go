@@ -0,0 +1,245 @@
+package backendtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// crashOps is the number of operations the backend is crashed in.
+	crashOps = 80
+	// crashesPerOp is the number of random points each operation is crashed
+	// at, besides its end.
+	crashesPerOp = 3
+	// crashImportRecords is the number of records imported by imports.
+	crashImportRecords = 20
+)
+
+// crasher crashes the file system of a backend at random points of its
+// operations. Each operation of the file system that changes it is a point
+// a crash can happen at, right before the change. A crash keeps the data
+// and directory entries synced before it, and a random part of the data
+// appended to files since they were last synced, as vfs.MemFS.Crash does.
+//
+// Crash points are sampled among the changes made while an operation runs,
+// including the ones made in the background, so backends that flush or
+// compact in the background are crashed in the middle of their work too.
+type crasher struct {
+	fs *vfs.MemFS
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	// points is the number of crash points seen since the operation started,
+	// and crashes the file systems left by the sampled ones.
+	points  int
+	crashes []*vfs.MemFS
+}
+
+func newCrasher(rng *rand.Rand) *crasher {
+	return &crasher{fs: vfs.NewMemFS(), rng: rng}
+}
+
+// fault is the vfs.Fault of the file system of the backend.
+func (c *crasher) fault(op vfs.Op, _ string) error {
+	switch op {
+	case vfs.OpWrite, vfs.OpSync, vfs.OpTruncate, vfs.OpRename, vfs.OpRemove, vfs.OpMkdir:
+	default:
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// reservoir sampling, since the number of points is not known upfront
+	c.points++
+	switch {
+	case len(c.crashes) < crashesPerOp:
+		c.crashes = append(c.crashes, c.crash())
+	case c.rng.Intn(c.points) < crashesPerOp:
+		c.crashes[c.rng.Intn(crashesPerOp)] = c.crash()
+	}
+	return nil
+}
+
+// crash returns the file system left by a crash now. c.mu must be held.
+func (c *crasher) crash() *vfs.MemFS {
+	return c.fs.Crash(func(_ string, unsynced int) int {
+		return c.rng.Intn(unsynced + 1)
+	})
+}
+
+// start starts sampling the crash points of an operation.
+func (c *crasher) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.points, c.crashes = 0, nil
+}
+
+// end returns the file systems left by crashes at the sampled points of the
+// operation, and by a crash right after it.
+func (c *crasher) end() []*vfs.MemFS {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(c.crashes, c.crash())
+}
+
+// mkdirDurable creates dir in fsys along with its parents, syncing them so
+// they survive crashes.
+func mkdirDurable(t *testing.T, fsys vfs.FS, dir string) {
+	t.Helper()
+	require.NoError(t, fsys.MkdirAll(dir, 0o755))
+	for d := dir; ; d = filepath.Dir(d) {
+		require.NoError(t, vfs.SyncDir(fsys, d))
+		if filepath.Dir(d) == d {
+			return
+		}
+	}
+}
+
+// crashOp is an operation the backend is crashed in. It returns the records
+// that are either all or none recovered after a crash in the middle of it.
+type crashOp func(t *testing.T, b backend.Backend) []*ddbv1.Record
+
+// testCrashes runs random operations on the backend, crashing it at random
+// points of each of them: writes and deletes, each synced, merges, imports
+// and reopens. The backend must recover from each crash with the records
+// synced before the operation, and either all or none of the records the
+// operation wrote, and must accept writes afterwards.
+func (s Suite) testCrashes(t *testing.T) {
+	if s.OpenFS == nil {
+		t.Skip("backend does not store its files in a vfs.FS")
+	}
+	g := newGenerator(seeds[0])
+	dir := t.TempDir()
+	// the crasher has its own source, since it is used by the goroutines
+	// of the backend
+	c := newCrasher(rand.New(rand.NewSource(g.rng.Int63())))
+	mkdirDurable(t, c.fs, dir)
+	fsys := vfs.WithFaults(c.fs, c.fault)
+	b := s.OpenFS(t, fsys, dir)
+	defer func() { b.Close() }()
+	m := model{}
+
+	for i := 0; i < crashOps; i++ {
+		name, op := s.crashOp(g, fsys, dir, &b)
+		c.start()
+		written := op(t, b)
+		crashes := c.end()
+
+		prev := m.clone()
+		for _, rec := range written {
+			m[rec.Key] = rec
+		}
+		for j, crashed := range crashes {
+			t.Run(fmt.Sprintf("op %d %s crash %d", i, name, j), func(t *testing.T) {
+				verifyRecovery(t, s, crashed, dir, prev, written)
+			})
+		}
+	}
+}
+
+// crashOp returns a random operation to crash the backend in, and its name.
+// Reopening replaces the backend b points to.
+func (s Suite) crashOp(g *generator, fsys vfs.FS, dir string, b *backend.Backend) (string, crashOp) {
+	p := g.rng.Float64()
+	if merger, ok := (*b).(backend.Merger); ok && p < 0.08 {
+		return "merge", func(t *testing.T, _ backend.Backend) []*ddbv1.Record {
+			require.NoError(t, merger.Merge(context.Background()))
+			return nil
+		}
+	}
+	if importer, ok := (*b).(backend.Importer); ok && p >= 0.08 && p < 0.14 {
+		recs := make([]*ddbv1.Record, crashImportRecords)
+		for i := range recs {
+			recs[i] = g.record(0.25)
+		}
+		return "import", func(t *testing.T, _ backend.Backend) []*ddbv1.Record {
+			next := recs
+			n, err := importer.Import(context.Background(), func() (*ddbv1.Record, error) {
+				if len(next) == 0 {
+					return nil, io.EOF
+				}
+				rec := next[0]
+				next = next[1:]
+				return rec, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, len(recs), n)
+			return imported(recs)
+		}
+	}
+	if p >= 0.14 && p < 0.2 {
+		return "reopen", func(t *testing.T, _ backend.Backend) []*ddbv1.Record {
+			require.NoError(t, (*b).Close())
+			*b = s.OpenFS(t, fsys, dir)
+			return nil
+		}
+	}
+	rec := g.record(0.25)
+	return "set", func(t *testing.T, b backend.Backend) []*ddbv1.Record {
+		require.NoError(t, b.Set(context.Background(), rec))
+		require.NoError(t, b.Sync(context.Background()))
+		return []*ddbv1.Record{rec}
+	}
+}
+
+// imported returns the records an import leaves for each key: the last one
+// imported for it.
+func imported(recs []*ddbv1.Record) []*ddbv1.Record {
+	last := make(map[string]int, len(recs))
+	for i, rec := range recs {
+		last[rec.Key] = i
+	}
+	var kept []*ddbv1.Record
+	for i, rec := range recs {
+		if last[rec.Key] == i {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
+
+// verifyRecovery opens the backend on the file system left by a crash in the
+// middle of an operation that wrote recs, verifying that it holds the records
+// of prev, and either all or none of recs.
+func verifyRecovery(t *testing.T, s Suite, fsys vfs.FS, dir string, prev model, recs []*ddbv1.Record) {
+	t.Helper()
+	b := s.OpenFS(t, fsys, dir)
+	recovered := prev.clone()
+	if len(recs) > 0 {
+		// a live record tells whether the records were written, since the
+		// key of a tombstone may not have been live before either
+		witness := recs[0]
+		for _, rec := range recs {
+			if rec.DeletedAt == nil {
+				witness = rec
+				break
+			}
+		}
+		written, err := matches(b, witness.Key, witness)
+		require.NoError(t, err)
+		if written {
+			for _, rec := range recs {
+				recovered[rec.Key] = rec
+			}
+		}
+	}
+	recovered.verify(t, b)
+
+	next := &ddbv1.Record{Key: "after-crash", Value: []byte("value")}
+	require.NoError(t, b.Set(context.Background(), next))
+	require.NoError(t, b.Close())
+	recovered[next.Key] = next
+
+	b = s.OpenFS(t, fsys, dir)
+	defer b.Close()
+	recovered.verify(t, b)
+}
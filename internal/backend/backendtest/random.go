@@ -0,0 +1,146 @@
+package backendtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// randomKeys is the number of distinct keys random operations write,
+	// few enough for keys to be overwritten and deleted often.
+	randomKeys = 50
+	// randomValueBytes is the maximum size of the values of random records.
+	randomValueBytes = 300
+)
+
+// seeds are the seeds of the random operation sequences. A failing sequence
+// is reproduced by running its seed alone.
+var seeds = []int64{1, 2, 3}
+
+// model is the state a backend is expected to be in: the newest record
+// written for each key, tombstones included.
+type model map[string]*ddbv1.Record
+
+func (m model) clone() model {
+	c := make(model, len(m))
+	for key, rec := range m {
+		c[key] = rec
+	}
+	return c
+}
+
+// generator generates random records over a small set of keys.
+type generator struct {
+	rng       *rand.Rand
+	timestamp int64
+}
+
+func newGenerator(seed int64) *generator {
+	return &generator{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (g *generator) key() string {
+	return fmt.Sprintf("key-%02d", g.rng.Intn(randomKeys))
+}
+
+// record returns a new record of a random key, a tombstone with the given
+// probability.
+func (g *generator) record(deleteRate float64) *ddbv1.Record {
+	g.timestamp++
+	rec := &ddbv1.Record{Timestamp: g.timestamp, Key: g.key()}
+	if g.rng.Float64() < deleteRate {
+		deletedAt := g.timestamp
+		rec.DeletedAt = &deletedAt
+		return rec
+	}
+	rec.Value = make([]byte, g.rng.Intn(randomValueBytes+1))
+	g.rng.Read(rec.Value)
+	return rec
+}
+
+// matches returns true if the backend has the given record for its key, or
+// has no live record for it if rec is nil or a tombstone.
+func matches(b backend.Backend, key string, rec *ddbv1.Record) (bool, error) {
+	if rec == nil || rec.DeletedAt != nil {
+		meta, exists := b.GetMetadata(key)
+		return !exists || meta.DeletedAt != nil, nil
+	}
+	got, exists, err := b.Get(context.Background(), key)
+	if err != nil || !exists {
+		return false, err
+	}
+	return proto.Equal(rec, got), nil
+}
+
+// verify checks that the backend holds the records of the model. Deleted
+// keys may or may not be listed by Keys, depending on whether their
+// tombstones were merged.
+func (m model) verify(t *testing.T, b backend.Backend) {
+	t.Helper()
+	for key, rec := range m {
+		ok, err := matches(b, key, rec)
+		require.NoError(t, err, key)
+		require.True(t, ok, "%s: want %v", key, rec)
+	}
+
+	keys := b.Keys()
+	require.True(t, sort.StringsAreSorted(keys), "keys are not sorted")
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		_, written := m[key]
+		require.True(t, written, "%s was never written", key)
+		listed[key] = true
+	}
+	for key, rec := range m {
+		if rec.DeletedAt == nil {
+			require.True(t, listed[key], "%s is not listed", key)
+		}
+	}
+	require.False(t, b.Has("never-written"))
+}
+
+// testRandomOps runs random sequences of writes, deletes, merges and
+// reopens, verifying the backend against a model after each of them.
+func (s Suite) testRandomOps(t *testing.T) {
+	for _, seed := range seeds {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			g := newGenerator(seed)
+			dir := t.TempDir()
+			b := s.Open(t, dir)
+			defer func() { b.Close() }()
+			m := model{}
+
+			for i := 0; i < 500; i++ {
+				switch p := g.rng.Float64(); {
+				case p < 0.03:
+					merger, ok := b.(backend.Merger)
+					if !ok {
+						continue
+					}
+					require.NoError(t, merger.Merge(context.Background()), "op %d", i)
+				case p < 0.06:
+					if !s.Persistent {
+						continue
+					}
+					require.NoError(t, b.Close(), "op %d", i)
+					b = s.Open(t, dir)
+				case p < 0.1:
+					m.verify(t, b)
+				default:
+					rec := g.record(0.25)
+					require.NoError(t, b.Set(context.Background(), rec), "op %d", i)
+					m[rec.Key] = rec
+				}
+			}
+			m.verify(t, b)
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package bitcask
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+// BackupManifestName is the name of the manifest in a backup archive, which
+// is always its last entry.
+const BackupManifestName = "backup-manifest.json"
+
+// restoreExt is appended to the files being restored until they are all verified.
+const restoreExt = ".restore"
+
+// backupFileName matches the names of the files of a backup.
+var backupFileName = regexp.MustCompile(`^[0-9]+\.(store|hint)$`)
+
+// filterFileName matches the names of the filter files, which are not backed
+// up since they are built again from the hint files.
+var filterFileName = regexp.MustCompile(`^([0-9]+)\.filter$`)
+
+// Backup writes a tar archive with the stores and hint files of the log to w,
+// followed by a manifest with their checksums. The active segment is rotated
+// first, so the archive only contains immutable segments, and merges wait for
+// the backup to finish so the segments are not replaced while being read.
+func (b *Bitcask) Backup(ctx context.Context, w io.Writer, since *backend.BackupManifest) (*backend.BackupManifest, error) {
+	ctx, span := tracer.Start(ctx, "bitcask.Backup")
+	defer span.End()
+	b.maintenance.Lock()
+	defer b.maintenance.Unlock()
+
+	segments, err := b.pin()
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	previous := make(map[string]backend.BackupFile)
+	if since != nil {
+		for _, f := range since.Files {
+			previous[f.Name] = f
+		}
+	}
+	manifest := &backend.BackupManifest{CreatedAt: time.Now().UTC()}
+	tw := tar.NewWriter(w)
+	for _, s := range segments {
+		hint, err := vfs.Open(b.Config.FS, s.hintPath)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		hintInfo, err := hint.Stat()
+		if err != nil {
+			hint.Close()
+			recordError(span, err)
+			return nil, err
+		}
+		files := []struct {
+			name string
+			r    io.ReaderAt
+			size int64
+		}{
+			{filepath.Base(s.store.Name()), s.store, int64(s.store.size)},
+			{filepath.Base(s.hintPath), hint, hintInfo.Size()},
+		}
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				hint.Close()
+				return nil, err
+			}
+			file, err := backupFile(tw, f.name, f.r, f.size, previous[f.name])
+			if err != nil {
+				hint.Close()
+				recordError(span, err)
+				return nil, err
+			}
+			manifest.Incremental = manifest.Incremental || !file.Included
+			manifest.Files = append(manifest.Files, file)
+		}
+		hint.Close()
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    BackupManifestName,
+		Mode:    int64(hintFileMode),
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// pin makes every segment immutable, rotating the active segment if it has
+// records, and returns them.
+func (b *Bitcask) pin() ([]*segment, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.activeSegment.store.size > 0 {
+		if err := b.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	segments := b.segments[:len(b.segments)-1]
+	for _, s := range segments {
+		if err := s.WriteHint(); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// backupFile writes the first size bytes of r to the archive, unless they
+// match the previous backup of the file.
+func backupFile(tw *tar.Writer, name string, r io.ReaderAt, size int64, prev backend.BackupFile) (backend.BackupFile, error) {
+	file := backend.BackupFile{Name: name, Size: size}
+	if prev.Size == size {
+		sum, err := checksum(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return file, err
+		}
+		if sum == prev.SHA256 {
+			file.SHA256 = sum
+			return file, nil
+		}
+	}
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(storeFileMode),
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return file, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), io.NewSectionReader(r, 0, size)); err != nil {
+		return file, err
+	}
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	file.Included = true
+	return file, nil
+}
+
+// Restore extracts a backup archive into dir. An incremental backup must be
+// restored over the backup it was based on. The files of the segments that
+// are not in the backup are removed from dir. Nothing but its lock file is
+// changed in dir unless every file of the backup is verified, and it fails
+// with vfs.ErrLocked if a log is open in dir.
+func Restore(dir string, r io.Reader) (*backend.BackupManifest, error) {
+	return restore(vfs.OS, dir, r)
+}
+
+func restore(fsys vfs.FS, dir string, r io.Reader) (*backend.BackupManifest, error) {
+	if err := fsys.MkdirAll(dir, mergeDirMode); err != nil {
+		return nil, err
+	}
+	lock, err := fsys.Lock(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	var manifest *backend.BackupManifest
+	extracted := make(map[string]string)
+	cleanup := func() {
+		for name := range extracted {
+			fsys.Remove(filepath.Join(dir, name+restoreExt))
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		switch {
+		case header.Name == BackupManifestName:
+			manifest = &backend.BackupManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+		case backupFileName.MatchString(header.Name):
+			extracted[header.Name], err = extract(fsys, filepath.Join(dir, header.Name+restoreExt), tr)
+		default:
+			err = fmt.Errorf("unexpected file in backup: %q", header.Name)
+		}
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	if manifest == nil {
+		cleanup()
+		return nil, fmt.Errorf("backup has no %s", BackupManifestName)
+	}
+
+	if err := verifyBackup(fsys, dir, manifest, extracted); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := applyBackup(fsys, dir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func extract(fsys vfs.FS, name string, r io.Reader) (sum string, err error) {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, storeFileMode)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), f.Sync()
+}
+
+// verifyBackup checks the extracted files and the files the backup is based
+// on match the manifest.
+func verifyBackup(fsys vfs.FS, dir string, manifest *backend.BackupManifest, extracted map[string]string) error {
+	for _, file := range manifest.Files {
+		if !backupFileName.MatchString(file.Name) {
+			return fmt.Errorf("unexpected file in backup manifest: %q", file.Name)
+		}
+		sum, ok := extracted[file.Name]
+		if !file.Included {
+			f, err := vfs.Open(fsys, filepath.Join(dir, file.Name))
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s is missing, restore the backup this one is based on first", file.Name)
+			}
+			if err != nil {
+				return err
+			}
+			sum, err = checksum(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		} else if !ok {
+			return fmt.Errorf("%s is missing from the backup", file.Name)
+		}
+		if sum != file.SHA256 {
+			return fmt.Errorf("%s does not match its checksum in the backup manifest", file.Name)
+		}
+	}
+	return nil
+}
+
+// applyBackup moves the restored files in place, removes the segments that
+// are not part of the backup and lists the restored ones in the manifest. The
+// filters of the segments restored or removed are removed too, so they are
+// built again for the restored ones.
+func applyBackup(fsys vfs.FS, dir string, manifest *backend.BackupManifest) error {
+	keep := make(map[string]bool)
+	restored := make(map[string]bool)
+	for _, file := range manifest.Files {
+		keep[file.Name] = true
+		if !file.Included {
+			continue
+		}
+		restored[strings.TrimSuffix(file.Name, filepath.Ext(file.Name))] = true
+		name := filepath.Join(dir, file.Name)
+		if err := fsys.Rename(name+restoreExt, name); err != nil {
+			return err
+		}
+	}
+
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if m := filterFileName.FindStringSubmatch(name); m != nil {
+			if keep[m[1]+storeExt] && !restored[m[1]] {
+				continue
+			}
+		} else if keep[name] || !backupFileName.MatchString(name) {
+			continue
+		}
+		if err := fsys.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if _, err := writeStoresManifest(fsys, dir); err != nil {
+		return err
+	}
+	return vfs.SyncDir(fsys, dir)
+}
+
+// ReadBackupManifest returns the manifest of a backup archive.
+func ReadBackupManifest(r io.Reader) (*backend.BackupManifest, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("backup has no %s", BackupManifestName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name != BackupManifestName {
+			continue
+		}
+		manifest := &backend.BackupManifest{}
+		return manifest, json.NewDecoder(tr).Decode(manifest)
+	}
+}
+
+func checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,147 @@
+package bitcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	tests := map[string]func(t *testing.T, log *Bitcask){
+		"restores a full backup":                       testBackupFull,
+		"restores an incremental backup over its base": testBackupIncremental,
+		"refuses an incremental backup without base":   testBackupIncrementalWithoutBase,
+		"refuses a corrupted backup":                   testBackupCorrupted,
+		"restores over existing segments":              testBackupOverSegments,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			log, err := NewBitcaskBackend(t.TempDir(), recoveryConfig())
+			require.NoError(t, err)
+			defer log.Close()
+			fn(t, log)
+		})
+	}
+}
+
+func backup(t *testing.T, log *Bitcask, since *backend.BackupManifest) (*bytes.Buffer, *backend.BackupManifest) {
+	t.Helper()
+	var buf bytes.Buffer
+	manifest, err := log.Backup(context.Background(), &buf, since)
+	require.NoError(t, err)
+	return &buf, manifest
+}
+
+func restored(t *testing.T, dir string) *Bitcask {
+	t.Helper()
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func testBackupFull(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 3)
+	archive, manifest := backup(t, log, nil)
+	require.False(t, manifest.Incremental)
+
+	read, err := ReadBackupManifest(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, manifest.Files, read.Files)
+
+	dir := t.TempDir()
+	_, err = Restore(dir, archive)
+	require.NoError(t, err)
+	requireVersions(t, restored(t, dir), 20, 3)
+}
+
+// testBackupOverSegments restores a backup into a directory whose segments
+// have the same ids and number of keys as the restored ones, and filters
+// persisted for their keys.
+func testBackupOverSegments(t *testing.T, log *Bitcask) {
+	dir := t.TempDir()
+	existing, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, existing.Set(context.Background(), &ddbv1.Record{
+			Key:   fmt.Sprintf("old-%d", i),
+			Value: []byte("hello world"),
+		}))
+	}
+	require.NoError(t, existing.Close())
+	require.FileExists(t, path.Join(dir, "1"+filterExt))
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Set(context.Background(), &ddbv1.Record{
+			Key:   fmt.Sprintf("key-%d", i),
+			Value: []byte("hello world"),
+		}))
+	}
+	archive, _ := backup(t, log, nil)
+	_, err = Restore(dir, archive)
+	require.NoError(t, err)
+
+	restored := restored(t, dir)
+	requireKeys(t, restored, 10)
+	require.False(t, restored.Has("old-0"))
+}
+
+func testBackupIncremental(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 3)
+	full, base := backup(t, log, nil)
+	writeVersions(t, log, 20, 5)
+	incremental, manifest := backup(t, log, base)
+	require.True(t, manifest.Incremental)
+	included := 0
+	for _, f := range manifest.Files {
+		if f.Included {
+			included++
+		}
+	}
+	require.Less(t, included, len(manifest.Files))
+
+	dir := t.TempDir()
+	_, err := Restore(dir, full)
+	require.NoError(t, err)
+	_, err = Restore(dir, incremental)
+	require.NoError(t, err)
+	requireVersions(t, restored(t, dir), 20, 5)
+}
+
+func testBackupIncrementalWithoutBase(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 3)
+	_, base := backup(t, log, nil)
+	writeVersions(t, log, 20, 1)
+	incremental, _ := backup(t, log, base)
+
+	dir := t.TempDir()
+	_, err := Restore(dir, incremental)
+	require.ErrorContains(t, err, "restore the backup this one is based on first")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, lockFile, entries[0].Name())
+}
+
+func testBackupCorrupted(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 3)
+	archive, _ := backup(t, log, nil)
+	// the first store starts right after the header of its tar entry
+	data := archive.Bytes()
+	data[512+storeHeaderSize+2] ^= 0xff
+
+	dir := t.TempDir()
+	_, err := Restore(dir, bytes.NewReader(data))
+	require.ErrorContains(t, err, "does not match its checksum")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, lockFile, entries[0].Name())
+}
@@ -1,30 +1,62 @@
 package bitcask
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"os"
 	"path"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
 	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
 
+// filesPerSegment is the number of files kept open by each segment: its store.
+const filesPerSegment = 1
+
 type Bitcask struct {
 	mu sync.RWMutex
+	// maintenance serializes merges, scrubs, backups and imports, which
+	// read or write segments without holding mu.
+	maintenance sync.Mutex
 
 	Dir    string
 	Config Config
 
 	activeSegment *segment
 	segments      []*segment
+	lastMerge     time.Time
+	// rawBytes and storedBytes account the compression of the records
+	// written to segments that were since replaced by merges, and of
+	// imported records, which are not in the counters of their segments.
+	rawBytes    uint64
+	storedBytes uint64
+	// ordered holds the keys of all segments in order when
+	// Config.OrderedKeys is set, and is nil otherwise.
+	ordered *orderedKeys
+	// manifest lists the segments of the log, and lock is held on its
+	// lock file until the log is closed.
+	manifest manifest
+	lock     io.Closer
+	logger   *zerolog.Logger
 }
 
-var _ backend.Backend = (*Bitcask)(nil)
+var (
+	_ backend.Backend  = (*Bitcask)(nil)
+	_ backend.Scrubber = (*Bitcask)(nil)
+	_ backend.Merger   = (*Bitcask)(nil)
+	_ backend.Backuper = (*Bitcask)(nil)
+	_ backend.Importer = (*Bitcask)(nil)
+	_ backend.Ranger   = (*Bitcask)(nil)
+)
 
 // NewBitcaskBackend creates a new Bitcask backend.
 func NewBitcaskBackend(dir string, c Config) (*Bitcask, error) {
@@ -34,49 +66,161 @@ func NewBitcaskBackend(dir string, c Config) (*Bitcask, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1e+8 // 100MB
 	}
+	c.FS = c.fs()
+	logger := log.With().Str("component", "bitcask").Logger()
 	bitcask := &Bitcask{
 		Dir:    dir,
 		Config: c,
+		logger: &logger,
 	}
 	err := bitcask.setup()
 	return bitcask, err
 }
 
+// setup locks the log directory and opens the segments of its manifest.
 func (b *Bitcask) setup() error {
-	files, err := os.ReadDir(b.Dir)
+	lock, err := b.Config.FS.Lock(path.Join(b.Dir, lockFile))
 	if err != nil {
 		return err
 	}
+	b.lock = lock
+	if err := b.load(); err != nil {
+		b.closeSegments()
+		b.unlock()
+		return err
+	}
+	return nil
+}
 
-	var ids []uint64
-	for _, file := range files {
-		idStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
-		id, err := strconv.ParseUint(idStr, 10, 0)
-		if err != nil {
+func (b *Bitcask) load() error {
+	m, exists, err := readManifest(b.Config.FS, b.Dir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if m, err = writeStoresManifest(b.Config.FS, b.Dir); err != nil {
+			return err
+		}
+	}
+	b.manifest = m
+	for _, dir := range []string{mergeDir, importDir} {
+		if err := b.recoverCommit(dir); err != nil {
 			return err
 		}
-		ids = append(ids, id)
 	}
-	slices.Sort(ids)
+	if err := b.removeLeftovers(); err != nil {
+		return err
+	}
 
-	for i := 0; i < len(ids); i++ {
-		if err = b.newSegment(ids[i]); err != nil {
+	ids := b.manifest.Segments
+	for i, id := range ids {
+		active := i == len(ids)-1
+		// the store of the active segment is created after it is listed
+		if !active {
+			if _, err := b.Config.FS.Stat(path.Join(b.Dir, fmt.Sprintf("%d%s", id, storeExt))); err != nil {
+				return fmt.Errorf("segment %d listed in %s: %w", id, manifestFile, err)
+			}
+		}
+		if err = b.openSegment(id, active); err != nil {
 			return err
 		}
-		i += 1 // skip hint files
 	}
 	if b.segments == nil {
 		if err = b.newSegment(1); err != nil {
 			return err
 		}
 	}
+	b.markShadowed()
+	if b.Config.OrderedKeys {
+		if b.ordered, err = buildOrderedKeys(b.segments); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	// records are appended with the format and the key of the active
+	// segment, so a new one is needed when it has an older format, when the
+	// current key was rotated, or when a crash left the active segment full
+	// before it was rotated.
+	stale, err := b.isStale(b.activeSegment)
+	if err != nil || !stale && !b.activeSegment.IsMaxed() {
+		return err
+	}
+	return b.rotate()
+}
+
+// isStale returns true if the segment has an older format version, or if
+// encryption is enabled and the segment is not encrypted with the current key.
+func (b *Bitcask) isStale(s *segment) (bool, error) {
+	if s.Version() < formatVersion {
+		return true, nil
+	}
+	if b.Config.Keyring == nil {
+		return false, nil
+	}
+	keyID, err := b.Config.Keyring.CurrentKeyID()
+	if err != nil {
+		return false, err
+	}
+	return s.KeyID() != keyID, nil
+}
+
+// markShadowed accounts the live records of older segments that were
+// overwritten by records in newer segments as dead bytes.
+func (b *Bitcask) markShadowed() {
+	for i, segment := range b.segments {
+		markShadowed(segment, b.segments[i+1:])
+	}
 }
 
+// newSegment lists a new segment in the manifest, then opens it as the
+// active segment.
 func (b *Bitcask) newSegment(id uint64) error {
+	m := b.manifest.add(id)
+	if err := writeManifest(b.Config.FS, b.Dir, m); err != nil {
+		return err
+	}
+	b.manifest = m
+	return b.openSegment(id, true)
+}
+
+// removeLeftovers removes the files of the segments the manifest does not
+// list, and the temporary files of the segments it lists. Other files are
+// not files of the log, and are left untouched.
+func (b *Bitcask) removeLeftovers() error {
+	entries, err := b.Config.FS.ReadDir(b.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, tmp, ok := parseSegmentFile(e.Name())
+		if !ok || !tmp && slices.Contains(b.manifest.Segments, id) {
+			continue
+		}
+		b.logger.Warn().Str("file", e.Name()).Msg("removing leftover file")
+		if err := b.Config.FS.Remove(path.Join(b.Dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSegment opens the segment with the given id, recovering it if it is
+// corrupted. Only the tail of the active segment is recovered automatically,
+// since a crash can leave its last append incomplete. Other corruptions are
+// only recovered if Config.Repair is set. Immutable segments are mapped in memory.
+func (b *Bitcask) openSegment(id uint64, active bool) error {
 	s, err := newSegment(b.Dir, id, b.Config)
+	var corrupt *CorruptionError
+	if errors.As(err, &corrupt) {
+		err = b.recover(s, corrupt, active)
+	}
+	if err == nil && !active {
+		err = s.Freeze()
+	}
 	if err != nil {
+		if s != nil {
+			s.Close()
+		}
 		return err
 	}
 	b.segments = append(b.segments, s)
@@ -84,10 +228,58 @@ func (b *Bitcask) newSegment(id uint64) error {
 	return nil
 }
 
+func (b *Bitcask) recover(s *segment, corrupt *CorruptionError, active bool) error {
+	torn, err := s.isTornTail(corrupt)
+	if err != nil {
+		return err
+	}
+	discarded := s.store.size - corrupt.Offset
+	logger := b.logger.Warn().
+		Uint64("segment", s.id).
+		Uint64("offset", corrupt.Offset).
+		Uint64("discarded_bytes", discarded).
+		AnErr("reason", corrupt.Reason)
+
+	switch {
+	case active && torn:
+		if _, err := s.Truncate(corrupt.Offset, false); err != nil {
+			return err
+		}
+		logger.Msg("truncated incomplete write at the end of the active segment")
+	case b.Config.Repair:
+		saved, err := s.Truncate(corrupt.Offset, true)
+		if err != nil {
+			return err
+		}
+		logger.Str("saved_to", saved).Msg("repaired corrupted segment by truncating it")
+	default:
+		return fmt.Errorf("segment %d: %w (enable repair to truncate it)", s.id, corrupt)
+	}
+	// a store truncated up to its torn headers gets new ones
+	return s.setupStore()
+}
+
+func (b *Bitcask) closeSegments() {
+	for _, s := range b.segments {
+		s.Close()
+	}
+	b.segments = nil
+	b.activeSegment = nil
+}
+
 // Keys returns a slice of the keys of all records stored in the log.
 func (b *Bitcask) Keys() []string {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
+	if b.ordered != nil {
+		tree := b.ordered.snapshot()
+		keys := make([]string, 0, tree.Len())
+		tree.Ascend(func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
 	keys := make(map[string]bool)
 	for _, segment := range b.segments {
 		for _, key := range segment.Keys() {
@@ -101,71 +293,189 @@ func (b *Bitcask) Keys() []string {
 
 // Has returns true if the key exists in the log.
 func (b *Bitcask) Has(key string) bool {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	for i := len(b.segments) - 1; i >= 0; i-- {
-		if b.segments[i].Has(key) {
-			return true
-		}
-	}
-	return false
+	_, exists := b.GetMetadata(key)
+	return exists
 }
 
 // Get returns a record by key.
-func (b *Bitcask) Get(key string) (rec *ddbv1.Record, exists bool, err error) {
+func (b *Bitcask) Get(ctx context.Context, key string) (rec *ddbv1.Record, exists bool, err error) {
+	_, span := tracer.Start(ctx, "bitcask.Get")
+	defer span.End()
+
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	for i := len(b.segments) - 1; i >= 0; i-- {
 		rec, exists, err = b.segments[i].Get(key)
 		if err != nil {
+			recordError(span, err)
 			return nil, false, err
 		}
 		if !exists {
 			continue
 		}
+		span.SetAttributes(attribute.Int64("bitcask.segment", int64(b.segments[i].id)))
 		return rec, true, nil
 	}
 	return nil, false, nil
 }
 
-// GetMetadata returns the metadata for a key.
+// GetMetadata returns the metadata for a key. A hashed key that cannot be read
+// back from the store is logged and reported as missing.
 func (b *Bitcask) GetMetadata(key string) (entry backend.RecordMetadata, exists bool) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
+	_, meta, exists, err := b.lookup(key)
+	if err != nil {
+		b.logger.Error().Err(err).Str("key", key).Msg("failed to look up key")
+		return backend.RecordMetadata{}, false
+	}
+	return meta, exists
+}
+
+// lookup returns the newest segment containing the given key and its metadata.
+func (b *Bitcask) lookup(key string) (s *segment, meta backend.RecordMetadata, exists bool, err error) {
 	for i := len(b.segments) - 1; i >= 0; i-- {
-		meta, exists := b.segments[i].GetMetadata(key)
-		if exists {
-			return meta, true
+		if meta, exists, err = b.segments[i].GetMetadata(key); err != nil || exists {
+			return b.segments[i], meta, exists, err
 		}
 	}
-	return backend.RecordMetadata{}, false
+	return nil, backend.RecordMetadata{}, false, nil
 }
 
 // Set appends a record to the log and updates the in-memory index.
-func (b *Bitcask) Set(rec *ddbv1.Record) error {
+func (b *Bitcask) Set(ctx context.Context, rec *ddbv1.Record) error {
+	ctx, span := tracer.Start(ctx, "bitcask.Set")
+	defer span.End()
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if err := b.activeSegment.Append(rec); err != nil {
+	owner, prev, shadowed, err := b.lookup(rec.Key)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+	if err := b.append(ctx, rec); err != nil {
+		recordError(span, err)
 		return err
 	}
+	// overwrites within the active segment are accounted by the segment itself
+	if shadowed && owner != b.activeSegment && prev.DeletedAt == nil {
+		owner.deadBytes += prev.Size
+	}
+	if !shadowed {
+		b.ordered.Add(rec.Key)
+	}
 	if b.activeSegment.IsMaxed() {
-		if err := b.newSegment(b.activeSegment.id + 1); err != nil {
+		span.AddEvent("rotating active segment")
+		if err := b.rotate(); err != nil {
+			recordError(span, err)
 			return err
 		}
 	}
 	return nil
 }
 
-// Sync flushes all pending log writes to disk.
-func (b *Bitcask) Sync() error {
-	return b.activeSegment.Sync()
+// rotate makes the active segment immutable, persisting its hint, and opens a new active segment.
+func (b *Bitcask) rotate() error {
+	if err := b.activeSegment.WriteHint(); err != nil {
+		return err
+	}
+	if err := b.activeSegment.Freeze(); err != nil {
+		return err
+	}
+	return b.newSegment(b.activeSegment.id + 1)
 }
 
-// Close closes the log.
-func (b *Bitcask) Close() error {
+func (b *Bitcask) append(ctx context.Context, rec *ddbv1.Record) error {
+	_, span := tracer.Start(ctx, "store.Append", trace.WithAttributes(
+		attribute.Int64("bitcask.segment", int64(b.activeSegment.id)),
+	))
+	defer span.End()
+	if err := b.activeSegment.Append(rec); err != nil {
+		recordError(span, err)
+		return err
+	}
+	return nil
+}
+
+// Stats returns statistics about the segments of the log.
+func (b *Bitcask) Stats() backend.Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := backend.Stats{
+		Segments:    make([]backend.SegmentStats, len(b.segments)),
+		OpenFiles:   filesPerSegment * len(b.segments),
+		LastMerge:   b.lastMerge,
+		RawBytes:    b.rawBytes,
+		StoredBytes: b.storedBytes,
+	}
+	for i, segment := range b.segments {
+		stats.Segments[i] = segment.Stats()
+		stats.RawBytes += segment.store.rawBytes
+		stats.StoredBytes += segment.store.storedBytes
+	}
+	return stats
+}
+
+// Scrub verifies the checksum of every record in the log, returning the
+// corrupted ranges found. Writes are not blocked while the log is scrubbed.
+func (b *Bitcask) Scrub(ctx context.Context) ([]backend.Corruption, error) {
+	ctx, span := tracer.Start(ctx, "bitcask.Scrub")
+	defer span.End()
+	b.maintenance.Lock()
+	defer b.maintenance.Unlock()
+
+	b.mu.RLock()
+	segments := slices.Clone(b.segments)
+	b.mu.RUnlock()
+
+	var corruptions []backend.Corruption
+	for _, segment := range segments {
+		corruption, err := segment.Scrub(ctx)
+		if err != nil {
+			recordError(span, err)
+			return corruptions, err
+		}
+		if corruption != nil {
+			corruptions = append(corruptions, *corruption)
+		}
+	}
+	span.SetAttributes(attribute.Int("bitcask.corruptions", len(corruptions)))
+	return corruptions, nil
+}
+
+// Sync flushes all pending log writes to disk. Writes are not blocked while
+// the active segment is synced, so they can be batched into the next sync.
+// Segments that were rotated out were already synced when their hint was written.
+func (b *Bitcask) Sync(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "store.Sync")
+	defer span.End()
+	defer observeSince(syncDuration, time.Now())
+	b.mu.RLock()
+	active := b.activeSegment
+	b.mu.RUnlock()
+	if err := active.Sync(); err != nil {
+		recordError(span, err)
+		return err
+	}
+	return nil
+}
+
+// Close flushes the log to disk, writes the hint files that are missing and
+// closes the log, unlocking its directory.
+func (b *Bitcask) Close() (err error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	// the directory is unlocked even if closing fails, so it can be opened again
+	defer func() {
+		if uerr := b.unlock(); err == nil {
+			err = uerr
+		}
+	}()
 	for _, segment := range b.segments {
+		if err := segment.WriteHint(); err != nil {
+			return err
+		}
 		if err := segment.Close(); err != nil {
 			return err
 		}
@@ -173,12 +483,22 @@ func (b *Bitcask) Close() error {
 	return nil
 }
 
+// unlock releases the lock of the log directory, if it is held.
+func (b *Bitcask) unlock() error {
+	if b.lock == nil {
+		return nil
+	}
+	err := b.lock.Close()
+	b.lock = nil
+	return err
+}
+
 // Remove closes the log and then removes its data from the filesystem.
 func (b *Bitcask) Remove() error {
 	if err := b.Close(); err != nil {
 		return err
 	}
-	return os.RemoveAll(b.Dir)
+	return b.Config.FS.RemoveAll(b.Dir)
 }
 
 // Reset removes the log and re-creates it.
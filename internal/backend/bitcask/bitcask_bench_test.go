@@ -2,7 +2,10 @@
 package bitcask
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -25,6 +28,7 @@ func BenchmarkBitcaskSet1000Batch100(b *testing.B) { benchmark(b, 1000, 100) }
 func benchmark(b *testing.B, dataSize, batchSize int) {
 	b.Helper()
 	b.ReportAllocs()
+	ctx := context.Background()
 	tempdir := b.TempDir()
 
 	config := Config{}
@@ -45,11 +49,53 @@ func benchmark(b *testing.B, dataSize, batchSize int) {
 	for i := 0; i < b.N; i++ {
 		record.Key = fmt.Sprint(i)
 		b.SetBytes(int64(proto.Size(record)))
-		err := db.Set(record)
+		err := db.Set(ctx, record)
 		require.NoError(b, err)
 		if batchSize > 0 && i%batchSize == 0 {
-			db.Sync()
+			db.Sync(ctx)
 		}
 	}
 	b.StopTimer()
 }
+
+func BenchmarkBitcaskOpenHinted(b *testing.B)       { benchmarkOpen(b, Config{}, true) }
+func BenchmarkBitcaskOpenScanned(b *testing.B)      { benchmarkOpen(b, Config{}, false) }
+func BenchmarkBitcaskOpenHintedHashed(b *testing.B) { benchmarkOpen(b, Config{HashKeys: true}, true) }
+
+// benchmarkOpen measures the startup time of a log of a million keys, with or
+// without hint files to load its index from.
+func benchmarkOpen(b *testing.B, config Config, hinted bool) {
+	b.Helper()
+	const keys = 1 << 20
+	ctx := context.Background()
+	tempdir := b.TempDir()
+	config.Segment.MaxIndexBytes = 1e+8 // 100MB
+	config.Segment.MaxStoreBytes = 1e+7 // 10MB
+
+	db, err := NewBitcaskBackend(tempdir, config)
+	require.NoError(b, err)
+	record := &ddbv1.Record{Value: []byte(strings.Repeat("a", 50))}
+	for i := 0; i < keys; i++ {
+		record.Key = benchmarkKey(i)
+		require.NoError(b, db.Set(ctx, record))
+	}
+	require.NoError(b, db.Close())
+	if !hinted {
+		hints, err := filepath.Glob(filepath.Join(tempdir, "*"+hintExt))
+		require.NoError(b, err)
+		for _, name := range hints {
+			require.NoError(b, os.Remove(name))
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db, err := NewBitcaskBackend(tempdir, config)
+		require.NoError(b, err)
+		b.StopTimer()
+		require.True(b, db.Has(benchmarkKey(keys-1)))
+		require.NoError(b, db.Close())
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/keys, "ns/key")
+}
@@ -1,6 +1,8 @@
 package bitcask
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
@@ -12,6 +14,9 @@ func TestCommitLog(t *testing.T) {
 	tests := map[string]func(t *testing.T, log *Bitcask){
 		"append and read a record succeeds": testAppendGet,
 		"init with existing segments":       testInitExisting,
+		"stats track live and dead bytes":   testStats,
+		"maps immutable segments in memory": testMapped,
+		"keeps only hashes of keys":         testHashedKeys,
 	}
 	for scenario, fn := range tests {
 		t.Run(scenario, func(t *testing.T) {
@@ -28,27 +33,29 @@ func TestCommitLog(t *testing.T) {
 }
 
 func testAppendGet(t *testing.T, log *Bitcask) {
+	ctx := context.Background()
 	want := &ddbv1.Record{
 		Timestamp: 12345,
 		Key:       "foo",
 		Value:     []byte("hello world"),
 	}
 
-	err := log.Set(want)
+	err := log.Set(ctx, want)
 	require.NoError(t, err)
 
-	got, exists, err := log.Get(want.Key)
+	got, exists, err := log.Get(ctx, want.Key)
 	require.NoError(t, err)
 	require.True(t, exists)
 	require.True(t, proto.Equal(want, got))
 
-	got, exists, err = log.Get("does_not_exist")
+	got, exists, err = log.Get(ctx, "does_not_exist")
 	require.NoError(t, err)
 	require.False(t, exists)
 	require.Nil(t, got)
 }
 
 func testInitExisting(t *testing.T, log *Bitcask) {
+	ctx := context.Background()
 	recs := []*ddbv1.Record{
 		{
 			Timestamp: 12345,
@@ -68,7 +75,7 @@ func testInitExisting(t *testing.T, log *Bitcask) {
 	}
 
 	for _, want := range recs {
-		err := log.Set(want)
+		err := log.Set(ctx, want)
 		require.NoError(t, err)
 	}
 
@@ -77,9 +84,125 @@ func testInitExisting(t *testing.T, log *Bitcask) {
 	require.NoError(t, err)
 
 	for _, want := range recs {
-		got, exists, err := log.Get(want.Key)
+		got, exists, err := log.Get(ctx, want.Key)
 		require.NoError(t, err)
 		require.True(t, exists)
 		require.True(t, proto.Equal(want, got))
 	}
 }
+
+func testStats(t *testing.T, log *Bitcask) {
+	ctx := context.Background()
+	deletedAt := int64(1)
+	recs := []*ddbv1.Record{
+		{Key: "foo", Value: []byte("hello world")},
+		{Key: "bar", Value: []byte("hi world")},
+		{Key: "foo", Value: []byte("hello again")},
+		{Key: "bar", DeletedAt: &deletedAt},
+	}
+
+	var dead uint64
+	for i, rec := range recs {
+		err := log.Set(ctx, rec)
+		require.NoError(t, err)
+		if i != 2 {
+			dead += uint64(proto.Size(rec)) + storeHeaderSize
+		}
+	}
+	// a segment holds 1024 bytes, so pad the log until it rotates
+	for i := 0; len(log.Stats().Segments) < 2; i++ {
+		err := log.Set(ctx, &ddbv1.Record{Key: fmt.Sprint(i), Value: []byte("padding")})
+		require.NoError(t, err)
+	}
+	err := log.Set(ctx, &ddbv1.Record{Key: "foo", Value: []byte("hello from a new segment")})
+	require.NoError(t, err)
+	dead += uint64(proto.Size(recs[2])) + storeHeaderSize
+
+	want := log.Stats()
+	var gotDead uint64
+	for _, s := range want.Segments {
+		gotDead += s.DeadBytes
+		require.Equal(t, s.Size, formatHeaderLen+s.LiveBytes+s.DeadBytes)
+	}
+	require.Equal(t, dead, gotDead)
+	require.Equal(t, 1, want.Segments[0].Tombstones)
+	require.Equal(t, 0, want.Segments[1].Tombstones)
+
+	require.True(t, want.Segments[0].Hinted)
+	require.False(t, want.Segments[1].Hinted)
+
+	// reopening loads every segment from the hint files written on close
+	log.Close()
+	log, err = NewBitcaskBackend(log.Dir, log.Config)
+	require.NoError(t, err)
+	want.Segments[1].Hinted = true
+	// compression is only accounted for the records written since opening
+	want.RawBytes, want.StoredBytes = 0, 0
+	require.Equal(t, want, log.Stats())
+
+	// appending to the active segment invalidates its hint
+	err = log.Set(ctx, &ddbv1.Record{Key: "baz", Value: []byte("hi")})
+	require.NoError(t, err)
+	require.False(t, log.Stats().Segments[1].Hinted)
+}
+
+// requireMapped checks every segment but the active one is mapped in memory,
+// unless it is empty.
+func requireMapped(t *testing.T, log *Bitcask) {
+	t.Helper()
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.Equal(t, int(s.store.size), len(s.store.data), s.id)
+	}
+	require.Nil(t, log.activeSegment.store.data)
+}
+
+func testHashedKeys(t *testing.T, log *Bitcask) {
+	require.NoError(t, log.Close())
+	config := log.Config
+	config.HashKeys = true
+	log, err := NewBitcaskBackend(log.Dir, config)
+	require.NoError(t, err)
+
+	writeVersions(t, log, 20, 5)
+	requireVersions(t, log, 20, 5)
+	require.False(t, log.Has("missing"))
+	for _, s := range log.segments {
+		require.Nil(t, s.index.keys)
+	}
+	deadBytes := func() (dead uint64) {
+		for _, s := range log.Stats().Segments {
+			dead += s.DeadBytes
+		}
+		return dead
+	}
+	dead := deadBytes()
+	require.NotZero(t, dead)
+
+	// records shadowed by newer segments are told apart by the hashes of their keys
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(log.Dir, config)
+	require.NoError(t, err)
+	require.Equal(t, dead, deadBytes())
+
+	require.NoError(t, log.Merge(context.Background()))
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
+
+func testMapped(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+	require.Greater(t, len(log.segments), 2)
+	requireMapped(t, log)
+	requireVersions(t, log, 20, 5)
+
+	require.NoError(t, log.Merge(context.Background()))
+	requireMapped(t, log)
+	requireMerged(t, log)
+
+	require.NoError(t, log.Close())
+	log, err := NewBitcaskBackend(log.Dir, log.Config)
+	require.NoError(t, err)
+	requireMapped(t, log)
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
@@ -1,8 +1,42 @@
 package bitcask
 
+import (
+	"github.com/danielfsousa/ddb/internal/compress"
+	"github.com/danielfsousa/ddb/internal/encryption"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 	}
+	// Repair truncates segments that are corrupted, instead of failing to
+	// open them. The discarded bytes are kept in a ".corrupt" file.
+	Repair bool
+	// Compression chooses the codec records are compressed with.
+	Compression compress.Policy
+	// Keyring encrypts new segments and hint files with its current key, and
+	// decrypts the ones encrypted with older keys. Segments are not encrypted
+	// if it is nil.
+	Keyring *encryption.Keyring
+	// HashKeys keeps only a 64 bits hash of each key in memory instead of the
+	// key itself, reading keys back from the segments when hashes match. It
+	// trades slower lookups for a smaller keydir when keys are long.
+	HashKeys bool
+	// OrderedKeys keeps the keys of the log sorted in memory, so RangeKeys
+	// does not need to collect and sort all of them. Hashed keys are read
+	// back from the segments to build it when the log is opened.
+	OrderedKeys bool
+	// FS is the file system the log is stored in. It is the file system of
+	// the operating system if nil.
+	FS vfs.FS
+}
+
+// fs returns the file system of the log.
+func (c Config) fs() vfs.FS {
+	if c.FS == nil {
+		return vfs.OS
+	}
+	return c.FS
 }
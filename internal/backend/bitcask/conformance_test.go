@@ -0,0 +1,52 @@
+package bitcask
+
+import (
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/backend/backendtest"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	memFS := vfs.NewMemFS()
+	for name, config := range map[string]func() Config{
+		"default": recoveryConfig,
+		"hashed": func() Config {
+			c := recoveryConfig()
+			c.HashKeys = true
+			return c
+		},
+		"ordered": func() Config {
+			c := recoveryConfig()
+			c.OrderedKeys = true
+			return c
+		},
+		"in memory": func() Config {
+			c := recoveryConfig()
+			c.FS = memFS
+			return c
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := config()
+			backendtest.Suite{
+				Open: func(t *testing.T, dir string) backend.Backend {
+					require.NoError(t, c.fs().MkdirAll(dir, mergeDirMode))
+					log, err := NewBitcaskBackend(dir, c)
+					require.NoError(t, err)
+					return log
+				},
+				OpenFS: func(t *testing.T, fsys vfs.FS, dir string) backend.Backend {
+					c := c
+					c.FS = fsys
+					log, err := NewBitcaskBackend(dir, c)
+					require.NoError(t, err)
+					return log
+				},
+				Persistent: true,
+			}.Run(t)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package bitcask
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/danielfsousa/ddb/internal/encryption"
+)
+
+func TestEncryption(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string, keys *encryption.KeyFile){
+		"encrypts stores and hints":               testEncryptedFiles,
+		"re-encrypts with a rotated key on merge": testEncryptionRotation,
+		"encrypts plaintext segments on merge":    testEncryptionPlaintext,
+		"refuses to open without the keys":        testEncryptionMissingKeys,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			keys := &encryption.KeyFile{
+				Current: "k1",
+				Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			}
+			fn(t, t.TempDir(), keys)
+		})
+	}
+}
+
+func encryptedConfig(keys encryption.KeyProvider) Config {
+	c := recoveryConfig()
+	c.Keyring = encryption.NewKeyring(keys)
+	return c
+}
+
+// keyIDs returns the key id of each segment of the log.
+func keyIDs(log *Bitcask) []string {
+	ids := make([]string, len(log.segments))
+	for i, s := range log.segments {
+		ids[i] = s.KeyID()
+	}
+	return ids
+}
+
+func requireKeyIDs(t *testing.T, log *Bitcask, want string) {
+	t.Helper()
+	for _, id := range keyIDs(log) {
+		require.Equal(t, want, id)
+	}
+}
+
+func testEncryptedFiles(t *testing.T, dir string, keys *encryption.KeyFile) {
+	log, err := NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	writeVersions(t, log, 40, 2)
+	require.Greater(t, len(log.segments), 2)
+	requireKeyIDs(t, log, "k1")
+	require.NoError(t, log.Close())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range files {
+		b, err := os.ReadFile(path.Join(dir, f.Name()))
+		require.NoError(t, err)
+		require.NotContains(t, string(b), "key-1", f.Name())
+		require.NotContains(t, string(b), "value-1", f.Name())
+	}
+
+	log, err = NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	for _, s := range log.Stats().Segments {
+		require.True(t, s.Hinted, s.ID)
+	}
+	requireVersions(t, log, 40, 2)
+	require.NoError(t, log.Close())
+
+	ids, err := SegmentIDs(dir)
+	require.NoError(t, err)
+	for _, id := range ids {
+		exists, err := VerifyHint(dir, id, encryptedConfig(keys))
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+}
+
+func testEncryptionRotation(t *testing.T, dir string, keys *encryption.KeyFile) {
+	log, err := NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	writeVersions(t, log, 20, 5)
+	require.NoError(t, log.Close())
+
+	keys.Keys["k2"] = bytes.Repeat([]byte{2}, 32)
+	keys.Current = "k2"
+	log, err = NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	ids := keyIDs(log)
+	require.Equal(t, "k2", ids[len(ids)-1])
+	require.Equal(t, "k1", ids[0])
+	requireVersions(t, log, 20, 5)
+
+	require.NoError(t, log.Merge(context.Background()))
+	requireKeyIDs(t, log, "k2")
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+
+	// the old key is no longer needed once every segment was re-encrypted
+	delete(keys.Keys, "k1")
+	log, err = NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
+
+func testEncryptionPlaintext(t *testing.T, dir string, keys *encryption.KeyFile) {
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	writeVersions(t, log, 20, 5)
+	requireKeyIDs(t, log, "")
+	require.NoError(t, log.Close())
+
+	log, err = NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	ids := keyIDs(log)
+	require.Equal(t, "k1", ids[len(ids)-1])
+	require.NoError(t, log.Merge(context.Background()))
+	requireKeyIDs(t, log, "k1")
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
+
+func testEncryptionMissingKeys(t *testing.T, dir string, keys *encryption.KeyFile) {
+	log, err := NewBitcaskBackend(dir, encryptedConfig(keys))
+	require.NoError(t, err)
+	writeVersions(t, log, 40, 1)
+	require.NoError(t, log.Close())
+	before, err := os.ReadFile(path.Join(dir, "1"+storeExt))
+	require.NoError(t, err)
+
+	_, err = NewBitcaskBackend(dir, recoveryConfig())
+	require.ErrorIs(t, err, ErrNoKeys)
+
+	// a missing key is not mistaken for a corruption, even when repairing
+	c := encryptedConfig(&encryption.KeyFile{
+		Current: "k2",
+		Keys:    map[string][]byte{"k2": bytes.Repeat([]byte{2}, 32)},
+	})
+	c.Repair = true
+	_, err = NewBitcaskBackend(dir, c)
+	require.ErrorIs(t, err, encryption.ErrKeyNotFound)
+	after, err := os.ReadFile(path.Join(dir, "1"+storeExt))
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	err = ScanSegment(dir, 1, recoveryConfig(), func(Entry) error { return nil })
+	require.ErrorIs(t, err, ErrNoKeys)
+}
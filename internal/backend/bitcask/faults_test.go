@@ -0,0 +1,158 @@
+package bitcask
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string, c Config, f *faults){
+		"reports failed syncs":                     testFailedSync,
+		"aborts a merge that fails to commit":      testFailedMerge,
+		"refuses to open an unreadable segment":    testFailedOpen,
+		"keeps the log usable after a failed hint": testFailedHint,
+		"aborts an import that fails to commit":    testFailedImport,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			f := &faults{}
+			c := recoveryConfig()
+			c.FS = vfs.WithFaults(vfs.NewMemFS(), f.inject)
+			dir := t.TempDir()
+			require.NoError(t, c.FS.MkdirAll(dir, mergeDirMode))
+			fn(t, dir, c, f)
+		})
+	}
+}
+
+// faults fails the operations of a file system on the files whose names have
+// a given suffix.
+type faults struct {
+	mu     sync.Mutex
+	op     vfs.Op
+	suffix string
+}
+
+func (f *faults) fail(op vfs.Op, suffix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.op, f.suffix = op, suffix
+}
+
+func (f *faults) inject(op vfs.Op, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if op == f.op && strings.HasSuffix(name, f.suffix) {
+		return vfs.ErrInjected
+	}
+	return nil
+}
+
+func testFailedSync(t *testing.T, dir string, c Config, f *faults) {
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+
+	f.fail(vfs.OpSync, storeExt)
+	require.ErrorIs(t, log.Sync(context.Background()), vfs.ErrInjected)
+	f.fail("", "")
+	require.NoError(t, log.Sync(context.Background()))
+}
+
+func testFailedMerge(t *testing.T, dir string, c Config, f *faults) {
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	writeVersions(t, log, 20, 5)
+
+	f.fail(vfs.OpRename, path.Join(mergeDir, mergeCommitFile+".tmp"))
+	require.ErrorIs(t, log.Merge(context.Background()), vfs.ErrInjected)
+	requireVersions(t, log, 20, 5)
+	_, err = c.FS.Stat(path.Join(dir, mergeDir))
+	require.Error(t, err, "the merge directory was not removed")
+
+	f.fail("", "")
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	requireVersions(t, log, 20, 5)
+}
+
+func testFailedOpen(t *testing.T, dir string, c Config, f *faults) {
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+	require.NoError(t, log.Close())
+	require.NoError(t, c.FS.Remove(path.Join(dir, "1"+hintExt)))
+
+	f.fail(vfs.OpRead, "1"+storeExt)
+	_, err = NewBitcaskBackend(dir, c)
+	require.ErrorIs(t, err, vfs.ErrInjected)
+}
+
+func testFailedHint(t *testing.T, dir string, c Config, f *faults) {
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+
+	f.fail(vfs.OpWrite, hintExt+".tmp")
+	require.ErrorIs(t, log.Close(), vfs.ErrInjected)
+
+	// the hint is written again when the log is closed next
+	f.fail("", "")
+	log, err = NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	requireKey(t, log, "foo")
+	require.NoError(t, log.Close())
+	_, err = c.FS.Stat(path.Join(dir, "1"+hintExt))
+	require.NoError(t, err)
+}
+
+func testFailedImport(t *testing.T, dir string, c Config, f *faults) {
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	writeVersions(t, log, 20, 5)
+	stats := log.Stats()
+
+	for op, suffix := range map[vfs.Op]string{
+		vfs.OpRead:   hintExt,
+		vfs.OpRename: manifestFile + ".tmp",
+	} {
+		f.fail(op, suffix)
+		_, err = log.Import(context.Background(), importVersion(60, 1))
+		require.ErrorIs(t, err, vfs.ErrInjected)
+		f.fail("", "")
+		require.Equal(t, stats, log.Stats())
+		requireVersions(t, log, 20, 5)
+		_, exists, err := log.Get(context.Background(), "key-59")
+		require.NoError(t, err)
+		require.False(t, exists)
+		_, err = c.FS.Stat(path.Join(dir, importDir))
+		require.Error(t, err, "the import directory was not removed")
+	}
+
+	writeVersions(t, log, 20, 6)
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	requireVersions(t, log, 20, 6)
+	_, exists, err := log.Get(context.Background(), "key-59")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func requireKey(t *testing.T, log *Bitcask, key string) {
+	t.Helper()
+	_, exists, err := log.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.True(t, exists, key)
+}
@@ -0,0 +1,133 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/bloom"
+	"github.com/danielfsousa/ddb/internal/encryption"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+const (
+	// filterExt is the extension of the Bloom filter files written along hint files.
+	filterExt = ".filter"
+	// filterFalsePositiveRate is the rate of lookups of missing keys that
+	// still probe the index of a segment.
+	filterFalsePositiveRate = 0.01
+	// filterFileVersion starts the filter files. Files of the first version
+	// held only the filter, and are built again.
+	filterFileVersion = 2
+	filterOwnerSize   = 1 + 8 + 8
+)
+
+// ============= Filter File Format =============
+// +---------+-----------+---------+--------+
+// | version | storeSize | keys    | filter |
+// +---------+-----------+---------+--------+
+// | 1 byte  | 8 bytes   | 8 bytes | ?      |
+// +---------+-----------+---------+--------+
+//
+// The filter is preceded by the owner of the file, so a filter is never used
+// for another segment with the same id, such as the one of a restored backup
+// or of a merge. Encrypted filters are sealed as a whole, after the encryption
+// header.
+
+// filterOwner identifies the segment a filter was built for.
+type filterOwner struct {
+	// storeSize is the size of the store of the segment.
+	storeSize uint64
+	// keys is the sum of the hashes of the keys of the index of the segment.
+	keys uint64
+}
+
+// newFilter returns a Bloom filter of the keys of the index, tombstones
+// included, since they shadow older versions of their keys.
+func newFilter(idx *index) *bloom.Filter {
+	f := bloom.New(idx.Len(), filterFalsePositiveRate)
+	idx.RangeHashes(func(h uint64, _ backend.RecordMetadata) {
+		f.AddHash(h)
+	})
+	return f
+}
+
+// writeFilterFile atomically replaces the filter file at the given path with
+// the filter of the segment owner, encrypted with the current key of the
+// keyring if any.
+func writeFilterFile(fsys vfs.FS, name string, f *bloom.Filter, owner filterOwner, keyring *encryption.Keyring) error {
+	filter, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0, filterOwnerSize+len(filter))
+	data = append(data, filterFileVersion)
+	data = encoding.AppendUint64(data, owner.storeSize)
+	data = encoding.AppendUint64(data, owner.keys)
+	data = append(data, filter...)
+	if keyring != nil {
+		keyID, err := keyring.CurrentKeyID()
+		if err != nil {
+			return err
+		}
+		sealed, err := keyring.Seal(keyID, data, nil)
+		if err != nil {
+			return err
+		}
+		data = append(encryption.AppendHeader(nil, keyID), sealed...)
+	}
+	return writeFileSync(fsys, name, data)
+}
+
+// readFilterFile reads the filter file at the given path and the owner it was
+// built for, decrypting it with the keyring if it is encrypted. Files of an
+// older version are reported as bloom.ErrInvalid.
+func readFilterFile(fsys vfs.FS, name string, keyring *encryption.Keyring) (*bloom.Filter, filterOwner, error) {
+	data, err := vfs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, filterOwner{}, err
+	}
+	keyID, n, encrypted, err := encryption.ParseHeader(data)
+	if err != nil {
+		return nil, filterOwner{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if encrypted {
+		if keyring == nil {
+			return nil, filterOwner{}, fmt.Errorf("%s: %w: key %q", name, ErrNoKeys, keyID)
+		}
+		if data, err = keyring.Open(keyID, data[n:], nil); err != nil {
+			return nil, filterOwner{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if len(data) < filterOwnerSize || data[0] != filterFileVersion {
+		return nil, filterOwner{}, fmt.Errorf("%s: %w", name, bloom.ErrInvalid)
+	}
+	owner := filterOwner{
+		storeSize: encoding.Uint64(data[1:]),
+		keys:      encoding.Uint64(data[9:]),
+	}
+	f := &bloom.Filter{}
+	if err := f.UnmarshalBinary(data[filterOwnerSize:]); err != nil {
+		return nil, filterOwner{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, owner, nil
+}
+
+// writeFileSync atomically replaces the file at the given path with data,
+// syncing it before renaming it over the previous one.
+func writeFileSync(fsys vfs.FS, name string, data []byte) error {
+	f, err := fsys.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hintFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(name+".tmp", name)
+}
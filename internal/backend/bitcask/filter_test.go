@@ -0,0 +1,111 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+func TestFilters(t *testing.T) {
+	tests := map[string]func(t *testing.T, log *Bitcask){
+		"skip segments without the key":       testFilterLookups,
+		"are persisted along hint files":      testFilterFiles,
+		"are rebuilt when missing or invalid": testFilterRebuild,
+		"are rebuilt for another segment":     testFilterOtherSegment,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			log, err := NewBitcaskBackend(t.TempDir(), recoveryConfig())
+			require.NoError(t, err)
+			fn(t, log)
+		})
+	}
+}
+
+func testFilterLookups(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 40, 2)
+	require.Greater(t, len(log.segments), 2)
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.NotNil(t, s.filter, s.id)
+	}
+	require.Nil(t, log.activeSegment.filter)
+
+	skipped := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("missing-%d", i)
+		for _, s := range log.segments[:len(log.segments)-1] {
+			if !s.mayHave(key) {
+				skipped++
+			}
+		}
+		require.False(t, log.Has(key))
+	}
+	require.Greater(t, skipped, 900*(len(log.segments)-1))
+	requireVersions(t, log, 40, 2)
+}
+
+func testFilterFiles(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 40, 2)
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.FileExists(t, s.filterPath)
+	}
+	require.NoFileExists(t, log.activeSegment.filterPath)
+	require.NoError(t, log.Close())
+
+	log, err := NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	// the active segment is hinted when it was closed, until it is written to
+	require.NotNil(t, log.activeSegment.filter)
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "new", Value: []byte("v")}))
+	require.Nil(t, log.activeSegment.filter)
+	require.NoFileExists(t, log.activeSegment.filterPath)
+	requireVersions(t, log, 40, 2)
+	require.NoError(t, log.Close())
+}
+
+func testFilterRebuild(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 40, 2)
+	require.NoError(t, log.Close())
+	first, second := log.segments[0], log.segments[1]
+	require.NoError(t, os.Remove(first.filterPath))
+	require.NoError(t, os.WriteFile(second.filterPath, []byte("garbage"), hintFileMode))
+
+	log, err := NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireVersions(t, log, 40, 2)
+	for _, s := range log.segments[:2] {
+		f, owner, err := readFilterFile(vfs.OS, s.filterPath, nil)
+		require.NoError(t, err)
+		require.Equal(t, uint64(s.index.Len()), f.Len())
+		require.Equal(t, s.filterOwner(), owner)
+	}
+	require.NoError(t, log.Close())
+}
+
+// testFilterOtherSegment replaces the filter of a segment with the one of a
+// segment with the same id and number of keys, which must not be used.
+func testFilterOtherSegment(t *testing.T, log *Bitcask) {
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: fmt.Sprintf("new-%d", i), Value: []byte("v")}))
+	}
+	require.NoError(t, log.Close())
+	other := t.TempDir()
+	fill(t, other, 10)
+	data, err := os.ReadFile(path.Join(other, "1"+filterExt))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path.Join(log.Dir, "1"+filterExt), data, hintFileMode))
+
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 10; i++ {
+		requireKey(t, log, fmt.Sprintf("new-%d", i))
+	}
+}
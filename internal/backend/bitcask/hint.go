@@ -2,33 +2,55 @@ package bitcask
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/encryption"
+	"github.com/danielfsousa/ddb/internal/vfs"
 )
 
-// ========== Hint File Format ===========
-// +-----------+----------------+--------+
-// | keyLenght | recordPosition | key    |
-// +-----------+----------------+--------+
-// | 8 bytes   | 8 bytes        | ?      |
-// +-----------+----------------+--------+
+// ================= Hint File Format =================
+// +-----------+----------------+------------+-------+-----------+-----+
+// | keyLength | recordPosition | recordSize | flags | deletedAt | key |
+// +-----------+----------------+------------+-------+-----------+-----+
+// | 8 bytes   | 8 bytes        | 8 bytes    | 1 byte| 8 bytes   | ?   |
+// +-----------+----------------+------------+-------+-----------+-----+
+//
+// Encrypted hints are sealed as a whole, after the encryption header.
 
 type hint struct {
-	file *os.File
+	file vfs.File
 	buf  *bufio.Writer
 	size uint64
+
+	// keyring decrypts encrypted hints. Once keyID is set, the entries are
+	// buffered in plaintext and sealed with that key when the hint is synced.
+	keyring   *encryption.Keyring
+	keyID     string
+	plaintext *bytes.Buffer
 }
 
 const (
 	keyLenSize     = 8
 	recPosSize     = 8
-	hintHeaderSize = keyLenSize + recPosSize
+	recSizeSize    = 8
+	flagsSize      = 1
+	deletedAtSize  = 8
+	hintHeaderSize = keyLenSize + recPosSize + recSizeSize + flagsSize + deletedAtSize
 )
 
-func newHint(f *os.File) (*hint, error) {
-	fi, err := os.Stat(f.Name())
+const (
+	// hintTombstone flags entries of deleted keys.
+	hintTombstone byte = 1 << iota
+)
+
+func newHint(f vfs.File) (*hint, error) {
+	fi, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
@@ -44,13 +66,34 @@ func (h *hint) Name() string {
 	return h.file.Name()
 }
 
-func (h *hint) Write(key string, pos uint64) error {
+// Encrypt makes the hint encrypt its entries with the key with the given id.
+// The hint must be empty, and it can only be synced once.
+func (h *hint) Encrypt(keyring *encryption.Keyring, keyID string) error {
+	if h.size != 0 {
+		return fmt.Errorf("cannot encrypt %s: hint is not empty", h.file.Name())
+	}
+	h.keyring, h.keyID = keyring, keyID
+	h.plaintext = &bytes.Buffer{}
+	h.buf = bufio.NewWriter(h.plaintext)
+	return nil
+}
+
+func (h *hint) Write(key string, meta backend.RecordMetadata) error {
 	keyLen := uint64(len(key))
 
 	// serialize header
 	metadata := [hintHeaderSize]byte{}
-	binary.BigEndian.PutUint64(metadata[:keyLenSize], keyLen)
-	binary.BigEndian.PutUint64(metadata[keyLenSize:], pos)
+	off := 0
+	binary.BigEndian.PutUint64(metadata[off:], keyLen)
+	off += keyLenSize
+	binary.BigEndian.PutUint64(metadata[off:], meta.Pos)
+	off += recPosSize
+	binary.BigEndian.PutUint64(metadata[off:], meta.Size)
+	off += recSizeSize
+	if meta.DeletedAt != nil {
+		metadata[off] |= hintTombstone
+		binary.BigEndian.PutUint64(metadata[off+flagsSize:], uint64(*meta.DeletedAt))
+	}
 
 	// write header
 	_, err := h.buf.Write(metadata[:])
@@ -69,33 +112,70 @@ func (h *hint) Write(key string, pos uint64) error {
 }
 
 func (h *hint) Sync() error {
-	if err := h.buf.Flush(); err != nil {
+	if err := h.flush(); err != nil {
 		return err
 	}
 	return h.file.Sync()
 }
 
 func (h *hint) Close() error {
-	if err := h.buf.Flush(); err != nil {
+	if err := h.flush(); err != nil {
 		return err
 	}
 	return h.file.Close()
 }
 
+// flush writes the buffered entries to the file, sealing them if the hint is encrypted.
+func (h *hint) flush() error {
+	if err := h.buf.Flush(); err != nil {
+		return err
+	}
+	if h.plaintext == nil {
+		return nil
+	}
+	sealed, err := h.keyring.Seal(h.keyID, h.plaintext.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	h.plaintext = nil
+	_, err = h.file.Write(append(encryption.AppendHeader(nil, h.keyID), sealed...))
+	return err
+}
+
+// Scanner returns a scanner of the entries of the hint, which reads them at
+// their offsets in the file of the hint.
 func (h *hint) Scanner() (*hintScanner, error) {
-	f, err := os.Open(h.file.Name())
+	scanner := &hintScanner{reader: bufio.NewReader(io.NewSectionReader(h.file, 0, int64(h.size)))}
+	keyID, headerSize, err := readEncryptionHeader(h.file, h.size)
+	if err == nil && keyID != "" {
+		scanner.reader, err = h.decrypt(h.file, keyID, headerSize)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &hintScanner{
-		reader: f,
-	}, nil
+	return scanner, nil
+}
+
+// decrypt returns a reader of the entries of an encrypted hint file.
+func (h *hint) decrypt(f vfs.File, keyID string, headerSize uint64) (io.Reader, error) {
+	if h.keyring == nil {
+		return nil, fmt.Errorf("%s: %w: key %q", h.file.Name(), ErrNoKeys, keyID)
+	}
+	sealed := make([]byte, h.size-headerSize)
+	if _, err := f.ReadAt(sealed, int64(headerSize)); err != nil {
+		return nil, err
+	}
+	plaintext, err := h.keyring.Open(keyID, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.file.Name(), err)
+	}
+	return bytes.NewReader(plaintext), nil
 }
 
 type hintScanner struct {
 	reader io.Reader
 	key    string
-	pos    uint64
+	meta   backend.RecordMetadata
 	err    error
 }
 
@@ -109,8 +189,17 @@ func (s *hintScanner) Scan() bool {
 		return false
 	}
 
-	keyLen := binary.BigEndian.Uint64(header[:keyLenSize])
-	s.pos = binary.BigEndian.Uint64(header[keyLenSize:])
+	off := 0
+	keyLen := binary.BigEndian.Uint64(header[off:])
+	off += keyLenSize
+	s.meta = backend.RecordMetadata{Pos: binary.BigEndian.Uint64(header[off:])}
+	off += recPosSize
+	s.meta.Size = binary.BigEndian.Uint64(header[off:])
+	off += recSizeSize
+	if header[off]&hintTombstone != 0 {
+		deletedAt := int64(binary.BigEndian.Uint64(header[off+flagsSize:]))
+		s.meta.DeletedAt = &deletedAt
+	}
 
 	key := make([]byte, keyLen)
 	if _, s.err = io.ReadFull(s.reader, key); s.err != nil {
@@ -122,11 +211,55 @@ func (s *hintScanner) Scan() bool {
 }
 
 // Next returns the most recent key generated by a call to Scan.
-func (s *hintScanner) Next() (key string, pos uint64) {
-	return s.key, s.pos
+func (s *hintScanner) Next() (key string, meta backend.RecordMetadata) {
+	return s.key, s.meta
 }
 
 // Err returns the first non-EOF error that was encountered by the Scanner.
 func (s *hintScanner) Err() error {
 	return s.err
 }
+
+// Close releases the scanner. The file of the hint stays open.
+func (s *hintScanner) Close() error {
+	return nil
+}
+
+// writeHintFile atomically replaces the hint file at the given path with the
+// entries of the index, encrypted with the current key of the keyring if any.
+func writeHintFile(fsys vfs.FS, name string, idx *index, keyring *encryption.Keyring) error {
+	tmp := name + ".tmp"
+	f, err := fsys.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, hintFileMode)
+	if err != nil {
+		return err
+	}
+	h, err := newHint(f)
+	if err != nil {
+		return err
+	}
+	if keyring != nil {
+		keyID, err := keyring.CurrentKeyID()
+		if err == nil {
+			err = h.Encrypt(keyring, keyID)
+		}
+		if err != nil {
+			_ = h.Close()
+			return err
+		}
+	}
+	err = idx.Range(func(key string, meta backend.RecordMetadata) error {
+		return h.Write(key, meta)
+	})
+	if err != nil {
+		_ = h.Close()
+		return err
+	}
+	if err = h.Sync(); err != nil {
+		_ = h.Close()
+		return err
+	}
+	if err = h.Close(); err != nil {
+		return err
+	}
+	return fsys.Rename(tmp, name)
+}
@@ -4,18 +4,22 @@ import (
 	"os"
 	"testing"
 
+	"github.com/danielfsousa/ddb/internal/backend"
+
 	"github.com/stretchr/testify/require"
 )
 
 type hintArgs struct {
-	key string
-	pos uint64
+	key  string
+	meta backend.RecordMetadata
 }
 
+var deletedAt = int64(1234)
+
 var expectedHints = []hintArgs{
-	{"hello world 1", 0},
-	{"hello world 2", 25},
-	{"hello world 3", 50},
+	{"hello world 1", backend.RecordMetadata{Pos: 0, Size: 25}},
+	{"hello world 2", backend.RecordMetadata{Pos: 25, Size: 25}},
+	{"hello world 3", backend.RecordMetadata{Pos: 50, Size: 20, DeletedAt: &deletedAt}},
 }
 
 func TestHintWriteScanClose(t *testing.T) {
@@ -33,7 +37,7 @@ func TestHintWriteScanClose(t *testing.T) {
 func testWrite(t *testing.T, hint *hint) {
 	t.Helper()
 	for _, arg := range expectedHints {
-		err := hint.Write(arg.key, arg.pos)
+		err := hint.Write(arg.key, arg.meta)
 		require.NoError(t, err)
 	}
 	err := hint.Sync()
@@ -48,10 +52,11 @@ func testScan(t *testing.T, hint *hint) {
 		err := scanner.Err()
 		require.NoError(t, err)
 
-		key, pos := scanner.Next()
+		key, meta := scanner.Next()
 		require.Equal(t, expectedHints[i].key, key)
-		require.Equal(t, expectedHints[i].pos, pos)
+		require.Equal(t, expectedHints[i].meta, meta)
 	}
+	require.NoError(t, scanner.Close())
 }
 
 func testClose(t *testing.T, hint *hint) {
@@ -0,0 +1,305 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"golang.org/x/exp/slices"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+)
+
+// importDir is the directory, inside the log directory, where imported segments are written.
+const importDir = "import"
+
+// Import writes the records returned by next to new segments, with their
+// hint files, and adds them to the log once every record was written.
+// Imported records take precedence over the records written to the log while
+// the import runs, since their segments are added after the active one.
+func (b *Bitcask) Import(ctx context.Context, next func() (*ddbv1.Record, error)) (int, error) {
+	ctx, span := tracer.Start(ctx, "bitcask.Import")
+	defer span.End()
+	b.maintenance.Lock()
+	defer b.maintenance.Unlock()
+
+	dir := path.Join(b.Dir, importDir)
+	imp, err := b.writeImported(ctx, dir, next)
+	if err == nil && imp.records > 0 {
+		// the immutable segments do not change while the maintenance lock is
+		// held, so the records the import overwrites in them are found
+		// before locking the log.
+		b.mu.RLock()
+		immutable := slices.Clone(b.segments[:len(b.segments)-1])
+		b.mu.RUnlock()
+		imp.findOwners(immutable)
+		err = b.swapImported(dir, imp, len(immutable))
+	}
+	if err != nil {
+		recordError(span, err)
+		_ = b.Config.FS.RemoveAll(dir)
+		return 0, err
+	}
+	if imp.records == 0 {
+		return 0, b.Config.FS.RemoveAll(dir)
+	}
+
+	importedRecords.Add(float64(imp.records))
+	b.logger.Info().
+		Int("records", imp.records).
+		Int("segments", imp.segments).
+		Msg("imported records")
+	return imp.records, nil
+}
+
+// imported describes the segments written by an import.
+type imported struct {
+	segments int
+	records  int
+	// rawBytes and storedBytes are the sizes of the imported records,
+	// before and after compression.
+	rawBytes    uint64
+	storedBytes uint64
+
+	// hashes has the hashes of the imported keys. Like markShadowed, the
+	// records overwritten by the import are found by comparing hashes.
+	hashes map[uint64]importedHash
+	// shadowedBytes is the size of the live records of each imported
+	// segment that are overwritten by a later imported segment.
+	shadowedBytes []uint64
+}
+
+// importedHash describes the records with a given key hash.
+type importedHash struct {
+	// segment is the last imported segment with the hash, numbered from 1,
+	// and liveBytes the size of its live records with the hash.
+	segment   int
+	liveBytes uint64
+	// owner is the newest segment of the log with the hash, whose live
+	// records with the hash become dead once the import is committed.
+	owner *segment
+}
+
+// writeImported appends the records returned by next to segments in the
+// import directory, numbered from 1.
+func (b *Bitcask) writeImported(ctx context.Context, dir string, next func() (*ddbv1.Record, error)) (imported, error) {
+	imp := imported{hashes: make(map[uint64]importedHash)}
+	if err := b.Config.FS.RemoveAll(dir); err != nil {
+		return imp, err
+	}
+	if err := b.Config.FS.Mkdir(dir, mergeDirMode); err != nil {
+		return imp, err
+	}
+
+	var out *segment
+	defer func() {
+		if out != nil {
+			out.Close()
+		}
+	}()
+	for {
+		if err := ctx.Err(); err != nil {
+			return imp, err
+		}
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imp, err
+		}
+		if out == nil || out.IsMaxed() {
+			if err := imp.close(out); err != nil {
+				return imp, err
+			}
+			imp.segments++
+			if out, err = newSegment(dir, uint64(imp.segments), b.Config); err != nil {
+				return imp, err
+			}
+		}
+		if err := out.Append(rec); err != nil {
+			return imp, err
+		}
+		imp.records++
+	}
+	err := imp.close(out)
+	out = nil
+	return imp, err
+}
+
+// close writes the hint file of an imported segment and closes it, keeping
+// the hashes of its keys.
+func (imp *imported) close(s *segment) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.WriteHint(); err != nil {
+		return err
+	}
+	imp.rawBytes += s.store.rawBytes
+	imp.storedBytes += s.store.storedBytes
+
+	n := int(s.id)
+	imp.shadowedBytes = append(imp.shadowedBytes, 0)
+	s.index.RangeHashes(func(h uint64, meta backend.RecordMetadata) {
+		var size uint64
+		if meta.DeletedAt == nil {
+			size = meta.Size
+		}
+		prev, exists := imp.hashes[h]
+		if exists && prev.segment == n {
+			prev.liveBytes += size
+			imp.hashes[h] = prev
+			return
+		}
+		if exists {
+			imp.shadowedBytes[prev.segment-1] += prev.liveBytes
+		}
+		imp.hashes[h] = importedHash{segment: n, liveBytes: size}
+	})
+	return s.Close()
+}
+
+// findOwners sets the owner of each imported hash to the newest of the
+// segments, ordered from the oldest, that has the hash.
+func (imp *imported) findOwners(segments []*segment) {
+	for h, ih := range imp.hashes {
+		for i := len(segments) - 1; i >= 0; i-- {
+			if segments[i].mayHaveHash(h) {
+				ih.owner = segments[i]
+				imp.hashes[h] = ih
+				break
+			}
+		}
+	}
+}
+
+// swapImported commits the imported segments, numbering them after the
+// active segment, and opens them along with a new active segment. The owners
+// of the imported hashes were found in the given number of oldest segments.
+//
+// The segments are moved to the log directory and opened before the manifest
+// lists them, so the log is left as it was if anything fails until the
+// manifest is written. Files of segments the manifest does not list are
+// removed when the log is opened anyway.
+func (b *Bitcask) swapImported(dir string, imp imported, checked int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// renamed from the last one, since the new ids are always higher than
+	// the ones they replace and could overlap with the next segments
+	base := b.activeSegment.id
+	commit := mergeCommit{Outputs: make([]uint64, imp.segments)}
+	for i := imp.segments; i > 0; i-- {
+		id := base + uint64(i)
+		for _, ext := range []string{filterExt, hintExt, storeExt} {
+			from := path.Join(dir, fmt.Sprintf("%d%s", i, ext))
+			to := path.Join(dir, fmt.Sprintf("%d%s", id, ext))
+			if err := b.Config.FS.Rename(from, to); err != nil {
+				return err
+			}
+		}
+		commit.Outputs[i-1] = id
+	}
+	if err := writeMergeCommit(b.Config.FS, dir, commit); err != nil {
+		return err
+	}
+
+	activeID := base + uint64(imp.segments) + 1
+	hinted := b.activeSegment.hinted
+	var opened []*segment
+	abort := func(err error) error {
+		if !hinted {
+			_ = b.activeSegment.DropHint()
+		}
+		for _, s := range opened {
+			s.Close()
+		}
+		for _, id := range append(commit.Outputs, activeID) {
+			for _, ext := range []string{filterExt, hintExt, storeExt} {
+				_ = b.Config.FS.Remove(path.Join(b.Dir, fmt.Sprintf("%d%s", id, ext)))
+			}
+		}
+		return err
+	}
+	if err := b.moveCommitted(dir, commit); err != nil {
+		return abort(err)
+	}
+	for _, id := range append(commit.Outputs, activeID) {
+		s, err := newSegment(b.Dir, id, b.Config)
+		if s != nil {
+			opened = append(opened, s)
+		}
+		if err == nil && id != activeID {
+			err = s.Freeze()
+		}
+		if err != nil {
+			return abort(err)
+		}
+	}
+	var keys []string
+	if b.ordered != nil {
+		for _, s := range opened {
+			err := s.index.Range(func(key string, _ backend.RecordMetadata) error {
+				keys = append(keys, key)
+				return nil
+			})
+			if err != nil {
+				return abort(err)
+			}
+		}
+	}
+	if err := b.activeSegment.WriteHint(); err != nil {
+		return abort(err)
+	}
+	m := b.manifest.commit(commit).add(activeID)
+	if err := writeManifest(b.Config.FS, b.Dir, m); err != nil {
+		return abort(err)
+	}
+
+	// the import is committed, and nothing below can fail
+	b.manifest = m
+	if err := b.Config.FS.RemoveAll(dir); err != nil {
+		b.logger.Warn().Err(err).Msg("failed to remove the import directory")
+	}
+	b.markImported(imp, checked)
+	if err := b.activeSegment.Freeze(); err != nil {
+		// immutable segments that are not mapped are read from their file
+		b.logger.Warn().Err(err).Uint64("segment", base).Msg("failed to map segment")
+	}
+	for i, s := range opened[:imp.segments] {
+		s.deadBytes += imp.shadowedBytes[i]
+	}
+	b.segments = append(b.segments, opened...)
+	b.activeSegment = opened[len(opened)-1]
+	for _, key := range keys {
+		b.ordered.Add(key)
+	}
+	b.rawBytes += imp.rawBytes
+	b.storedBytes += imp.storedBytes
+	return nil
+}
+
+// markImported accounts the live records overwritten by imported records as
+// dead bytes. Their owners were found in the given number of oldest segments,
+// and the segments added since, which are few, take precedence over them.
+// Records overwritten by segments that were not imported were already
+// accounted when the log was opened or written to.
+func (b *Bitcask) markImported(imp imported, checked int) {
+	newer := b.segments[checked:]
+	for h, ih := range imp.hashes {
+		owner := ih.owner
+		for i := len(newer) - 1; i >= 0; i-- {
+			if newer[i].mayHaveHash(h) {
+				owner = newer[i]
+				break
+			}
+		}
+		if owner != nil {
+			owner.deadBytes += owner.index.HashLiveBytes(h)
+		}
+	}
+}
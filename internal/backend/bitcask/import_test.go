@@ -0,0 +1,109 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	tests := map[string]func(t *testing.T, log *Bitcask){
+		"adds hinted segments after the active one": testImportSegments,
+		"discards an interrupted import on opening": testImportInterrupted,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			log, err := NewBitcaskBackend(t.TempDir(), recoveryConfig())
+			require.NoError(t, err)
+			fn(t, log)
+		})
+	}
+}
+
+// importVersion returns a source of n records with the given version as value.
+func importVersion(n, version int) func() (*ddbv1.Record, error) {
+	i := 0
+	return func() (*ddbv1.Record, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		return &ddbv1.Record{
+			Key:   fmt.Sprintf("key-%d", i-1),
+			Value: []byte(fmt.Sprintf("value-%d", version)),
+		}, nil
+	}
+}
+
+func testImportSegments(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 1)
+	active := log.activeSegment.id
+	before := deadBytes(log, active)
+
+	n, err := log.Import(context.Background(), importVersion(60, 1))
+	require.NoError(t, err)
+	require.Equal(t, 60, n)
+	require.NoDirExists(t, path.Join(log.Dir, importDir))
+	imported := log.segments[len(log.segments)-1].id - active - 1
+	require.Greater(t, imported, uint64(1))
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.True(t, s.hinted)
+		require.FileExists(t, s.hintPath)
+	}
+	// the odd keys written before the import were overwritten
+	require.Greater(t, deadBytes(log, active), before)
+	requireImported(t, log, 60, 1)
+	stats := log.Stats()
+
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireImported(t, log, 60, 1)
+	// the overwritten records are accounted like when the log is opened
+	for i, s := range log.Stats().Segments {
+		require.Equal(t, stats.Segments[i].DeadBytes, s.DeadBytes, s.ID)
+	}
+	require.NoError(t, log.Close())
+}
+
+// deadBytes sums the dead bytes of the segments up to the given id.
+func deadBytes(log *Bitcask, upTo uint64) (dead uint64) {
+	for _, s := range log.segments {
+		if s.id <= upTo {
+			dead += s.deadBytes
+		}
+	}
+	return dead
+}
+
+func requireImported(t *testing.T, log *Bitcask, n, version int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		rec, exists, err := log.Get(context.Background(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Nil(t, rec.DeletedAt)
+		require.Equal(t, fmt.Sprintf("value-%d", version), string(rec.Value))
+	}
+}
+
+func testImportInterrupted(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 1)
+	segments := len(log.segments)
+	_, err := log.writeImported(context.Background(), path.Join(log.Dir, importDir), importVersion(60, 1))
+	require.NoError(t, err)
+	// crash before the imported segments were committed
+	require.NoError(t, log.Close())
+
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	require.Len(t, log.segments, segments)
+	requireVersions(t, log, 20, 1)
+	require.NoDirExists(t, path.Join(log.Dir, importDir))
+	require.NoError(t, log.Close())
+}
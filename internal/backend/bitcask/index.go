@@ -1,43 +1,351 @@
 package bitcask
 
 import (
+	"encoding/binary"
+	"sync"
+
 	"github.com/danielfsousa/ddb/internal/backend"
-	cmap "github.com/orcaman/concurrent-map/v2"
 )
 
-// index is an in-memory index of the commit log.
+// index is an in-memory index of the records of a segment, the keydir.
+//
+// It is laid out to take a few dozen bytes per key instead of the hundred or
+// so of a map of strings, and to hold no pointers the garbage collector has
+// to scan:
+//
+//   - entries holds the hash, position, size and flags of each record in a
+//     fixed-width struct. The deletion time of tombstones is kept aside, as
+//     tombstones are a minority of the entries.
+//   - table is an open-addressing hash table of entry numbers, probed
+//     linearly from the hash of the key.
+//   - keys holds the offset of each key in an arena of large blocks, where
+//     keys are stored one after the other, prefixed by their length.
+//
+// When keys are hashed, the index only keeps their 64 bits hashes, and reads
+// the keys back from the store to tell apart the keys sharing a hash.
+//
+// Entries are never removed: overwriting or deleting a key replaces its entry
+// in place, so the arena holds each distinct key once. The keys of deleted
+// records are kept until the segment is merged away, as their tombstones are.
 type index struct {
-	items cmap.ConcurrentMap[string, backend.RecordMetadata]
+	mu sync.RWMutex
+
+	// hash is the hash of the keys, the same one as the Bloom filters, so
+	// they can be built from the index without its keys.
+	hash func(key string) uint64
+
+	entries   []indexEntry
+	deletedAt map[uint32]int64
+	table     []uint32
+
+	keys  []uint64
+	arena [][]byte
+
+	// keyAt reads back the key of the record at the given position of the
+	// store when keys are hashed. It is nil when keys are kept in memory.
+	keyAt func(pos uint64) (string, error)
 }
 
-// newIndex creates a new index.
-func newIndex() *index {
-	return &index{
-		items: cmap.New[backend.RecordMetadata](),
-	}
+type indexEntry struct {
+	hash  uint64
+	pos   uint64
+	size  uint32
+	flags uint32
+}
+
+// entryTombstone flags the entries of deleted keys.
+const entryTombstone uint32 = 1
+
+const (
+	// arenaBlockSize is the size of the blocks of the key arena. Larger keys
+	// get a block of their own.
+	arenaBlockSize = 1 << 20
+	// minTableSize is the initial number of slots of the hash table.
+	minTableSize = 64
+	// emptySlot is the value of the slots of the hash table that are free.
+	// Entry numbers are stored plus one.
+	emptySlot = 0
+)
+
+// newIndex creates a new index that keeps its keys in memory.
+func newIndex(hash func(key string) uint64) *index {
+	return &index{hash: hash}
+}
+
+// newHashedIndex creates a new index that only keeps the hashes of its keys,
+// reading them back with keyAt.
+func newHashedIndex(hash func(key string) uint64, keyAt func(pos uint64) (string, error)) *index {
+	return &index{hash: hash, keyAt: keyAt}
 }
 
-// Keys returns all keys in the index.
+// Keys returns all keys in the index. Hashed keys are read back from the
+// store, skipping the ones that cannot be read.
 func (i *index) Keys() []string {
-	return i.items.Keys()
+	keys := make([]string, 0, i.Len())
+	_ = i.Range(func(key string, _ backend.RecordMetadata) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
+
+// Len returns the number of items in the index.
+func (i *index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.entries)
 }
 
-// Get returns an item from the index.
-func (i *index) Get(key string) (entry backend.RecordMetadata, exists bool) {
-	return i.items.Get(key)
+// Get returns an item from the index. It returns an error if the key is hashed
+// and a key sharing its hash cannot be read back from the store.
+func (i *index) Get(key string) (entry backend.RecordMetadata, exists bool, err error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	n, found, err := i.find(key, i.hash(key))
+	if err != nil || !found {
+		return backend.RecordMetadata{}, false, err
+	}
+	return i.metadata(n), true, nil
 }
 
 // Set stores an item in the index.
-func (i *index) Set(key string, entry backend.RecordMetadata) {
-	i.items.Set(key, entry)
+func (i *index) Set(key string, entry backend.RecordMetadata) error {
+	_, err := i.Replace(key, entry)
+	return err
 }
 
-// Delete removes an item from the index.
-func (i *index) Delete(key string) {
-	i.items.Remove(key)
+// Replace stores an item in the index and returns the number of bytes that
+// became dead, either because a live record was overwritten or because the new
+// item is a tombstone. It returns an error, leaving the index unchanged, if the
+// key is hashed and a key sharing its hash cannot be read back from the store.
+func (i *index) Replace(key string, entry backend.RecordMetadata) (deadBytes uint64, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	h := i.hash(key)
+	n, found, err := i.find(key, h)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		if prev := i.entries[n]; prev.flags&entryTombstone == 0 {
+			deadBytes += uint64(prev.size)
+		}
+		delete(i.deletedAt, n)
+	} else {
+		n = i.insert(key, h)
+	}
+	if entry.DeletedAt != nil {
+		deadBytes += entry.Size
+	}
+	i.entries[n] = i.newEntry(h, entry)
+	if entry.DeletedAt != nil {
+		if i.deletedAt == nil {
+			i.deletedAt = make(map[uint32]int64)
+		}
+		i.deletedAt[n] = *entry.DeletedAt
+	}
+	return deadBytes, nil
 }
 
 // Clear removes all items from the index.
 func (i *index) Clear() {
-	i.items.Clear()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.entries, i.deletedAt, i.table = nil, nil, nil
+	i.keys, i.arena = nil, nil
+}
+
+// Range calls fn for each item of the index until it returns an error, which
+// is returned. Hashed keys are read back from the store, returning the error
+// if they cannot be read. The index is not locked while fn runs.
+func (i *index) Range(fn func(key string, meta backend.RecordMetadata) error) error {
+	for n := 0; ; n++ {
+		i.mu.RLock()
+		if n >= len(i.entries) {
+			i.mu.RUnlock()
+			return nil
+		}
+		e := i.entries[n]
+		var key string
+		if i.keyAt == nil {
+			key = string(i.key(uint32(n)))
+		}
+		meta := i.metadata(uint32(n))
+		i.mu.RUnlock()
+
+		if i.keyAt != nil {
+			var err error
+			if key, err = i.keyAt(e.pos); err != nil {
+				return err
+			}
+		}
+		if err := fn(key, meta); err != nil {
+			return err
+		}
+	}
+}
+
+// RangeHashes calls fn with the hash of the key of each item of the index.
+// The index is read locked while fn runs.
+func (i *index) RangeHashes(fn func(hash uint64, meta backend.RecordMetadata)) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for n, e := range i.entries {
+		fn(e.hash, i.metadata(uint32(n)))
+	}
+}
+
+// HasHash returns true if the index has a key with the given hash, which is
+// not necessarily the key the hash was computed from.
+func (i *index) HasHash(h uint64) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	found := false
+	i.probe(h, func(uint32) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// HashSum returns the sum of the hashes of the keys of the index, which
+// identifies its set of keys.
+func (i *index) HashSum() (sum uint64) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, e := range i.entries {
+		sum += e.hash
+	}
+	return sum
+}
+
+// HashLiveBytes returns the size of the live records whose key has the given
+// hash, which are not necessarily records of the key the hash was computed from.
+func (i *index) HashLiveBytes(h uint64) (size uint64) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	i.probe(h, func(n uint32) bool {
+		if i.entries[n].flags&entryTombstone == 0 {
+			size += uint64(i.entries[n].size)
+		}
+		return true
+	})
+	return size
+}
+
+// find returns the number of the entry of the key with the given hash, or the
+// error reading back a hashed key sharing it.
+func (i *index) find(key string, h uint64) (n uint32, found bool, err error) {
+	i.probe(h, func(candidate uint32) bool {
+		if i.keyAt == nil {
+			found = string(i.key(candidate)) == key
+		} else {
+			var k string
+			if k, err = i.keyAt(i.entries[candidate].pos); err != nil {
+				return false
+			}
+			found = k == key
+		}
+		n = candidate
+		return !found
+	})
+	return n, found, err
+}
+
+// probe calls fn with the number of each entry whose key has the given hash,
+// until it returns false.
+func (i *index) probe(h uint64, fn func(n uint32) bool) {
+	if len(i.table) == 0 {
+		return
+	}
+	mask := uint64(len(i.table) - 1)
+	for slot := h & mask; i.table[slot] != emptySlot; slot = (slot + 1) & mask {
+		n := i.table[slot] - 1
+		if i.entries[n].hash == h && !fn(n) {
+			return
+		}
+	}
+}
+
+// insert adds an entry for a key that is not in the index, returning its number.
+func (i *index) insert(key string, h uint64) uint32 {
+	if (len(i.entries)+1)*4 > len(i.table)*3 {
+		i.grow()
+	}
+	n := uint32(len(i.entries))
+	i.entries = append(i.entries, indexEntry{hash: h})
+	if i.keyAt == nil {
+		i.keys = append(i.keys, i.store(key))
+	}
+	i.place(n)
+	return n
+}
+
+// grow doubles the size of the hash table.
+func (i *index) grow() {
+	size := minTableSize
+	for (len(i.entries)+1)*4 > size*3 {
+		size *= 2
+	}
+	i.table = make([]uint32, size)
+	for n := range i.entries {
+		i.place(uint32(n))
+	}
+}
+
+// place puts an entry in the first free slot from its hash.
+func (i *index) place(n uint32) {
+	mask := uint64(len(i.table) - 1)
+	slot := i.entries[n].hash & mask
+	for i.table[slot] != emptySlot {
+		slot = (slot + 1) & mask
+	}
+	i.table[slot] = n + 1
+}
+
+func (i *index) newEntry(h uint64, meta backend.RecordMetadata) indexEntry {
+	e := indexEntry{hash: h, pos: meta.Pos, size: uint32(meta.Size)}
+	if meta.DeletedAt != nil {
+		e.flags |= entryTombstone
+	}
+	return e
+}
+
+func (i *index) metadata(n uint32) backend.RecordMetadata {
+	e := i.entries[n]
+	meta := backend.RecordMetadata{Pos: e.pos, Size: uint64(e.size)}
+	if e.flags&entryTombstone != 0 {
+		deletedAt := i.deletedAt[n]
+		meta.DeletedAt = &deletedAt
+	}
+	return meta
+}
+
+// store appends a key to the arena, returning its offset: the number of its
+// block in the high 32 bits, and its position in the block in the low ones.
+func (i *index) store(key string) uint64 {
+	size := binary.MaxVarintLen64 + len(key)
+	last := len(i.arena) - 1
+	if last < 0 || cap(i.arena[last])-len(i.arena[last]) < size {
+		blockSize := arenaBlockSize
+		if size > blockSize {
+			blockSize = size
+		}
+		i.arena = append(i.arena, make([]byte, 0, blockSize))
+		last++
+	}
+	block := i.arena[last]
+	off := uint64(last)<<32 | uint64(len(block))
+	block = binary.AppendUvarint(block, uint64(len(key)))
+	i.arena[last] = append(block, key...)
+	return off
+}
+
+// key returns the bytes of the key of an entry from the arena.
+func (i *index) key(n uint32) []byte {
+	off := i.keys[n]
+	block := i.arena[off>>32][uint32(off):]
+	keyLen, size := binary.Uvarint(block)
+	return block[size : size+int(keyLen)]
 }
@@ -1,9 +1,16 @@
 package bitcask
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
 	"testing"
 
 	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/bloom"
+	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,30 +26,287 @@ var expectedIndexPos = []backend.RecordMetadata{
 }
 
 func TestIndexSetGetDelete(t *testing.T) {
-	idx := newIndex()
+	idx := newIndex(bloom.Hash)
 	testSetGet(t, idx)
-	testDeleteGet(t, idx)
+	testDeleteGet(t, idx, storedKeys{})
 }
 
 func testSetGet(t *testing.T, idx *index) {
 	t.Helper()
 	for i, key := range expectedIndexKeys {
-		idx.Set(key, expectedIndexPos[i])
-		item, exists := idx.Get(key)
+		require.NoError(t, idx.Set(key, expectedIndexPos[i]))
+		item, exists, err := idx.Get(key)
+		require.NoError(t, err)
 		require.Equal(t, expectedIndexPos[i], item)
 		require.True(t, exists)
 	}
 }
 
-func testDeleteGet(t *testing.T, idx *index) {
+// testDeleteGet replaces the first key with a tombstone, which takes the
+// place of its entry.
+func testDeleteGet(t *testing.T, idx *index, keys storedKeys) {
 	t.Helper()
 
-	item, exists := idx.Get(expectedIndexKeys[0])
+	item, exists, err := idx.Get(expectedIndexKeys[0])
+	require.NoError(t, err)
 	require.Equal(t, expectedIndexPos[0], item)
 	require.True(t, exists)
 
-	idx.Delete(expectedIndexKeys[0])
-	item, exists = idx.Get(expectedIndexKeys[0])
-	require.Zero(t, item)
+	deletedAt := int64(42)
+	tombstone := backend.RecordMetadata{Pos: 75, Size: 10, DeletedAt: &deletedAt}
+	keys.set(t, idx, expectedIndexKeys[0], tombstone)
+	item, exists, err = idx.Get(expectedIndexKeys[0])
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, tombstone, item)
+	require.Equal(t, len(expectedIndexKeys), idx.Len())
+}
+
+// storedKeys stands in for the store of a hashed index, holding the key of
+// the record at each position.
+type storedKeys map[uint64]string
+
+func (s storedKeys) set(t *testing.T, idx *index, key string, meta backend.RecordMetadata) uint64 {
+	t.Helper()
+	s[meta.Pos] = key
+	deadBytes, err := idx.Replace(key, meta)
+	require.NoError(t, err)
+	return deadBytes
+}
+
+func (s storedKeys) keyAt(pos uint64) (string, error) {
+	key, ok := s[pos]
+	if !ok {
+		return "", errors.New("no record")
+	}
+	return key, nil
+}
+
+// newTestIndex creates an index with the given hash for a scenario.
+type newTestIndex func(hash func(key string) uint64) *index
+
+func TestIndex(t *testing.T) {
+	tests := map[string]func(t *testing.T, newIdx newTestIndex, keys storedKeys){
+		"set get and delete keys":           testIndexSetGetDelete,
+		"account overwritten records":       testIndexDeadBytes,
+		"match a model under random writes": testIndexModel,
+		"tell apart keys sharing a hash":    testIndexCollisions,
+		"range over keys and hashes":        testIndexRange,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			t.Run("keys", func(t *testing.T) {
+				fn(t, newIndex, storedKeys{})
+			})
+			t.Run("hashed", func(t *testing.T) {
+				keys := storedKeys{}
+				fn(t, func(hash func(key string) uint64) *index {
+					return newHashedIndex(hash, keys.keyAt)
+				}, keys)
+			})
+		})
+	}
+}
+
+func testIndexSetGetDelete(t *testing.T, newIdx newTestIndex, keys storedKeys) {
+	idx := newIdx(bloom.Hash)
+	for i, key := range expectedIndexKeys {
+		keys.set(t, idx, key, expectedIndexPos[i])
+	}
+	testDeleteGet(t, idx, keys)
+	for i, key := range expectedIndexKeys[1:] {
+		item, exists, err := idx.Get(key)
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, expectedIndexPos[i+1], item)
+	}
+
+	idx.Clear()
+	require.Zero(t, idx.Len())
+	_, exists, err := idx.Get(expectedIndexKeys[1])
+	require.NoError(t, err)
 	require.False(t, exists)
 }
+
+func testIndexDeadBytes(t *testing.T, newIdx newTestIndex, keys storedKeys) {
+	idx := newIdx(bloom.Hash)
+	require.Zero(t, keys.set(t, idx, "key", backend.RecordMetadata{Pos: 0, Size: 10}))
+	require.Equal(t, uint64(10), keys.set(t, idx, "key", backend.RecordMetadata{Pos: 10, Size: 20}))
+
+	deletedAt := int64(42)
+	tombstone := backend.RecordMetadata{Pos: 30, Size: 5, DeletedAt: &deletedAt}
+	require.Equal(t, uint64(25), keys.set(t, idx, "key", tombstone))
+	require.Equal(t, uint64(5), keys.set(t, idx, "key", backend.RecordMetadata{Pos: 35, Size: 5, DeletedAt: &deletedAt}))
+
+	item, exists, err := idx.Get("key")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, int64(42), *item.DeletedAt)
+	require.Equal(t, uint64(35), item.Pos)
+	require.Zero(t, keys.set(t, idx, "key", backend.RecordMetadata{Pos: 40, Size: 7}))
+	item, _, err = idx.Get("key")
+	require.NoError(t, err)
+	require.Nil(t, item.DeletedAt)
+	require.Equal(t, 1, idx.Len())
+}
+
+func testIndexModel(t *testing.T, newIdx newTestIndex, keys storedKeys) {
+	idx := newIdx(bloom.Hash)
+	rnd := rand.New(rand.NewSource(1))
+	model := map[string]backend.RecordMetadata{}
+	for pos := uint64(0); pos < 20_000; pos++ {
+		key := fmt.Sprintf("key-%d", rnd.Intn(3_000))
+		meta := backend.RecordMetadata{Pos: pos, Size: uint64(rnd.Intn(100))}
+		if rnd.Intn(3) == 0 {
+			deletedAt := int64(pos)
+			meta.DeletedAt = &deletedAt
+		}
+		keys.set(t, idx, key, meta)
+		model[key] = meta
+	}
+
+	require.Equal(t, len(model), idx.Len())
+	for i := 0; i < 3_000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		item, exists, err := idx.Get(key)
+		require.NoError(t, err)
+		want, ok := model[key]
+		require.Equal(t, ok, exists, key)
+		require.Equal(t, want, item, key)
+	}
+}
+
+func testIndexCollisions(t *testing.T, newIdx newTestIndex, keys storedKeys) {
+	idx := newIdx(func(key string) uint64 { return uint64(len(key)) })
+	for i, key := range []string{"a", "b", "c", "dd"} {
+		keys.set(t, idx, key, backend.RecordMetadata{Pos: uint64(i)})
+	}
+	_, exists, err := idx.Get("e")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.True(t, idx.HasHash(1))
+
+	for i, key := range []string{"a", "b", "c", "dd"} {
+		item, exists, err := idx.Get(key)
+		require.NoError(t, err)
+		require.True(t, exists, key)
+		require.Equal(t, uint64(i), item.Pos)
+	}
+	require.Equal(t, 4, idx.Len())
+
+	if idx.keyAt == nil {
+		return
+	}
+	// a key sharing the hash that cannot be read back fails the lookup
+	// instead of matching it
+	delete(keys, 1)
+	_, _, err = idx.Get("c")
+	require.Error(t, err)
+	_, err = idx.Replace("e", backend.RecordMetadata{Pos: 4})
+	require.Error(t, err)
+	require.Equal(t, 4, idx.Len())
+	item, exists, err := idx.Get("a")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Zero(t, item.Pos)
+}
+
+func testIndexRange(t *testing.T, newIdx newTestIndex, keys storedKeys) {
+	idx := newIdx(bloom.Hash)
+	want := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys.set(t, idx, key, backend.RecordMetadata{Pos: uint64(i)})
+		want = append(want, key)
+	}
+	deletedAt := int64(42)
+	for i := 0; i < 100; i += 2 {
+		keys.set(t, idx, fmt.Sprintf("key-%d", i), backend.RecordMetadata{Pos: uint64(100 + i), DeletedAt: &deletedAt})
+	}
+
+	got := idx.Keys()
+	sort.Strings(got)
+	sort.Strings(want)
+	require.Equal(t, want, got)
+
+	hashes, tombstones := 0, 0
+	idx.RangeHashes(func(h uint64, meta backend.RecordMetadata) {
+		require.Equal(t, bloom.Hash(keys[meta.Pos]), h)
+		hashes++
+		if meta.DeletedAt != nil {
+			tombstones++
+		}
+	})
+	require.Equal(t, 100, hashes)
+	require.Equal(t, 50, tombstones)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := idx.Range(func(string, backend.RecordMetadata) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, calls)
+}
+
+func BenchmarkIndexMemoryKeys(b *testing.B) {
+	benchmarkIndexMemory(b, func(n int) any {
+		idx := newIndex(bloom.Hash)
+		for i := 0; i < n; i++ {
+			_ = idx.Set(benchmarkKey(i), benchmarkMetadata(i))
+		}
+		return idx
+	})
+}
+
+func BenchmarkIndexMemoryHashed(b *testing.B) {
+	benchmarkIndexMemory(b, func(n int) any {
+		idx := newHashedIndex(bloom.Hash, func(pos uint64) (string, error) {
+			return benchmarkKey(int(pos / 100)), nil
+		})
+		for i := 0; i < n; i++ {
+			_ = idx.Set(benchmarkKey(i), benchmarkMetadata(i))
+		}
+		return idx
+	})
+}
+
+// BenchmarkIndexMemoryConcurrentMap measures the map the index used to be, as
+// a baseline.
+func BenchmarkIndexMemoryConcurrentMap(b *testing.B) {
+	benchmarkIndexMemory(b, func(n int) any {
+		m := cmap.New[backend.RecordMetadata]()
+		for i := 0; i < n; i++ {
+			m.Set(benchmarkKey(i), benchmarkMetadata(i))
+		}
+		return m
+	})
+}
+
+func benchmarkKey(i int) string {
+	return fmt.Sprintf("user:%012d", i)
+}
+
+func benchmarkMetadata(i int) backend.RecordMetadata {
+	return backend.RecordMetadata{Pos: uint64(i) * 100, Size: 100}
+}
+
+// benchmarkIndexMemory reports the heap used per key by an index of a million
+// keys.
+func benchmarkIndexMemory(b *testing.B, build func(n int) any) {
+	b.Helper()
+	const n = 1 << 20
+	var bytesPerKey float64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		idx := build(n)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		bytesPerKey = float64(after.HeapAlloc-before.HeapAlloc) / n
+		runtime.KeepAlive(idx)
+	}
+	b.ReportMetric(bytesPerKey, "B/key")
+}
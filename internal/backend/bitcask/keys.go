@@ -0,0 +1,152 @@
+package bitcask
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/btree"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+)
+
+// keysDegree is the degree of the B-tree of ordered keys.
+const keysDegree = 32
+
+// orderedKeys is a sorted set of the keys of the log, deleted ones included,
+// kept when Config.OrderedKeys is set. Its methods are no-ops on a nil set.
+type orderedKeys struct {
+	mu   sync.Mutex
+	tree *btree.BTreeG[string]
+}
+
+// buildOrderedKeys returns the sorted set of the keys of the segments.
+func buildOrderedKeys(segments []*segment) (*orderedKeys, error) {
+	k := &orderedKeys{tree: btree.NewOrderedG[string](keysDegree)}
+	for _, s := range segments {
+		if err := k.addSegment(s); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Add adds a key to the set.
+func (k *orderedKeys) Add(key string) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.tree.ReplaceOrInsert(key)
+}
+
+// Delete removes a key from the set.
+func (k *orderedKeys) Delete(key string) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.tree.Delete(key)
+}
+
+// addSegment adds the keys of a segment to the set.
+func (k *orderedKeys) addSegment(s *segment) error {
+	if k == nil {
+		return nil
+	}
+	return s.index.Range(func(key string, _ backend.RecordMetadata) error {
+		k.Add(key)
+		return nil
+	})
+}
+
+// snapshot returns a copy of the set that can be read while keys are added
+// to or deleted from the set. Copying is lazy, so it is cheap.
+func (k *orderedKeys) snapshot() *btree.BTreeG[string] {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.tree.Clone()
+}
+
+// deletedKeys returns the keys of the tombstones of the segments, which a
+// merge of the segments drops.
+func deletedKeys(segments []*segment) ([]string, error) {
+	var keys []string
+	for _, s := range segments {
+		err := s.index.Range(func(key string, meta backend.RecordMetadata) error {
+			if meta.DeletedAt != nil {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// RangeKeys calls fn for the keys from start, included, to end, excluded, in
+// ascending order, or descending if reverse is true, until fn returns an
+// error, which is returned. An empty end is no bound. Deleted keys are
+// included, like in Keys, and keys written meanwhile may or may not be seen.
+//
+// Without Config.OrderedKeys, all keys are collected and sorted first.
+func (b *Bitcask) RangeKeys(start, end string, reverse bool, fn func(key string) error) error {
+	b.mu.RLock()
+	ordered := b.ordered
+	b.mu.RUnlock()
+	if ordered == nil {
+		return rangeSorted(b.Keys(), start, end, reverse, fn)
+	}
+
+	var err error
+	tree := ordered.snapshot()
+	if !reverse {
+		ascend := func(key string) bool {
+			err = fn(key)
+			return err == nil
+		}
+		if end == "" {
+			tree.AscendGreaterOrEqual(start, ascend)
+		} else {
+			tree.AscendRange(start, end, ascend)
+		}
+		return err
+	}
+	descend := func(key string) bool {
+		if key < start {
+			return false
+		}
+		if key == end {
+			return true
+		}
+		err = fn(key)
+		return err == nil
+	}
+	if end == "" {
+		tree.Descend(descend)
+	} else {
+		tree.DescendLessOrEqual(end, descend)
+	}
+	return err
+}
+
+// rangeSorted is RangeKeys over a sorted slice of keys.
+func rangeSorted(keys []string, start, end string, reverse bool, fn func(key string) error) error {
+	lo, hi := sort.SearchStrings(keys, start), len(keys)
+	if end != "" {
+		hi = sort.SearchStrings(keys, end)
+	}
+	for i := lo; i < hi; i++ {
+		key := keys[i]
+		if reverse {
+			key = keys[hi-1-(i-lo)]
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+func TestKeys(t *testing.T) {
+	tests := map[string]func(t *testing.T, log *Bitcask){
+		"range in both directions":        testRangeKeys,
+		"drop the keys merges delete":     testKeysMerged,
+		"follow imports and reopening":    testKeysImported,
+		"stop ranging at the first error": testRangeKeysError,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			for name, ordered := range map[string]bool{"sorted": false, "ordered": true} {
+				t.Run(name, func(t *testing.T) {
+					config := recoveryConfig()
+					config.OrderedKeys = ordered
+					log, err := NewBitcaskBackend(t.TempDir(), config)
+					require.NoError(t, err)
+					require.Equal(t, ordered, log.ordered != nil)
+					fn(t, log)
+				})
+			}
+		})
+	}
+}
+
+// segmentKeys returns the sorted keys of the segments of the log.
+func segmentKeys(log *Bitcask) []string {
+	keys := make(map[string]bool)
+	for _, s := range log.segments {
+		for _, key := range s.Keys() {
+			keys[key] = true
+		}
+	}
+	sorted := maps.Keys(keys)
+	slices.Sort(sorted)
+	return sorted
+}
+
+func rangeKeys(t *testing.T, log *Bitcask, start, end string, reverse bool) []string {
+	t.Helper()
+	var keys []string
+	require.NoError(t, log.RangeKeys(start, end, reverse, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	return keys
+}
+
+func testRangeKeys(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 2)
+	all := segmentKeys(log)
+	require.Len(t, all, 20)
+	require.Equal(t, all, log.Keys())
+
+	reversed := slices.Clone(all)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	require.Equal(t, all, rangeKeys(t, log, "", "", false))
+	require.Equal(t, reversed, rangeKeys(t, log, "", "", true))
+
+	// key-1, key-10 ... key-19, key-2
+	require.Equal(t, all[1:12], rangeKeys(t, log, "key-1", "key-2", false))
+	require.Equal(t, reversed[8:19], rangeKeys(t, log, "key-1", "key-2", true))
+	require.Equal(t, all[12:], rangeKeys(t, log, "key-2", "", false))
+	require.Equal(t, reversed[:8], rangeKeys(t, log, "key-2", "", true))
+	require.Equal(t, all[2:12], rangeKeys(t, log, "key-1\x00", "key-2", false))
+	require.Empty(t, rangeKeys(t, log, "key-3", "key-2", false))
+	require.Empty(t, rangeKeys(t, log, "key-3", "key-2", true))
+}
+
+func testKeysMerged(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 2)
+	// rotate the segment with the tombstones out of the active one
+	for i := 0; i < 40; i++ {
+		require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: fmt.Sprintf("other-%d", i), Value: []byte("value")}))
+	}
+	require.NoError(t, log.Merge(context.Background()))
+	keys := segmentKeys(log)
+	require.Less(t, len(keys), 60)
+	require.Equal(t, keys, log.Keys())
+	require.Equal(t, keys, rangeKeys(t, log, "", "", false))
+}
+
+func testKeysImported(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 10, 2)
+	_, err := log.Import(context.Background(), importVersion(30, 1))
+	require.NoError(t, err)
+	keys := segmentKeys(log)
+	require.Len(t, keys, 30)
+	require.Equal(t, keys, log.Keys())
+
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(log.Dir, log.Config)
+	require.NoError(t, err)
+	require.Equal(t, keys, log.Keys())
+	require.Equal(t, keys, rangeKeys(t, log, "", "", false))
+	require.NoError(t, log.Close())
+}
+
+func testRangeKeysError(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 1)
+	errStop := errors.New("stop")
+	var keys []string
+	err := log.RangeKeys("", "", true, func(key string) error {
+		keys = append(keys, key)
+		if len(keys) == 3 {
+			return errStop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, []string{"key-9", "key-8", "key-7"}, keys)
+}
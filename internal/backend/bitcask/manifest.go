@@ -0,0 +1,186 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// manifestFile lists the segments of the log, and lockFile is locked
+	// while the log is open.
+	manifestFile = "MANIFEST"
+	lockFile     = "LOCK"
+
+	// manifestVersion is the version of the layout of the log directory.
+	// Directories without a manifest are version 0, and are migrated by
+	// listing their store files.
+	manifestVersion = 1
+	// manifestEngine identifies the manifests written by this backend, since
+	// other backends keep a manifest with the same name.
+	manifestEngine = "bitcask"
+)
+
+// ErrUnsupportedVersion is returned when opening a log written by a newer
+// version, whose files cannot be read.
+var ErrUnsupportedVersion = errors.New("unsupported data format version")
+
+// segmentFileName matches the names of the files of segments, and of the
+// temporary files they are written to.
+var segmentFileName = regexp.MustCompile(`^([0-9]+)(\.store|\.hint|\.filter)(\.tmp)?$`)
+
+// manifest lists the live segments of the log, from oldest to newest. It is
+// replaced atomically whenever they change: before a new segment is created,
+// and when merges and imports are committed. The files of segments it does
+// not list are leftovers, and other files in the directory are ignored.
+type manifest struct {
+	Version  int      `json:"version"`
+	Engine   string   `json:"engine"`
+	Segments []uint64 `json:"segments"`
+}
+
+// readManifest reads the manifest of the log in dir, returning false if
+// there is none.
+func readManifest(fsys vfs.FS, dir string) (m manifest, exists bool, err error) {
+	b, err := vfs.ReadFile(fsys, path.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{Version: manifestVersion}, false, nil
+	}
+	if err != nil {
+		return m, false, err
+	}
+	if err := decodeManifest(b, &m); err != nil {
+		return m, false, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	return m, true, nil
+}
+
+// decodeManifest decodes a manifest written by this backend. Since the files
+// of the segments it does not list are removed, manifests of other backends,
+// of newer versions, and ones with unknown or missing fields are refused.
+func decodeManifest(b []byte, m *manifest) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	var engine string
+	if raw, ok := fields["engine"]; ok {
+		if err := json.Unmarshal(raw, &engine); err != nil {
+			return err
+		}
+	}
+	if engine != manifestEngine {
+		return fmt.Errorf("%w: engine %q, expected %q", backend.ErrWrongBackend, engine, manifestEngine)
+	}
+	var version int
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return err
+		}
+	}
+	if version > manifestVersion {
+		return fmt.Errorf("%w %d, expected at most %d", ErrUnsupportedVersion, version, manifestVersion)
+	}
+	for _, name := range []string{"version", "segments"} {
+		if raw, ok := fields[name]; !ok || string(raw) == "null" {
+			return fmt.Errorf("missing field %q", name)
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(m)
+}
+
+// writeManifest atomically replaces the manifest of the log in dir.
+func writeManifest(fsys vfs.FS, dir string, m manifest) error {
+	m.Engine = manifestEngine
+	if m.Segments == nil {
+		m.Segments = []uint64{}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(fsys, path.Join(dir, manifestFile), b); err != nil {
+		return err
+	}
+	return vfs.SyncDir(fsys, dir)
+}
+
+// writeStoresManifest replaces the manifest of the log in dir with one listing
+// the segments that have a store file.
+func writeStoresManifest(fsys vfs.FS, dir string) (manifest, error) {
+	ids, err := storeIDs(fsys, dir)
+	if err != nil {
+		return manifest{}, err
+	}
+	m := manifest{Version: manifestVersion, Segments: ids}
+	return m, writeManifest(fsys, dir, m)
+}
+
+// add returns a copy of the manifest also listing the segments with the given ids.
+func (m manifest) add(ids ...uint64) manifest {
+	segments := slices.Clone(m.Segments)
+	for _, id := range ids {
+		if !slices.Contains(segments, id) {
+			segments = append(segments, id)
+		}
+	}
+	slices.Sort(segments)
+	return manifest{Version: manifestVersion, Segments: segments}
+}
+
+// commit returns a copy of the manifest with the changes of a commit: its
+// inputs replaced by its outputs.
+func (m manifest) commit(c mergeCommit) manifest {
+	segments := make([]uint64, 0, len(m.Segments))
+	for _, id := range m.Segments {
+		if !slices.Contains(c.Inputs, id) {
+			segments = append(segments, id)
+		}
+	}
+	return manifest{Segments: segments}.add(c.Outputs...)
+}
+
+// parseSegmentFile returns the id of the segment a file belongs to, and
+// whether it is a temporary file. It returns false if the file is not a file
+// of a segment.
+func parseSegmentFile(name string) (id uint64, tmp bool, ok bool) {
+	match := segmentFileName.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false, false
+	}
+	id, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return 0, false, false
+	}
+	return id, match[3] != "", true
+}
+
+// storeIDs returns the ids of the segments with a store file in dir, which
+// are the segments of a log without a manifest.
+func storeIDs(fsys vfs.FS, dir string) ([]uint64, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		id, tmp, ok := parseSegmentFile(e.Name())
+		if ok && !tmp && path.Ext(e.Name()) == storeExt {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
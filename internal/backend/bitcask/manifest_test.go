@@ -0,0 +1,161 @@
+package bitcask
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayout(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string){
+		"lists the segments in the manifest":        testManifestSegments,
+		"ignores unknown files":                     testUnknownFiles,
+		"removes files of unlisted segments":        testUnlistedFiles,
+		"refuses a missing segment":                 testMissingSegment,
+		"migrates a directory without a manifest":   testMigrateManifest,
+		"refuses a newer version":                   testNewerManifest,
+		"refuses the manifest of another backend":   testForeignManifest,
+		"refuses invalid manifests":                 testInvalidManifest,
+		"locks the directory while the log is open": testLockDirectory,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, t.TempDir())
+		})
+	}
+}
+
+func requireManifest(t *testing.T, dir string, ids ...uint64) {
+	t.Helper()
+	m, exists, err := readManifest(vfs.OS, dir)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, manifestVersion, m.Version)
+	require.Equal(t, manifestEngine, m.Engine)
+	require.Equal(t, ids, m.Segments)
+}
+
+func testManifestSegments(t *testing.T, dir string) {
+	sizes := fill(t, dir, 80)
+	require.Len(t, sizes, 3)
+	requireManifest(t, dir, 1, 2, 3)
+
+	ids, err := SegmentIDs(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3}, ids)
+}
+
+func testUnknownFiles(t *testing.T, dir string) {
+	fill(t, dir, 10)
+	for _, name := range []string{"notes.txt", "foo" + storeExt, "1.store.bak"} {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("data"), 0o644))
+	}
+
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKey(t, log, "key-9")
+	require.NoError(t, log.Close())
+	for _, name := range []string{"notes.txt", "foo" + storeExt, "1.store.bak"} {
+		_, err := os.Stat(path.Join(dir, name))
+		require.NoError(t, err, name)
+	}
+}
+
+func testUnlistedFiles(t *testing.T, dir string) {
+	fill(t, dir, 10)
+	leftovers := []string{"99" + storeExt, "99" + hintExt, "1" + hintExt + ".tmp"}
+	for _, name := range leftovers {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("data"), 0o644))
+	}
+
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKey(t, log, "key-9")
+	require.Len(t, log.Stats().Segments, 1)
+	require.NoError(t, log.Close())
+	for _, name := range leftovers {
+		_, err := os.Stat(path.Join(dir, name))
+		require.ErrorIs(t, err, os.ErrNotExist, name)
+	}
+}
+
+func testMissingSegment(t *testing.T, dir string) {
+	fill(t, dir, 80)
+	require.NoError(t, os.Remove(storePath(dir, 2)))
+
+	_, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorContains(t, err, "segment 2 listed in "+manifestFile)
+}
+
+func testMigrateManifest(t *testing.T, dir string) {
+	fill(t, dir, 80)
+	require.NoError(t, os.Remove(path.Join(dir, manifestFile)))
+
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKey(t, log, "key-0")
+	requireKey(t, log, "key-79")
+	require.NoError(t, log.Close())
+	requireManifest(t, dir, 1, 2, 3)
+}
+
+func testNewerManifest(t *testing.T, dir string) {
+	fill(t, dir, 10)
+	data := []byte(`{"version":2,"engine":"bitcask","segments":[1],"new":true}`)
+	require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), data, 0o644))
+
+	_, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	_, err = SegmentIDs(dir)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func testForeignManifest(t *testing.T, dir string) {
+	fill(t, dir, 10)
+	data := []byte(`{"version":1,"engine":"lsm","next_file":2,"wal":1,"levels":[]}`)
+	require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), data, 0o644))
+
+	_, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.ErrorIs(t, err, backend.ErrWrongBackend)
+	_, err = SegmentIDs(dir)
+	require.ErrorIs(t, err, backend.ErrWrongBackend)
+	// the segments are not mistaken for leftovers
+	_, err = os.Stat(storePath(dir, 1))
+	require.NoError(t, err)
+}
+
+func testInvalidManifest(t *testing.T, dir string) {
+	fill(t, dir, 10)
+	for _, data := range []string{
+		`{"version":1,"segments":[1]}`,
+		`{"version":1,"engine":"bitcask"}`,
+		`{"version":1,"engine":"bitcask","segments":null}`,
+		`{"engine":"bitcask","segments":[1]}`,
+		`{"version":1,"engine":"bitcask","segments":[1],"levels":[]}`,
+	} {
+		require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), []byte(data), 0o644))
+		_, err := NewBitcaskBackend(dir, recoveryConfig())
+		require.Error(t, err, data)
+	}
+	_, err := os.Stat(storePath(dir, 1))
+	require.NoError(t, err)
+}
+
+func testLockDirectory(t *testing.T, dir string) {
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	_, err = NewBitcaskBackend(dir, recoveryConfig())
+	require.ErrorIs(t, err, vfs.ErrLocked)
+	_, err = Restore(dir, nil)
+	require.ErrorIs(t, err, vfs.ErrLocked)
+
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+}
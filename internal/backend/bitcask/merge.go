@@ -0,0 +1,307 @@
+package bitcask
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+const (
+	// mergeDir is the directory, inside the log directory, where merged segments are written.
+	mergeDir = "merge"
+	// mergeCommitFile marks the merged segments as complete, so they can replace their inputs.
+	mergeCommitFile = "COMMIT"
+
+	mergeDirMode = 0o755
+)
+
+// mergeCommit lists the segments replaced by a merge. Merged segments reuse
+// the ids of the oldest inputs, so the log only stays consistent once every
+// input was replaced. The commit file lets an interrupted merge be completed
+// when the log is opened again. Imports commit their segments the same way,
+// with no inputs.
+type mergeCommit struct {
+	Inputs  []uint64 `json:"inputs"`
+	Outputs []uint64 `json:"outputs"`
+}
+
+// Merge rewrites the immutable segments keeping only the latest version of
+// each key and dropping tombstones, then writes their hint files. Writes to
+// the active segment are not blocked while the segments are rewritten.
+// Segments written with an older format version or encrypted with an older
+// key are rewritten even if they have no dead bytes, upgrading them to the
+// latest version and re-encrypting them with the current key.
+func (b *Bitcask) Merge(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "bitcask.Merge")
+	defer span.End()
+	b.maintenance.Lock()
+	defer b.maintenance.Unlock()
+	defer observeSince(mergeDuration, time.Now())
+
+	b.mu.RLock()
+	inputs := slices.Clone(b.segments[:len(b.segments)-1])
+	var deadBytes uint64
+	var staleKeys bool
+	for _, s := range inputs {
+		deadBytes += s.deadBytes
+		stale, err := b.isStale(s)
+		if err != nil {
+			b.mu.RUnlock()
+			recordError(span, err)
+			return err
+		}
+		staleKeys = staleKeys || stale
+	}
+	b.mu.RUnlock()
+	if deadBytes == 0 && !staleKeys {
+		return nil
+	}
+
+	outputs, err := b.writeMerged(ctx, inputs)
+	if err != nil {
+		recordError(span, err)
+		_ = b.Config.FS.RemoveAll(path.Join(b.Dir, mergeDir))
+		return err
+	}
+	if err := b.swapMerged(inputs, outputs); err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	mergesTotal.Inc()
+	mergeReclaimedBytes.Add(float64(deadBytes))
+	b.logger.Info().
+		Int("inputs", len(inputs)).
+		Int("outputs", len(outputs)).
+		Uint64("reclaimed_bytes", deadBytes).
+		Msg("merged segments")
+	return nil
+}
+
+// writeMerged copies the live records of the inputs to new segments in the
+// merge directory and commits them, returning the ids of the new segments.
+func (b *Bitcask) writeMerged(ctx context.Context, inputs []*segment) ([]uint64, error) {
+	dir := path.Join(b.Dir, mergeDir)
+	if err := b.Config.FS.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := b.Config.FS.Mkdir(dir, mergeDirMode); err != nil {
+		return nil, err
+	}
+
+	// merged segments are rotated here, since the last one must take all
+	// the remaining records to not run out of input ids.
+	config := b.Config
+	config.Segment.MaxStoreBytes = math.MaxUint64
+
+	var outputs []*segment
+	closeOutputs := func() {
+		for _, s := range outputs {
+			s.Close()
+		}
+	}
+	next := func() error {
+		id := inputs[len(outputs)].id
+		s, err := newSegment(dir, id, config)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, s)
+		return nil
+	}
+	if err := next(); err != nil {
+		return nil, err
+	}
+
+	for _, in := range inputs {
+		err := in.index.Range(func(key string, meta backend.RecordMetadata) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if meta.DeletedAt != nil {
+				return nil
+			}
+			b.mu.RLock()
+			owner, _, _, err := b.lookup(key)
+			b.mu.RUnlock()
+			if err != nil {
+				return err
+			}
+			if owner != in {
+				return nil
+			}
+
+			out := outputs[len(outputs)-1]
+			if out.store.size >= b.Config.Segment.MaxStoreBytes && len(outputs) < len(inputs) {
+				if err := next(); err != nil {
+					return err
+				}
+				out = outputs[len(outputs)-1]
+			}
+			rec, err := in.store.Read(meta.Pos)
+			if err != nil {
+				return err
+			}
+			return out.Append(rec)
+		})
+		if err != nil {
+			closeOutputs()
+			return nil, err
+		}
+	}
+
+	commit := mergeCommit{Outputs: make([]uint64, len(outputs))}
+	for i, s := range outputs {
+		commit.Outputs[i] = s.id
+		if err := s.WriteHint(); err != nil {
+			closeOutputs()
+			return nil, err
+		}
+	}
+	closeOutputs()
+	for _, s := range inputs {
+		commit.Inputs = append(commit.Inputs, s.id)
+	}
+	return commit.Outputs, writeMergeCommit(b.Config.FS, dir, commit)
+}
+
+func writeMergeCommit(fsys vfs.FS, dir string, commit mergeCommit) error {
+	b, err := json.Marshal(commit)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(fsys, path.Join(dir, mergeCommitFile), b); err != nil {
+		return err
+	}
+	return vfs.SyncDir(fsys, dir)
+}
+
+// swapMerged replaces the input segments by the merged ones.
+func (b *Bitcask) swapMerged(inputs []*segment, outputs []uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the tombstones of the inputs are dropped, along with their keys if
+	// no newer segment has them.
+	var deleted []string
+	if b.ordered != nil {
+		var err error
+		if deleted, err = deletedKeys(inputs); err != nil {
+			return err
+		}
+	}
+	for _, s := range inputs {
+		b.rawBytes += s.store.rawBytes
+		b.storedBytes += s.store.storedBytes
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	if err := b.recoverCommit(mergeDir); err != nil {
+		return err
+	}
+
+	merged := make([]*segment, 0, len(b.segments)-len(inputs)+len(outputs))
+	for _, id := range outputs {
+		s, err := newSegment(b.Dir, id, b.Config)
+		if err == nil {
+			err = s.Freeze()
+		}
+		if err != nil {
+			return err
+		}
+		merged = append(merged, s)
+	}
+	merged = append(merged, b.segments[len(inputs):]...)
+	b.segments = merged
+	for i := range outputs {
+		markShadowed(b.segments[i], b.segments[i+1:])
+	}
+	for _, key := range deleted {
+		// keys that cannot be looked up are kept, as they may still exist
+		if _, _, exists, err := b.lookup(key); err == nil && !exists {
+			b.ordered.Delete(key)
+		}
+	}
+	b.lastMerge = time.Now()
+	return nil
+}
+
+// recoverCommit completes the changes committed in the given directory of
+// the log, moving the segments it wrote over the ones they replace and
+// listing them in the manifest, or discards them if they were not committed.
+func (b *Bitcask) recoverCommit(name string) error {
+	dir := path.Join(b.Dir, name)
+	data, err := vfs.ReadFile(b.Config.FS, path.Join(dir, mergeCommitFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return b.Config.FS.RemoveAll(dir)
+	}
+	if err != nil {
+		return err
+	}
+	var commit mergeCommit
+	if err := json.Unmarshal(data, &commit); err != nil {
+		return fmt.Errorf("invalid commit in %s: %w", name, err)
+	}
+
+	if err := b.moveCommitted(dir, commit); err != nil {
+		return err
+	}
+	m := b.manifest.commit(commit)
+	if err := writeManifest(b.Config.FS, b.Dir, m); err != nil {
+		return err
+	}
+	b.manifest = m
+	return b.Config.FS.RemoveAll(dir)
+}
+
+// moveCommitted moves the segments written by a commit from dir to the log
+// directory, over the ones they replace, and removes the inputs it does not
+// replace. The manifest is left untouched.
+func (b *Bitcask) moveCommitted(dir string, commit mergeCommit) error {
+	ids := append(slices.Clone(commit.Inputs), commit.Outputs...)
+	for _, id := range ids {
+		for _, ext := range []string{filterExt, hintExt, storeExt} {
+			var err error
+			name := fmt.Sprintf("%d%s", id, ext)
+			if slices.Contains(commit.Outputs, id) {
+				err = b.Config.FS.Rename(path.Join(dir, name), path.Join(b.Dir, name))
+			} else {
+				err = b.Config.FS.Remove(path.Join(b.Dir, name))
+			}
+			// files were already moved by a previous attempt
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return vfs.SyncDir(b.Config.FS, b.Dir)
+}
+
+// markShadowed accounts the live records of a segment that were overwritten
+// by records in newer segments as dead bytes. Keys are compared by hash, so
+// the rare keys sharing a hash with a newer one are accounted as dead too,
+// which only makes the segment a candidate for merging sooner.
+func markShadowed(s *segment, newer []*segment) {
+	s.index.RangeHashes(func(h uint64, meta backend.RecordMetadata) {
+		if meta.DeletedAt != nil {
+			return
+		}
+		for _, n := range newer {
+			if n.mayHaveHash(h) {
+				s.deadBytes += meta.Size
+				return
+			}
+		}
+	})
+}
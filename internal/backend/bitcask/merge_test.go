@@ -0,0 +1,213 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	tests := map[string]func(t *testing.T, log *Bitcask){
+		"keeps the latest version of each key":     testMergeLatest,
+		"allows writes while merging":              testMergeConcurrentWrites,
+		"completes a committed merge when opening": testMergeRecoverCommitted,
+		"discards an uncommitted merge on opening": testMergeRecoverUncommitted,
+		"upgrades segments of older versions":      testMergeUpgrade,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			log, err := NewBitcaskBackend(t.TempDir(), recoveryConfig())
+			require.NoError(t, err)
+			fn(t, log)
+		})
+	}
+}
+
+// writeVersions writes n keys, each overwritten versions times, and deletes every other key.
+func writeVersions(t *testing.T, log *Bitcask, n, versions int) {
+	t.Helper()
+	ctx := context.Background()
+	for v := 0; v < versions; v++ {
+		for i := 0; i < n; i++ {
+			require.NoError(t, log.Set(ctx, &ddbv1.Record{
+				Key:   fmt.Sprintf("key-%d", i),
+				Value: []byte(fmt.Sprintf("value-%d", v)),
+			}))
+		}
+	}
+	deletedAt := int64(1)
+	for i := 0; i < n; i += 2 {
+		require.NoError(t, log.Set(ctx, &ddbv1.Record{Key: fmt.Sprintf("key-%d", i), DeletedAt: &deletedAt}))
+	}
+}
+
+func requireVersions(t *testing.T, log *Bitcask, n, versions int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		rec, exists, err := log.Get(context.Background(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.True(t, exists)
+		if i%2 == 0 {
+			require.NotNil(t, rec.DeletedAt)
+			continue
+		}
+		require.Equal(t, fmt.Sprintf("value-%d", versions-1), string(rec.Value))
+	}
+}
+
+func testMergeLatest(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+	// size is the size of the stores of the log on disk
+	size := func() (size int64) {
+		for _, s := range log.segments {
+			f, n, err := openFile(s.store.Name())
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			size += n
+		}
+		return size
+	}
+	before := size()
+	segments := len(log.segments)
+	require.Greater(t, segments, 2)
+
+	require.NoError(t, log.Merge(context.Background()))
+	require.Less(t, size(), before)
+	require.Less(t, len(log.segments), segments)
+	require.False(t, log.Stats().LastMerge.IsZero())
+	requireMerged(t, log)
+
+	require.NoError(t, log.Close())
+	log, err := NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
+
+// requireMerged checks the odd keys kept their latest version and the even
+// ones stayed deleted.
+func requireMerged(t *testing.T, log *Bitcask) {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		meta, exists := log.GetMetadata(fmt.Sprintf("key-%d", i))
+		if i%2 == 0 {
+			require.True(t, !exists || meta.DeletedAt != nil)
+			continue
+		}
+		rec, exists, err := log.Get(context.Background(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "value-4", string(rec.Value))
+	}
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.Zero(t, s.tombstones)
+	}
+}
+
+func testMergeConcurrentWrites(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < 20; i += 2 {
+			require.NoError(t, log.Set(context.Background(), &ddbv1.Record{
+				Key:   fmt.Sprintf("key-%d", i),
+				Value: []byte("value-5"),
+			}))
+		}
+	}()
+	require.NoError(t, log.Merge(context.Background()))
+	wg.Wait()
+
+	for i := 1; i < 20; i += 2 {
+		rec, exists, err := log.Get(context.Background(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "value-5", string(rec.Value))
+	}
+	require.NoError(t, log.Close())
+}
+
+func testMergeRecoverCommitted(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+	inputs := log.segments[:len(log.segments)-1]
+	_, err := log.writeMerged(context.Background(), inputs)
+	require.NoError(t, err)
+	// crash before the merged segments replaced their inputs
+	require.NoError(t, log.Close())
+
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireMerged(t, log)
+	require.NoDirExists(t, path.Join(log.Dir, mergeDir))
+	require.NoError(t, log.Close())
+}
+
+func testMergeRecoverUncommitted(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+	inputs := log.segments[:len(log.segments)-1]
+	_, err := log.writeMerged(context.Background(), inputs)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(path.Join(log.Dir, mergeDir, mergeCommitFile)))
+	segments := len(log.segments)
+	require.NoError(t, log.Close())
+
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	require.Len(t, log.segments, segments)
+	requireVersions(t, log, 20, 5)
+	require.NoDirExists(t, path.Join(log.Dir, mergeDir))
+	require.NoError(t, log.Close())
+}
+
+func testMergeUpgrade(t *testing.T, log *Bitcask) {
+	writeVersions(t, log, 20, 5)
+	ids := make([]uint64, len(log.segments))
+	for i, s := range log.segments {
+		ids[i] = s.id
+	}
+	require.NoError(t, log.Close())
+
+	// version 0 stores are the same without the format header, and
+	// directories written by that version had no manifest
+	for _, id := range ids {
+		b, err := os.ReadFile(storePath(log.Dir, id))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(storePath(log.Dir, id), b[formatHeaderLen:], storeFileMode))
+		require.NoError(t, os.Remove(path.Join(log.Dir, fmt.Sprintf("%d%s", id, hintExt))))
+	}
+	require.NoError(t, os.Remove(path.Join(log.Dir, manifestFile)))
+
+	log, err := NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireVersions(t, log, 20, 5)
+	var old int
+	for _, s := range log.segments {
+		if s.Version() == 0 {
+			old++
+		}
+	}
+	require.Greater(t, old, 1)
+	// records are not appended to segments of an older version
+	require.Equal(t, uint16(formatVersion), log.activeSegment.Version())
+
+	require.NoError(t, log.Merge(context.Background()))
+	for _, s := range log.segments {
+		require.Equal(t, uint16(formatVersion), s.Version())
+	}
+	requireMerged(t, log)
+
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(log.Dir, recoveryConfig())
+	require.NoError(t, err)
+	requireMerged(t, log)
+	require.NoError(t, log.Close())
+}
@@ -0,0 +1,64 @@
+package bitcask
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var syncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "ddb",
+	Subsystem: "bitcask",
+	Name:      "sync_duration_seconds",
+	Help:      "Latency of flushing and fsyncing the active segment to disk.",
+	Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16), // 100µs to ~3.2s
+})
+
+var (
+	mergesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "bitcask",
+		Name:      "merges_total",
+		Help:      "Number of completed merges.",
+	})
+	mergeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "ddb",
+		Subsystem: "bitcask",
+		Name:      "merge_duration_seconds",
+		Help:      "Latency of merging the immutable segments.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms to ~5.5m
+	})
+	mergeReclaimedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "bitcask",
+		Name:      "merge_reclaimed_bytes_total",
+		Help:      "Bytes of overwritten records and tombstones reclaimed by merges.",
+	})
+)
+
+var (
+	compressionRawBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "bitcask",
+		Name:      "compression_raw_bytes_total",
+		Help:      "Bytes of records appended, before compression.",
+	}, []string{"codec"})
+	compressionStoredBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "bitcask",
+		Name:      "compression_stored_bytes_total",
+		Help:      "Bytes of records appended, after compression.",
+	}, []string{"codec"})
+)
+
+var importedRecords = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "ddb",
+	Subsystem: "bitcask",
+	Name:      "imported_records_total",
+	Help:      "Number of records written by bulk imports.",
+})
+
+func observeSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package bitcask
+
+import "github.com/danielfsousa/ddb/internal/vfs"
+
+// mmap is not supported on this platform, so immutable stores are read from
+// their file like the active one.
+func mmap(vfs.File, uint64) ([]byte, error) {
+	return nil, nil
+}
+
+func munmap([]byte) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package bitcask
+
+import (
+	"os"
+
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"golang.org/x/sys/unix"
+)
+
+// mmap maps the first size bytes of the file in memory, read-only. Only files
+// of the operating system are mapped, the others are read like the active
+// store, so faults injected in their reads are not bypassed.
+func mmap(f vfs.File, size uint64) ([]byte, error) {
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return nil, nil
+	}
+	return unix.Mmap(int(osFile.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,138 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+// This file contains functions to inspect a log directory that is not open,
+// used by offline tooling.
+
+// SegmentIDs returns the ids of the segments stored in dir, from oldest to
+// newest: the ones listed by its manifest, or the ones with a store file if
+// the log was not opened since it had no manifest.
+func SegmentIDs(dir string) ([]uint64, error) {
+	return segmentIDs(vfs.OS, dir)
+}
+
+func segmentIDs(fsys vfs.FS, dir string) ([]uint64, error) {
+	m, exists, err := readManifest(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return storeIDs(fsys, dir)
+	}
+	return m.Segments, nil
+}
+
+// Entry is a record read from a store, along with its location.
+type Entry struct {
+	Segment uint64
+	Offset  uint64
+	// Size is the size of the record in the store, including its header.
+	Size   uint64
+	Record *ddbv1.Record
+}
+
+// ScanSegment calls fn for every record of the segment with the given id, in
+// the order they were appended. The record of the entry is reused by the next
+// call of fn. It returns a *CorruptionError if a record cannot be read back.
+// Encrypted segments are decrypted with Config.Keyring.
+func ScanSegment(dir string, id uint64, c Config, fn func(Entry) error) error {
+	name := path.Join(dir, fmt.Sprintf("%d%s", id, storeExt))
+	fi, err := c.fs().Stat(name)
+	if err != nil {
+		return err
+	}
+	scanner, err := openStoreScanner(c.fs(), name, uint64(fi.Size()), c.Keyring)
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	for scanner.Scan() {
+		rec, pos := scanner.Next()
+		if err := fn(Entry{Segment: id, Offset: pos, Size: scanner.Size(), Record: rec}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ErrHintMismatch is returned by VerifyHint when a hint file does not match its store.
+var ErrHintMismatch = errors.New("hint does not match store")
+
+// VerifyHint compares the hint file of the segment with the given id to the
+// index built by scanning its store. It returns false if there is no hint.
+func VerifyHint(dir string, id uint64, c Config) (exists bool, err error) {
+	want := make(map[string]backend.RecordMetadata)
+	err = ScanSegment(dir, id, c, func(e Entry) error {
+		want[e.Record.Key] = backend.RecordMetadata{Pos: e.Offset, Size: e.Size, DeletedAt: e.Record.DeletedAt}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	h, err := buildHint(c.fs(), path.Join(dir, fmt.Sprintf("%d%s", id, hintExt)), c.Keyring)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer h.Close()
+	scanner, err := h.Scanner()
+	if err != nil {
+		return true, err
+	}
+	defer scanner.Close()
+
+	entries := 0
+	for scanner.Scan() {
+		key, got := scanner.Next()
+		entries++
+		meta, ok := want[key]
+		if !ok {
+			return true, fmt.Errorf("%w: key %q is not in the store", ErrHintMismatch, key)
+		}
+		if got.Pos != meta.Pos || got.Size != meta.Size || (got.DeletedAt == nil) != (meta.DeletedAt == nil) {
+			return true, fmt.Errorf("%w: key %q points to offset %d, expected %d", ErrHintMismatch, key, got.Pos, meta.Pos)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("%w: %v", ErrHintMismatch, err)
+	}
+	if entries != len(want) {
+		return true, fmt.Errorf("%w: %d entries, expected %d", ErrHintMismatch, entries, len(want))
+	}
+	return true, nil
+}
+
+// RebuildHints removes the hint and filter files of the log in dir and
+// writes them again from its stores.
+func RebuildHints(dir string, c Config) error {
+	ids, err := segmentIDs(c.fs(), dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		for _, ext := range []string{hintExt, filterExt} {
+			err := c.fs().Remove(path.Join(dir, fmt.Sprintf("%d%s", id, ext)))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	b, err := NewBitcaskBackend(dir, c)
+	if err != nil {
+		return err
+	}
+	return b.Close()
+}
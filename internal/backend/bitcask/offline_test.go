@@ -0,0 +1,49 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffline(t *testing.T) {
+	dir := t.TempDir()
+	sizes := fill(t, dir, 60)
+
+	ids, err := SegmentIDs(dir)
+	require.NoError(t, err)
+	require.Len(t, ids, len(sizes))
+
+	var entries []Entry
+	var keys []string
+	for _, id := range ids {
+		require.NoError(t, ScanSegment(dir, id, Config{}, func(e Entry) error {
+			entries = append(entries, e)
+			keys = append(keys, e.Record.Key)
+			return nil
+		}))
+		exists, err := VerifyHint(dir, id, Config{})
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+	require.Len(t, entries, 60)
+	require.Equal(t, "key-0", keys[0])
+	require.Equal(t, uint64(formatHeaderLen), entries[0].Offset)
+	require.Equal(t, entries[0].Offset+entries[0].Size, entries[1].Offset)
+
+	// a hint of another segment does not match the store
+	hint := func(id uint64) string { return path.Join(dir, fmt.Sprintf("%d%s", id, hintExt)) }
+	b, err := os.ReadFile(hint(2))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(hint(1), b, hintFileMode))
+	_, err = VerifyHint(dir, 1, Config{})
+	require.ErrorIs(t, err, ErrHintMismatch)
+
+	require.NoError(t, RebuildHints(dir, recoveryConfig()))
+	exists, err := VerifyHint(dir, 1, Config{})
+	require.NoError(t, err)
+	require.True(t, exists)
+}
@@ -0,0 +1,208 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string){
+		"truncates torn write at the end of the active segment": testTornTail,
+		"truncates zeroes at the end of the active segment":     testZeroTail,
+		"rotates an active segment left full":                   testFullActive,
+		"rewrites torn headers of the active segment":           testTornHeader,
+		"refuses corruption in the middle of a segment":         testCorruptedMiddle,
+		"refuses corruption in an immutable segment":            testCorruptedImmutable,
+		"repairs corruption in an immutable segment":            testRepairImmutable,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, t.TempDir())
+		})
+	}
+}
+
+func recoveryConfig() Config {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	return c
+}
+
+// fill writes n records to a new log in dir and closes it, returning the size
+// of each segment's store.
+func fill(t *testing.T, dir string, n int) map[uint64]uint64 {
+	t.Helper()
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, log.Set(context.Background(), &ddbv1.Record{
+			Key:   fmt.Sprintf("key-%d", i),
+			Value: []byte("hello world"),
+		}))
+	}
+	sizes := make(map[uint64]uint64)
+	for _, s := range log.Stats().Segments {
+		sizes[s.ID] = s.Size
+	}
+	require.NoError(t, log.Close())
+	return sizes
+}
+
+func storePath(dir string, id uint64) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", id, storeExt))
+}
+
+func appendBytes(t *testing.T, name string, b []byte) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func corruptByte(t *testing.T, name string, off int64) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func requireKeys(t *testing.T, log *Bitcask, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, exists, err := log.Get(context.Background(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+}
+
+func testTornTail(t *testing.T, dir string) {
+	sizes := fill(t, dir, 3)
+	// a header announcing more data than was written
+	appendBytes(t, storePath(dir, 1), []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 100, 'x'})
+
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKeys(t, log, 3)
+	require.Equal(t, sizes[1], log.activeSegment.store.size)
+
+	// the log keeps working after the recovery
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "key-3", Value: []byte("v")}))
+	require.NoError(t, log.Close())
+	log, err = NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKeys(t, log, 4)
+	require.NoError(t, log.Close())
+}
+
+func testZeroTail(t *testing.T, dir string) {
+	sizes := fill(t, dir, 3)
+	appendBytes(t, storePath(dir, 1), make([]byte, 100))
+
+	log, err := NewBitcaskBackend(dir, recoveryConfig())
+	require.NoError(t, err)
+	requireKeys(t, log, 3)
+	require.Equal(t, sizes[1], log.activeSegment.store.size)
+	require.NoError(t, log.Close())
+}
+
+func testFullActive(t *testing.T, dir string) {
+	sizes := fill(t, dir, 3)
+	// a crash after the write that filled the segment, before its rotation
+	c := recoveryConfig()
+	c.Segment.MaxStoreBytes = sizes[1]
+
+	log, err := NewBitcaskBackend(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), log.activeSegment.id)
+	require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "key-3", Value: []byte("v")}))
+	requireKeys(t, log, 4)
+	require.NoError(t, log.Close())
+}
+
+func testTornHeader(t *testing.T, dir string) {
+	fill(t, dir, 80)
+	header := appendFormatHeader(nil, 0)
+	for _, n := range []int{3, 8, 10, formatHeaderLen - 1} {
+		// a crash while the next segment was created, after it was listed
+		// in the manifest
+		m, _, err := readManifest(vfs.OS, dir)
+		require.NoError(t, err)
+		require.NoError(t, writeManifest(vfs.OS, dir, m.add(4)))
+		require.NoError(t, os.WriteFile(storePath(dir, 4), header[:n], 0o644))
+
+		log, err := NewBitcaskBackend(dir, recoveryConfig())
+		require.NoError(t, err, n)
+		requireKeys(t, log, 80)
+		require.Equal(t, uint64(4), log.activeSegment.id)
+		require.Equal(t, uint16(formatVersion), log.activeSegment.Version())
+		require.Equal(t, uint64(formatHeaderLen), log.activeSegment.store.size)
+
+		require.NoError(t, log.Set(context.Background(), &ddbv1.Record{Key: "key-80", Value: []byte("v")}))
+		require.NoError(t, log.Close())
+		log, err = NewBitcaskBackend(dir, recoveryConfig())
+		require.NoError(t, err)
+		requireKeys(t, log, 81)
+		require.NoError(t, log.Close())
+
+		// start over from the three segments
+		require.NoError(t, os.Remove(storePath(dir, 4)))
+		require.NoError(t, os.Remove(path.Join(dir, "4"+hintExt)))
+		require.NoError(t, os.Remove(path.Join(dir, "4"+filterExt)))
+		require.NoError(t, writeManifest(vfs.OS, dir, m))
+	}
+}
+
+func testCorruptedMiddle(t *testing.T, dir string) {
+	fill(t, dir, 3)
+	corruptByte(t, storePath(dir, 1), formatHeaderLen+storeHeaderSize+1)
+	require.NoError(t, os.Remove(path.Join(dir, "1"+hintExt)))
+
+	_, err := NewBitcaskBackend(dir, recoveryConfig())
+	var corrupt *CorruptionError
+	require.ErrorAs(t, err, &corrupt)
+	require.Equal(t, uint64(formatHeaderLen), corrupt.Offset)
+}
+
+func testCorruptedImmutable(t *testing.T, dir string) {
+	sizes := fill(t, dir, 60)
+	require.Greater(t, len(sizes), 1)
+	appendBytes(t, storePath(dir, 1), []byte{0, 0, 0, 1})
+
+	_, err := NewBitcaskBackend(dir, recoveryConfig())
+	var corrupt *CorruptionError
+	require.ErrorAs(t, err, &corrupt)
+	require.Equal(t, sizes[1], corrupt.Offset)
+}
+
+func testRepairImmutable(t *testing.T, dir string) {
+	sizes := fill(t, dir, 60)
+	garbage := []byte{0, 0, 0, 1}
+	appendBytes(t, storePath(dir, 1), garbage)
+
+	config := recoveryConfig()
+	config.Repair = true
+	log, err := NewBitcaskBackend(dir, config)
+	require.NoError(t, err)
+	requireKeys(t, log, 60)
+	require.Equal(t, sizes[1], log.segments[0].store.size)
+	require.NoError(t, log.Close())
+
+	saved, err := os.ReadFile(storePath(dir, 1) + corruptExt)
+	require.NoError(t, err)
+	require.Equal(t, garbage, saved)
+}
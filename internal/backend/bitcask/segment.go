@@ -1,6 +1,8 @@
 package bitcask
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -8,47 +10,89 @@ import (
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
 	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/bloom"
+	"github.com/danielfsousa/ddb/internal/encryption"
+	"github.com/danielfsousa/ddb/internal/vfs"
 	"github.com/danielfsousa/ddb/pkg/fmode"
 )
 
+const (
+	storeExt = ".store"
+	hintExt  = ".hint"
+	// corruptExt is appended to the name of a store to save the records discarded by a repair.
+	corruptExt = ".corrupt"
+
+	storeFileMode = fmode.USER_RW | fmode.GROUP_R | fmode.OTHER_R
+	hintFileMode  = fmode.USER_RW | fmode.GROUP_R | fmode.OTHER_R
+)
+
 type segment struct {
-	id     uint64
-	store  *store
-	index  *index
-	hint   *hint
-	config Config
+	id         uint64
+	store      *store
+	index      *index
+	hintPath   string
+	filterPath string
+	hinted     bool
+	config     Config
+
+	// filter is a Bloom filter of the keys of the index, consulted before
+	// probing it. It is written and dropped along with the hint, and is set
+	// whenever the segment is hinted or immutable.
+	filter *bloom.Filter
+
+	// deadBytes is the number of bytes in the store occupied by tombstones and
+	// records that were overwritten, which can be reclaimed by a merge.
+	deadBytes uint64
+	// tombstones is the number of tombstones in the store.
+	tombstones int
 }
 
 func newSegment(dir string, id uint64, c Config) (*segment, error) {
+	c.FS = c.fs()
 	s := &segment{
-		id:     id,
-		config: c,
+		id:         id,
+		hintPath:   path.Join(dir, fmt.Sprintf("%d%s", id, hintExt)),
+		filterPath: path.Join(dir, fmt.Sprintf("%d%s", id, filterExt)),
+		config:     c,
 	}
 
 	var err error
-	s.store, err = buildStore(id, dir)
+	s.store, err = buildStore(c.FS, id, dir)
+	var corrupt *CorruptionError
+	if errors.As(err, &corrupt) {
+		// the headers of the store are torn, so it has no records, and the
+		// caller can recover it by truncating it.
+		s.store.compression = c.Compression
+		s.index = s.newIndex()
+		return s, err
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	s.hint, err = buildHint(id, dir)
-	if err != nil {
+	s.store.compression = c.Compression
+	if err = s.setupStore(); err != nil {
+		s.store.Close()
 		return nil, err
 	}
 
-	s.index, err = buildIndex(s.hint, s.store)
-	if err != nil {
+	if err = s.buildIndex(); err != nil {
+		var corrupt *CorruptionError
+		if errors.As(err, &corrupt) {
+			// the records before the corruption are indexed, so the caller
+			// can recover the segment by truncating it.
+			return s, err
+		}
 		return nil, err
 	}
 
 	return s, nil
 }
 
-func buildStore(id uint64, dir string) (*store, error) {
-	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", id, ".store")),
+func buildStore(fsys vfs.FS, id uint64, dir string) (*store, error) {
+	storeFile, err := fsys.OpenFile(
+		path.Join(dir, fmt.Sprintf("%d%s", id, storeExt)),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
-		fmode.USER_RW|fmode.GROUP_R|fmode.OTHER_R,
+		storeFileMode,
 	)
 	if err != nil {
 		return nil, err
@@ -56,46 +100,311 @@ func buildStore(id uint64, dir string) (*store, error) {
 	return newStore(storeFile)
 }
 
-func buildHint(id uint64, dir string) (*hint, error) {
-	hintFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", id, ".hint")),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
-		fmode.USER_RW|fmode.GROUP_R|fmode.OTHER_R,
-	)
+func buildHint(fsys vfs.FS, name string, keyring *encryption.Keyring) (*hint, error) {
+	hintFile, err := fsys.OpenFile(name, os.O_RDONLY, hintFileMode)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newHint(hintFile)
 	if err != nil {
 		return nil, err
 	}
-	return newHint(hintFile)
+	h.keyring = keyring
+	return h, nil
+}
+
+// setupStore gives an encrypted store its keyring, and writes the headers of
+// new stores, encrypting them with the current key when encryption is enabled.
+func (s *segment) setupStore() error {
+	s.store.cipher.keyring = s.config.Keyring
+	if err := s.store.cipher.check(); err != nil {
+		return fmt.Errorf("%s: %w", s.store.Name(), err)
+	}
+	if s.store.size != 0 {
+		return nil
+	}
+	var keyID string
+	if s.config.Keyring != nil {
+		var err error
+		if keyID, err = s.config.Keyring.CurrentKeyID(); err != nil {
+			return err
+		}
+	}
+	if err := s.store.Init(s.config.Keyring, keyID); err != nil {
+		return err
+	}
+	// the store file may have just been created
+	return vfs.SyncDir(s.config.FS, path.Dir(s.store.Name()))
+}
+
+// Version returns the version of the format of the segment's store.
+func (s *segment) Version() uint16 {
+	return s.store.version
+}
+
+// KeyID returns the id of the key the segment is encrypted with, or an empty
+// string if it is not encrypted.
+func (s *segment) KeyID() string {
+	return s.store.cipher.keyID
+}
+
+// buildIndex loads the segment's index from its hint file, falling back to
+// scanning the whole store when there is no hint or it does not match the store.
+func (s *segment) buildIndex() error {
+	loaded, err := s.loadHint()
+	if err != nil || loaded {
+		return err
+	}
+
+	s.index = s.newIndex()
+	scanner, err := s.store.Scanner()
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	for scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		rec, pos := scanner.Next()
+		if err := s.add(rec, backend.RecordMetadata{Pos: pos, Size: scanner.Size(), DeletedAt: rec.DeletedAt}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
-func buildIndex(hint *hint, store *store) (idx *index, err error) {
-	idx = newIndex()
-	if hint.size > 0 {
-		scanner, err := hint.Scanner()
+// newIndex returns an empty index for the segment, which reads keys back from
+// the store if they are hashed.
+func (s *segment) newIndex() *index {
+	if !s.config.HashKeys {
+		return newIndex(bloom.Hash)
+	}
+	return newHashedIndex(bloom.Hash, func(pos uint64) (string, error) {
+		rec, err := s.store.Read(pos)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		return rec.Key, nil
+	})
+}
+
+// loadHint loads the index from the hint file, returning false if there is no
+// usable hint for the store.
+func (s *segment) loadHint() (loaded bool, err error) {
+	h, err := buildHint(s.config.FS, s.hintPath, s.config.Keyring)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer h.Close()
+
+	scanner, err := h.Scanner()
+	if err != nil {
+		return false, err
+	}
+	defer scanner.Close()
+
+	s.index = s.newIndex()
+	var liveBytes uint64
+	end := s.store.dataStart
+	for scanner.Scan() {
+		key, meta := scanner.Next()
+		if err := s.index.Set(key, meta); err != nil {
+			return false, err
+		}
+		if meta.DeletedAt == nil {
+			liveBytes += meta.Size
+		} else {
+			s.tombstones++
+		}
+		if meta.Pos+meta.Size > end {
+			end = meta.Pos + meta.Size
+		}
+	}
+	// a hint that does not cover the whole store is stale
+	if scanner.Err() != nil || end != s.store.size || liveBytes > s.store.size-s.store.dataStart {
+		s.tombstones = 0
+		return false, nil
+	}
+	s.deadBytes = s.store.size - s.store.dataStart - liveBytes
+	s.hinted = true
+	return true, s.loadFilter()
+}
+
+// loadFilter loads the Bloom filter of a hinted segment. Filters that are
+// missing or were built for another segment are built again and persisted.
+func (s *segment) loadFilter() error {
+	f, owner, err := readFilterFile(s.config.FS, s.filterPath, s.config.Keyring)
+	if err == nil && owner == s.filterOwner() && f.Len() == uint64(s.index.Len()) {
+		s.filter = f
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, bloom.ErrInvalid) {
+		return err
+	}
+	s.filter = newFilter(s.index)
+	return writeFilterFile(s.config.FS, s.filterPath, s.filter, s.filterOwner(), s.config.Keyring)
+}
+
+// filterOwner returns the owner of the filter of the segment.
+func (s *segment) filterOwner() filterOwner {
+	return filterOwner{storeSize: s.store.size, keys: s.index.HashSum()}
+}
+
+// Freeze makes the segment immutable: its store is mapped in memory and its
+// Bloom filter is built if it is not hinted. No records may be appended to
+// the segment afterwards.
+func (s *segment) Freeze() error {
+	if err := s.store.Map(); err != nil {
+		return err
+	}
+	if s.filter == nil {
+		s.filter = newFilter(s.index)
+	}
+	return nil
+}
+
+// Truncate discards the records of the segment starting at offset, which must
+// not be indexed. If keep is true, the discarded bytes are saved to a file next
+// to the store, whose path is returned.
+func (s *segment) Truncate(offset uint64, keep bool) (saved string, err error) {
+	if keep {
+		saved = s.store.Name() + corruptExt
+		if err := s.saveFrom(offset, saved); err != nil {
+			return "", err
 		}
-		for scanner.Scan() {
-			if err := scanner.Err(); err != nil {
-				return nil, err
+	}
+	if err := s.DropHint(); err != nil {
+		return "", err
+	}
+	return saved, s.store.Truncate(offset)
+}
+
+func (s *segment) saveFrom(offset uint64, name string) error {
+	f, err := s.config.FS.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, storeFileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := io.NewSectionReader(s.store, int64(offset), int64(s.store.size-offset))
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// isTornTail returns true if the corruption is confined to the end of the
+// store, as left by a crash in the middle of an append: either the record
+// extends beyond the end of the file or only zeroes follow it.
+func (s *segment) isTornTail(corrupt *CorruptionError) (bool, error) {
+	if errors.Is(corrupt.Reason, io.ErrUnexpectedEOF) {
+		return true, nil
+	}
+	r := io.NewSectionReader(s.store, int64(corrupt.Offset), int64(s.store.size-corrupt.Offset))
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
 			}
-			key, pos := scanner.Next()
-			idx.Set(key, backend.RecordMetadata{Pos: pos})
 		}
-	} else {
-		scanner, err := store.Scanner()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		for scanner.Scan() {
-			if err := scanner.Err(); err != nil {
-				return nil, err
-			}
-			rec, pos := scanner.Next()
-			idx.Set(rec.Key, backend.RecordMetadata{Pos: pos, DeletedAt: rec.DeletedAt})
+	}
+}
+
+// WriteHint persists the segment's index to its hint file, so the next time the
+// segment is opened it does not need to scan the store, along with its Bloom filter.
+func (s *segment) WriteHint() error {
+	if s.hinted {
+		return nil
+	}
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	if err := writeHintFile(s.config.FS, s.hintPath, s.index, s.config.Keyring); err != nil {
+		return err
+	}
+	filter := newFilter(s.index)
+	if err := writeFilterFile(s.config.FS, s.filterPath, filter, s.filterOwner(), s.config.Keyring); err != nil {
+		return err
+	}
+	s.hinted = true
+	s.filter = filter
+	return nil
+}
+
+// DropHint removes the segment's hint and filter files, which must be done
+// before appending to the segment since they would become stale.
+func (s *segment) DropHint() error {
+	s.hinted = false
+	s.filter = nil
+	for _, name := range []string{s.hintPath, s.filterPath} {
+		if err := s.config.FS.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mayHave returns false if the segment definitely does not have the given key.
+func (s *segment) mayHave(key string) bool {
+	return s.filter == nil || s.filter.MayContain(key)
+}
+
+// mayHaveHash returns false if the segment definitely does not have a key with
+// the given hash.
+func (s *segment) mayHaveHash(h uint64) bool {
+	if s.filter != nil && !s.filter.MayContainHash(h) {
+		return false
+	}
+	return s.index.HasHash(h)
+}
+
+// add stores the metadata of a record in the index and updates the segment's statistics.
+func (s *segment) add(rec *ddbv1.Record, meta backend.RecordMetadata) error {
+	deadBytes, err := s.index.Replace(rec.Key, meta)
+	if err != nil {
+		return err
+	}
+	s.deadBytes += deadBytes
+	if rec.DeletedAt != nil {
+		s.tombstones++
+	}
+	return nil
+}
+
+// Scrub reads back every record of the segment, returning the range starting
+// at the first record that fails its checksum, if any.
+func (s *segment) Scrub(ctx context.Context) (*backend.Corruption, error) {
+	scanner, err := s.store.Scanner()
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Close()
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 	}
-	return idx, nil
+	var corrupt *CorruptionError
+	if errors.As(scanner.Err(), &corrupt) {
+		return &backend.Corruption{
+			Segment: s.id,
+			Offset:  corrupt.Offset,
+			Size:    scanner.size - corrupt.Offset,
+			Err:     corrupt,
+		}, nil
+	}
+	return nil, scanner.Err()
 }
 
 // Keys returns the keys of all records stored in the segment.
@@ -108,19 +417,23 @@ func (s *segment) Append(record *ddbv1.Record) error {
 	if s.IsMaxed() {
 		return io.EOF
 	}
-	_, pos, err := s.store.Append(record)
+	if s.hinted {
+		if err := s.DropHint(); err != nil {
+			return err
+		}
+	}
+	n, pos, err := s.store.Append(record)
 	if err != nil {
 		return err
 	}
-	s.index.Set(record.Key, backend.RecordMetadata{Pos: pos, DeletedAt: record.DeletedAt})
-	return nil
+	return s.add(record, backend.RecordMetadata{Pos: pos, Size: n, DeletedAt: record.DeletedAt})
 }
 
 // Get returns the record for the given key.
 func (s *segment) Get(key string) (rec *ddbv1.Record, exists bool, err error) {
-	meta, exists := s.index.Get(key)
-	if !exists {
-		return nil, false, nil
+	meta, exists, err := s.GetMetadata(key)
+	if err != nil || !exists {
+		return nil, false, err
 	}
 	rec, err = s.store.Read(meta.Pos)
 	if err != nil {
@@ -129,14 +442,27 @@ func (s *segment) Get(key string) (rec *ddbv1.Record, exists bool, err error) {
 	return rec, true, nil
 }
 
-func (s *segment) GetMetadata(key string) (entry backend.RecordMetadata, exists bool) {
+// GetMetadata returns the metadata of the record of the given key, or the
+// error reading back a hashed key.
+func (s *segment) GetMetadata(key string) (entry backend.RecordMetadata, exists bool, err error) {
+	if !s.mayHave(key) {
+		return backend.RecordMetadata{}, false, nil
+	}
 	return s.index.Get(key)
 }
 
-// Has returns true if the segment has the given key.
-func (s *segment) Has(key string) bool {
-	_, exists := s.index.Get(key)
-	return exists
+// Stats returns statistics about the segment.
+func (s *segment) Stats() backend.SegmentStats {
+	size := s.store.size
+	return backend.SegmentStats{
+		ID:         s.id,
+		Keys:       s.index.Len(),
+		Tombstones: s.tombstones,
+		Size:       size,
+		LiveBytes:  size - s.store.dataStart - s.deadBytes,
+		DeadBytes:  s.deadBytes,
+		Hinted:     s.hinted,
+	}
 }
 
 // IsMaxed returns true if the segment is at its max size.
@@ -149,15 +475,12 @@ func (s *segment) Sync() error {
 	return s.store.Sync()
 }
 
-// Close closes the store and hint files.
+// Close flushes the store to disk and closes it.
 func (s *segment) Close() error {
-	if err := s.store.Close(); err != nil {
-		return err
-	}
-	if err := s.hint.Close(); err != nil {
+	if err := s.store.Sync(); err != nil {
 		return err
 	}
-	return nil
+	return s.store.Close()
 }
 
 // Remove clears the index, closes the segment and removes the store and hint files.
@@ -166,11 +489,8 @@ func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
 	}
-	if err := os.Remove(s.store.Name()); err != nil {
-		return err
-	}
-	if err := os.Remove(s.hint.Name()); err != nil {
+	if err := s.config.FS.Remove(s.store.Name()); err != nil {
 		return err
 	}
-	return nil
+	return s.DropHint()
 }
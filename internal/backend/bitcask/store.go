@@ -2,32 +2,94 @@ package bitcask
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash"
 	"hash/crc32"
 	"io"
-	"os"
 	"sync"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/compress"
+	"github.com/danielfsousa/ddb/internal/encryption"
+	"github.com/danielfsousa/ddb/internal/vfs"
 	"google.golang.org/protobuf/proto"
 )
 
-// =========== Store Format ===========
-// +----------+--------------+--------+
-// |         metadata        |  data  |
-// +-------------------------+--------+
-// | checksum | recordLength | record |
-// +----------+--------------+--------+
-// | 4 bytes  | 8 bytes      | ?      |
-// +----------+--------------+--------+
+// ============== Store Format ===============
+// +----------+--------+--------------+--------+
+// |              metadata             |  data  |
+// +----------+--------+--------------+--------+
+// | checksum | codec  | recordLength | record |
+// +----------+--------+--------------+--------+
+// | 4 bytes  | 1 byte | 7 bytes      | ?      |
+// +----------+--------+--------------+--------+
+//
+// The codec is the compression of the record, so segments written with
+// different codecs stay readable. It takes the most significant byte of what
+// used to be an 8 bytes record length, so uncompressed records are unchanged.
+//
+// Encrypted stores have a header naming their key, see the encryption
+// package. Their records are compressed, then sealed with the id of the store
+// and their position as additional data, so records cannot be moved within or
+// across stores, and the checksum covers the sealed bytes.
+//
+// ========== Format Header ===========
+// +----------+---------+----------+
+// | magic    | version | features |
+// +----------+---------+----------+
+// | 8 bytes  | 2 bytes | 4 bytes  |
+// +----------+---------+----------+
+//
+// Stores start with a header giving the version of their format and the
+// optional features their records use, followed by the id of the store and the
+// encryption header if they are encrypted. The id is 16 random bytes, and is
+// not tied to the segment id, since imports rename their segments. Stores
+// written before the header was introduced are version 0: they have no header,
+// and start with the encryption header if they are encrypted. Encrypted stores
+// of version 1 have no id, and seal their records with their position only.
+// New stores are written with the latest version, and merges rewrite the
+// stores written with older ones.
+//
+// Stores of immutable segments are mapped in memory, so their records are read
+// without locking the store nor copying them out of the page cache. The active
+// store is read from its file, after flushing its write buffer.
 
 const (
 	checksumSize    = 4
 	recLenSize      = 8
 	storeHeaderSize = checksumSize + recLenSize
+
+	// codecShift is the position of the codec in the record length.
+	codecShift = 56
+	recLenMask = 1<<codecShift - 1
+
+	// formatVersion is the version of the format new stores are written with.
+	formatVersion   = 2
+	formatHeaderLen = 8 + 2 + 4
+	// storeIDLen is the size of the id of encrypted stores.
+	storeIDLen = 16
+)
+
+// formatMagic identifies the format header. Like the magic of the encryption
+// header, its fifth byte would be an unknown compression codec in the first
+// record of a version 0 store, so it cannot be confused with one.
+var formatMagic = []byte("ddbSTORE")
+
+// feature is a flag of the format header, set when the records of a store use
+// an optional feature. Stores with features that are not known are refused,
+// so records can change in ways older versions cannot read.
+type feature uint32
+
+const (
+	// featureEncrypted marks stores whose format header is followed by an
+	// encryption header.
+	featureEncrypted feature = 1 << iota
+
+	knownFeatures = featureEncrypted
 )
 
 var (
@@ -37,56 +99,279 @@ var (
 )
 
 type store struct {
-	*os.File
+	vfs.File
 	mu   sync.Mutex
 	buf  *bufio.Writer
-	hash hash.Hash32
 	size uint64
+
+	// data is the store mapped in memory once it is immutable. It is only
+	// set while the log is locked for writing, so readers holding its read
+	// lock can use it without locking the store.
+	data []byte
+
+	// compression chooses the codec of appended records.
+	compression compress.Policy
+	// rawBytes and storedBytes are the sizes of the records appended since
+	// the store was opened, before and after compression.
+	rawBytes    uint64
+	storedBytes uint64
+
+	// version is the version of the format of the store.
+	version uint16
+	// cipher encrypts the records of encrypted stores. The first record is
+	// at dataStart, after the headers of the store.
+	cipher    recordCipher
+	dataStart uint64
+}
+
+// recordCipher seals and opens the records of a store with the key with the
+// given id. Records are stored as is if there is no key id.
+type recordCipher struct {
+	keyring *encryption.Keyring
+	keyID   string
+	// storeID is the id of the store, which is empty for stores written
+	// before version 2.
+	storeID []byte
+}
+
+func (c recordCipher) seal(pos uint64, b []byte) ([]byte, error) {
+	if c.keyID == "" {
+		return b, nil
+	}
+	return c.keyring.Seal(c.keyID, b, c.additionalData(pos))
+}
+
+func (c recordCipher) open(pos uint64, b []byte) ([]byte, error) {
+	if c.keyID == "" {
+		return b, nil
+	}
+	return c.keyring.Open(c.keyID, b, c.additionalData(pos))
+}
+
+// additionalData returns the data records are authenticated with: the id of
+// the store followed by the position of the record.
+func (c recordCipher) additionalData(pos uint64) []byte {
+	ad := make([]byte, 0, len(c.storeID)+8)
+	return encoding.AppendUint64(append(ad, c.storeID...), pos)
+}
+
+// check returns an error if the key of an encrypted store is not available,
+// so its records are not mistaken for corrupted ones.
+func (c recordCipher) check() error {
+	if c.keyID == "" {
+		return nil
+	}
+	if c.keyring == nil {
+		return fmt.Errorf("%w: key %q", ErrNoKeys, c.keyID)
+	}
+	return c.keyring.Load(c.keyID)
 }
 
-func newStore(f *os.File) (*store, error) {
-	fi, err := os.Stat(f.Name())
+// ErrNoKeys is the error returned when opening encrypted files without a keyring.
+var ErrNoKeys = errors.New("data is encrypted, but no encryption keys are configured")
+
+// errTornHeader is the reason of the CorruptionError of a store whose headers
+// are incomplete, as left by a crash while it was created. It holds no
+// records, so it is recovered like a torn append, by truncating it.
+var errTornHeader = fmt.Errorf("headers are truncated: %w", io.ErrUnexpectedEOF)
+
+// newStore opens the store in the file. If its headers are torn, it returns
+// the store along with a CorruptionError, so it can be truncated.
+func newStore(f vfs.File) (*store, error) {
+	fi, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 	size := uint64(fi.Size())
+	header, err := readStoreHeader(f, size)
+	if errors.Is(err, errTornHeader) {
+		s := &store{File: f, size: size, buf: bufio.NewWriter(f)}
+		return s, &CorruptionError{Path: f.Name(), Offset: 0, Reason: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
 	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-		hash: crc32.New(crcTable),
+		File:      f,
+		size:      size,
+		buf:       bufio.NewWriter(f),
+		version:   header.version,
+		cipher:    recordCipher{keyID: header.keyID, storeID: header.storeID},
+		dataStart: header.size,
 	}, nil
 }
 
+// storeHeader is the content of the headers at the start of a store.
+type storeHeader struct {
+	version  uint16
+	features feature
+	// keyID is the id of the key the store is encrypted with, if it is.
+	keyID string
+	// storeID is the id of encrypted stores of version 2 and later.
+	storeID []byte
+	// size is the size of the headers, where the first record starts.
+	size uint64
+}
+
+// readStoreHeader reads the headers of a store of the given size, of any
+// format version up to the latest. It returns errTornHeader if the store ends
+// before the end of its headers.
+func readStoreHeader(r io.ReaderAt, size uint64) (h storeHeader, err error) {
+	b := make([]byte, formatHeaderLen)
+	if size < uint64(len(b)) {
+		b = b[:size]
+	}
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return h, err
+	}
+	if size > 0 && size < uint64(len(formatMagic)) && bytes.HasPrefix(formatMagic, b) {
+		return h, errTornHeader
+	}
+	if !bytes.HasPrefix(b, formatMagic) {
+		// stores of version 0 have no format header
+		h.keyID, h.size, err = readEncryptionHeader(r, size)
+		return h, err
+	}
+	if len(b) < formatHeaderLen {
+		return h, errTornHeader
+	}
+
+	h.version = encoding.Uint16(b[len(formatMagic):])
+	h.features = feature(encoding.Uint32(b[len(formatMagic)+2:]))
+	h.size = formatHeaderLen
+	if h.version > formatVersion {
+		return h, fmt.Errorf("%w %d, expected at most %d", ErrUnsupportedVersion, h.version, formatVersion)
+	}
+	if unknown := h.features &^ knownFeatures; unknown != 0 {
+		return h, fmt.Errorf("%w: unknown features %#x", ErrUnsupportedVersion, uint32(unknown))
+	}
+	if h.features&featureEncrypted == 0 {
+		return h, nil
+	}
+
+	if h.version >= 2 {
+		if size < h.size+storeIDLen {
+			return h, errTornHeader
+		}
+		h.storeID = make([]byte, storeIDLen)
+		if _, err := r.ReadAt(h.storeID, int64(h.size)); err != nil {
+			return h, err
+		}
+		h.size += storeIDLen
+	}
+	rest := size - h.size
+	keyID, n, err := readEncryptionHeader(io.NewSectionReader(r, int64(h.size), int64(rest)), rest)
+	if (err != nil || keyID == "") && rest < encryption.MaxHeaderSize {
+		// records cannot follow an incomplete encryption header
+		return h, errTornHeader
+	}
+	if err != nil {
+		return h, err
+	}
+	if keyID == "" {
+		return h, errors.New("encryption header is missing")
+	}
+	h.keyID, h.size = keyID, h.size+n
+	return h, nil
+}
+
+// appendFormatHeader appends the format header of a store with the latest
+// version and the given features to dst.
+func appendFormatHeader(dst []byte, features feature) []byte {
+	dst = append(dst, formatMagic...)
+	dst = encoding.AppendUint16(dst, formatVersion)
+	return encoding.AppendUint32(dst, uint32(features))
+}
+
+// readEncryptionHeader returns the key id and size of the encryption header
+// of the file, if it is encrypted.
+func readEncryptionHeader(r io.ReaderAt, size uint64) (keyID string, n uint64, err error) {
+	b := make([]byte, encryption.MaxHeaderSize)
+	if size < uint64(len(b)) {
+		b = b[:size]
+	}
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return "", 0, err
+	}
+	keyID, headerSize, _, err := encryption.ParseHeader(b)
+	return keyID, uint64(headerSize), err
+}
+
+// Init writes and syncs the headers of a new store, with the latest format
+// version, so a crash cannot leave them incomplete once records follow. Its
+// records are encrypted with the key with the given id, unless it is empty.
+// Encrypted stores are given a random id. The store must be empty.
+func (s *store) Init(keyring *encryption.Keyring, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size != 0 {
+		return fmt.Errorf("cannot initialize %s: store is not empty", s.File.Name())
+	}
+	var features feature
+	var storeID []byte
+	if keyID != "" {
+		features |= featureEncrypted
+		storeID = make([]byte, storeIDLen)
+		if _, err := rand.Read(storeID); err != nil {
+			return fmt.Errorf("cannot initialize %s: %w", s.File.Name(), err)
+		}
+	}
+	header := appendFormatHeader(nil, features)
+	if keyID != "" {
+		header = encryption.AppendHeader(append(header, storeID...), keyID)
+	}
+	n, err := s.File.Write(header)
+	if err == nil {
+		err = s.File.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	s.size = uint64(n)
+	s.dataStart = s.size
+	s.version = formatVersion
+	s.cipher = recordCipher{keyring: keyring, keyID: keyID, storeID: storeID}
+	return nil
+}
+
 // Name returns the store's file path.
 func (s *store) Name() string {
 	return s.File.Name()
 }
 
-// Append persists the given bytes to the store.
+// Append persists the given record to the store, compressed with the codec
+// chosen for its key unless that does not make it smaller.
 func (s *store) Append(rec *ddbv1.Record) (n, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	b, err := proto.Marshal(rec)
+	raw, err := proto.Marshal(rec)
 	if err != nil {
 		return 0, 0, fmt.Errorf("store failed to marshal record: %w", err)
 	}
+	codec := s.compression.For(rec.Key)
+	b, err := compress.Encode(codec, raw)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(b) >= len(raw) {
+		codec, b = compress.None, raw
+	}
+	compressedLen := uint64(len(b))
 
 	pos = s.size
-	recordLen := uint64(len(b))
-
-	// calculate checksum
-	s.hash.Reset()
-	if _, err = s.hash.Write(b); err != nil {
+	if b, err = s.cipher.seal(pos, b); err != nil {
 		return 0, 0, err
 	}
-	checksum := s.hash.Sum32()
+	recordLen := uint64(len(b))
+
+	checksum := crc32.Checksum(b, crcTable)
 
 	// serialize header
 	metadata := [storeHeaderSize]byte{}
-	binary.BigEndian.PutUint32(metadata[:checksumSize], checksum)
-	binary.BigEndian.PutUint64(metadata[checksumSize:], recordLen)
+	encoding.PutUint32(metadata[:checksumSize], checksum)
+	encoding.PutUint64(metadata[checksumSize:], uint64(codec)<<codecShift|recordLen)
 
 	// write header
 	bytesMetadata, err := s.buf.Write(metadata[:])
@@ -102,12 +387,41 @@ func (s *store) Append(rec *ddbv1.Record) (n, pos uint64, err error) {
 
 	writtenBytes := uint64(bytesMetadata + bytesRecord)
 	s.size += writtenBytes
+	s.rawBytes += uint64(len(raw))
+	s.storedBytes += compressedLen
+	compressionRawBytes.WithLabelValues(codec.String()).Add(float64(len(raw)))
+	compressionStoredBytes.WithLabelValues(codec.String()).Add(float64(compressedLen))
 
 	return writtenBytes, pos, nil
 }
 
+// Map maps the store in memory, so its records are read without locking. No
+// records may be appended to the store afterwards. It does nothing on
+// platforms without mmap.
+func (s *store) Map() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data != nil || s.size == 0 {
+		return nil
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	data, err := mmap(s.File, s.size)
+	if err != nil {
+		return fmt.Errorf("failed to map %s: %w", s.File.Name(), err)
+	}
+	s.data = data
+	return nil
+}
+
 // Read returns the record stored at the given position.
 func (s *store) Read(pos uint64) (*ddbv1.Record, error) {
+	if s.data != nil {
+		return s.readMapped(pos)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -115,24 +429,82 @@ func (s *store) Read(pos uint64) (*ddbv1.Record, error) {
 		return nil, err
 	}
 
-	recordLen := make([]byte, recLenSize)
-	if _, err := s.File.ReadAt(recordLen, int64(pos+checksumSize)); err != nil {
-		return nil, err
+	var header [storeHeaderSize]byte
+	if _, err := s.File.ReadAt(header[:], int64(pos)); err != nil {
+		return nil, s.readError(pos, err)
+	}
+	checksum, codec, recordLen := parseHeader(header)
+	if recordLen > s.size-pos-storeHeaderSize {
+		return nil, s.corrupted(pos, io.ErrUnexpectedEOF)
 	}
 
-	b := make([]byte, encoding.Uint64(recordLen))
+	b := make([]byte, recordLen)
 	if _, err := s.File.ReadAt(b, int64(pos+storeHeaderSize)); err != nil {
-		return nil, err
+		return nil, s.readError(pos, err)
 	}
 
 	rec := &ddbv1.Record{}
-	if err := proto.Unmarshal(b, rec); err != nil {
-		return nil, fmt.Errorf("store failed to marshal record: %w", err)
+	if err := decodeRecord(s.cipher, pos, checksum, codec, b, rec); err != nil {
+		return nil, s.corrupted(pos, err)
 	}
+	return rec, nil
+}
 
+// readMapped returns the record stored at the given position of a mapped
+// store. Its bytes are decoded in place, since unmarshaling copies them.
+func (s *store) readMapped(pos uint64) (*ddbv1.Record, error) {
+	size := uint64(len(s.data))
+	if pos > size || size-pos < storeHeaderSize {
+		return nil, s.corrupted(pos, io.ErrUnexpectedEOF)
+	}
+	checksum, codec, recordLen := parseHeader(*(*[storeHeaderSize]byte)(s.data[pos:]))
+	if recordLen > size-pos-storeHeaderSize {
+		return nil, s.corrupted(pos, io.ErrUnexpectedEOF)
+	}
+	start := pos + storeHeaderSize
+	rec := &ddbv1.Record{}
+	if err := decodeRecord(s.cipher, pos, checksum, codec, s.data[start:start+recordLen], rec); err != nil {
+		return nil, s.corrupted(pos, err)
+	}
 	return rec, nil
 }
 
+// parseHeader returns the checksum, codec and length of a record from its header.
+func parseHeader(header [storeHeaderSize]byte) (checksum uint32, codec compress.Codec, recordLen uint64) {
+	checksum = encoding.Uint32(header[:checksumSize])
+	recordLen = encoding.Uint64(header[checksumSize:])
+	return checksum, compress.Codec(recordLen >> codecShift), recordLen & recLenMask
+}
+
+// decodeRecord verifies the checksum of the stored bytes of the record at the
+// given position, then decrypts, decompresses and unmarshals them into rec.
+func decodeRecord(c recordCipher, pos uint64, checksum uint32, codec compress.Codec, b []byte, rec *ddbv1.Record) error {
+	if c := crc32.Checksum(b, crcTable); c != checksum {
+		return fmt.Errorf("checksum mismatch. Expected %d, got %d", checksum, c)
+	}
+	b, err := c.open(pos, b)
+	if err != nil {
+		return err
+	}
+	raw, err := compress.Decode(codec, b)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(raw, rec)
+}
+
+// readError reports reading beyond the end of the store as a corruption.
+func (s *store) readError(pos uint64, err error) error {
+	if errors.Is(err, io.EOF) {
+		return s.corrupted(pos, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+func (s *store) corrupted(pos uint64, reason error) error {
+	return &CorruptionError{Path: s.File.Name(), Offset: pos, Reason: reason}
+}
+
 // ReadAt reads len(in) bytes from the store starting at byte offset off.
 // It implements the io.ReaderAt interface.
 func (s *store) ReadAt(in []byte, offset int64) (int, error) {
@@ -146,18 +518,18 @@ func (s *store) ReadAt(in []byte, offset int64) (int, error) {
 	return s.File.ReadAt(in, offset)
 }
 
-// Sync flushes the store to disk.
+// Sync flushes the store to disk. Appends can proceed while the file is being synced.
 func (s *store) Sync() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if err := s.buf.Flush(); err != nil {
+	err := s.buf.Flush()
+	s.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return s.File.Sync()
 }
 
-// Close persists any buffered data before closing the file.
+// Close persists any buffered data before closing the file, and unmaps it.
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -165,63 +537,163 @@ func (s *store) Close() error {
 	if err := s.buf.Flush(); err != nil {
 		return err
 	}
+	if s.data != nil {
+		if err := munmap(s.data); err != nil {
+			return err
+		}
+		s.data = nil
+	}
 	return s.File.Close()
 }
 
-// Scanner returns a new storeScanner for iterating over the records in the store.
+// Truncate discards the data of the store after the given size.
+func (s *store) Truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	return nil
+}
+
+// errInvalidRecord is the reason of a CorruptionError for a record that has a
+// valid checksum but could not have been written by the store, such as the
+// zeroes left by a crash when the file size was updated before its data.
+var errInvalidRecord = errors.New("invalid record")
+
+// CorruptionError is returned when a record of a store cannot be read back.
+type CorruptionError struct {
+	// Path is the path of the store file.
+	Path string
+	// Offset is the position of the first byte of the corrupted record.
+	Offset uint64
+	// Reason is the underlying error. It is io.ErrUnexpectedEOF if the record
+	// extends beyond the end of the file.
+	Reason error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s is corrupted at offset %d: %v", e.Path, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Reason
+}
+
+// Is makes CorruptionError match backend.ErrCorrupted.
+func (e *CorruptionError) Is(target error) bool {
+	return target == backend.ErrCorrupted
+}
+
+// Scanner returns a new storeScanner for iterating over the records in the
+// store. It only sees the records appended before it was created.
 func (s *store) Scanner() (*storeScanner, error) {
-	f, err := os.Open(s.File.Name())
+	s.mu.Lock()
+	err := s.buf.Flush()
+	size := s.size
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return newStoreScanner(s.File, size, s.cipher.keyring)
+}
+
+// openStoreScanner opens the store file with the given name, and returns a
+// new storeScanner for its first size bytes, which closes it once done.
+func openStoreScanner(fsys vfs.FS, name string, size uint64, keyring *encryption.Keyring) (*storeScanner, error) {
+	f, err := vfs.Open(fsys, name)
 	if err != nil {
 		return nil, err
 	}
+	scanner, err := newStoreScanner(f, size, keyring)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	scanner.closer = f
+	return scanner, nil
+}
+
+// newStoreScanner returns a new storeScanner for the first size bytes of the
+// store file, read at their offsets so the file can be shared with its store.
+// The keyring decrypts encrypted stores.
+func newStoreScanner(f vfs.File, size uint64, keyring *encryption.Keyring) (*storeScanner, error) {
+	header, err := readStoreHeader(f, size)
+	cipher := recordCipher{keyring: keyring, keyID: header.keyID, storeID: header.storeID}
+	if err == nil {
+		err = cipher.check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	dataStart := header.size
 	return &storeScanner{
-		file:   f,
-		crc:    crc32.New(crcTable),
-		record: &ddbv1.Record{},
+		file:    f,
+		reader:  io.NewSectionReader(f, int64(dataStart), int64(size-dataStart)),
+		size:    size,
+		cipher:  cipher,
+		record:  &ddbv1.Record{},
+		pos:     dataStart,
+		nextPos: dataStart,
 	}, nil
 }
 
 // storeScanner enables iterating over the records in the store.
 type storeScanner struct {
-	file    *os.File
-	crc     hash.Hash32
+	file vfs.File
+	// closer closes the file once the scan is done, if the scanner opened it.
+	closer  io.Closer
+	reader  io.Reader
+	size    uint64
+	cipher  recordCipher
 	record  *ddbv1.Record
 	pos     uint64
 	nextPos uint64
 	err     error
 }
 
-// Scan advances the scanner to the next record.
+// Scan advances the scanner to the next record. Records that cannot be read
+// back stop the scan with a *CorruptionError.
 func (s *storeScanner) Scan() bool {
 	s.record.Reset()
 
 	var header [storeHeaderSize]byte
-	if _, s.err = io.ReadFull(s.file, header[:]); s.err != nil {
+	if _, s.err = io.ReadFull(s.reader, header[:]); s.err != nil {
 		if errors.Is(s.err, io.EOF) {
 			s.err = nil
+		} else if errors.Is(s.err, io.ErrUnexpectedEOF) {
+			s.err = s.corrupted(io.ErrUnexpectedEOF)
 		}
 		return false
 	}
 
-	checksum := binary.BigEndian.Uint32(header[:checksumSize])
-	recordLen := binary.BigEndian.Uint64(header[checksumSize:])
+	checksum, codec, recordLen := parseHeader(header)
 
-	data := make([]byte, recordLen)
-	if _, s.err = io.ReadFull(s.file, data); s.err != nil {
+	// don't trust the length of a torn record to allocate its buffer
+	if recordLen > s.size-s.nextPos-storeHeaderSize {
+		s.err = s.corrupted(io.ErrUnexpectedEOF)
 		return false
 	}
 
-	s.crc.Reset()
-	if _, s.err = s.crc.Write(data); s.err != nil {
+	data := make([]byte, recordLen)
+	if _, s.err = io.ReadFull(s.reader, data); s.err != nil {
+		if errors.Is(s.err, io.ErrUnexpectedEOF) {
+			s.err = s.corrupted(io.ErrUnexpectedEOF)
+		}
 		return false
 	}
-	c := s.crc.Sum32()
-	if c != checksum {
-		s.err = fmt.Errorf("checksum mismatch. Expected %d, got %d", checksum, c)
+
+	if err := decodeRecord(s.cipher, s.nextPos, checksum, codec, data, s.record); err != nil {
+		s.err = s.corrupted(err)
 		return false
 	}
-
-	if s.err = proto.Unmarshal(data, s.record); s.err != nil {
+	if s.record.Key == "" {
+		s.err = s.corrupted(errInvalidRecord)
 		return false
 	}
 
@@ -231,12 +703,30 @@ func (s *storeScanner) Scan() bool {
 	return true
 }
 
+// corrupted returns a CorruptionError for the record being scanned.
+func (s *storeScanner) corrupted(reason error) error {
+	return &CorruptionError{Path: s.file.Name(), Offset: s.nextPos, Reason: reason}
+}
+
 // Returns the current record.
 func (s *storeScanner) Next() (rec *ddbv1.Record, pos uint64) {
 	return s.record, s.pos
 }
 
+// Size returns the size in bytes of the current record, including its header.
+func (s *storeScanner) Size() uint64 {
+	return s.nextPos - s.pos
+}
+
 // Returns last error encountered by the scanner.
 func (s *storeScanner) Err() error {
 	return s.err
 }
+
+// Close closes the file being scanned, if the scanner opened it.
+func (s *storeScanner) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
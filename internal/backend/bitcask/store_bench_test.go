@@ -51,3 +51,47 @@ func benchmarkStore(b *testing.B, dataSize, batchSize int) {
 	}
 	b.StopTimer()
 }
+
+func BenchmarkStoreRead100File(b *testing.B)    { benchmarkStoreRead(b, 100, false) }
+func BenchmarkStoreRead100Mapped(b *testing.B)  { benchmarkStoreRead(b, 100, true) }
+func BenchmarkStoreRead1000File(b *testing.B)   { benchmarkStoreRead(b, 1000, false) }
+func BenchmarkStoreRead1000Mapped(b *testing.B) { benchmarkStoreRead(b, 1000, true) }
+
+// benchmarkStoreRead reads random records from parallel goroutines, as Get
+// does, from a store that is either read from its file or mapped in memory.
+func benchmarkStoreRead(b *testing.B, dataSize int, mapped bool) {
+	b.Helper()
+	b.ReportAllocs()
+	f, err := os.OpenFile(filepath.Join(b.TempDir(), "commitlog_bench"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	require.NoError(b, err)
+	store, err := newStore(f)
+	require.NoError(b, err)
+	defer store.Close()
+
+	record := &ddbv1.Record{
+		Timestamp: time.Now().Unix(),
+		Key:       "key",
+		Value:     []byte(strings.Repeat("a", dataSize)),
+	}
+	b.SetBytes(int64(proto.Size(record)))
+	positions := make([]uint64, 1000)
+	for i := range positions {
+		_, positions[i], err = store.Append(record)
+		require.NoError(b, err)
+	}
+	require.NoError(b, store.Sync())
+	if mapped {
+		require.NoError(b, store.Map())
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := store.Read(positions[i*7919%len(positions)]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+}
@@ -1,11 +1,17 @@
 package bitcask
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/compress"
+	"github.com/danielfsousa/ddb/internal/encryption"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 )
@@ -105,6 +111,29 @@ func testReadAt(t *testing.T, store *store) {
 	}
 }
 
+func TestStoreMap(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "store_map_test")
+	require.NoError(t, err)
+	store, err := newStore(f)
+	require.NoError(t, err)
+
+	testAppend(t, store)
+	require.NoError(t, store.Map())
+	require.Equal(t, int(store.size), len(store.data))
+	testRead(t, store)
+	testScanner(t, store)
+
+	var corrupt *CorruptionError
+	_, err = store.Read(store.size - 1)
+	require.ErrorAs(t, err, &corrupt)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = store.Read(store.size + 1)
+	require.ErrorAs(t, err, &corrupt)
+
+	require.NoError(t, store.Close())
+	require.Nil(t, store.data)
+}
+
 func TestStoreSync(t *testing.T) {
 	f, err := os.CreateTemp(t.TempDir(), "store_sync_test")
 	require.NoError(t, err)
@@ -161,3 +190,189 @@ func openFile(name string) (file *os.File, size int64, err error) {
 
 	return f, fi.Size(), nil
 }
+
+func TestStoreCompression(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "store_compression_test")
+	require.NoError(t, err)
+	store, err := newStore(f)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte(`{"compressible":true}`), 50)
+	var positions []uint64
+	for _, c := range []compress.Codec{compress.None, compress.Snappy, compress.Zstd} {
+		// records of the same store can be written with different codecs
+		store.compression = compress.Policy{Default: c}
+		n, pos, err := store.Append(&ddbv1.Record{Key: c.String(), Value: value})
+		require.NoError(t, err)
+		if c != compress.None {
+			require.Less(t, n, uint64(len(value)), c)
+		}
+		positions = append(positions, pos)
+	}
+	// records that do not shrink are stored uncompressed
+	_, pos, err := store.Append(&ddbv1.Record{Key: "tiny", Value: []byte("x")})
+	require.NoError(t, err)
+	require.Greater(t, store.rawBytes, store.storedBytes)
+
+	var header [storeHeaderSize]byte
+	_, err = store.ReadAt(header[:], int64(pos))
+	require.NoError(t, err)
+	_, codec, _ := parseHeader(header)
+	require.Equal(t, compress.None, codec)
+
+	store, err = newStore(f)
+	require.NoError(t, err)
+	for i, c := range []compress.Codec{compress.None, compress.Snappy, compress.Zstd} {
+		rec, err := store.Read(positions[i])
+		require.NoError(t, err)
+		require.Equal(t, c.String(), rec.Key)
+		require.Equal(t, value, rec.Value)
+	}
+
+	scanner, err := store.Scanner()
+	require.NoError(t, err)
+	defer scanner.Close()
+	scanned := 0
+	for scanner.Scan() {
+		scanned++
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 4, scanned)
+}
+
+func TestStoreFormats(t *testing.T) {
+	keyring := encryption.NewKeyring(&encryption.KeyFile{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	})
+	// version 0 stores were written without a format header
+	version0 := func(t *testing.T, store *store, keyID string) {
+		if keyID == "" {
+			return
+		}
+		n, err := store.buf.Write(encryption.AppendHeader(nil, keyID))
+		require.NoError(t, err)
+		store.size, store.dataStart = uint64(n), uint64(n)
+		store.cipher = recordCipher{keyring: keyring, keyID: keyID}
+	}
+	// version 1 stores were encrypted without a store id
+	version1 := func(t *testing.T, store *store, keyID string) {
+		header := appendVersion1Header(keyID)
+		n, err := store.buf.Write(header)
+		require.NoError(t, err)
+		store.size, store.dataStart, store.version = uint64(n), uint64(n), 1
+		store.cipher = recordCipher{keyring: keyring, keyID: keyID}
+	}
+	latest := func(t *testing.T, store *store, keyID string) {
+		require.NoError(t, store.Init(keyring, keyID))
+	}
+
+	tests := map[string]func(t *testing.T){
+		"reads version 0":                    func(t *testing.T) { testStoreFormat(t, keyring, version0, "", 0) },
+		"reads encrypted version 0":          func(t *testing.T) { testStoreFormat(t, keyring, version0, "k1", 0) },
+		"reads encrypted version 1":          func(t *testing.T) { testStoreFormat(t, keyring, version1, "k1", 1) },
+		"reads the latest version":           func(t *testing.T) { testStoreFormat(t, keyring, latest, "", formatVersion) },
+		"reads the encrypted latest version": func(t *testing.T) { testStoreFormat(t, keyring, latest, "k1", formatVersion) },
+		"refuses a newer version":            func(t *testing.T) { testStoreHeaderRefused(t, formatVersion+1, 0) },
+		"refuses unknown features":           func(t *testing.T) { testStoreHeaderRefused(t, formatVersion, 1<<31) },
+		"refuses records of another store":   func(t *testing.T) { testStoreMovedRecord(t, keyring) },
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, fn)
+	}
+}
+
+func testStoreFormat(t *testing.T, keyring *encryption.Keyring, init func(*testing.T, *store, string), keyID string, version uint16) {
+	f, err := os.CreateTemp(t.TempDir(), "store_format_test")
+	require.NoError(t, err)
+	store, err := newStore(f)
+	require.NoError(t, err)
+	init(t, store, keyID)
+
+	var positions []uint64
+	for _, rec := range expectedWrites {
+		_, pos, err := store.Append(rec)
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, store.Close())
+
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	store, err = newStore(f)
+	require.NoError(t, err)
+	defer store.Close()
+	require.Equal(t, version, store.version)
+	require.Equal(t, keyID, store.cipher.keyID)
+	store.cipher.keyring = keyring
+	for i, pos := range positions {
+		rec, err := store.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, expectedWrites[i].Key, rec.Key)
+	}
+
+	scanner, err := newStoreScanner(store.File, store.size, keyring)
+	require.NoError(t, err)
+	for i := 0; scanner.Scan(); i++ {
+		rec, pos := scanner.Next()
+		require.Equal(t, positions[i], pos)
+		require.Equal(t, expectedWrites[i].Value, rec.Value)
+	}
+	require.NoError(t, scanner.Err())
+}
+
+// appendVersion1Header returns the headers of an encrypted store of version 1.
+func appendVersion1Header(keyID string) []byte {
+	header := encoding.AppendUint16(append([]byte{}, formatMagic...), 1)
+	header = encoding.AppendUint32(header, uint32(featureEncrypted))
+	return encryption.AppendHeader(header, keyID)
+}
+
+// testStoreMovedRecord copies a record to the same position of another store
+// encrypted with the same key, which must not accept it.
+func testStoreMovedRecord(t *testing.T, keyring *encryption.Keyring) {
+	dir := t.TempDir()
+	var names [2]string
+	var pos uint64
+	for i := range names {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d%s", i, storeExt)))
+		require.NoError(t, err)
+		store, err := newStore(f)
+		require.NoError(t, err)
+		require.NoError(t, store.Init(keyring, "k1"))
+		_, pos, err = store.Append(expectedWrites[i])
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+		names[i] = f.Name()
+	}
+	from, err := os.ReadFile(names[0])
+	require.NoError(t, err)
+	to, err := os.ReadFile(names[1])
+	require.NoError(t, err)
+	require.Equal(t, from[:formatHeaderLen], to[:formatHeaderLen])
+	require.NotEqual(t, from[:pos], to[:pos])
+	require.NoError(t, os.WriteFile(names[1], append(to[:pos], from[pos:]...), 0o644))
+
+	f, err := os.OpenFile(names[1], os.O_RDWR, 0)
+	require.NoError(t, err)
+	store, err := newStore(f)
+	require.NoError(t, err)
+	defer store.Close()
+	store.cipher.keyring = keyring
+	_, err = store.Read(pos)
+	require.ErrorIs(t, err, encryption.ErrDecrypt)
+}
+
+func testStoreHeaderRefused(t *testing.T, version uint16, features feature) {
+	f, err := os.CreateTemp(t.TempDir(), "store_format_test")
+	require.NoError(t, err)
+	defer f.Close()
+	header := encoding.AppendUint16(append([]byte{}, formatMagic...), version)
+	_, err = f.Write(encoding.AppendUint32(header, uint32(features)))
+	require.NoError(t, err)
+
+	_, err = newStore(f)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	_, err = newStoreScanner(f, formatHeaderLen, nil)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
@@ -0,0 +1,327 @@
+package lsm
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// compaction merges tables into a level, replacing them with tables that do
+// not overlap.
+type compaction struct {
+	// level is the level compacted, and output the level the merged tables
+	// are written to.
+	level, output int
+	// inputs are the tables replaced in each level.
+	inputs [numLevels][]*table
+	// dropTombstones is true if no level below the output may hold older
+	// values of the keys of the inputs, which tombstones would hide.
+	dropTombstones bool
+}
+
+// maybeCompact wakes up the background compaction, if it is not already running.
+func (l *LSM) maybeCompact() {
+	select {
+	case l.compactc <- struct{}{}:
+	default:
+	}
+}
+
+func (l *LSM) compactLoop() {
+	defer close(l.done)
+	for range l.compactc {
+		if err := l.compact(context.Background()); err != nil {
+			l.logger.Error().Err(err).Msg("compaction failed")
+		}
+	}
+}
+
+// compact runs compactions until every level is within its size.
+func (l *LSM) compact(ctx context.Context) error {
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+	for {
+		l.mu.RLock()
+		var c *compaction
+		if !l.closed {
+			c = l.pickCompaction()
+		}
+		l.mu.RUnlock()
+		if c == nil {
+			return nil
+		}
+		if err := l.runCompaction(ctx, c); err != nil {
+			return err
+		}
+	}
+}
+
+// pickCompaction returns the next compaction to run, or nil if there is none.
+// Level 0 is compacted once it has Config.L0Tables tables, and the other
+// levels once they hold more than their size, one table at a time. It must
+// be called while holding the lock.
+func (l *LSM) pickCompaction() *compaction {
+	if len(l.levels[0]) >= l.Config.L0Tables {
+		c := &compaction{level: 0, output: 1}
+		c.inputs[0] = append([]*table(nil), l.levels[0]...)
+		return l.expand(c)
+	}
+	maxBytes := l.Config.BaseLevelBytes
+	for level := 1; level < numLevels-1; level++ {
+		tables := l.levels[level]
+		var size uint64
+		for _, t := range tables {
+			size += t.size
+		}
+		if size > maxBytes {
+			// the first table after the last one compacted, wrapping around
+			i := sort.Search(len(tables), func(i int) bool {
+				return tables[i].smallest > l.compactPointers[level]
+			})
+			if i == len(tables) {
+				i = 0
+			}
+			c := &compaction{level: level, output: level + 1}
+			c.inputs[level] = []*table{tables[i]}
+			return l.expand(c)
+		}
+		maxBytes *= l.Config.LevelMultiplier
+	}
+	return nil
+}
+
+// expand adds the tables of the output level that overlap the inputs of a
+// compaction, and decides if it can drop tombstones.
+func (l *LSM) expand(c *compaction) *compaction {
+	smallest, largest := bounds(c.inputs[c.level])
+	for _, t := range l.levels[c.output] {
+		if t.Overlaps(smallest, largest) {
+			c.inputs[c.output] = append(c.inputs[c.output], t)
+		}
+	}
+	if lo, hi := bounds(c.inputs[c.output]); len(c.inputs[c.output]) > 0 {
+		if lo < smallest {
+			smallest = lo
+		}
+		if hi > largest {
+			largest = hi
+		}
+	}
+	c.dropTombstones = true
+	for _, tables := range l.levels[c.output+1:] {
+		for _, t := range tables {
+			if t.Overlaps(smallest, largest) {
+				c.dropTombstones = false
+			}
+		}
+	}
+	return c
+}
+
+// bounds returns the smallest and largest keys of the given tables.
+func bounds(tables []*table) (smallest, largest string) {
+	for i, t := range tables {
+		if i == 0 || t.smallest < smallest {
+			smallest = t.smallest
+		}
+		if i == 0 || t.largest > largest {
+			largest = t.largest
+		}
+	}
+	return smallest, largest
+}
+
+// runCompaction writes the merged tables of a compaction, without holding the
+// lock, then replaces its inputs with them.
+func (l *LSM) runCompaction(ctx context.Context, c *compaction) error {
+	_, span := tracer.Start(ctx, "lsm.Compact", traceCompaction(c))
+	defer span.End()
+	defer observeSince(compactionDuration, time.Now())
+
+	outputs, err := l.writeCompacted(c)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+	if err := l.applyCompaction(c, outputs); err != nil {
+		recordError(span, err)
+		return err
+	}
+	for _, t := range outputs {
+		compactionWrittenBytes.Add(float64(t.size))
+	}
+	compactionsTotal.WithLabelValues(strconv.Itoa(c.output)).Inc()
+	return nil
+}
+
+func traceCompaction(c *compaction) trace.SpanStartOption {
+	var inputs int
+	for _, tables := range c.inputs {
+		inputs += len(tables)
+	}
+	return trace.WithAttributes(
+		attribute.Int("lsm.level", c.level),
+		attribute.Int("lsm.output_level", c.output),
+		attribute.Int("lsm.inputs", inputs),
+	)
+}
+
+// writeCompacted merges the inputs of a compaction into tables of about
+// Config.TableBytes, keeping the newest value of each key.
+func (l *LSM) writeCompacted(c *compaction) (outputs []*table, err error) {
+	var its []iterator
+	for i := len(c.inputs[0]) - 1; i >= 0; i-- {
+		its = append(its, c.inputs[0][i].iterator())
+	}
+	for _, tables := range c.inputs[1:] {
+		if len(tables) > 0 {
+			its = append(its, newLevelIterator(tables))
+		}
+	}
+
+	var w *tableWriter
+	defer func() {
+		if err == nil {
+			return
+		}
+		if w != nil {
+			w.Abort()
+		}
+		for _, t := range outputs {
+			t.Remove()
+		}
+	}()
+	finish := func() error {
+		t, err := w.Finish()
+		w = nil
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, t)
+		return nil
+	}
+
+	it := newMergingIterator(its...)
+	for it.SeekGE(""); it.Valid(); it.Next() {
+		if c.dropTombstones && isTombstone(it.Value()) {
+			continue
+		}
+		if w == nil {
+			if w, err = newTableWriter(l.Config.FS, l.Dir, l.nextFile.Add(1)-1, l.Config.BlockBytes); err != nil {
+				return outputs, err
+			}
+		}
+		if err = w.Add(it.Key(), it.Value()); err != nil {
+			return outputs, err
+		}
+		if w.Size() >= l.Config.TableBytes {
+			if err = finish(); err != nil {
+				return outputs, err
+			}
+		}
+	}
+	if err = it.Err(); err != nil {
+		return outputs, err
+	}
+	if w != nil {
+		err = finish()
+	}
+	return outputs, err
+}
+
+// applyCompaction replaces the inputs of a compaction with its outputs and
+// removes them. Tables flushed to level 0 meanwhile are kept. The manifest is
+// written while holding the install lock, so reads and writes are only
+// blocked while the levels are replaced.
+func (l *LSM) applyCompaction(c *compaction, outputs []*table) error {
+	replaced := make(map[*table]bool)
+	for _, tables := range c.inputs {
+		for _, t := range tables {
+			replaced[t] = true
+		}
+	}
+
+	l.install.Lock()
+	l.mu.RLock()
+	var levels [numLevels][]*table
+	for level, tables := range l.levels {
+		for _, t := range tables {
+			if !replaced[t] {
+				levels[level] = append(levels[level], t)
+			}
+		}
+	}
+	walID := l.oldestWAL()
+	l.mu.RUnlock()
+	levels[c.output] = append(levels[c.output], outputs...)
+	sort.Slice(levels[c.output], func(i, j int) bool {
+		return levels[c.output][i].smallest < levels[c.output][j].smallest
+	})
+	err := l.writeManifest(levels, walID)
+	if err != nil {
+		l.install.Unlock()
+		for _, t := range outputs {
+			t.Remove()
+		}
+		return err
+	}
+	l.mu.Lock()
+	l.levels = levels
+	if c.level > 0 {
+		_, l.compactPointers[c.level] = bounds(c.inputs[c.level])
+	}
+	l.mu.Unlock()
+	l.install.Unlock()
+
+	for t := range replaced {
+		if err := t.Remove(); err != nil {
+			l.logger.Warn().Err(err).Uint64("table", t.id).Msg("failed to remove compacted table")
+		}
+	}
+	return nil
+}
+
+// Merge flushes the memtable and compacts every table into the deepest
+// level, dropping tombstones. Writes are not blocked while tables are merged.
+func (l *LSM) Merge(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "lsm.Merge")
+	defer span.End()
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	l.mu.RLock()
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return errClosed
+	}
+	if err := l.flush(); err != nil {
+		recordError(span, err)
+		return err
+	}
+	l.mu.Lock()
+	c := &compaction{output: 1, dropTombstones: true}
+	var inputs int
+	for level, tables := range l.levels {
+		c.inputs[level] = append([]*table(nil), tables...)
+		if len(tables) > 0 && level > c.output {
+			c.output = level
+		}
+		inputs += len(tables)
+	}
+	l.mu.Unlock()
+
+	if inputs > 0 {
+		if err := l.runCompaction(ctx, c); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	l.lastMerge = time.Now()
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,58 @@
+package lsm
+
+import (
+	"github.com/danielfsousa/ddb/internal/compress"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+type Config struct {
+	// MemtableBytes is the size the memtable reaches before it is flushed
+	// to a table of level 0.
+	MemtableBytes uint64
+	// BlockBytes is the size of the blocks of tables, the unit they are
+	// read in.
+	BlockBytes uint64
+	// TableBytes is the size of the tables written by compactions.
+	TableBytes uint64
+	// L0Tables is the number of tables of level 0 that triggers their
+	// compaction into level 1.
+	L0Tables int
+	// BaseLevelBytes is the size of level 1 that triggers the compaction of
+	// one of its tables into level 2. Each level below holds LevelMultiplier
+	// times more than the one above.
+	BaseLevelBytes  uint64
+	LevelMultiplier uint64
+	// Repair truncates the write-ahead log where it is corrupted, instead of
+	// failing to open it. The tail left incomplete by a crash is always
+	// truncated.
+	Repair bool
+	// Compression chooses the codec values are compressed with.
+	Compression compress.Policy
+	// FS is the file system the tree is stored in. It is the file system of
+	// the operating system if nil.
+	FS vfs.FS
+}
+
+func (c *Config) setDefaults() {
+	if c.FS == nil {
+		c.FS = vfs.OS
+	}
+	if c.MemtableBytes == 0 {
+		c.MemtableBytes = 4 << 20 // 4MB
+	}
+	if c.BlockBytes == 0 {
+		c.BlockBytes = 4 << 10 // 4KB
+	}
+	if c.TableBytes == 0 {
+		c.TableBytes = 2 << 20 // 2MB
+	}
+	if c.L0Tables == 0 {
+		c.L0Tables = 4
+	}
+	if c.BaseLevelBytes == 0 {
+		c.BaseLevelBytes = 10 << 20 // 10MB
+	}
+	if c.LevelMultiplier == 0 {
+		c.LevelMultiplier = 10
+	}
+}
@@ -0,0 +1,38 @@
+package lsm
+
+import (
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/backend/backendtest"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	// trees that only compact when merged are tested too, as their levels
+	// are only changed by merges.
+	foreground := testConfig()
+	foreground.L0Tables = 1 << 20
+	foreground.BaseLevelBytes = 1 << 40
+
+	for name, config := range map[string]Config{"background": testConfig(), "foreground": foreground} {
+		t.Run(name, func(t *testing.T) {
+			backendtest.Suite{
+				Open: func(t *testing.T, dir string) backend.Backend {
+					tree, err := NewLSMBackend(dir, config)
+					require.NoError(t, err)
+					return tree
+				},
+				OpenFS: func(t *testing.T, fsys vfs.FS, dir string) backend.Backend {
+					config := config
+					config.FS = fsys
+					tree, err := NewLSMBackend(dir, config)
+					require.NoError(t, err)
+					return tree
+				},
+				Persistent: true,
+			}.Run(t)
+		})
+	}
+}
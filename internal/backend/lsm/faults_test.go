@@ -0,0 +1,126 @@
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string, c Config, f *faults){
+		"keeps writing while flushes fail": testFailedFlush,
+		"reads the memtable being flushed": testSlowFlush,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			f := &faults{}
+			c := testConfig()
+			c.FS = vfs.WithFaults(vfs.NewMemFS(), f.inject)
+			dir := t.TempDir()
+			require.NoError(t, c.FS.MkdirAll(dir, 0o755))
+			fn(t, dir, c, f)
+		})
+	}
+}
+
+// faults fails or delays the operations of a file system on the files whose
+// names have a given suffix.
+type faults struct {
+	mu     sync.Mutex
+	op     vfs.Op
+	suffix string
+	// wait delays the operations instead of failing them, if set.
+	wait chan struct{}
+}
+
+func (f *faults) fail(op vfs.Op, suffix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.op, f.suffix = op, suffix
+}
+
+// delay blocks the operations until the returned function is called.
+func (f *faults) delay(op vfs.Op, suffix string) (release func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.op, f.suffix, f.wait = op, suffix, make(chan struct{})
+	wait := f.wait
+	return func() {
+		f.fail("", "")
+		close(wait)
+	}
+}
+
+func (f *faults) inject(op vfs.Op, name string) error {
+	f.mu.Lock()
+	match := op == f.op && strings.HasSuffix(name, f.suffix)
+	wait := f.wait
+	f.mu.Unlock()
+	switch {
+	case !match:
+		return nil
+	case wait != nil:
+		<-wait
+		return nil
+	default:
+		return vfs.ErrInjected
+	}
+}
+
+func testFailedFlush(t *testing.T, dir string, c Config, f *faults) {
+	tree, err := NewLSMBackend(dir, c)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	f.fail(vfs.OpOpen, tableExt)
+	fillTree(t, tree, 100)
+	for i := 0; i < 100; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+	require.Error(t, tree.Merge(context.Background()))
+
+	f.fail("", "")
+	require.NoError(t, tree.Merge(context.Background()))
+	stats := tree.Stats()
+	require.Zero(t, stats.Segments[len(stats.Segments)-1].Keys, "the memtable was not flushed")
+	tree = reopen(t, tree)
+	for i := 0; i < 100; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+}
+
+func testSlowFlush(t *testing.T, dir string, c Config, f *faults) {
+	tree, err := NewLSMBackend(dir, c)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	release := f.delay(vfs.OpSync, tableExt)
+	var n int
+	for ; ; n++ {
+		set(t, tree, fmt.Sprintf("key-%04d", n), fmt.Sprintf("value-%d", n))
+		tree.mu.RLock()
+		flushing := tree.imm != nil
+		tree.mu.RUnlock()
+		if flushing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// writes and reads go on while the table is written
+	set(t, tree, "after", "value")
+	for i := 0; i <= n; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+	require.Len(t, tree.Keys(), n+2)
+
+	release()
+	waitFlushed(t, tree)
+	require.Len(t, tree.Keys(), n+2)
+	requireValue(t, tree, "after", "value")
+}
@@ -0,0 +1,184 @@
+package lsm
+
+import "sort"
+
+// iterator iterates over sorted entries, in both directions. It starts
+// invalid, until positioned by SeekGE or SeekLT.
+type iterator interface {
+	// SeekGE moves to the first entry whose key is not lower than key.
+	SeekGE(key string)
+	// SeekLT moves to the last entry whose key is lower than key, or to the
+	// last entry if key is empty.
+	SeekLT(key string)
+	Next()
+	Prev()
+	Valid() bool
+	Key() string
+	Value() []byte
+	Err() error
+}
+
+// levelIterator iterates over the tables of a level below level 0, which
+// do not overlap and are sorted by key.
+type levelIterator struct {
+	tables []*table
+	i      int
+	it     *tableIterator
+}
+
+func newLevelIterator(tables []*table) *levelIterator {
+	return &levelIterator{tables: tables, i: -1}
+}
+
+func (it *levelIterator) open(i int) {
+	it.i, it.it = i, nil
+	if i >= 0 && i < len(it.tables) {
+		it.it = it.tables[i].iterator()
+	}
+}
+
+func (it *levelIterator) SeekGE(key string) {
+	i := sort.Search(len(it.tables), func(i int) bool { return it.tables[i].largest >= key })
+	it.open(i)
+	if it.it != nil {
+		it.it.SeekGE(key)
+		it.skipForward()
+	}
+}
+
+func (it *levelIterator) SeekLT(key string) {
+	i := len(it.tables) - 1
+	if key != "" {
+		i = sort.Search(len(it.tables), func(i int) bool { return it.tables[i].smallest >= key }) - 1
+	}
+	it.open(i)
+	if it.it != nil {
+		it.it.SeekLT(key)
+		it.skipBackward()
+	}
+}
+
+func (it *levelIterator) Next() {
+	it.it.Next()
+	it.skipForward()
+}
+
+func (it *levelIterator) Prev() {
+	it.it.Prev()
+	it.skipBackward()
+}
+
+// skipForward moves to the first entry of the next tables once the current
+// one is exhausted.
+func (it *levelIterator) skipForward() {
+	for it.it != nil && !it.it.Valid() && it.it.Err() == nil {
+		it.open(it.i + 1)
+		if it.it != nil {
+			it.it.SeekGE("")
+		}
+	}
+}
+
+// skipBackward moves to the last entry of the previous tables once the
+// current one is exhausted.
+func (it *levelIterator) skipBackward() {
+	for it.it != nil && !it.it.Valid() && it.it.Err() == nil {
+		it.open(it.i - 1)
+		if it.it != nil {
+			it.it.SeekLT("")
+		}
+	}
+}
+
+func (it *levelIterator) Valid() bool   { return it.it != nil && it.it.Valid() }
+func (it *levelIterator) Key() string   { return it.it.Key() }
+func (it *levelIterator) Value() []byte { return it.it.Value() }
+
+func (it *levelIterator) Err() error {
+	if it.it == nil {
+		return nil
+	}
+	return it.it.Err()
+}
+
+// mergingIterator merges iterators over overlapping entries, from the newest
+// to the oldest. Each key is seen once, with its newest value.
+type mergingIterator struct {
+	its     []iterator
+	current int
+	reverse bool
+	err     error
+}
+
+func newMergingIterator(its ...iterator) *mergingIterator {
+	return &mergingIterator{its: its, current: -1}
+}
+
+func (m *mergingIterator) SeekGE(key string) {
+	m.reverse = false
+	for _, it := range m.its {
+		it.SeekGE(key)
+	}
+	m.pick()
+}
+
+func (m *mergingIterator) SeekLT(key string) {
+	m.reverse = true
+	for _, it := range m.its {
+		it.SeekLT(key)
+	}
+	m.pick()
+}
+
+// Next moves past the current key in every iterator. It must not be called
+// after SeekLT.
+func (m *mergingIterator) Next() {
+	key := m.Key()
+	for _, it := range m.its {
+		if it.Valid() && it.Key() == key {
+			it.Next()
+		}
+	}
+	m.pick()
+}
+
+// Prev moves before the current key in every iterator. It must not be called
+// after SeekGE.
+func (m *mergingIterator) Prev() {
+	key := m.Key()
+	for _, it := range m.its {
+		if it.Valid() && it.Key() == key {
+			it.Prev()
+		}
+	}
+	m.pick()
+}
+
+// pick makes the iterator with the lowest key current, or the highest one in
+// reverse. The newest iterator wins ties.
+func (m *mergingIterator) pick() {
+	m.current = -1
+	for i, it := range m.its {
+		if err := it.Err(); err != nil {
+			m.err = err
+			m.current = -1
+			return
+		}
+		if !it.Valid() {
+			continue
+		}
+		if m.current == -1 {
+			m.current = i
+			continue
+		}
+		key, best := it.Key(), m.its[m.current].Key()
+		if (!m.reverse && key < best) || (m.reverse && key > best) {
+			m.current = i
+		}
+	}
+}
+
+func (m *mergingIterator) Valid() bool   { return m.current >= 0 }
+func (m *mergingIterator) Key() string   { return m.its[m.current].Key() }
+func (m *mergingIterator) Value() []byte { return m.its[m.current].Value() }
+func (m *mergingIterator) Err() error    { return m.err }
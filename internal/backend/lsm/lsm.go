@@ -0,0 +1,800 @@
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// numLevels is the number of levels of tables, level 0 included.
+	numLevels = 7
+	// rangePageSize is the number of keys collected by RangeKeys while
+	// holding the lock.
+	rangePageSize = 256
+)
+
+var errClosed = errors.New("lsm: backend is closed")
+
+// LSM is a log-structured merge-tree backend. Records are appended to a
+// write-ahead log and kept sorted in a memtable, which is flushed to a table
+// of level 0 once it is full. Tables are compacted in the background into
+// deeper levels, where they do not overlap.
+type LSM struct {
+	mu sync.RWMutex
+	// maintenance serializes compactions, merges and scrubs, which read
+	// tables without holding mu.
+	maintenance sync.Mutex
+	// flushing serializes flushes, which write tables without holding mu.
+	flushing sync.Mutex
+	// install serializes the changes to the tables of the levels, so the
+	// manifest listing them is written without holding mu.
+	install sync.Mutex
+
+	Dir    string
+	Config Config
+	// lock is held on the lock file of the directory until the tree is
+	// closed.
+	lock io.Closer
+
+	mem *memtable
+	wal *wal
+	// staleWALs are the logs replayed into the memtable besides wal, which
+	// are removed once it is flushed.
+	staleWALs []*wal
+	// imm is the full memtable being flushed, which reads check after mem,
+	// and immWALs are the logs of its entries, removed once it is flushed.
+	imm     *memtable
+	immWALs []*wal
+	// levels holds the tables of each level. Tables of level 0 may overlap
+	// and are sorted from the oldest to the newest, the others are sorted
+	// by key.
+	levels   [numLevels][]*table
+	nextFile atomic.Uint64
+	// compactPointers are the largest keys of the last tables compacted out
+	// of each level, so compactions go round-robin over their keys.
+	compactPointers [numLevels]string
+	lastMerge       time.Time
+	rawBytes        uint64
+	storedBytes     uint64
+
+	closed   bool
+	flushc   chan struct{}
+	flushed  chan struct{}
+	compactc chan struct{}
+	done     chan struct{}
+	logger   *zerolog.Logger
+}
+
+var (
+	_ backend.Backend  = (*LSM)(nil)
+	_ backend.Scrubber = (*LSM)(nil)
+	_ backend.Merger   = (*LSM)(nil)
+	_ backend.Ranger   = (*LSM)(nil)
+)
+
+// NewLSMBackend creates a new LSM backend.
+func NewLSMBackend(dir string, c Config) (*LSM, error) {
+	c.setDefaults()
+	logger := log.With().Str("component", "lsm").Logger()
+	l := &LSM{
+		Dir:      dir,
+		Config:   c,
+		mem:      newMemtable(),
+		flushc:   make(chan struct{}, 1),
+		flushed:  make(chan struct{}),
+		compactc: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		logger:   &logger,
+	}
+	if err := l.setup(); err != nil {
+		l.closeFiles()
+		l.unlock()
+		return nil, err
+	}
+	go l.flushLoop()
+	go l.compactLoop()
+	l.maybeCompact()
+	return l, nil
+}
+
+func (l *LSM) setup() error {
+	if err := l.Config.FS.MkdirAll(l.Dir, 0o755); err != nil {
+		return err
+	}
+	lock, err := l.Config.FS.Lock(path.Join(l.Dir, lockFile))
+	if err != nil {
+		return err
+	}
+	l.lock = lock
+	m, _, err := readManifest(l.Config.FS, l.Dir)
+	if err != nil {
+		return err
+	}
+	l.nextFile.Store(m.NextFile)
+
+	referenced := make(map[uint64]bool)
+	for level, ids := range m.Levels {
+		if level >= numLevels {
+			return fmt.Errorf("%s: too many levels", manifestFile)
+		}
+		for _, id := range ids {
+			t, err := openTable(l.Config.FS, l.Dir, id)
+			if err != nil {
+				return err
+			}
+			l.levels[level] = append(l.levels[level], t)
+			referenced[id] = true
+		}
+	}
+
+	walIDs, err := l.cleanup(m, referenced)
+	if err != nil {
+		return err
+	}
+	for i, id := range walIDs {
+		w, err := openWAL(l.Config.FS, l.Dir, id)
+		if err != nil {
+			return err
+		}
+		if err := l.replay(w, i == len(walIDs)-1); err != nil {
+			w.Close()
+			return err
+		}
+		if l.wal != nil {
+			l.staleWALs = append(l.staleWALs, l.wal)
+		}
+		l.wal = w
+	}
+
+	if l.wal == nil {
+		return l.newWAL()
+	}
+	// logs are only appended to until their memtable is flushed, so
+	// several of them are left by a crash in the middle of a flush.
+	if len(l.staleWALs) > 0 && l.mem.Len() > 0 {
+		return l.flush()
+	}
+	for _, w := range l.staleWALs {
+		if err := w.Remove(); err != nil {
+			return err
+		}
+	}
+	l.staleWALs = nil
+	return nil
+}
+
+// cleanup removes the files that are not referenced by the manifest, and
+// returns the ids of the logs to replay, in order.
+func (l *LSM) cleanup(m manifest, referenced map[uint64]bool) ([]uint64, error) {
+	entries, err := l.Config.FS.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var walIDs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		ext := path.Ext(name)
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		switch {
+		case name == manifestFile+".tmp":
+		case err != nil:
+			continue
+		case ext == walExt && id >= m.WAL:
+			walIDs = append(walIDs, id)
+			// a crash in the middle of a flush leaves a log newer than the
+			// manifest, whose id must not be given to another file.
+			if id >= l.nextFile.Load() {
+				l.nextFile.Store(id + 1)
+			}
+			continue
+		case ext == walExt, ext == tableExt && !referenced[id]:
+		default:
+			continue
+		}
+		l.logger.Debug().Str("file", name).Msg("removing leftover file")
+		if err := l.Config.FS.Remove(path.Join(l.Dir, name)); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(walIDs, func(i, j int) bool { return walIDs[i] < walIDs[j] })
+	return walIDs, nil
+}
+
+// replay rebuilds the memtable from a log. Only the tail of the last log is
+// truncated automatically, since a crash can leave its last append
+// incomplete. Other corruptions are only truncated if Config.Repair is set.
+func (l *LSM) replay(w *wal, last bool) error {
+	err := w.Replay(func(key string, value []byte) {
+		l.mem.Set(key, value)
+	})
+	var corrupt *CorruptionError
+	if !errors.As(err, &corrupt) {
+		return err
+	}
+	torn, err := w.isTornTail(corrupt)
+	if err != nil {
+		return err
+	}
+	logger := l.logger.Warn().
+		Uint64("wal", w.id).
+		Uint64("offset", corrupt.Offset).
+		Uint64("discarded_bytes", w.size-corrupt.Offset).
+		AnErr("reason", corrupt.Reason)
+	switch {
+	case last && torn:
+		logger.Msg("truncated incomplete write at the end of the write-ahead log")
+	case l.Config.Repair:
+		logger.Msg("repaired corrupted write-ahead log by truncating it")
+	default:
+		return fmt.Errorf("wal %d: %w (enable repair to truncate it)", w.id, corrupt)
+	}
+	return w.Truncate(corrupt.Offset)
+}
+
+func (l *LSM) newWAL() error {
+	w, err := openWAL(l.Config.FS, l.Dir, l.nextFile.Add(1)-1)
+	if err != nil {
+		return err
+	}
+	l.wal = w
+	return l.writeManifest(l.levels, w.id)
+}
+
+// writeManifest persists the given levels as the tables of the backend, and
+// walID as the oldest write-ahead log to replay. It must be called while
+// holding the install lock, or while the tree is not shared.
+func (l *LSM) writeManifest(levels [numLevels][]*table, walID uint64) error {
+	m := manifest{
+		Version:  manifestVersion,
+		NextFile: l.nextFile.Load(),
+		WAL:      walID,
+		Levels:   make([][]uint64, numLevels),
+	}
+	for i, tables := range levels {
+		m.Levels[i] = make([]uint64, len(tables))
+		for j, t := range tables {
+			m.Levels[i][j] = t.id
+		}
+	}
+	return writeManifest(l.Config.FS, l.Dir, m)
+}
+
+// unlock releases the lock of the directory, if it is held.
+func (l *LSM) unlock() error {
+	if l.lock == nil {
+		return nil
+	}
+	err := l.lock.Close()
+	l.lock = nil
+	return err
+}
+
+// oldestWAL returns the id of the oldest write-ahead log whose memtable was
+// not flushed yet. It must be called while holding the lock.
+func (l *LSM) oldestWAL() uint64 {
+	if len(l.immWALs) > 0 {
+		return l.immWALs[0].id
+	}
+	if len(l.staleWALs) > 0 {
+		return l.staleWALs[0].id
+	}
+	return l.wal.id
+}
+
+func (l *LSM) closeFiles() {
+	if l.wal != nil {
+		l.wal.Close()
+	}
+	for _, w := range l.staleWALs {
+		w.Close()
+	}
+	for _, w := range l.immWALs {
+		w.Close()
+	}
+	for _, tables := range l.levels {
+		for _, t := range tables {
+			t.Close()
+		}
+	}
+}
+
+// lookup returns the newest value of a key.
+func (l *LSM) lookup(key string) ([]byte, bool, error) {
+	if value, ok := l.mem.Get(key); ok {
+		return value, true, nil
+	}
+	if l.imm != nil {
+		if value, ok := l.imm.Get(key); ok {
+			return value, true, nil
+		}
+	}
+	for i := len(l.levels[0]) - 1; i >= 0; i-- {
+		value, ok, err := l.levels[0][i].Get(key)
+		if err != nil || ok {
+			return value, ok, err
+		}
+	}
+	for _, tables := range l.levels[1:] {
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+		if i == len(tables) {
+			continue
+		}
+		value, ok, err := tables[i].Get(key)
+		if err != nil || ok {
+			return value, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Has returns true if the key exists in the tree.
+func (l *LSM) Has(key string) bool {
+	_, exists := l.GetMetadata(key)
+	return exists
+}
+
+// Get returns a record by key.
+func (l *LSM) Get(ctx context.Context, key string) (rec *ddbv1.Record, exists bool, err error) {
+	_, span := tracer.Start(ctx, "lsm.Get")
+	defer span.End()
+
+	l.mu.RLock()
+	value, exists, err := l.lookup(key)
+	l.mu.RUnlock()
+	if err == nil && exists {
+		rec, err = decodeValue(key, value)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, false, err
+	}
+	return rec, exists, nil
+}
+
+// GetMetadata returns the metadata for a key. Since tables have no position
+// to point at, Pos is always zero. Keys whose value cannot be read are
+// reported as existing, so the error is returned by Get.
+func (l *LSM) GetMetadata(key string) (meta backend.RecordMetadata, exists bool) {
+	l.mu.RLock()
+	value, exists, err := l.lookup(key)
+	l.mu.RUnlock()
+	if err != nil {
+		return meta, true
+	}
+	if !exists {
+		return meta, false
+	}
+	meta.Size = uint64(len(value))
+	if isTombstone(value) {
+		if rec, err := decodeValue(key, value); err == nil {
+			meta.DeletedAt = rec.DeletedAt
+		}
+	}
+	return meta, true
+}
+
+// Keys returns the sorted keys of all records in the tree.
+func (l *LSM) Keys() []string {
+	var keys []string
+	_ = l.RangeKeys("", "", false, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
+
+// RangeKeys calls fn for the keys of all records from start to end. Keys are
+// collected a page at a time, so the lock is not held while fn runs, and
+// writes made meanwhile may or may not be seen.
+func (l *LSM) RangeKeys(start, end string, reverse bool, fn func(key string) error) error {
+	for {
+		keys, err := l.keyPage(start, end, reverse)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if len(keys) < rangePageSize {
+			return nil
+		}
+		last := keys[len(keys)-1]
+		if reverse {
+			end = last
+		} else {
+			start = last + "\x00"
+		}
+	}
+}
+
+func (l *LSM) keyPage(start, end string, reverse bool) ([]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !reverse && end != "" && start >= end {
+		return nil, nil
+	}
+	keys := make([]string, 0, rangePageSize)
+	it := l.iterator()
+	if reverse {
+		for it.SeekLT(end); it.Valid() && it.Key() >= start && len(keys) < rangePageSize; it.Prev() {
+			keys = append(keys, it.Key())
+		}
+	} else {
+		for it.SeekGE(start); it.Valid() && (end == "" || it.Key() < end) && len(keys) < rangePageSize; it.Next() {
+			keys = append(keys, it.Key())
+		}
+	}
+	return keys, it.Err()
+}
+
+// iterator returns an iterator over the newest values of all keys. It must be
+// used while holding the lock.
+func (l *LSM) iterator() iterator {
+	its := []iterator{l.mem.iterator()}
+	if l.imm != nil {
+		its = append(its, l.imm.iterator())
+	}
+	for i := len(l.levels[0]) - 1; i >= 0; i-- {
+		its = append(its, l.levels[0][i].iterator())
+	}
+	for _, tables := range l.levels[1:] {
+		if len(tables) > 0 {
+			its = append(its, newLevelIterator(tables))
+		}
+	}
+	return newMergingIterator(its...)
+}
+
+// Set appends a record to the write-ahead log and inserts it in the
+// memtable. Once it is full, the memtable is flushed in the background, and
+// writes go to a new one meanwhile.
+func (l *LSM) Set(ctx context.Context, rec *ddbv1.Record) error {
+	_, span := tracer.Start(ctx, "lsm.Set")
+	defer span.End()
+
+	codec := l.Config.Compression.For(rec.Key)
+	value, rawSize, err := encodeValue(rec, codec)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errClosed
+	}
+	if err := l.wal.Append(rec.Key, value); err != nil {
+		recordError(span, err)
+		return err
+	}
+	l.mem.Set(rec.Key, value)
+	l.rawBytes += uint64(rawSize)
+	l.storedBytes += uint64(len(value) - flagsSize)
+
+	if l.mem.size >= l.Config.MemtableBytes {
+		l.maybeFlush()
+	}
+	return nil
+}
+
+// maybeFlush wakes up the background flush, if it is not already running.
+func (l *LSM) maybeFlush() {
+	select {
+	case l.flushc <- struct{}{}:
+	default:
+	}
+}
+
+func (l *LSM) flushLoop() {
+	defer close(l.flushed)
+	for range l.flushc {
+		if err := l.flush(); err != nil {
+			// the memtable is kept, and flushed again on the next write
+			l.logger.Error().Err(err).Msg("flush failed")
+		}
+	}
+}
+
+// flush writes the memtable to a new table of level 0, along with the
+// immutable memtable left by a flush that failed. Writes are only blocked
+// while the memtable is swapped for an empty one, and while the table is
+// added to the tree.
+func (l *LSM) flush() error {
+	l.flushing.Lock()
+	defer l.flushing.Unlock()
+	if err := l.flushImmutable(); err != nil {
+		return err
+	}
+	if err := l.rotate(); err != nil {
+		return err
+	}
+	return l.flushImmutable()
+}
+
+// rotate makes the memtable immutable, unless it is empty, and starts a new
+// one with a new write-ahead log. It must be called while holding the
+// flushing lock, once the immutable memtable was flushed.
+func (l *LSM) rotate() error {
+	l.mu.RLock()
+	empty := l.mem.Len() == 0
+	l.mu.RUnlock()
+	if empty {
+		return nil
+	}
+
+	w, err := openWAL(l.Config.FS, l.Dir, l.nextFile.Add(1)-1)
+	if err != nil {
+		return err
+	}
+	// writes acknowledged from the new log must not be lost with its file
+	if err := vfs.SyncDir(l.Config.FS, l.Dir); err != nil {
+		w.Remove()
+		return err
+	}
+	l.mu.Lock()
+	l.imm, l.immWALs = l.mem, append(l.staleWALs, l.wal)
+	l.mem, l.wal, l.staleWALs = newMemtable(), w, nil
+	l.mu.Unlock()
+	return nil
+}
+
+// flushImmutable writes the immutable memtable to a new table of level 0,
+// and removes its write-ahead logs. It must be called while holding the
+// flushing lock.
+func (l *LSM) flushImmutable() error {
+	l.mu.RLock()
+	imm, wals := l.imm, l.immWALs
+	l.mu.RUnlock()
+	if imm == nil {
+		return nil
+	}
+	defer observeSince(flushDuration, time.Now())
+
+	w, err := newTableWriter(l.Config.FS, l.Dir, l.nextFile.Add(1)-1, l.Config.BlockBytes)
+	if err != nil {
+		return err
+	}
+	it := imm.iterator()
+	for it.SeekGE(""); it.Valid(); it.Next() {
+		if err := w.Add(it.Key(), it.Value()); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	t, err := w.Finish()
+	if err != nil {
+		return err
+	}
+
+	l.install.Lock()
+	defer l.install.Unlock()
+	l.mu.RLock()
+	levels := l.levels
+	levels[0] = append(levels[0][:len(levels[0]):len(levels[0])], t)
+	walID := l.wal.id
+	l.mu.RUnlock()
+	if err := l.writeManifest(levels, walID); err != nil {
+		t.Remove()
+		return err
+	}
+	l.mu.Lock()
+	l.levels = levels
+	l.imm, l.immWALs = nil, nil
+	l.mu.Unlock()
+
+	for _, w := range wals {
+		if err := w.Remove(); err != nil {
+			l.logger.Warn().Err(err).Uint64("wal", w.id).Msg("failed to remove flushed write-ahead log")
+		}
+	}
+	flushesTotal.Inc()
+	l.maybeCompact()
+	return nil
+}
+
+// Stats returns statistics about the tables of the tree. Tables are listed
+// from the deepest level to level 0, and the memtable last, with the id and
+// size of its write-ahead log.
+func (l *LSM) Stats() backend.Stats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	stats := backend.Stats{
+		LastMerge:   l.lastMerge,
+		RawBytes:    l.rawBytes,
+		StoredBytes: l.storedBytes,
+	}
+	for level := numLevels - 1; level >= 0; level-- {
+		for _, t := range l.levels[level] {
+			stats.Segments = append(stats.Segments, t.Stats())
+		}
+	}
+	if l.imm != nil {
+		var size uint64
+		for _, w := range l.immWALs {
+			size += w.size
+		}
+		stats.Segments = append(stats.Segments, backend.SegmentStats{
+			ID:         l.immWALs[len(l.immWALs)-1].id,
+			Keys:       l.imm.Len(),
+			Tombstones: l.imm.Tombstones(),
+			Size:       size,
+			LiveBytes:  size,
+		})
+	}
+	tombstones := l.mem.Tombstones()
+	stats.Segments = append(stats.Segments, backend.SegmentStats{
+		ID:         l.wal.id,
+		Keys:       l.mem.Len(),
+		Tombstones: tombstones,
+		Size:       l.wal.size,
+		LiveBytes:  l.wal.size,
+	})
+	stats.OpenFiles = len(stats.Segments)
+	return stats
+}
+
+// Scrub verifies the checksum of every block of every table, returning the
+// corrupted ranges found. The write-ahead log is verified when it is replayed.
+func (l *LSM) Scrub(ctx context.Context) ([]backend.Corruption, error) {
+	_, span := tracer.Start(ctx, "lsm.Scrub")
+	defer span.End()
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return nil, errClosed
+	}
+	var tables []*table
+	for _, level := range l.levels {
+		tables = append(tables, level...)
+	}
+	l.mu.RUnlock()
+
+	var corruptions []backend.Corruption
+	for _, t := range tables {
+		if err := ctx.Err(); err != nil {
+			return corruptions, err
+		}
+		corruption, err := t.Scrub()
+		if err != nil {
+			recordError(span, err)
+			return corruptions, err
+		}
+		if corruption != nil {
+			corruptions = append(corruptions, *corruption)
+		}
+	}
+	span.SetAttributes(attribute.Int("lsm.corruptions", len(corruptions)))
+	return corruptions, nil
+}
+
+// Sync flushes the write-ahead logs to disk, including the ones of the
+// memtable being flushed. Writes are not blocked while they are synced, so
+// they can be batched into the next sync.
+func (l *LSM) Sync(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "lsm.Sync")
+	defer span.End()
+	defer observeSince(syncDuration, time.Now())
+	l.mu.RLock()
+	wals := append(append([]*wal(nil), l.immWALs...), l.wal)
+	l.mu.RUnlock()
+	for _, w := range wals {
+		if err := w.Sync(); err != nil {
+			recordError(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Close waits for the running flush, compaction, merge or scrub, syncs the
+// write-ahead logs and closes the tree, unlocking its directory. The
+// memtable is rebuilt from the logs when it is opened.
+func (l *LSM) Close() (err error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	close(l.flushc)
+	<-l.flushed
+	close(l.compactc)
+	<-l.done
+	// wait for the running merge or scrub, which may still install tables
+	l.maintenance.Lock()
+	defer l.maintenance.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the directory is unlocked even if closing fails, so it can be opened again
+	defer func() {
+		if uerr := l.unlock(); err == nil {
+			err = uerr
+		}
+	}()
+	// the logs of a memtable whose flush failed are replayed when the tree
+	// is opened
+	for _, w := range append(l.immWALs, l.wal) {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	for _, tables := range l.levels {
+		for _, t := range tables {
+			if err := t.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reader returns an io.Reader instance to read all the tables of the tree,
+// followed by its write-ahead logs. The tables are kept open until they are
+// read to the end, even if they are compacted or the tree is closed
+// meanwhile. Logs are removed once their memtable is flushed, so they are
+// copied instead.
+func (l *LSM) Reader() io.Reader {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var readers []io.Reader
+	for level := numLevels - 1; level >= 0; level-- {
+		for _, t := range l.levels[level] {
+			t.ref()
+			readers = append(readers, &tableReader{t: t, r: io.NewSectionReader(t.file, 0, int64(t.size))})
+		}
+	}
+	for _, w := range append(append([]*wal(nil), l.immWALs...), l.wal) {
+		if b, err := w.Bytes(); err == nil {
+			readers = append(readers, bytes.NewReader(b))
+		}
+	}
+	return io.MultiReader(readers...)
+}
+
+// tableReader reads the file of a table, releasing its reference to the
+// table once it is read to the end.
+type tableReader struct {
+	t    *table
+	r    io.Reader
+	done bool
+}
+
+func (r *tableReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.done = true
+		if uerr := r.t.unref(); err == io.EOF && uerr != nil {
+			err = uerr
+		}
+	}
+	return n, err
+}
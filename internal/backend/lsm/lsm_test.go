@@ -0,0 +1,311 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLSM(t *testing.T) {
+	tests := map[string]func(t *testing.T, tree *LSM){
+		"set and get records":                testSetGet,
+		"reopen from tables and the log":     testReopen,
+		"compact tables into deeper levels":  testCompaction,
+		"range keys in both directions":      testRangeKeys,
+		"merge drops overwrites and deletes": testMerge,
+		"scrub finds corrupted blocks":       testScrub,
+		"read tables compacted meanwhile":    testReader,
+		"close while merging":                testCloseMerging,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tree, err := NewLSMBackend(t.TempDir(), testConfig())
+			require.NoError(t, err)
+			t.Cleanup(func() { tree.Close() })
+			fn(t, tree)
+		})
+	}
+}
+
+func testConfig() Config {
+	return Config{
+		MemtableBytes:   1024,
+		BlockBytes:      128,
+		TableBytes:      512,
+		L0Tables:        2,
+		BaseLevelBytes:  2048,
+		LevelMultiplier: 2,
+	}
+}
+
+func set(t *testing.T, tree *LSM, key, value string) {
+	t.Helper()
+	require.NoError(t, tree.Set(context.Background(), &ddbv1.Record{Key: key, Value: []byte(value)}))
+}
+
+func del(t *testing.T, tree *LSM, key string) {
+	t.Helper()
+	deletedAt := time.Now().UnixNano()
+	require.NoError(t, tree.Set(context.Background(), &ddbv1.Record{Key: key, DeletedAt: &deletedAt}))
+}
+
+func requireValue(t *testing.T, tree *LSM, key, want string) {
+	t.Helper()
+	rec, exists, err := tree.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.True(t, exists, key)
+	require.Equal(t, want, string(rec.Value), key)
+}
+
+func fillTree(t *testing.T, tree *LSM, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		set(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+}
+
+// waitFlushed waits for the background flushes of full memtables.
+func waitFlushed(t *testing.T, tree *LSM) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		tree.mu.RLock()
+		defer tree.mu.RUnlock()
+		return tree.imm == nil && tree.mem.size < tree.Config.MemtableBytes
+	}, 5*time.Second, time.Millisecond)
+}
+
+func reopen(t *testing.T, tree *LSM) *LSM {
+	t.Helper()
+	require.NoError(t, tree.Close())
+	tree, err := NewLSMBackend(tree.Dir, tree.Config)
+	require.NoError(t, err)
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+func testSetGet(t *testing.T, tree *LSM) {
+	want := &ddbv1.Record{Timestamp: 12345, Key: "foo", Value: []byte("hello world")}
+	require.NoError(t, tree.Set(context.Background(), want))
+	got, exists, err := tree.Get(context.Background(), "foo")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.True(t, proto.Equal(want, got))
+
+	set(t, tree, "foo", "bar")
+	requireValue(t, tree, "foo", "bar")
+
+	del(t, tree, "foo")
+	meta, exists := tree.GetMetadata("foo")
+	require.True(t, exists)
+	require.NotNil(t, meta.DeletedAt)
+
+	_, exists, err = tree.Get(context.Background(), "missing")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.False(t, tree.Has("missing"))
+}
+
+func testReopen(t *testing.T, tree *LSM) {
+	fillTree(t, tree, 100)
+	waitFlushed(t, tree)
+	stats := tree.Stats()
+	require.Greater(t, len(stats.Segments), 1, "the memtable was never flushed")
+	// the deletion is only in the log
+	del(t, tree, "key-0050")
+
+	tree = reopen(t, tree)
+	for i := 0; i < 100; i++ {
+		if i != 50 {
+			requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+		}
+	}
+	meta, exists := tree.GetMetadata("key-0050")
+	require.True(t, exists)
+	require.NotNil(t, meta.DeletedAt)
+}
+
+func testCompaction(t *testing.T, tree *LSM) {
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 300; i++ {
+			set(t, tree, fmt.Sprintf("key-%04d", (i*7)%300), fmt.Sprintf("value-%d-%d", round, i))
+		}
+		// memtables grow while they are flushed in the background, so
+		// every round is flushed to its own table
+		require.NoError(t, tree.flush())
+	}
+	require.NoError(t, tree.compact(context.Background()))
+
+	tree.mu.RLock()
+	levels := tree.levels
+	tree.mu.RUnlock()
+	require.Less(t, len(levels[0]), tree.Config.L0Tables)
+	var deeper int
+	for level := 1; level < numLevels; level++ {
+		tables := levels[level]
+		deeper += len(tables)
+		for i := 1; i < len(tables); i++ {
+			require.Less(t, tables[i-1].largest, tables[i].smallest, "tables of level %d overlap", level)
+		}
+	}
+	require.NotZero(t, deeper)
+
+	for i := 0; i < 300; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", (i*7)%300), fmt.Sprintf("value-2-%d", i))
+	}
+	require.Len(t, tree.Keys(), 300)
+
+	tree = reopen(t, tree)
+	requireValue(t, tree, "key-0000", "value-2-0")
+}
+
+func testRangeKeys(t *testing.T, tree *LSM) {
+	n := 2*rangePageSize + 10
+	fillTree(t, tree, n)
+	del(t, tree, "key-0003")
+
+	var keys []string
+	require.NoError(t, tree.RangeKeys("", "", false, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	require.Len(t, keys, n)
+	for i, key := range keys {
+		require.Equal(t, fmt.Sprintf("key-%04d", i), key)
+	}
+
+	keys = nil
+	require.NoError(t, tree.RangeKeys("key-0002", "key-0300", true, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	require.Len(t, keys, 298)
+	require.Equal(t, "key-0299", keys[0])
+	require.Equal(t, "key-0002", keys[len(keys)-1])
+
+	stop := errors.New("stop")
+	var calls int
+	err := tree.RangeKeys("", "", false, func(key string) error {
+		calls++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+}
+
+func testMerge(t *testing.T, tree *LSM) {
+	fillTree(t, tree, 200)
+	fillTree(t, tree, 200)
+	for i := 0; i < 100; i++ {
+		del(t, tree, fmt.Sprintf("key-%04d", i))
+	}
+	require.NoError(t, tree.Merge(context.Background()))
+
+	stats := tree.Stats()
+	require.False(t, stats.LastMerge.IsZero())
+	var keys, tombstones int
+	for _, s := range stats.Segments {
+		keys += s.Keys
+		tombstones += s.Tombstones
+	}
+	require.Equal(t, 100, keys)
+	require.Zero(t, tombstones)
+	require.Zero(t, tree.mem.Len())
+	require.Empty(t, tree.levels[0])
+	require.False(t, tree.Has("key-0000"))
+	requireValue(t, tree, "key-0150", "value-150")
+}
+
+func testScrub(t *testing.T, tree *LSM) {
+	fillTree(t, tree, 100)
+	require.NoError(t, tree.Merge(context.Background()))
+	corruptions, err := tree.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, corruptions)
+
+	var target *table
+	for _, tables := range tree.levels {
+		if len(tables) > 0 {
+			target = tables[0]
+		}
+	}
+	require.NotNil(t, target)
+	block := target.blocks[1]
+	corruptByte(t, target.file.Name(), int64(block.offset+1))
+
+	corruptions, err = tree.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Len(t, corruptions, 1)
+	require.Equal(t, target.id, corruptions[0].Segment)
+	require.Equal(t, block.offset, corruptions[0].Offset)
+	require.ErrorIs(t, corruptions[0].Err, backend.ErrCorrupted)
+}
+
+func corruptByte(t *testing.T, name string, off int64) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+}
+
+func testReader(t *testing.T, tree *LSM) {
+	fillTree(t, tree, 200)
+	require.NoError(t, tree.flush())
+	var size uint64
+	var files []string
+	tree.mu.RLock()
+	for _, tables := range tree.levels {
+		for _, tbl := range tables {
+			size += tbl.size
+			files = append(files, tbl.file.Name())
+		}
+	}
+	tree.mu.RUnlock()
+	require.NotEmpty(t, files)
+
+	r := tree.Reader()
+	set(t, tree, "unflushed", "value")
+	require.NoError(t, tree.Merge(context.Background()))
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, size, uint64(len(b)))
+
+	// the compacted tables are removed once they are read
+	for _, name := range files {
+		_, err := os.Stat(name)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func testCloseMerging(t *testing.T, tree *LSM) {
+	fillTree(t, tree, 300)
+	merged := make(chan error, 1)
+	go func() { merged <- tree.Merge(context.Background()) }()
+	require.NoError(t, tree.Close())
+	if err := <-merged; err != nil {
+		require.ErrorIs(t, err, errClosed)
+	}
+	_, err := tree.Scrub(context.Background())
+	require.ErrorIs(t, err, errClosed)
+
+	tree, err = NewLSMBackend(tree.Dir, tree.Config)
+	require.NoError(t, err)
+	defer tree.Close()
+	for i := 0; i < 300; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+}
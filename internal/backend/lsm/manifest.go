@@ -0,0 +1,115 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+)
+
+const (
+	// manifestFile lists the files of the tree, and lockFile is locked while
+	// the tree is open. Both are shared with the other persistent backends.
+	manifestFile    = "MANIFEST"
+	lockFile        = "LOCK"
+	manifestVersion = 1
+	// manifestEngine identifies the manifests written by this backend, since
+	// other backends keep a manifest with the same name.
+	manifestEngine = "lsm"
+)
+
+// manifest lists the tables of each level and the write-ahead log of the
+// memtable. It is replaced atomically whenever they change, so files it does
+// not list are leftovers of flushes or compactions that did not complete.
+type manifest struct {
+	Version  int        `json:"version"`
+	Engine   string     `json:"engine"`
+	NextFile uint64     `json:"next_file"`
+	WAL      uint64     `json:"wal"`
+	Levels   [][]uint64 `json:"levels"`
+}
+
+// readManifest reads the manifest of the backend in dir, returning false if
+// there is none.
+func readManifest(fsys vfs.FS, dir string) (m manifest, exists bool, err error) {
+	b, err := vfs.ReadFile(fsys, path.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{Version: manifestVersion, NextFile: 1}, false, nil
+	}
+	if err != nil {
+		return m, false, err
+	}
+	if err := decodeManifest(b, &m); err != nil {
+		return m, false, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	return m, true, nil
+}
+
+// decodeManifest decodes a manifest written by this backend. Since the files
+// it does not list are removed, manifests of other backends, of other
+// versions, and ones with unknown or missing fields are refused.
+func decodeManifest(b []byte, m *manifest) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	var engine string
+	if raw, ok := fields["engine"]; ok {
+		if err := json.Unmarshal(raw, &engine); err != nil {
+			return err
+		}
+	}
+	if engine != manifestEngine {
+		return fmt.Errorf("%w: engine %q, expected %q", backend.ErrWrongBackend, engine, manifestEngine)
+	}
+	for _, name := range []string{"version", "next_file", "wal", "levels"} {
+		if raw, ok := fields[name]; !ok || string(raw) == "null" {
+			return fmt.Errorf("missing field %q", name)
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(m); err != nil {
+		return err
+	}
+	if m.Version != manifestVersion {
+		return fmt.Errorf("unsupported version %d", m.Version)
+	}
+	return nil
+}
+
+// writeManifest atomically replaces the manifest of the backend in dir.
+func writeManifest(fsys vfs.FS, dir string, m manifest) error {
+	m.Engine = manifestEngine
+	if m.Levels == nil {
+		m.Levels = [][]uint64{}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	name := path.Join(dir, manifestFile)
+	f, err := fsys.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, tableFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := fsys.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	return vfs.SyncDir(fsys, dir)
+}
@@ -0,0 +1,82 @@
+package lsm
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayout(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string){
+		"writes the engine in the manifest":          testManifestEngine,
+		"refuses the manifest of another backend":    testForeignManifest,
+		"refuses invalid manifests":                  testInvalidManifest,
+		"locks the directory while the tree is open": testLockDirectory,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, t.TempDir())
+		})
+	}
+}
+
+func testManifestEngine(t *testing.T, dir string) {
+	fillLog(t, dir, 10)
+	m, exists, err := readManifest(vfs.OS, dir)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, manifestEngine, m.Engine)
+	require.Len(t, m.Levels, numLevels)
+}
+
+func testForeignManifest(t *testing.T, dir string) {
+	data := []byte(`{"version":1,"engine":"bitcask","segments":[1]}`)
+	require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), data, 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "1.store"), []byte("data"), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "1.store.tmp"), []byte("data"), 0o644))
+
+	_, err := NewLSMBackend(dir, Config{})
+	require.ErrorIs(t, err, backend.ErrWrongBackend)
+	// neither the manifest nor the files of the other backend are touched
+	b, err := os.ReadFile(path.Join(dir, manifestFile))
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	for _, name := range []string{"1.store", "1.store.tmp"} {
+		_, err = os.Stat(path.Join(dir, name))
+		require.NoError(t, err, name)
+	}
+}
+
+func testInvalidManifest(t *testing.T, dir string) {
+	name := fillLog(t, dir, 10)
+	for _, data := range []string{
+		`{"version":1,"next_file":2,"wal":1,"levels":[]}`,
+		`{"version":1,"engine":"lsm","wal":1,"levels":[]}`,
+		`{"version":1,"engine":"lsm","next_file":2,"wal":1}`,
+		`{"version":1,"engine":"lsm","next_file":2,"wal":1,"levels":null}`,
+		`{"version":2,"engine":"lsm","next_file":2,"wal":1,"levels":[]}`,
+		`{"version":1,"engine":"lsm","next_file":2,"wal":1,"levels":[],"segments":[]}`,
+	} {
+		require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), []byte(data), 0o644))
+		_, err := NewLSMBackend(dir, Config{})
+		require.Error(t, err, data)
+	}
+	_, err := os.Stat(name)
+	require.NoError(t, err)
+}
+
+func testLockDirectory(t *testing.T, dir string) {
+	tree, err := NewLSMBackend(dir, Config{})
+	require.NoError(t, err)
+	_, err = NewLSMBackend(dir, Config{})
+	require.ErrorIs(t, err, vfs.ErrLocked)
+
+	require.NoError(t, tree.Close())
+	tree, err = NewLSMBackend(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+}
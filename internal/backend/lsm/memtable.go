@@ -0,0 +1,114 @@
+package lsm
+
+import (
+	"github.com/google/btree"
+)
+
+// memtableDegree is the degree of the B-tree of the memtable.
+const memtableDegree = 32
+
+// entryOverhead approximates the memory taken by an entry of the memtable
+// besides its key and value.
+const entryOverhead = 48
+
+type entry struct {
+	key   string
+	value []byte
+}
+
+func entryLess(a, b entry) bool {
+	return a.key < b.key
+}
+
+// memtable holds the latest entries in memory, sorted by key, until they are
+// flushed to a table. It is not safe for concurrent writes, but can be read
+// concurrently.
+type memtable struct {
+	tree *btree.BTreeG[entry]
+	size uint64
+}
+
+func newMemtable() *memtable {
+	return &memtable{tree: btree.NewG(memtableDegree, entryLess)}
+}
+
+// Set stores the value of a key, replacing the previous one.
+func (m *memtable) Set(key string, value []byte) {
+	prev, replaced := m.tree.ReplaceOrInsert(entry{key: key, value: value})
+	if replaced {
+		m.size -= uint64(len(prev.key) + len(prev.value) + entryOverhead)
+	}
+	m.size += uint64(len(key) + len(value) + entryOverhead)
+}
+
+// Get returns the value of a key.
+func (m *memtable) Get(key string) ([]byte, bool) {
+	e, ok := m.tree.Get(entry{key: key})
+	return e.value, ok
+}
+
+// Len returns the number of keys in the memtable.
+func (m *memtable) Len() int {
+	return m.tree.Len()
+}
+
+// Tombstones returns the number of deleted keys in the memtable.
+func (m *memtable) Tombstones() int {
+	n := 0
+	m.tree.Ascend(func(e entry) bool {
+		if isTombstone(e.value) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// memtableIterator iterates over the entries of a memtable, looking up the
+// next one from the current key in the tree at each step.
+type memtableIterator struct {
+	m     *memtable
+	cur   entry
+	valid bool
+}
+
+func (m *memtable) iterator() *memtableIterator {
+	return &memtableIterator{m: m}
+}
+
+func (it *memtableIterator) SeekGE(key string) {
+	it.valid = false
+	it.m.tree.AscendGreaterOrEqual(entry{key: key}, func(e entry) bool {
+		it.cur, it.valid = e, true
+		return false
+	})
+}
+
+func (it *memtableIterator) SeekLT(key string) {
+	it.valid = false
+	visit := func(e entry) bool {
+		if e.key == key {
+			return true
+		}
+		it.cur, it.valid = e, true
+		return false
+	}
+	if key == "" {
+		it.m.tree.Descend(visit)
+	} else {
+		it.m.tree.DescendLessOrEqual(entry{key: key}, visit)
+	}
+}
+
+func (it *memtableIterator) Next() {
+	it.SeekGE(it.cur.key + "\x00")
+}
+
+func (it *memtableIterator) Prev() {
+	it.SeekLT(it.cur.key)
+}
+
+func (it *memtableIterator) Valid() bool   { return it.valid }
+func (it *memtableIterator) Key() string   { return it.cur.key }
+func (it *memtableIterator) Value() []byte { return it.cur.value }
+func (it *memtableIterator) Err() error    { return nil }
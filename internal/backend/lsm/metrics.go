@@ -0,0 +1,58 @@
+package lsm
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var syncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "ddb",
+	Subsystem: "lsm",
+	Name:      "sync_duration_seconds",
+	Help:      "Latency of flushing and fsyncing the write-ahead log to disk.",
+	Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16), // 100µs to ~3.2s
+})
+
+var (
+	flushesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "lsm",
+		Name:      "flushes_total",
+		Help:      "Number of memtables flushed to tables of level 0.",
+	})
+	flushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "ddb",
+		Subsystem: "lsm",
+		Name:      "flush_duration_seconds",
+		Help:      "Latency of flushing the memtable to a table.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16), // 1ms to ~33s
+	})
+)
+
+var (
+	compactionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "lsm",
+		Name:      "compactions_total",
+		Help:      "Number of completed compactions, by the level they compacted into.",
+	}, []string{"level"})
+	compactionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "ddb",
+		Subsystem: "lsm",
+		Name:      "compaction_duration_seconds",
+		Help:      "Latency of compacting tables into the next level.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16), // 10ms to ~5.5m
+	})
+	compactionWrittenBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddb",
+		Subsystem: "lsm",
+		Name:      "compaction_written_bytes_total",
+		Help:      "Bytes of tables written by compactions.",
+	})
+)
+
+func observeSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,78 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/compress"
+	"google.golang.org/protobuf/proto"
+)
+
+// ================ Value Format ================
+// +-------+--------+
+// | flags | record |
+// +-------+--------+
+// | 1 byte| ?      |
+// +-------+--------+
+//
+// Values are the records stored under each key, without their key, which the
+// tables already hold. The low bits of the flags are the codec the record is
+// compressed with, and the high bit is set for tombstones, so compactions can
+// drop them without decoding the record.
+
+const (
+	flagsSize     = 1
+	codecMask     = 0x0f
+	flagTombstone = 0x80
+)
+
+// errInvalidValue is the error returned when decoding a value that was not
+// encoded by encodeValue.
+var errInvalidValue = errors.New("invalid value")
+
+// encodeValue encodes a record, compressed with the given codec. It also
+// returns the size of the record before compression.
+func encodeValue(rec *ddbv1.Record, codec compress.Codec) (value []byte, rawSize int, err error) {
+	// the key is left out, and every other field of the record copied
+	b, err := proto.Marshal(&ddbv1.Record{
+		Timestamp: rec.Timestamp,
+		Value:     rec.Value,
+		DeletedAt: rec.DeletedAt,
+		Chunked:   rec.Chunked,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	compressed, err := compress.Encode(codec, b)
+	if err != nil {
+		return nil, 0, err
+	}
+	flags := byte(codec)
+	if rec.DeletedAt != nil {
+		flags |= flagTombstone
+	}
+	return append([]byte{flags}, compressed...), len(b), nil
+}
+
+// decodeValue decodes the record of the given key from its value.
+func decodeValue(key string, value []byte) (*ddbv1.Record, error) {
+	if len(value) < flagsSize {
+		return nil, fmt.Errorf("%w: key %q", errInvalidValue, key)
+	}
+	b, err := compress.Decode(compress.Codec(value[0]&codecMask), value[flagsSize:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %q: %v", errInvalidValue, key, err)
+	}
+	rec := &ddbv1.Record{}
+	if err := proto.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("%w: key %q: %v", errInvalidValue, key, err)
+	}
+	rec.Key = key
+	return rec, nil
+}
+
+// isTombstone returns true if the value is the record of a deleted key.
+func isTombstone(value []byte) bool {
+	return len(value) > 0 && value[0]&flagTombstone != 0
+}
@@ -0,0 +1,115 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	tests := map[string]func(t *testing.T, dir string){
+		"truncates torn write at the end of the log":  testTornTail,
+		"refuses corruption in the middle of the log": testCorruptedLog,
+		"repairs corruption in the middle of the log": testRepairLog,
+		"removes files left by an interrupted flush":  testLeftovers,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, t.TempDir())
+		})
+	}
+}
+
+// fillLog writes n records to the memtable of a new tree in dir, which is
+// large enough for them not to be flushed, and closes it, returning the path
+// of its log.
+func fillLog(t *testing.T, dir string, n int) string {
+	t.Helper()
+	tree, err := NewLSMBackend(dir, Config{})
+	require.NoError(t, err)
+	fillTree(t, tree, n)
+	name := tree.wal.file.Name()
+	require.NoError(t, tree.Close())
+	return name
+}
+
+func testTornTail(t *testing.T, dir string) {
+	name := fillLog(t, dir, 10)
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(name, fi.Size()-3))
+
+	tree, err := NewLSMBackend(dir, Config{})
+	require.NoError(t, err)
+	defer tree.Close()
+	require.Len(t, tree.Keys(), 9)
+	requireValue(t, tree, "key-0008", "value-8")
+
+	set(t, tree, "key-0009", "again")
+	tree = reopen(t, tree)
+	requireValue(t, tree, "key-0009", "again")
+}
+
+func testCorruptedLog(t *testing.T, dir string) {
+	name := fillLog(t, dir, 10)
+	corruptByte(t, name, walHeaderSize+1)
+
+	_, err := NewLSMBackend(dir, Config{})
+	var corrupt *CorruptionError
+	require.ErrorAs(t, err, &corrupt)
+	require.Equal(t, uint64(0), corrupt.Offset)
+}
+
+func testRepairLog(t *testing.T, dir string) {
+	name := fillLog(t, dir, 10)
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	corruptByte(t, name, fi.Size()/2)
+
+	tree, err := NewLSMBackend(dir, Config{Repair: true})
+	require.NoError(t, err)
+	defer tree.Close()
+	keys := tree.Keys()
+	require.NotEmpty(t, keys)
+	require.Less(t, len(keys), 10)
+	requireValue(t, tree, "key-0000", "value-0")
+}
+
+func testLeftovers(t *testing.T, dir string) {
+	tree, err := NewLSMBackend(dir, testConfig())
+	require.NoError(t, err)
+	fillTree(t, tree, 50)
+	waitFlushed(t, tree)
+	set(t, tree, "unflushed", "value")
+	require.NoError(t, tree.Close())
+
+	// a log written by a flush, and a table written by a compaction, whose
+	// manifests were never written
+	next := tree.nextFile.Load()
+	require.NoError(t, os.WriteFile(tablePath(dir, next+5), []byte("partial"), tableFileMode))
+	w, err := openWAL(vfs.OS, dir, next+1)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	tree, err = NewLSMBackend(dir, testConfig())
+	require.NoError(t, err)
+	_, err = os.Stat(tablePath(dir, next+5))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	// both logs were replayed, and flushed to a table
+	_, err = os.Stat(walPath(dir, next+1))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Zero(t, tree.mem.Len())
+	require.Greater(t, tree.wal.id, next+1)
+
+	// the new log is not mistaken for the leftover one
+	set(t, tree, "after", "value")
+	tree = reopen(t, tree)
+	defer tree.Close()
+	requireValue(t, tree, "after", "value")
+	for i := 0; i < 50; i++ {
+		requireValue(t, tree, fmt.Sprintf("key-%04d", i), fmt.Sprintf("value-%d", i))
+	}
+}
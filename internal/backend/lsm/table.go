@@ -0,0 +1,548 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync/atomic"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/bloom"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/danielfsousa/ddb/pkg/fmode"
+)
+
+// ================ Table Format ================
+// +---------+-----+---------+--------+-------+--------+
+// | block 0 | ... | block n | filter | index | footer |
+// +---------+-----+---------+--------+-------+--------+
+//
+// Tables hold entries sorted by key, each key once, in blocks of about
+// Config.BlockBytes that are read as a whole:
+//
+//	block:  (keyLength uvarint | key | valueLength uvarint | value)* | checksum 4 bytes
+//	filter: a Bloom filter of the keys, see the bloom package
+//	index:  keys | tombstones | tombstoneBytes | firstKey |
+//	        (lastKey | blockOffset | blockSize)* | checksum 4 bytes
+//	footer: filterOffset | filterSize | indexOffset | indexSize | magic, 8 bytes each
+//
+// Lengths in the index are uvarints, prefixing keys too. The index and the
+// filter are loaded in memory when the table is opened, so looking up a key
+// reads at most one block.
+
+const (
+	tableExt      = ".sst"
+	tableMagic    = 0x64646273_73743031 // "ddbsst01"
+	footerSize    = 5 * 8
+	blockCRCSize  = 4
+	tableFileMode = fmode.USER_RW | fmode.GROUP_R | fmode.OTHER_R
+
+	// filterFalsePositiveRate is the rate of lookups of missing keys that
+	// still read a block of a table.
+	filterFalsePositiveRate = 0.01
+)
+
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	size    uint64
+}
+
+// table is an immutable sorted table of entries. It is safe for concurrent reads.
+type table struct {
+	id     uint64
+	fs     vfs.FS
+	file   vfs.File
+	size   uint64
+	blocks []blockHandle
+	filter *bloom.Filter
+
+	smallest, largest string
+
+	keys           uint64
+	tombstones     uint64
+	tombstoneBytes uint64
+
+	// refs counts the references to the table, held by the tree and by the
+	// readers of its file, which is closed once they are all released, and
+	// removed if the table was replaced.
+	refs     atomic.Int32
+	replaced atomic.Bool
+}
+
+func tablePath(dir string, id uint64) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", id, tableExt))
+}
+
+// openTable opens the table with the given id, loading its index and filter.
+func openTable(fsys vfs.FS, dir string, id uint64) (*table, error) {
+	f, err := vfs.Open(fsys, tablePath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+	t := &table{id: id, fs: fsys, file: f}
+	t.refs.Store(1)
+	if err := t.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *table) corrupted(offset uint64, reason error) error {
+	return &CorruptionError{Path: t.file.Name(), Offset: offset, Reason: reason}
+}
+
+func (t *table) load() error {
+	fi, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	t.size = uint64(fi.Size())
+	if t.size < footerSize {
+		return t.corrupted(0, io.ErrUnexpectedEOF)
+	}
+	var footer [footerSize]byte
+	if _, err := t.file.ReadAt(footer[:], int64(t.size-footerSize)); err != nil {
+		return err
+	}
+	if encoding.Uint64(footer[32:]) != tableMagic {
+		return t.corrupted(t.size-footerSize, errors.New("bad magic number"))
+	}
+	filterOffset, filterSize := encoding.Uint64(footer[0:]), encoding.Uint64(footer[8:])
+	indexOffset, indexSize := encoding.Uint64(footer[16:]), encoding.Uint64(footer[24:])
+	if filterOffset+filterSize > indexOffset || indexOffset+indexSize > t.size-footerSize {
+		return t.corrupted(t.size-footerSize, errors.New("invalid footer"))
+	}
+
+	filter := make([]byte, filterSize)
+	if _, err := t.file.ReadAt(filter, int64(filterOffset)); err != nil {
+		return err
+	}
+	t.filter = &bloom.Filter{}
+	if err := t.filter.UnmarshalBinary(filter); err != nil {
+		return t.corrupted(filterOffset, err)
+	}
+
+	index := make([]byte, indexSize)
+	if _, err := t.file.ReadAt(index, int64(indexOffset)); err != nil {
+		return err
+	}
+	if err := t.parseIndex(index); err != nil {
+		return t.corrupted(indexOffset, err)
+	}
+	return nil
+}
+
+func (t *table) parseIndex(b []byte) error {
+	if len(b) < blockCRCSize {
+		return io.ErrUnexpectedEOF
+	}
+	data, sum := b[:len(b)-blockCRCSize], encoding.Uint32(b[len(b)-blockCRCSize:])
+	if crc32.Checksum(data, crcTable) != sum {
+		return errors.New("checksum mismatch")
+	}
+	r := &byteReader{b: data}
+	t.keys = r.uvarint()
+	t.tombstones = r.uvarint()
+	t.tombstoneBytes = r.uvarint()
+	t.smallest = r.string()
+	for r.err == nil && len(r.b) > 0 {
+		h := blockHandle{lastKey: r.string(), offset: r.uvarint(), size: r.uvarint()}
+		t.blocks = append(t.blocks, h)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(t.blocks) > 0 {
+		t.largest = t.blocks[len(t.blocks)-1].lastKey
+	}
+	return nil
+}
+
+// MayHave returns false if the table definitely does not have the key.
+func (t *table) MayHave(key string) bool {
+	return key >= t.smallest && key <= t.largest && t.filter.MayContain(key)
+}
+
+// Get returns the value of a key.
+func (t *table) Get(key string) ([]byte, bool, error) {
+	if !t.MayHave(key) {
+		return nil, false, nil
+	}
+	i := t.findBlock(key)
+	if i == len(t.blocks) {
+		return nil, false, nil
+	}
+	entries, err := t.readBlock(i)
+	if err != nil {
+		return nil, false, err
+	}
+	j := sort.Search(len(entries), func(j int) bool { return entries[j].key >= key })
+	if j == len(entries) || entries[j].key != key {
+		return nil, false, nil
+	}
+	return entries[j].value, true, nil
+}
+
+// findBlock returns the first block whose last key is not lower than key.
+func (t *table) findBlock(key string) int {
+	return sort.Search(len(t.blocks), func(i int) bool { return t.blocks[i].lastKey >= key })
+}
+
+// readBlock reads and decodes the entries of a block.
+func (t *table) readBlock(i int) ([]entry, error) {
+	h := t.blocks[i]
+	b := make([]byte, h.size)
+	if _, err := t.file.ReadAt(b, int64(h.offset)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, t.corrupted(h.offset, io.ErrUnexpectedEOF)
+		}
+		return nil, err
+	}
+	if len(b) < blockCRCSize {
+		return nil, t.corrupted(h.offset, io.ErrUnexpectedEOF)
+	}
+	data, sum := b[:len(b)-blockCRCSize], encoding.Uint32(b[len(b)-blockCRCSize:])
+	if crc32.Checksum(data, crcTable) != sum {
+		return nil, t.corrupted(h.offset, errors.New("checksum mismatch"))
+	}
+	var entries []entry
+	r := &byteReader{b: data}
+	for r.err == nil && len(r.b) > 0 {
+		key := r.string()
+		value := r.bytes()
+		entries = append(entries, entry{key: key, value: value})
+	}
+	if r.err != nil {
+		return nil, t.corrupted(h.offset, r.err)
+	}
+	return entries, nil
+}
+
+// Overlaps returns true if the table may have keys from smallest to largest, included.
+func (t *table) Overlaps(smallest, largest string) bool {
+	return t.smallest <= largest && t.largest >= smallest
+}
+
+// Stats returns statistics about the table.
+func (t *table) Stats() backend.SegmentStats {
+	return backend.SegmentStats{
+		ID:         t.id,
+		Keys:       int(t.keys),
+		Tombstones: int(t.tombstones),
+		Size:       t.size,
+		LiveBytes:  t.size - t.tombstoneBytes,
+		DeadBytes:  t.tombstoneBytes,
+	}
+}
+
+// Scrub reads every block of the table, returning the range from the first
+// corrupted one to the end of the table, or nil if there is none.
+func (t *table) Scrub() (*backend.Corruption, error) {
+	for i := range t.blocks {
+		_, err := t.readBlock(i)
+		var corrupt *CorruptionError
+		if errors.As(err, &corrupt) {
+			return &backend.Corruption{
+				Segment: t.id,
+				Offset:  corrupt.Offset,
+				Size:    t.size - corrupt.Offset,
+				Err:     corrupt,
+			}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// ref takes a reference to the table, keeping its file open until it is
+// released.
+func (t *table) ref() {
+	t.refs.Add(1)
+}
+
+// unref releases a reference to the table. The last one closes its file, and
+// removes it if the table was replaced.
+func (t *table) unref() error {
+	if t.refs.Add(-1) > 0 {
+		return nil
+	}
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	if t.replaced.Load() {
+		return t.fs.Remove(t.file.Name())
+	}
+	return nil
+}
+
+// Close releases the reference of the tree to the table.
+func (t *table) Close() error {
+	return t.unref()
+}
+
+// Remove releases the reference of the tree to the table, whose file is
+// removed once it is not read anymore.
+func (t *table) Remove() error {
+	t.replaced.Store(true)
+	return t.unref()
+}
+
+// tableWriter writes the entries of a table, which must be added in order.
+type tableWriter struct {
+	fs         vfs.FS
+	dir        string
+	id         uint64
+	file       vfs.File
+	buf        *bufio.Writer
+	blockBytes uint64
+
+	offset uint64
+	block  []byte
+	last   string
+	t      table
+	hashes []uint64
+}
+
+func newTableWriter(fsys vfs.FS, dir string, id uint64, blockBytes uint64) (*tableWriter, error) {
+	f, err := fsys.OpenFile(tablePath(dir, id), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, tableFileMode)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		fs:         fsys,
+		dir:        dir,
+		id:         id,
+		file:       f,
+		buf:        bufio.NewWriter(f),
+		blockBytes: blockBytes,
+	}, nil
+}
+
+// Add appends an entry to the table.
+func (w *tableWriter) Add(key string, value []byte) error {
+	if w.t.keys == 0 {
+		w.t.smallest = key
+	}
+	w.block = binary.AppendUvarint(w.block, uint64(len(key)))
+	w.block = append(w.block, key...)
+	w.block = binary.AppendUvarint(w.block, uint64(len(value)))
+	w.block = append(w.block, value...)
+	w.last = key
+	w.hashes = append(w.hashes, bloom.Hash(key))
+	w.t.keys++
+	if isTombstone(value) {
+		w.t.tombstones++
+		w.t.tombstoneBytes += uint64(len(key) + len(value))
+	}
+	if uint64(len(w.block)) >= w.blockBytes {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// Size returns the number of bytes written so far.
+func (w *tableWriter) Size() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	w.block = encoding.AppendUint32(w.block, crc32.Checksum(w.block, crcTable))
+	if _, err := w.buf.Write(w.block); err != nil {
+		return err
+	}
+	w.t.blocks = append(w.t.blocks, blockHandle{lastKey: w.last, offset: w.offset, size: uint64(len(w.block))})
+	w.offset += uint64(len(w.block))
+	w.block = w.block[:0]
+	return nil
+}
+
+// Finish writes the filter, index and footer of the table, syncs it and
+// opens it for reading.
+func (w *tableWriter) Finish() (*table, error) {
+	if err := w.finish(); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return openTable(w.fs, w.dir, w.id)
+}
+
+func (w *tableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	filter := bloom.New(len(w.hashes), filterFalsePositiveRate)
+	for _, h := range w.hashes {
+		filter.AddHash(h)
+	}
+	filterData, err := filter.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	filterOffset := w.offset
+	if _, err := w.buf.Write(filterData); err != nil {
+		return err
+	}
+	w.offset += uint64(len(filterData))
+
+	index := binary.AppendUvarint(nil, w.t.keys)
+	index = binary.AppendUvarint(index, w.t.tombstones)
+	index = binary.AppendUvarint(index, w.t.tombstoneBytes)
+	index = appendString(index, w.t.smallest)
+	for _, h := range w.t.blocks {
+		index = appendString(index, h.lastKey)
+		index = binary.AppendUvarint(index, h.offset)
+		index = binary.AppendUvarint(index, h.size)
+	}
+	index = encoding.AppendUint32(index, crc32.Checksum(index, crcTable))
+	indexOffset := w.offset
+	if _, err := w.buf.Write(index); err != nil {
+		return err
+	}
+	w.offset += uint64(len(index))
+
+	var footer [footerSize]byte
+	encoding.PutUint64(footer[0:], filterOffset)
+	encoding.PutUint64(footer[8:], uint64(len(filterData)))
+	encoding.PutUint64(footer[16:], indexOffset)
+	encoding.PutUint64(footer[24:], uint64(len(index)))
+	encoding.PutUint64(footer[32:], tableMagic)
+	if _, err := w.buf.Write(footer[:]); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// Abort discards the table.
+func (w *tableWriter) Abort() {
+	w.file.Close()
+	w.fs.Remove(w.file.Name())
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// byteReader decodes the uvarints and length-prefixed strings of blocks and
+// indexes, keeping the first error.
+type byteReader struct {
+	b   []byte
+	err error
+}
+
+func (r *byteReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *byteReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *byteReader) string() string {
+	return string(r.bytes())
+}
+
+// tableIterator iterates over the entries of a table, a block at a time.
+type tableIterator struct {
+	t       *table
+	block   int
+	entries []entry
+	i       int
+	err     error
+}
+
+func (t *table) iterator() *tableIterator {
+	return &tableIterator{t: t}
+}
+
+// load reads the given block, positioning the iterator at its first entry,
+// or its last one if last is true.
+func (it *tableIterator) load(block int, last bool) {
+	it.block, it.entries, it.i = block, nil, 0
+	if block < 0 || block >= len(it.t.blocks) {
+		return
+	}
+	it.entries, it.err = it.t.readBlock(block)
+	if last {
+		it.i = len(it.entries) - 1
+	}
+}
+
+func (it *tableIterator) SeekGE(key string) {
+	it.load(it.t.findBlock(key), false)
+	for it.Valid() && it.Key() < key {
+		it.Next()
+	}
+}
+
+func (it *tableIterator) SeekLT(key string) {
+	if key == "" || key > it.t.largest {
+		it.load(len(it.t.blocks)-1, true)
+		return
+	}
+	it.load(it.t.findBlock(key), true)
+	for it.Valid() && it.Key() >= key {
+		it.Prev()
+	}
+}
+
+func (it *tableIterator) Next() {
+	it.i++
+	if it.i >= len(it.entries) && it.err == nil && it.block < len(it.t.blocks) {
+		it.load(it.block+1, false)
+	}
+}
+
+func (it *tableIterator) Prev() {
+	it.i--
+	if it.i < 0 && it.err == nil && it.block >= 0 {
+		it.load(it.block-1, true)
+	}
+}
+
+func (it *tableIterator) Valid() bool {
+	return it.err == nil && it.i >= 0 && it.i < len(it.entries)
+}
+
+func (it *tableIterator) Key() string   { return it.entries[it.i].key }
+func (it *tableIterator) Value() []byte { return it.entries[it.i].value }
+func (it *tableIterator) Err() error    { return it.err }
@@ -0,0 +1,140 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	tests := map[string]func(t *testing.T, tbl *table){
+		"get keys of every block":         testTableGet,
+		"seek and iterate both ways":      testTableIterator,
+		"merge with newer tables winning": testMergingIterator,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tbl := writeTable(t, t.TempDir(), 1, 0, 2, 100, "old")
+			defer tbl.Close()
+			require.Greater(t, len(tbl.blocks), 1)
+			fn(t, tbl)
+		})
+	}
+}
+
+func TestLevelIterator(t *testing.T) {
+	dir := t.TempDir()
+	var tables []*table
+	for i, start := range []int{0, 40, 80} {
+		tbl := writeTable(t, dir, uint64(i+1), start, 1, start+30, "v")
+		defer tbl.Close()
+		tables = append(tables, tbl)
+	}
+	it := newLevelIterator(tables)
+
+	it.SeekGE("key-035")
+	require.Equal(t, "key-040", it.Key())
+	it.SeekGE("key-110")
+	require.False(t, it.Valid())
+	it.SeekGE("")
+	require.Len(t, collect(it, false), 90)
+
+	it.SeekLT("key-080")
+	require.Equal(t, "key-069", it.Key())
+	it.SeekLT("key-000")
+	require.False(t, it.Valid())
+	it.SeekLT("")
+	keys := collect(it, true)
+	require.Len(t, keys, 90)
+	require.Equal(t, "key-109", keys[0])
+	require.NoError(t, it.Err())
+}
+
+// writeTable writes a table with the keys from start to end, stepping by
+// step, whose values are prefixed by prefix.
+func writeTable(t *testing.T, dir string, id uint64, start, step, end int, prefix string) *table {
+	t.Helper()
+	w, err := newTableWriter(vfs.OS, dir, id, 64)
+	require.NoError(t, err)
+	for i := start; i < end; i += step {
+		require.NoError(t, w.Add(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("%s-%d", prefix, i))))
+	}
+	tbl, err := w.Finish()
+	require.NoError(t, err)
+	return tbl
+}
+
+func testTableGet(t *testing.T, tbl *table) {
+	require.Equal(t, uint64(50), tbl.keys)
+	require.Equal(t, "key-000", tbl.smallest)
+	require.Equal(t, "key-098", tbl.largest)
+	for i := 0; i < 100; i++ {
+		value, ok, err := tbl.Get(fmt.Sprintf("key-%03d", i))
+		require.NoError(t, err)
+		require.Equal(t, i%2 == 0, ok, i)
+		if ok {
+			require.Equal(t, fmt.Sprintf("old-%d", i), string(value))
+		}
+	}
+}
+
+func collect(it iterator, reverse bool) []string {
+	var keys []string
+	for it.Valid() {
+		keys = append(keys, it.Key())
+		if reverse {
+			it.Prev()
+		} else {
+			it.Next()
+		}
+	}
+	return keys
+}
+
+func testTableIterator(t *testing.T, tbl *table) {
+	it := tbl.iterator()
+	it.SeekGE("key-041")
+	keys := collect(it, false)
+	require.Len(t, keys, 29)
+	require.Equal(t, "key-042", keys[0])
+	require.Equal(t, "key-098", keys[len(keys)-1])
+
+	it.SeekLT("key-042")
+	keys = collect(it, true)
+	require.Len(t, keys, 21)
+	require.Equal(t, "key-040", keys[0])
+	require.Equal(t, "key-000", keys[len(keys)-1])
+
+	it.SeekLT("")
+	require.Equal(t, "key-098", it.Key())
+	it.SeekGE("key-099")
+	require.False(t, it.Valid())
+	require.NoError(t, it.Err())
+}
+
+func testMergingIterator(t *testing.T, tbl *table) {
+	newer := writeTable(t, t.TempDir(), 2, 0, 3, 100, "new")
+	defer newer.Close()
+
+	it := newMergingIterator(newer.iterator(), tbl.iterator())
+	it.SeekGE("")
+	var n int
+	for ; it.Valid(); it.Next() {
+		var i int
+		_, err := fmt.Sscanf(it.Key(), "key-%03d", &i)
+		require.NoError(t, err)
+		want := fmt.Sprintf("old-%d", i)
+		if i%3 == 0 {
+			want = fmt.Sprintf("new-%d", i)
+		}
+		require.Equal(t, want, string(it.Value()))
+		n++
+	}
+	// keys divisible by 2 or 3
+	require.Equal(t, 67, n)
+
+	it.SeekLT("key-010")
+	require.Equal(t, []string{"key-009", "key-008", "key-006", "key-004", "key-003", "key-002", "key-000"}, collect(it, true))
+}
@@ -0,0 +1,248 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/vfs"
+	"github.com/danielfsousa/ddb/pkg/fmode"
+)
+
+// ================ WAL Format ================
+// +----------+--------+----------+-----+-------+
+// | checksum | length | keyLength| key | value |
+// +----------+--------+----------+-----+-------+
+// | 4 bytes  | 4 bytes| uvarint  | ?   | ?     |
+// +----------+--------+----------+-----+-------+
+//
+// The write-ahead log holds the entries of the memtable, so it can be rebuilt
+// when the backend is opened. The checksum covers everything after the
+// length. A log is removed once its memtable is flushed to a table.
+
+const (
+	walExt        = ".wal"
+	walHeaderSize = 4 + 4
+
+	walFileMode = fmode.USER_RW | fmode.GROUP_R | fmode.OTHER_R
+)
+
+var (
+	encoding = binary.BigEndian
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// errInvalidEntry is the reason of a CorruptionError for an entry that has a
+// valid checksum but could not have been written, such as the zeroes left by
+// a crash when the file size was updated before its data.
+var errInvalidEntry = errors.New("invalid entry")
+
+// CorruptionError is returned when a log or a table cannot be read back.
+type CorruptionError struct {
+	// Path is the path of the file.
+	Path string
+	// Offset is the position of the first byte of the corrupted entry or block.
+	Offset uint64
+	// Reason is the underlying error. It is io.ErrUnexpectedEOF if the entry
+	// extends beyond the end of the file.
+	Reason error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s is corrupted at offset %d: %v", e.Path, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Reason
+}
+
+// Is makes CorruptionError match backend.ErrCorrupted.
+func (e *CorruptionError) Is(target error) bool {
+	return target == backend.ErrCorrupted
+}
+
+type wal struct {
+	id   uint64
+	mu   sync.Mutex
+	fs   vfs.FS
+	file vfs.File
+	buf  *bufio.Writer
+	size uint64
+	// closed is set once the memtable of the log was flushed, after which
+	// syncing it has nothing left to do.
+	closed bool
+}
+
+func walPath(dir string, id uint64) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", id, walExt))
+}
+
+// openWAL opens the log with the given id for appending, creating it if needed.
+func openWAL(fsys vfs.FS, dir string, id uint64) (*wal, error) {
+	f, err := fsys.OpenFile(walPath(dir, id), os.O_RDWR|os.O_CREATE|os.O_APPEND, walFileMode)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wal{id: id, fs: fsys, file: f, buf: bufio.NewWriter(f), size: uint64(fi.Size())}, nil
+}
+
+// Append writes an entry to the log. It is only durable once the log is synced.
+func (w *wal) Append(key string, value []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	payload := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	var header [walHeaderSize]byte
+	encoding.PutUint32(header[:4], crc32.Checksum(payload, crcTable))
+	encoding.PutUint32(header[4:], uint32(len(payload)))
+	if _, err := w.buf.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(payload); err != nil {
+		return err
+	}
+	w.size += walHeaderSize + uint64(len(payload))
+	return nil
+}
+
+// Sync flushes the log to disk.
+func (w *wal) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close syncs and closes the log.
+func (w *wal) Close() error {
+	if err := w.Sync(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.file.Close()
+}
+
+// Remove closes the log and removes its file.
+func (w *wal) Remove() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return w.fs.Remove(w.file.Name())
+}
+
+// Bytes returns the content of the log, including the entries not synced yet.
+func (w *wal) Bytes() ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		return nil, err
+	}
+	b := make([]byte, w.size)
+	if _, err := w.file.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Truncate discards the entries of the log starting at offset.
+func (w *wal) Truncate(offset uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	w.size = offset
+	return nil
+}
+
+// Replay calls fn for every entry of the log, in the order they were
+// appended. It returns a CorruptionError at the first entry that cannot be
+// read back.
+func (w *wal) Replay(fn func(key string, value []byte)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(w.file, 0, int64(w.size)))
+	var offset uint64
+	for offset < w.size {
+		corrupted := func(reason error) error {
+			return &CorruptionError{Path: w.file.Name(), Offset: offset, Reason: reason}
+		}
+		var header [walHeaderSize]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return corrupted(io.ErrUnexpectedEOF)
+		}
+		length := uint64(encoding.Uint32(header[4:]))
+		if length > w.size-offset-walHeaderSize {
+			return corrupted(io.ErrUnexpectedEOF)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return corrupted(io.ErrUnexpectedEOF)
+		}
+		if crc32.Checksum(payload, crcTable) != encoding.Uint32(header[:4]) {
+			return corrupted(errors.New("checksum mismatch"))
+		}
+		keyLen, n := binary.Uvarint(payload)
+		if n <= 0 || keyLen > uint64(len(payload)-n) || length == 0 {
+			return corrupted(errInvalidEntry)
+		}
+		key := string(payload[n : n+int(keyLen)])
+		fn(key, payload[n+int(keyLen):])
+		offset += walHeaderSize + length
+	}
+	return nil
+}
+
+// isTornTail returns true if the corruption is confined to the end of the
+// log, as left by a crash in the middle of an append: either the entry
+// extends beyond the end of the file or only zeroes follow it.
+func (w *wal) isTornTail(corrupt *CorruptionError) (bool, error) {
+	if errors.Is(corrupt.Reason, io.ErrUnexpectedEOF) {
+		return true, nil
+	}
+	r := io.NewSectionReader(w.file, int64(corrupt.Offset), int64(w.size-corrupt.Offset))
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
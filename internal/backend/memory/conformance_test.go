@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/internal/backend/backendtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("ephemeral", func(t *testing.T) {
+		backendtest.Suite{
+			Open: func(t *testing.T, dir string) backend.Backend {
+				m, err := NewMemoryBackend(Config{})
+				require.NoError(t, err)
+				return m
+			},
+		}.Run(t)
+	})
+	t.Run("snapshotted", func(t *testing.T) {
+		backendtest.Suite{
+			Open: func(t *testing.T, dir string) backend.Backend {
+				m, err := NewMemoryBackend(Config{SnapshotPath: filepath.Join(dir, "snapshot")})
+				require.NoError(t, err)
+				return m
+			},
+			Persistent: true,
+		}.Run(t)
+	})
+}
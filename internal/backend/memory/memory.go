@@ -0,0 +1,279 @@
+// Package memory implements a backend that keeps every record in memory,
+// for tests and ephemeral caches.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/google/btree"
+	"google.golang.org/protobuf/proto"
+)
+
+// degree is the degree of the B-tree records are kept in.
+const degree = 32
+
+var errClosed = errors.New("memory: backend is closed")
+
+type Config struct {
+	// SnapshotPath is the file records are saved to when the backend is
+	// closed, and loaded from when it is opened. Records are only kept in
+	// memory if it is empty.
+	SnapshotPath string
+}
+
+// Memory is a backend that keeps the newest record of each key in memory,
+// sorted by key. Deletes are kept as tombstones until merged, like in the
+// backends that store records on disk.
+type Memory struct {
+	mu     sync.RWMutex
+	Config Config
+
+	records   *btree.BTreeG[*ddbv1.Record]
+	lastMerge time.Time
+	// rawBytes is the size of the records written since the backend was
+	// opened, which are stored uncompressed.
+	rawBytes uint64
+	closed   bool
+}
+
+var (
+	_ backend.Backend  = (*Memory)(nil)
+	_ backend.Merger   = (*Memory)(nil)
+	_ backend.Ranger   = (*Memory)(nil)
+	_ backend.Importer = (*Memory)(nil)
+)
+
+func recordLess(a, b *ddbv1.Record) bool {
+	return a.Key < b.Key
+}
+
+// NewMemoryBackend creates a new memory backend, loading the records of its
+// snapshot if there is one.
+func NewMemoryBackend(c Config) (*Memory, error) {
+	m := &Memory{
+		Config:  c,
+		records: btree.NewG(degree, recordLess),
+	}
+	if c.SnapshotPath == "" {
+		return m, nil
+	}
+	if err := readSnapshot(c.SnapshotPath, func(rec *ddbv1.Record) {
+		m.records.ReplaceOrInsert(rec)
+	}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Has returns true if the key exists, deleted or not.
+func (m *Memory) Has(key string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.records.Has(&ddbv1.Record{Key: key})
+}
+
+// Get returns a copy of the record of a key.
+func (m *Memory) Get(_ context.Context, key string) (rec *ddbv1.Record, exists bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, exists = m.records.Get(&ddbv1.Record{Key: key})
+	if !exists {
+		return nil, false, nil
+	}
+	return proto.Clone(rec).(*ddbv1.Record), true, nil
+}
+
+// GetMetadata returns the metadata for a key. Size is the encoded size of
+// its record, and Pos is always zero.
+func (m *Memory) GetMetadata(key string) (meta backend.RecordMetadata, exists bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, exists := m.records.Get(&ddbv1.Record{Key: key})
+	if !exists {
+		return meta, false
+	}
+	return backend.RecordMetadata{Size: uint64(proto.Size(rec)), DeletedAt: rec.DeletedAt}, true
+}
+
+// Keys returns the sorted keys of all records.
+func (m *Memory) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, m.records.Len())
+	m.records.Ascend(func(rec *ddbv1.Record) bool {
+		keys = append(keys, rec.Key)
+		return true
+	})
+	return keys
+}
+
+// RangeKeys calls fn for the keys of all records from start to end. Keys
+// are read from a snapshot of the tree, so fn can write to the backend.
+func (m *Memory) RangeKeys(start, end string, reverse bool, fn func(key string) error) (err error) {
+	records := m.clone()
+
+	visit := func(rec *ddbv1.Record) bool {
+		err = fn(rec.Key)
+		return err == nil
+	}
+	descend := func(rec *ddbv1.Record) bool {
+		if rec.Key < start {
+			return false
+		}
+		return rec.Key == end || visit(rec)
+	}
+	switch {
+	case reverse && end == "":
+		records.Descend(descend)
+	case reverse:
+		records.DescendLessOrEqual(&ddbv1.Record{Key: end}, descend)
+	case end == "":
+		records.AscendGreaterOrEqual(&ddbv1.Record{Key: start}, visit)
+	default:
+		records.AscendRange(&ddbv1.Record{Key: start}, &ddbv1.Record{Key: end}, visit)
+	}
+	return err
+}
+
+// clone returns a copy-on-write snapshot of the records. Cloning changes
+// the tree, so it takes the write lock.
+func (m *Memory) clone() *btree.BTreeG[*ddbv1.Record] {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records.Clone()
+}
+
+// Set stores a copy of a record, replacing the previous record of its key.
+func (m *Memory) Set(_ context.Context, rec *ddbv1.Record) error {
+	rec = proto.Clone(rec).(*ddbv1.Record)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+	m.records.ReplaceOrInsert(rec)
+	m.rawBytes += uint64(proto.Size(rec))
+	return nil
+}
+
+// Import sets the records read from next at once, so none of them are seen
+// until all of them were read.
+func (m *Memory) Import(ctx context.Context, next func() (*ddbv1.Record, error)) (int, error) {
+	var recs []*ddbv1.Record
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		recs = append(recs, proto.Clone(rec).(*ddbv1.Record))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, errClosed
+	}
+	for _, rec := range recs {
+		m.records.ReplaceOrInsert(rec)
+		m.rawBytes += uint64(proto.Size(rec))
+	}
+	return len(recs), nil
+}
+
+// Merge drops the tombstones of deleted keys.
+func (m *Memory) Merge(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted []*ddbv1.Record
+	m.records.Ascend(func(rec *ddbv1.Record) bool {
+		if rec.DeletedAt != nil {
+			deleted = append(deleted, rec)
+		}
+		return true
+	})
+	for _, rec := range deleted {
+		m.records.Delete(rec)
+	}
+	m.lastMerge = time.Now()
+	return nil
+}
+
+// Stats returns statistics about the records, reported as a single segment.
+func (m *Memory) Stats() backend.Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	segment := backend.SegmentStats{ID: 1, Keys: m.records.Len()}
+	m.records.Ascend(func(rec *ddbv1.Record) bool {
+		size := uint64(proto.Size(rec))
+		segment.Size += size
+		if rec.DeletedAt != nil {
+			segment.Tombstones++
+			segment.DeadBytes += size
+		} else {
+			segment.LiveBytes += size
+		}
+		return true
+	})
+	return backend.Stats{
+		Segments:    []backend.SegmentStats{segment},
+		LastMerge:   m.lastMerge,
+		RawBytes:    m.rawBytes,
+		StoredBytes: m.rawBytes,
+	}
+}
+
+// Sync does nothing: records are only saved to the snapshot when the
+// backend is closed.
+func (m *Memory) Sync(context.Context) error {
+	return nil
+}
+
+// Snapshot saves the records to the snapshot file, if there is one.
+func (m *Memory) Snapshot() error {
+	if m.Config.SnapshotPath == "" {
+		return nil
+	}
+	records := m.clone()
+	return writeSnapshot(m.Config.SnapshotPath, records)
+}
+
+// Close saves the records to the snapshot file, if there is one, and
+// rejects further writes.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mu.Unlock()
+	return m.Snapshot()
+}
+
+// Reader returns an io.Reader instance to read the records, encoded like in
+// a snapshot.
+func (m *Memory) Reader() io.Reader {
+	records := m.clone()
+	var buf bytes.Buffer
+	if err := encodeSnapshot(&buf, records); err != nil {
+		return errReader{err}
+	}
+	return &buf
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	tests := map[string]func(t *testing.T, m *Memory){
+		"returns copies of records":      testCopies,
+		"merge drops tombstones":         testMergeTombstones,
+		"refuses writes once closed":     testClosed,
+		"refuses a corrupted snapshot":   testCorruptedSnapshot,
+		"saves a snapshot when asked":    testSnapshot,
+		"reads records as in a snapshot": testReader,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			m, err := NewMemoryBackend(Config{SnapshotPath: filepath.Join(t.TempDir(), "snapshot")})
+			require.NoError(t, err)
+			fn(t, m)
+		})
+	}
+}
+
+func testCopies(t *testing.T, m *Memory) {
+	ctx := context.Background()
+	rec := &ddbv1.Record{Key: "foo", Value: []byte("bar")}
+	require.NoError(t, m.Set(ctx, rec))
+	rec.Value[0] = 'c'
+
+	got, _, err := m.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), got.Value)
+	got.Value[0] = 'c'
+
+	got, _, err = m.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), got.Value)
+}
+
+func testMergeTombstones(t *testing.T, m *Memory) {
+	ctx := context.Background()
+	deletedAt := int64(1)
+	require.NoError(t, m.Set(ctx, &ddbv1.Record{Key: "live", Value: []byte("value")}))
+	require.NoError(t, m.Set(ctx, &ddbv1.Record{Key: "deleted", DeletedAt: &deletedAt}))
+	require.Equal(t, 1, m.Stats().Segments[0].Tombstones)
+
+	require.NoError(t, m.Merge(ctx))
+	require.Equal(t, []string{"live"}, m.Keys())
+	require.Zero(t, m.Stats().Segments[0].Tombstones)
+	require.Zero(t, m.Stats().Segments[0].DeadBytes)
+}
+
+func testClosed(t *testing.T, m *Memory) {
+	require.NoError(t, m.Close())
+	require.Error(t, m.Set(context.Background(), &ddbv1.Record{Key: "foo"}))
+	require.NoError(t, m.Close())
+}
+
+func testCorruptedSnapshot(t *testing.T, m *Memory) {
+	require.NoError(t, m.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+	require.NoError(t, m.Close())
+
+	b, err := os.ReadFile(m.Config.SnapshotPath)
+	require.NoError(t, err)
+	b[len(snapshotMagic)+2] ^= 0xff
+	require.NoError(t, os.WriteFile(m.Config.SnapshotPath, b, snapshotFileMode))
+
+	_, err = NewMemoryBackend(m.Config)
+	require.ErrorIs(t, err, backend.ErrCorrupted)
+}
+
+func testSnapshot(t *testing.T, m *Memory) {
+	require.NoError(t, m.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+	_, err := os.Stat(m.Config.SnapshotPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, m.Snapshot())
+	reopened, err := NewMemoryBackend(m.Config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, reopened.Keys())
+}
+
+func testReader(t *testing.T, m *Memory) {
+	require.NoError(t, m.Set(context.Background(), &ddbv1.Record{Key: "foo", Value: []byte("bar")}))
+	require.NoError(t, m.Snapshot())
+	want, err := os.ReadFile(m.Config.SnapshotPath)
+	require.NoError(t, err)
+
+	got := new(bytes.Buffer)
+	_, err = got.ReadFrom(m.Reader())
+	require.NoError(t, err)
+	require.Equal(t, want, got.Bytes())
+}
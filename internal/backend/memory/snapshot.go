@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/internal/backend"
+	"github.com/danielfsousa/ddb/pkg/fmode"
+	"github.com/google/btree"
+	"google.golang.org/protobuf/proto"
+)
+
+// ================ Snapshot Format ================
+// +---------+----------------------+----------+
+// | magic   | (length | record)*   | checksum |
+// +---------+----------------------+----------+
+// | 8 bytes | uvarint | ?          | 4 bytes  |
+// +---------+----------------------+----------+
+//
+// A snapshot holds the records sorted by key. The checksum covers everything
+// before it, so a snapshot is either loaded whole or refused.
+
+const (
+	snapshotMagic    = "ddbmem01"
+	snapshotFileMode = fmode.USER_RW | fmode.GROUP_R | fmode.OTHER_R
+	snapshotDirMode  = 0o755
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeSnapshot writes the records in the snapshot format.
+func encodeSnapshot(w io.Writer, records *btree.BTreeG[*ddbv1.Record]) (err error) {
+	crc := crc32.New(crcTable)
+	buf := bufio.NewWriter(io.MultiWriter(w, crc))
+	if _, err := buf.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	var b []byte
+	var opts proto.MarshalOptions
+	records.Ascend(func(rec *ddbv1.Record) bool {
+		b = binary.AppendUvarint(b[:0], uint64(proto.Size(rec)))
+		if b, err = opts.MarshalAppend(b, rec); err != nil {
+			return false
+		}
+		_, err = buf.Write(b)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// writeSnapshot atomically replaces the snapshot at path with the records.
+func writeSnapshot(path string, records *btree.BTreeG[*ddbv1.Record]) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, snapshotDirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, snapshotFileMode)
+	if err != nil {
+		return err
+	}
+	if err = encodeSnapshot(f, records); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readSnapshot calls fn for every record of the snapshot at path, if it exists.
+func readSnapshot(path string, fn func(rec *ddbv1.Record)) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	corrupted := func(reason string) error {
+		return fmt.Errorf("snapshot %s: %s: %w", path, reason, backend.ErrCorrupted)
+	}
+	if len(b) < len(snapshotMagic)+4 || string(b[:len(snapshotMagic)]) != snapshotMagic {
+		return corrupted("not a snapshot")
+	}
+	data, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.Checksum(data, crcTable) != sum {
+		return corrupted("checksum mismatch")
+	}
+	data = data[len(snapshotMagic):]
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return corrupted("invalid record length")
+		}
+		rec := &ddbv1.Record{}
+		if err := proto.Unmarshal(data[n:n+int(size)], rec); err != nil {
+			return corrupted(err.Error())
+		}
+		fn(rec)
+		data = data[n+int(size):]
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package bloom implements Bloom filters, which tell whether a key may be in
+// a set or is definitely not in it, using a few bits per key.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ================ Filter Format ================
+// +---------+--------+--------+---------+----------+
+// | version | hashes | keys   | bits    | checksum |
+// +---------+--------+--------+---------+----------+
+// | 1 byte  | 1 byte | 8 bytes| 8*words | 4 bytes  |
+// +---------+--------+--------+---------+----------+
+//
+// Keys are hashed with xxhash, so persisted filters stay valid across
+// processes. The checksum covers everything before it.
+
+const (
+	version    = 1
+	headerSize = 1 + 1 + 8
+	crcSize    = 4
+)
+
+var (
+	encoding = binary.BigEndian
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// ErrInvalid is the error returned when decoding data that is not a valid filter.
+var ErrInvalid = errors.New("invalid bloom filter")
+
+// Filter is a Bloom filter. It is not safe for concurrent writes, but can be
+// read concurrently once all keys were added.
+type Filter struct {
+	bits   []uint64
+	hashes uint8
+	keys   uint64
+}
+
+// New returns a filter sized to hold n keys with the given false positive rate.
+func New(n int, falsePositiveRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	bits := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bits / float64(n) * math.Ln2)
+	if hashes < 1 {
+		hashes = 1
+	}
+	if hashes > 30 {
+		hashes = 30
+	}
+	return &Filter{
+		bits:   make([]uint64, (uint64(bits)+63)/64),
+		hashes: uint8(hashes),
+	}
+}
+
+// Add adds a key to the filter.
+func (f *Filter) Add(key string) {
+	f.AddHash(Hash(key))
+}
+
+// AddHash adds a key to the filter given its Hash.
+func (f *Filter) AddHash(h uint64) {
+	delta := h>>33 | h<<31
+	m := uint64(len(f.bits)) * 64
+	for i := uint8(0); i < f.hashes; i++ {
+		bit := h % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+		h += delta
+	}
+	f.keys++
+}
+
+// MayContain returns false if the key was definitely not added to the filter.
+func (f *Filter) MayContain(key string) bool {
+	return f.MayContainHash(Hash(key))
+}
+
+// MayContainHash returns false if the key with the given Hash was definitely
+// not added to the filter.
+func (f *Filter) MayContainHash(h uint64) bool {
+	delta := h>>33 | h<<31
+	m := uint64(len(f.bits)) * 64
+	for i := uint8(0); i < f.hashes; i++ {
+		bit := h % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// Len returns the number of keys added to the filter.
+func (f *Filter) Len() uint64 {
+	return f.keys
+}
+
+// Hash returns the hash of a key the filter derives its positions from, each
+// one a rotation of the hash apart from the previous one.
+func Hash(key string) uint64 {
+	return xxhash.Sum64String(key)
+}
+
+// MarshalBinary encodes the filter.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	b := make([]byte, headerSize, headerSize+len(f.bits)*8+crcSize)
+	b[0] = version
+	b[1] = f.hashes
+	encoding.PutUint64(b[2:], f.keys)
+	for _, w := range f.bits {
+		b = encoding.AppendUint64(b, w)
+	}
+	return encoding.AppendUint32(b, crc32.Checksum(b, crcTable)), nil
+}
+
+// UnmarshalBinary decodes a filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize+8+crcSize || (len(b)-headerSize-crcSize)%8 != 0 {
+		return fmt.Errorf("%w: size %d", ErrInvalid, len(b))
+	}
+	data, sum := b[:len(b)-crcSize], encoding.Uint32(b[len(b)-crcSize:])
+	if crc32.Checksum(data, crcTable) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalid)
+	}
+	if data[0] != version {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalid, data[0])
+	}
+	if data[1] == 0 {
+		return fmt.Errorf("%w: no hash functions", ErrInvalid)
+	}
+	f.hashes = data[1]
+	f.keys = encoding.Uint64(data[2:])
+	f.bits = make([]uint64, (len(data)-headerSize)/8)
+	for i := range f.bits {
+		f.bits[i] = encoding.Uint64(data[headerSize+i*8:])
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10_000, 0.01)
+	for i := 0; i < 10_000; i++ {
+		f.Add(fmt.Sprintf("key-%d", i))
+	}
+	require.Equal(t, uint64(10_000), f.Len())
+	for i := 0; i < 10_000; i++ {
+		require.True(t, f.MayContain(fmt.Sprintf("key-%d", i)))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10_000; i++ {
+		if f.MayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 200)
+}
+
+func TestEncoding(t *testing.T) {
+	f := New(100, 0.01)
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("key-%d", i))
+	}
+	b, err := f.MarshalBinary()
+	require.NoError(t, err)
+
+	got := &Filter{}
+	require.NoError(t, got.UnmarshalBinary(b))
+	require.Equal(t, f, got)
+
+	b[headerSize] ^= 1
+	require.ErrorIs(t, got.UnmarshalBinary(b), ErrInvalid)
+	require.ErrorIs(t, got.UnmarshalBinary(b[:headerSize]), ErrInvalid)
+}
@@ -0,0 +1,104 @@
+// Package compress implements the codecs used to compress stored records.
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies a compression algorithm. Its value is persisted with each
+// compressed record, so existing values must never change.
+type Codec uint8
+
+const (
+	// None stores data as is.
+	None Codec = iota
+	// Snappy favors speed over compression ratio.
+	Snappy
+	// Zstd favors compression ratio over speed.
+	Zstd
+)
+
+// ErrUnknownCodec is the error returned for a codec that is not supported.
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// Parse parses a codec from one of: none, snappy or zstd.
+func Parse(s string) (Codec, error) {
+	for _, c := range []Codec{None, Snappy, Zstd} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return None, fmt.Errorf("%w: %q, must be one of none, snappy or zstd", ErrUnknownCodec, s)
+}
+
+func (c Codec) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Snappy:
+		return "snappy"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Codec(%d)", uint8(c))
+	}
+}
+
+var (
+	// the zstd encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll.
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// Encode compresses src with the codec.
+func Encode(c Codec, src []byte) ([]byte, error) {
+	switch c {
+	case None:
+		return src, nil
+	case Snappy:
+		return s2.EncodeSnappy(nil, src), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(src, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+	}
+}
+
+// Decode decompresses src, which was compressed with the codec.
+func Decode(c Codec, src []byte) ([]byte, error) {
+	switch c {
+	case None:
+		return src, nil
+	case Snappy:
+		return s2.Decode(nil, src)
+	case Zstd:
+		return zstdDecoder.DecodeAll(src, nil)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+	}
+}
+
+// Policy chooses the codec of each record by its key. Keys are grouped in
+// namespaces by their prefix, and the longest matching namespace wins.
+type Policy struct {
+	// Default is the codec of the keys outside every namespace.
+	Default Codec
+	// Namespaces maps key prefixes to their codec.
+	Namespaces map[string]Codec
+}
+
+// For returns the codec of the given key.
+func (p Policy) For(key string) Codec {
+	codec, longest := p.Default, -1
+	for prefix, c := range p.Namespaces {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			codec, longest = c, len(prefix)
+		}
+	}
+	return codec
+}
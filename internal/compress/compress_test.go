@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"ddb","tags":["kv","bitcask"]}`), 100)
+	for _, c := range []Codec{None, Snappy, Zstd} {
+		encoded, err := Encode(c, data)
+		require.NoError(t, err, c)
+		if c != None {
+			require.Less(t, len(encoded), len(data), c)
+		}
+		decoded, err := Decode(c, encoded)
+		require.NoError(t, err, c)
+		require.Equal(t, data, decoded, c)
+
+		parsed, err := Parse(c.String())
+		require.NoError(t, err)
+		require.Equal(t, c, parsed)
+	}
+
+	_, err := Decode(Codec(42), data)
+	require.ErrorIs(t, err, ErrUnknownCodec)
+	_, err = Parse("lz4")
+	require.ErrorIs(t, err, ErrUnknownCodec)
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{
+		Default: Snappy,
+		Namespaces: map[string]Codec{
+			"logs:":     Zstd,
+			"logs:raw:": None,
+		},
+	}
+	tests := map[string]Codec{
+		"user:1":      Snappy,
+		"logs:1":      Zstd,
+		"logs:raw:1":  None,
+		"logs":        Snappy,
+		"":            Snappy,
+		"logs:rawer1": Zstd,
+	}
+	for key, want := range tests {
+		require.Equal(t, want, p.For(key), key)
+	}
+}
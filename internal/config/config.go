@@ -1,5 +1,13 @@
 package config
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/danielfsousa/ddb/internal/compress"
+	"github.com/danielfsousa/ddb/internal/encryption"
+)
+
 const (
 	// DefaultMaxDatafileSize is the default maximum datafile size in bytes
 	DefaultMaxDatafileSize = 1 << 20 // 1MB
@@ -7,21 +15,88 @@ const (
 	// DefaultMaxKeySize is the default maximum key size in bytes
 	DefaultMaxKeySize = uint64(64) // 64 bytes
 
-	// DefaultMaxValueSize is the default value size in bytes
-	DefaultMaxValueSize = uint64(1 << 16) // 65KB
+	// DefaultMaxValueSize is the default value size in bytes. Values larger
+	// than the chunk size are split into chunks, so they do not need to fit in
+	// a segment.
+	DefaultMaxValueSize = uint64(1 << 30) // 1GB
+
+	// DefaultChunkSize is the default size in bytes of the chunks large values are split into
+	DefaultChunkSize = uint64(1 << 16) // 65KB
 )
 
 type Config struct {
+	Backend            Backend
 	MaxKeySize         uint64
 	MaxValueSize       uint64
+	ChunkSize          uint64
 	MaxSegmentDataSize uint64
+	Durability         Durability
+	Repair             bool
+	Compression        compress.Policy
+	// Keys encrypts data at rest if it is not nil.
+	Keys encryption.KeyProvider
+	// CacheSize is the maximum size in bytes of the values cached in memory.
+	// Values are not cached if it is zero.
+	CacheSize uint64
+	// HashKeys keeps only a hash of each key in memory.
+	HashKeys bool
+	// OrderedKeys keeps the keys sorted in memory for scans.
+	OrderedKeys bool
+}
+
+// Backend is the storage engine records are stored with.
+type Backend string
+
+const (
+	// BackendBitcask appends records to segments and indexes every key in memory.
+	BackendBitcask Backend = "bitcask"
+	// BackendLSM keeps records sorted in a log-structured merge-tree.
+	BackendLSM Backend = "lsm"
+	// BackendMemory keeps records in memory, saving them to disk on close if
+	// the database has a directory.
+	BackendMemory Backend = "memory"
+)
+
+// SyncMode defines when writes are synced to disk.
+type SyncMode int
+
+const (
+	// SyncNever leaves syncing writes to the operating system.
+	SyncNever SyncMode = iota
+	// SyncEveryWrite syncs every write before acknowledging it.
+	SyncEveryWrite
+	// SyncInterval syncs pending writes periodically.
+	SyncInterval
+)
+
+// Durability configures how writes are synced to disk.
+type Durability struct {
+	Mode SyncMode
+	// Interval is the time between syncs when Mode is SyncInterval.
+	Interval time.Duration
+}
+
+func (d Durability) String() string {
+	switch d.Mode {
+	case SyncNever:
+		return "never"
+	case SyncEveryWrite:
+		return "always"
+	case SyncInterval:
+		return d.Interval.String()
+	default:
+		return fmt.Sprintf("SyncMode(%d)", d.Mode)
+	}
 }
 
 // NewDefaultConfig creates a new Config with default settings.
 func NewDefaultConfig() *Config {
 	return &Config{
+		Backend:            BackendBitcask,
 		MaxKeySize:         DefaultMaxKeySize,
 		MaxValueSize:       DefaultMaxValueSize,
+		ChunkSize:          DefaultChunkSize,
 		MaxSegmentDataSize: DefaultMaxDatafileSize,
+		Durability:         Durability{Mode: SyncNever},
 	}
 }
@@ -41,6 +41,11 @@ func (m *Membership) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// State returns the state of the local serf agent.
+func (m *Membership) State() serf.SerfState {
+	return m.serf.State()
+}
+
 func (m *Membership) Leave() error {
 	return m.serf.Leave()
 }
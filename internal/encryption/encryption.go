@@ -0,0 +1,206 @@
+// Package encryption encrypts data at rest with AES-GCM, using keys supplied
+// by a local key file or a KMS.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrKeyNotFound is the error returned when a key id is unknown to a KeyProvider.
+	ErrKeyNotFound = errors.New("encryption key not found")
+	// ErrDecrypt is the error returned when data cannot be decrypted, either
+	// because it was modified or it was encrypted with another key.
+	ErrDecrypt = errors.New("failed to decrypt")
+)
+
+// KeyProvider supplies the keys data is encrypted with, such as a local key
+// file or a KMS. Keys are identified by an id that is stored along with the
+// data they encrypt, so they can be rotated while the data encrypted with
+// older keys stays readable.
+type KeyProvider interface {
+	// CurrentKeyID returns the id of the key new data is encrypted with.
+	CurrentKeyID() (string, error)
+	// Key returns the AES key with the given id, which is 16, 24 or 32 bytes
+	// long. It returns ErrKeyNotFound if there is no such key.
+	Key(id string) ([]byte, error)
+}
+
+// KeyFile is a KeyProvider backed by a JSON file holding the current key id
+// and every key by id, base64 encoded:
+//
+//	{"current": "2024-02", "keys": {"2024-01": "...", "2024-02": "..."}}
+type KeyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+var _ KeyProvider = (*KeyFile)(nil)
+
+// LoadKeyFile reads a KeyFile from the given path.
+func LoadKeyFile(name string) (*KeyFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &KeyFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", name, err)
+	}
+	if _, err := f.Key(f.Current); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: current key: %w", name, err)
+	}
+	return f, nil
+}
+
+// CurrentKeyID implements KeyProvider.
+func (f *KeyFile) CurrentKeyID() (string, error) {
+	return f.Current, nil
+}
+
+// Key implements KeyProvider.
+func (f *KeyFile) Key(id string) ([]byte, error) {
+	key, ok := f.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// Keyring encrypts and decrypts data with the keys of a KeyProvider, which
+// are only requested once.
+type Keyring struct {
+	provider KeyProvider
+	mu       sync.RWMutex
+	aeads    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring for the keys of the given provider.
+func NewKeyring(p KeyProvider) *Keyring {
+	return &Keyring{provider: p, aeads: make(map[string]cipher.AEAD)}
+}
+
+// CurrentKeyID returns the id of the key new data is encrypted with.
+func (k *Keyring) CurrentKeyID() (string, error) {
+	id, err := k.provider.CurrentKeyID()
+	if err != nil {
+		return "", err
+	}
+	if len(id) == 0 || len(id) > maxKeyIDSize {
+		return "", fmt.Errorf("key id %q must be between 1 and %d bytes", id, maxKeyIDSize)
+	}
+	return id, nil
+}
+
+// Load fetches the key with the given id, returning an error if it is not available.
+func (k *Keyring) Load(id string) error {
+	_, err := k.aead(id)
+	return err
+}
+
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, ok := k.aeads[id]
+	k.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := k.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.aeads[id] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// Seal encrypts and authenticates plaintext and authenticates aad with the
+// key with the given id. The random nonce is prepended to the ciphertext.
+func (k *Keyring) Seal(id string, plaintext, aad []byte) ([]byte, error) {
+	aead, err := k.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open decrypts and authenticates data returned by Seal.
+func (k *Keyring) Open(id string, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := k.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w with key %q", ErrDecrypt, id)
+	}
+	return plaintext, nil
+}
+
+// ============= Header Format ==============
+// +----------+--------------+--------+
+// | magic    | keyIDLength  | keyID  |
+// +----------+--------------+--------+
+// | 8 bytes  | 1 byte       | ?      |
+// +----------+--------------+--------+
+//
+// Encrypted files start with a header naming the key they are encrypted with.
+
+// magic identifies encrypted files. Its fifth byte would be an unknown
+// compression codec in the first record of a plaintext store, so it cannot
+// be confused with one.
+var magic = []byte("ddbENCR1")
+
+const (
+	maxKeyIDSize = 255
+	// MaxHeaderSize is the size of the largest header.
+	MaxHeaderSize = 8 + 1 + maxKeyIDSize
+)
+
+// AppendHeader appends the header of a file encrypted with the given key to dst.
+func AppendHeader(dst []byte, id string) []byte {
+	dst = append(dst, magic...)
+	dst = append(dst, byte(len(id)))
+	return append(dst, id...)
+}
+
+// ParseHeader returns the key id of the header at the start of b and its
+// size. It returns false if b does not start with a header.
+func ParseHeader(b []byte) (id string, n int, ok bool, err error) {
+	if !bytes.HasPrefix(b, magic) {
+		return "", 0, false, nil
+	}
+	if len(b) < len(magic)+1 {
+		return "", 0, true, errors.New("encryption header is truncated")
+	}
+	n = len(magic) + 1 + int(b[len(magic)])
+	if len(b) < n {
+		return "", 0, true, errors.New("encryption header is truncated")
+	}
+	return string(b[len(magic)+1 : n]), n, true, nil
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	keys := &KeyFile{
+		Current: "k2",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 16),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	k := NewKeyring(keys)
+	id, err := k.CurrentKeyID()
+	require.NoError(t, err)
+	require.Equal(t, "k2", id)
+
+	plaintext := []byte("hello world")
+	for _, id := range []string{"k1", "k2"} {
+		sealed, err := k.Seal(id, plaintext, []byte("aad"))
+		require.NoError(t, err, id)
+		require.NotContains(t, string(sealed), "hello")
+
+		opened, err := k.Open(id, sealed, []byte("aad"))
+		require.NoError(t, err, id)
+		require.Equal(t, plaintext, opened, id)
+
+		_, err = k.Open(id, sealed, []byte("other"))
+		require.ErrorIs(t, err, ErrDecrypt, id)
+		sealed[len(sealed)-1] ^= 1
+		_, err = k.Open(id, sealed, []byte("aad"))
+		require.ErrorIs(t, err, ErrDecrypt, id)
+	}
+
+	sealed, err := k.Seal("k1", plaintext, nil)
+	require.NoError(t, err)
+	_, err = k.Open("k2", sealed, nil)
+	require.ErrorIs(t, err, ErrDecrypt)
+	_, err = k.Seal("k3", plaintext, nil)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.ErrorIs(t, k.Load("k3"), ErrKeyNotFound)
+}
+
+func TestKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "keys.json")
+	key := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	require.NoError(t, os.WriteFile(name, []byte(`{"current":"k1","keys":{"k1":"`+key+`"}}`), 0o600))
+	keys, err := LoadKeyFile(name)
+	require.NoError(t, err)
+	got, err := keys.Key("k1")
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte{1}, 32), got)
+
+	require.NoError(t, os.WriteFile(name, []byte(`{"current":"k2","keys":{"k1":"`+key+`"}}`), 0o600))
+	_, err = LoadKeyFile(name)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestHeader(t *testing.T) {
+	b := AppendHeader(nil, "k1")
+	b = append(b, "data"...)
+	id, n, ok, err := ParseHeader(b)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "k1", id)
+	require.Equal(t, "data", string(b[n:]))
+
+	_, _, ok, err = ParseHeader([]byte("plaintext"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, _, _, err = ParseHeader(b[:len(magic)+2])
+	require.Error(t, err)
+}
@@ -0,0 +1,25 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotReady is returned by checks of components that did not finish starting up.
+var ErrNotReady = errors.New("not ready")
+
+// DiskSpace returns a check that fails when the filesystem holding dir has
+// less than minFree bytes available.
+func DiskSpace(dir string, minFree uint64) Check {
+	return func(context.Context) error {
+		free, err := freeBytes(dir)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d bytes free, at least %d are required", free, minFree)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package health
+
+import "math"
+
+// freeBytes is not supported on this platform, so disk space checks always pass.
+func freeBytes(string) (uint64, error) {
+	return math.MaxUint64, nil
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// HealthServiceName is the fully qualified name of the gRPC health service.
+	HealthServiceName = "grpc.health.v1.Health"
+
+	checkProcedure = "/" + HealthServiceName + "/Check"
+	watchProcedure = "/" + HealthServiceName + "/Watch"
+)
+
+// watchInterval is how often the checks are evaluated for streaming watchers.
+var watchInterval = time.Second
+
+// NewGRPCHandler returns the path and handler implementing the gRPC health
+// checking protocol. The empty service name reports the readiness of the
+// whole node, as do the names of the given services; any other name is unknown.
+func (h *Health) NewGRPCHandler(services ...string) (string, http.Handler) {
+	known := map[string]bool{"": true}
+	for _, service := range services {
+		known[service] = true
+	}
+
+	status := func(ctx context.Context, service string) (healthv1.HealthCheckResponse_ServingStatus, error) {
+		if !known[service] {
+			return healthv1.HealthCheckResponse_SERVICE_UNKNOWN, fmt.Errorf("unknown service %q", service)
+		}
+		if h.Ready(ctx).OK() {
+			return healthv1.HealthCheckResponse_SERVING, nil
+		}
+		return healthv1.HealthCheckResponse_NOT_SERVING, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(checkProcedure, connect.NewUnaryHandler(
+		checkProcedure,
+		func(
+			ctx context.Context,
+			req *connect.Request[healthv1.HealthCheckRequest],
+		) (*connect.Response[healthv1.HealthCheckResponse], error) {
+			s, err := status(ctx, req.Msg.GetService())
+			if err != nil {
+				return nil, connect.NewError(connect.CodeNotFound, err)
+			}
+			return connect.NewResponse(&healthv1.HealthCheckResponse{Status: s}), nil
+		},
+	))
+	mux.Handle(watchProcedure, connect.NewServerStreamHandler(
+		watchProcedure,
+		func(
+			ctx context.Context,
+			req *connect.Request[healthv1.HealthCheckRequest],
+			stream *connect.ServerStream[healthv1.HealthCheckResponse],
+		) error {
+			ticker := time.NewTicker(watchInterval)
+			defer ticker.Stop()
+			last := healthv1.HealthCheckResponse_UNKNOWN
+			for {
+				// unknown services are reported instead of failing, as the protocol requires
+				s, _ := status(ctx, req.Msg.GetService())
+				if s != last {
+					if err := stream.Send(&healthv1.HealthCheckResponse{Status: s}); err != nil {
+						return err
+					}
+					last = s
+				}
+				select {
+				case <-ctx.Done():
+					if errors.Is(ctx.Err(), context.Canceled) {
+						return nil
+					}
+					return ctx.Err()
+				case <-ticker.C:
+				}
+			}
+		},
+	))
+	return "/" + HealthServiceName + "/", mux
+}
@@ -0,0 +1,132 @@
+// Package health reports whether a node is alive and ready to serve requests,
+// over plain HTTP endpoints and the standard gRPC health checking protocol.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check returns an error if the component it checks is not healthy.
+type Check func(ctx context.Context) error
+
+const (
+	// StatusOK is reported when every check passed.
+	StatusOK = "ok"
+	// StatusFailing is reported when at least one check failed.
+	StatusFailing = "failing"
+)
+
+// checkTimeout bounds how long a single check can take.
+const checkTimeout = 5 * time.Second
+
+// Result is the outcome of running a set of checks.
+type Result struct {
+	Status string `json:"status"`
+	// Checks maps the name of every check to StatusOK or the error it returned.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// OK returns true if every check passed.
+func (r Result) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Health keeps track of liveness and readiness checks.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+// New creates a Health without any checks, which is both alive and ready.
+func New() *Health {
+	return &Health{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+}
+
+// AddLivenessCheck registers a check that must pass for the node to be considered alive.
+// A node that is not alive should be restarted.
+func (h *Health) AddLivenessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// AddReadinessCheck registers a check that must pass for the node to receive traffic.
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// Live runs the liveness checks.
+func (h *Health) Live(ctx context.Context) Result {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return run(ctx, h.liveness)
+}
+
+// Ready runs the liveness and readiness checks, since a node that is not alive is not ready either.
+func (h *Health) Ready(ctx context.Context) Result {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	checks := make(map[string]Check, len(h.liveness)+len(h.readiness))
+	for name, check := range h.liveness {
+		checks[name] = check
+	}
+	for name, check := range h.readiness {
+		checks[name] = check
+	}
+	return run(ctx, checks)
+}
+
+func run(ctx context.Context, checks map[string]Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := Result{Status: StatusOK, Checks: make(map[string]string, len(checks))}
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			res.Status = StatusFailing
+			res.Checks[name] = err.Error()
+			continue
+		}
+		res.Checks[name] = StatusOK
+	}
+	return res
+}
+
+// LivenessHandler serves the result of the liveness checks, to be mounted at /healthz.
+func (h *Health) LivenessHandler() http.Handler {
+	return resultHandler(h.Live)
+}
+
+// ReadinessHandler serves the result of the readiness checks, to be mounted at /readyz.
+func (h *Health) ReadinessHandler() http.Handler {
+	return resultHandler(h.Ready)
+}
+
+func resultHandler(fn func(context.Context) Result) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := fn(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !res.OK() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/require"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecks(t *testing.T) {
+	h := New()
+	ctx := context.Background()
+	require.True(t, h.Live(ctx).OK())
+	require.True(t, h.Ready(ctx).OK())
+
+	h.AddReadinessCheck("database", func(context.Context) error { return ErrNotReady })
+	require.True(t, h.Live(ctx).OK())
+	res := h.Ready(ctx)
+	require.False(t, res.OK())
+	require.Equal(t, map[string]string{"database": "not ready"}, res.Checks)
+
+	h.AddReadinessCheck("database", func(context.Context) error { return nil })
+	h.AddLivenessCheck("deadlock", func(context.Context) error { return errors.New("stuck") })
+	require.False(t, h.Live(ctx).OK())
+	res = h.Ready(ctx)
+	require.False(t, res.OK())
+	require.Equal(t, map[string]string{"database": "ok", "deadlock": "stuck"}, res.Checks)
+}
+
+func TestHTTPHandlers(t *testing.T) {
+	h := New()
+	h.AddReadinessCheck("database", func(context.Context) error { return ErrNotReady })
+
+	rec := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var res Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	require.Equal(t, StatusFailing, res.Status)
+	require.Equal(t, "not ready", res.Checks["database"])
+}
+
+func TestDiskSpace(t *testing.T) {
+	require.NoError(t, DiskSpace(t.TempDir(), 1)(context.Background()))
+	require.Error(t, DiskSpace(t.TempDir(), 1<<62)(context.Background()))
+}
+
+func TestGRPCHealth(t *testing.T) {
+	watchInterval = 10 * time.Millisecond
+
+	var ready atomic.Bool
+	h := New()
+	h.AddReadinessCheck("database", func(context.Context) error {
+		if !ready.Load() {
+			return ErrNotReady
+		}
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle(h.NewGRPCHandler("ddb.v1.DdbService"))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	check := connect.NewClient[healthv1.HealthCheckRequest, healthv1.HealthCheckResponse](
+		http.DefaultClient, srv.URL+checkProcedure, connect.WithGRPC(),
+	)
+	watch := connect.NewClient[healthv1.HealthCheckRequest, healthv1.HealthCheckResponse](
+		http.DefaultClient, srv.URL+watchProcedure, connect.WithGRPC(),
+	)
+	ctx := context.Background()
+
+	res, err := check.CallUnary(ctx, connect.NewRequest(&healthv1.HealthCheckRequest{}))
+	require.NoError(t, err)
+	require.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, res.Msg.Status)
+
+	_, err = check.CallUnary(ctx, connect.NewRequest(&healthv1.HealthCheckRequest{Service: "unknown"}))
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := watch.CallServerStream(ctx, connect.NewRequest(&healthv1.HealthCheckRequest{Service: "ddb.v1.DdbService"}))
+	require.NoError(t, err)
+	require.True(t, stream.Receive())
+	require.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, stream.Msg().Status)
+
+	ready.Store(true)
+	require.True(t, stream.Receive())
+	require.Equal(t, healthv1.HealthCheckResponse_SERVING, stream.Msg().Status)
+
+	res, err = check.CallUnary(ctx, connect.NewRequest(&healthv1.HealthCheckRequest{Service: "ddb.v1.DdbService"}))
+	require.NoError(t, err)
+	require.Equal(t, healthv1.HealthCheckResponse_SERVING, res.Msg.Status)
+}
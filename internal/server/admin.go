@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/danielfsousa/ddb"
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+)
+
+// streamChunkSize is the size of the chunks backups, exports and values are streamed in.
+const streamChunkSize = 64 << 10 // 64KB
+
+// Stats will return statistics about the database.
+func (s *Server) Stats(
+	_ context.Context,
+	_ *connect.Request[ddbv1.StatsRequest],
+) (*connect.Response[ddbv1.StatsResponse], error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	res := &ddbv1.StatsResponse{
+		Segments:         int64(stats.Segments),
+		Keys:             int64(stats.Keys),
+		Tombstones:       int64(stats.Tombstones),
+		Size:             stats.Size,
+		LiveBytes:        stats.LiveBytes,
+		DeadBytes:        stats.DeadBytes,
+		HintedSegments:   int64(stats.HintedSegments),
+		OpenFiles:        int64(stats.OpenFiles),
+		CompressionRatio: stats.CompressionRatio,
+		SegmentStats:     make([]*ddbv1.SegmentStats, len(stats.SegmentStats)),
+	}
+	if !stats.LastMerge.IsZero() {
+		res.LastMerge = timestamppb.New(stats.LastMerge)
+	}
+	for i, seg := range stats.SegmentStats {
+		res.SegmentStats[i] = &ddbv1.SegmentStats{
+			Id:         seg.ID,
+			Keys:       int64(seg.Keys),
+			Tombstones: int64(seg.Tombstones),
+			Size:       seg.Size,
+			LiveBytes:  seg.LiveBytes,
+			DeadBytes:  seg.DeadBytes,
+			Hinted:     seg.Hinted,
+		}
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+// Backup will stream a backup archive of the database.
+func (s *Server) Backup(
+	ctx context.Context,
+	req *connect.Request[ddbv1.BackupRequest],
+	stream *connect.ServerStream[ddbv1.BackupResponse],
+) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	var since *ddb.BackupManifest
+	if b := req.Msg.GetSinceManifest(); len(b) > 0 {
+		since = &ddb.BackupManifest{}
+		if err := json.Unmarshal(b, since); err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	w := bufio.NewWriterSize(chunkWriter(func(chunk []byte) error {
+		return stream.Send(&ddbv1.BackupResponse{Chunk: chunk})
+	}), streamChunkSize)
+	if _, err := db.Backup(ctx, w, since); err != nil {
+		if errors.Is(err, ddb.ErrBackupUnsupported) {
+			return connect.NewError(connect.CodeUnimplemented, err)
+		}
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	return w.Flush()
+}
+
+// Export will stream the records of the database in a portable format.
+func (s *Server) Export(
+	ctx context.Context,
+	req *connect.Request[ddbv1.ExportRequest],
+	stream *connect.ServerStream[ddbv1.ExportResponse],
+) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	format, err := parseFormat(req.Msg.GetFormat())
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(chunkWriter(func(chunk []byte) error {
+		return stream.Send(&ddbv1.ExportResponse{Chunk: chunk})
+	}), streamChunkSize)
+	_, err = db.Export(ctx, w, ddb.ExportOptions{
+		Format:     format,
+		Prefix:     req.Msg.GetPrefix(),
+		Tombstones: req.Msg.GetTombstones(),
+	})
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	return w.Flush()
+}
+
+// Import will load records in a portable format into the database.
+func (s *Server) Import(
+	ctx context.Context,
+	stream *connect.ClientStream[ddbv1.ImportRequest],
+) (*connect.Response[ddbv1.ImportResponse], error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	if !stream.Receive() {
+		if err := stream.Err(); err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&ddbv1.ImportResponse{}), nil
+	}
+	first := stream.Msg()
+	format, err := parseFormat(first.GetFormat())
+	if err != nil {
+		return nil, err
+	}
+
+	r := newChunkReader(stream, (*ddbv1.ImportRequest).GetChunk, first.GetChunk())
+	n, err := db.Import(ctx, r, ddb.ImportOptions{
+		Format:     format,
+		Prefix:     first.GetPrefix(),
+		Tombstones: first.GetTombstones(),
+	})
+	if errors.Is(err, ddb.ErrInvalidImport) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&ddbv1.ImportResponse{Records: int64(n)}), nil
+}
+
+func parseFormat(f ddbv1.Format) (ddb.Format, error) {
+	switch f {
+	case ddbv1.Format_FORMAT_JSONL:
+		return ddb.FormatJSONLines, nil
+	case ddbv1.Format_FORMAT_CSV:
+		return ddb.FormatCSV, nil
+	case ddbv1.Format_FORMAT_PROTO:
+		return ddb.FormatProto, nil
+	default:
+		return 0, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%w: %s", ddb.ErrUnknownFormat, f))
+	}
+}
+
+// chunkWriter sends everything written to it as chunks of a stream.
+type chunkWriter func(chunk []byte) error
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// chunkReader reads the chunks of a client stream.
+type chunkReader struct {
+	next  func() ([]byte, error)
+	chunk []byte
+}
+
+// newChunkReader returns a chunkReader of the chunks of stream, starting
+// with the chunk of the first message, which was already received.
+func newChunkReader[T any](stream *connect.ClientStream[T], chunk func(*T) []byte, first []byte) *chunkReader {
+	return &chunkReader{
+		chunk: first,
+		next: func() ([]byte, error) {
+			if !stream.Receive() {
+				if err := stream.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			return chunk(stream.Msg()), nil
+		},
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		chunk, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = chunk
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
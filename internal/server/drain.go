@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// drainer keeps track of in-flight requests so they can finish before the server stops.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{idle: make(chan struct{})}
+}
+
+// Handler wraps the handler to track its requests, rejecting new ones once draining.
+func (d *drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.acquire() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer d.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *drainer) acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *drainer) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+// Drain stops accepting new requests.
+func (d *drainer) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	if d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+// Wait blocks until every in-flight request finished or the context is done.
+func (d *drainer) Wait(ctx context.Context) error {
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
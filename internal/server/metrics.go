@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ddb",
+	Subsystem: "rpc",
+	Name:      "duration_seconds",
+	Help:      "Latency of RPCs handled by the server, partitioned by procedure and status code.",
+	Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16), // 100µs to ~3.2s
+}, []string{"procedure", "code"})
+
+// metricsInterceptor records the latency of every RPC handled by the server.
+// Streaming RPCs are measured until the handler returns.
+type metricsInterceptor struct{}
+
+func newMetricsInterceptor() connect.Interceptor {
+	return metricsInterceptor{}
+}
+
+func (metricsInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		observeRPC(req.Spec().Procedure, start, err)
+		return res, err
+	}
+}
+
+// WrapStreamingClient leaves client calls alone, since the interceptor is
+// only installed on handlers.
+func (metricsInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (metricsInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		err := next(ctx, conn)
+		observeRPC(conn.Spec().Procedure, start, err)
+		return err
+	}
+}
+
+func observeRPC(procedure string, start time.Time, err error) {
+	code := "ok"
+	if err != nil {
+		code = connect.CodeOf(err).String()
+	}
+	rpcDuration.
+		WithLabelValues(procedure, code).
+		Observe(time.Since(start).Seconds())
+}
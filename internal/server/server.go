@@ -2,8 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/rs/zerolog"
@@ -14,60 +19,152 @@ import (
 	"github.com/danielfsousa/ddb"
 	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
 	"github.com/danielfsousa/ddb/gen/ddb/v1/ddbv1connect"
+	"github.com/danielfsousa/ddb/internal/telemetry"
 )
 
-// Server implements the DdbService API.
+// DefaultShutdownTimeout is how long in-flight requests are given to finish when the server stops.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Server implements the DdbService and AdminService APIs.
 type Server struct {
 	*Config
 	ddbv1connect.UnimplementedDdbServiceHandler
-	httpServer *http2.Server
-	logger     *zerolog.Logger
+	ddbv1connect.UnimplementedAdminServiceHandler
+	httpServer *http.Server
+	listener   net.Listener
+	drainer    *drainer
+	// database is the database served by the Server. It is set with SetDdb
+	// once it is open, so the server can answer health checks while the
+	// database is recovering.
+	database atomic.Pointer[ddb.Ddb]
+	logger   *zerolog.Logger
 }
 
 type Config struct {
 	Host string
 	Port int
-	Ddb  *ddb.Ddb
+	// Handlers are additional HTTP handlers served alongside the RPC service, keyed by path.
+	Handlers map[string]http.Handler
+	// ShutdownTimeout is how long Stop waits for in-flight requests before closing connections.
+	ShutdownTimeout time.Duration
 }
 
-var _ ddbv1connect.DdbServiceHandler = (*Server)(nil)
+var (
+	_ ddbv1connect.DdbServiceHandler   = (*Server)(nil)
+	_ ddbv1connect.AdminServiceHandler = (*Server)(nil)
+)
 
 // New will create a new Server.
 func New(config *Config) *Server {
 	logger := log.With().Str("component", "server").Logger()
-	return &Server{
-		Config: config,
-		logger: &logger,
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	s := &Server{
+		Config:  config,
+		drainer: newDrainer(),
+		logger:  &logger,
+	}
+
+	mux := http.NewServeMux()
+	interceptors := connect.WithInterceptors(
+		newMetricsInterceptor(),
+		telemetry.NewTracingInterceptor(),
+	)
+	mux.Handle(ddbv1connect.NewDdbServiceHandler(s, interceptors))
+	mux.Handle(ddbv1connect.NewAdminServiceHandler(s, interceptors))
+	for path, handler := range s.Handlers {
+		mux.Handle(path, handler)
+	}
+
+	h2s := &http2.Server{}
+	s.httpServer = &http.Server{
+		Addr: fmt.Sprintf("%s:%d", s.Host, s.Port),
+		// Use h2c so we can serve HTTP/2 without TLS.
+		Handler:           h2c.NewHandler(s.drainer.Handler(mux), h2s),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	// Let the HTTP/2 server send GOAWAY frames to its connections on shutdown.
+	if err := http2.ConfigureServer(s.httpServer, h2s); err != nil {
+		s.logger.Error().Err(err).Msg("failed to configure http2 server")
 	}
+	return s
+}
+
+const readHeaderTimeout = 10 * time.Second
+
+// Listen binds the address of the Server, which Start then serves on. Start
+// calls it if it was not called before.
+func (s *Server) Listen() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	return nil
 }
 
 // Start will start the Server and block until it is signaled to stop.
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	mux := http.NewServeMux()
-	path, handler := ddbv1connect.NewDdbServiceHandler(s)
-	mux.Handle(path, handler)
-	s.httpServer = &http2.Server{}
-	s.logger.Info().Msgf("server listening on %s", addr)
-	return http.ListenAndServe( //nolint:gosec // TODO: fix this
-		addr,
-		// Use h2c so we can serve HTTP/2 without TLS.
-		h2c.NewHandler(mux, s.httpServer),
-	)
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	s.logger.Info().Msgf("server listening on %s", s.httpServer.Addr)
+	err := s.httpServer.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-// Stop will attempt to gracefully shut the Server down by signaling the stop.
+// Stop will gracefully shut the Server down. It stops accepting new requests and
+// waits up to ShutdownTimeout for in-flight requests to finish before closing
+// every connection.
 func (s *Server) Stop() error {
-	// TODO: graceful shutdown
-	// if s.httpServer != nil {
-	// s.httpServer.Stop()
-	// }
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	s.logger.Info().Msg("draining in-flight requests")
+	s.drainer.Drain()
+	err := s.httpServer.Shutdown(ctx)
+	if s.listener != nil {
+		// Start may not be serving on the listener yet, in which case
+		// Shutdown does not close it
+		if cerr := s.listener.Close(); err == nil && !errors.Is(cerr, net.ErrClosed) {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = s.drainer.Wait(ctx)
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("in-flight requests did not finish in time, closing connections")
+		return s.httpServer.Close()
+	}
 	return nil
 }
 
+// errNotReady is returned to clients while the database is not open yet.
+var errNotReady = errors.New("database is not ready")
+
+// SetDdb sets the database served by the Server. Requests fail as unavailable until it is set.
+func (s *Server) SetDdb(db *ddb.Ddb) {
+	s.database.Store(db)
+}
+
+func (s *Server) db() (*ddb.Ddb, error) {
+	db := s.database.Load()
+	if db == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, errNotReady)
+	}
+	return db, nil
+}
+
 // Has will return true if the given key exists in the database.
 func (s *Server) Has(
-	_ context.Context,
+	ctx context.Context,
 	req *connect.Request[ddbv1.HasRequest],
 ) (*connect.Response[ddbv1.HasResponse], error) {
 	key := req.Msg.GetKey()
@@ -75,14 +172,19 @@ func (s *Server) Has(
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	exists := s.Ddb.Has(key)
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := db.Has(key)
 
 	return connect.NewResponse(&ddbv1.HasResponse{Key: key, Exists: exists}), nil
 }
 
 // Get will return the value for the given key.
 func (s *Server) Get(
-	_ context.Context,
+	ctx context.Context,
 	req *connect.Request[ddbv1.GetRequest],
 ) (*connect.Response[ddbv1.GetResponse], error) {
 	key := req.Msg.GetKey()
@@ -90,11 +192,19 @@ func (s *Server) Get(
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	value, err := s.Ddb.Get(key)
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := db.Get(ctx, key)
 	if err != nil {
 		if err == ddb.ErrKeyNotFound {
 			return nil, connect.NewError(connect.CodeNotFound, err)
 		}
+		if errors.Is(err, ddb.ErrCorrupted) {
+			return nil, connect.NewError(connect.CodeDataLoss, err)
+		}
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
@@ -103,7 +213,7 @@ func (s *Server) Get(
 
 // Set will set the value for the given key.
 func (s *Server) Set(
-	_ context.Context,
+	ctx context.Context,
 	req *connect.Request[ddbv1.SetRequest],
 ) (*connect.Response[ddbv1.SetResponse], error) {
 	key := req.Msg.GetKey()
@@ -112,7 +222,16 @@ func (s *Server) Set(
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	err := s.Ddb.Set(key, value)
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Msg.GetDurable() {
+		err = db.SetDurable(ctx, key, value)
+	} else {
+		err = db.Set(ctx, key, value)
+	}
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -121,7 +240,7 @@ func (s *Server) Set(
 }
 
 func (s *Server) Delete(
-	_ context.Context,
+	ctx context.Context,
 	req *connect.Request[ddbv1.DeleteRequest],
 ) (*connect.Response[ddbv1.DeleteResponse], error) {
 	key := req.Msg.GetKey()
@@ -129,11 +248,16 @@ func (s *Server) Delete(
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	if !s.Ddb.Has(key) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	if !db.Has(key) {
 		return nil, connect.NewError(connect.CodeNotFound, ddb.ErrKeyNotFound)
 	}
 
-	err := s.Ddb.Delete(key)
+	err = db.Delete(ctx, key)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeNotFound, err)
 	}
@@ -141,6 +265,101 @@ func (s *Server) Delete(
 	return connect.NewResponse(&ddbv1.DeleteResponse{}), nil
 }
 
+// GetStream will stream the value for the given key in chunks.
+func (s *Server) GetStream(
+	ctx context.Context,
+	req *connect.Request[ddbv1.GetStreamRequest],
+	stream *connect.ServerStream[ddbv1.GetStreamResponse],
+) error {
+	key := req.Msg.GetKey()
+	if err := validateKey(key); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+
+	r, size, err := db.GetReader(ctx, key)
+	if err != nil {
+		return valueError(err)
+	}
+	buf := make([]byte, streamChunkSize)
+	for sent := false; ; sent = true {
+		n, err := io.ReadFull(r, buf)
+		// the first message is sent even for empty values, for their size
+		if n > 0 || !sent {
+			res := &ddbv1.GetStreamResponse{Chunk: buf[:n]}
+			if !sent {
+				res.Size = uint64(size)
+			}
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return valueError(err)
+		}
+	}
+}
+
+// PutStream will set the value for the key of the first message to the
+// concatenation of the chunks of the stream.
+func (s *Server) PutStream(
+	ctx context.Context,
+	stream *connect.ClientStream[ddbv1.PutStreamRequest],
+) (*connect.Response[ddbv1.PutStreamResponse], error) {
+	if !stream.Receive() {
+		if err := stream.Err(); err != nil {
+			return nil, err
+		}
+		return nil, connect.NewError(connect.CodeInvalidArgument, ddb.ErrKeyEmpty)
+	}
+	first := stream.Msg()
+	key := first.GetKey()
+	if err := validateKey(key); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+
+	r := newChunkReader(stream, (*ddbv1.PutStreamRequest).GetChunk, first.GetChunk())
+	var size int64
+	if first.GetDurable() {
+		size, err = db.SetDurableFromReader(ctx, key, r)
+	} else {
+		size, err = db.SetFromReader(ctx, key, r)
+	}
+	if errors.Is(err, ddb.ErrValueTooLarge) || errors.Is(err, ddb.ErrKeyTooLarge) || errors.Is(err, ddb.ErrKeyReserved) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return connect.NewResponse(&ddbv1.PutStreamResponse{Size: uint64(size)}), nil
+}
+
+// valueError returns the error for a client failing to read a value.
+func valueError(err error) error {
+	switch {
+	case errors.Is(err, ddb.ErrKeyNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, ddb.ErrValueChanged):
+		return connect.NewError(connect.CodeAborted, err)
+	case errors.Is(err, ddb.ErrCorrupted):
+		return connect.NewError(connect.CodeDataLoss, err)
+	default:
+		return connect.NewError(connect.CodeInternal, err)
+	}
+}
+
 func validateKey(key string) error {
 	if key == "" {
 		return ddb.ErrKeyEmpty
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+)
+
+func TestStopDrainsInflightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := New(&Config{
+		Host: "127.0.0.1",
+		Port: dynaport.Get(1)[0],
+		Handlers: map[string]http.Handler{
+			"/slow": http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				<-release
+				w.WriteHeader(http.StatusOK)
+			}),
+		},
+	})
+	go func() {
+		_ = srv.Start()
+	}()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", srv.httpServer.Addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	url := fmt.Sprintf("http://%s/slow", srv.httpServer.Addr)
+	res := make(chan int)
+	go func() {
+		r, err := http.Get(url) //nolint:noctx
+		require.NoError(t, err)
+		r.Body.Close()
+		res <- r.StatusCode
+	}()
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- srv.Stop() }()
+
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before the in-flight request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-res)
+	require.NoError(t, <-stopped)
+}
+
+func TestDrainerRejectsNewRequests(t *testing.T) {
+	d := newDrainer()
+	require.True(t, d.acquire())
+
+	d.Drain()
+	require.False(t, d.acquire())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.Wait(ctx), context.DeadlineExceeded)
+
+	d.release()
+	require.NoError(t, d.Wait(context.Background()))
+}
@@ -0,0 +1,194 @@
+// Package telemetry sets up distributed tracing for the DDB components.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = ""
+	// ExporterStdout writes spans to the standard output.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP.
+	ExporterOTLP = "otlp"
+)
+
+const instrumentationName = "github.com/danielfsousa/ddb/internal/telemetry"
+
+// TracingConfig configures how spans are exported.
+type TracingConfig struct {
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP.
+	Exporter string
+	// Endpoint is the host:port of the OTLP collector.
+	Endpoint string
+	// Insecure disables TLS when talking to the OTLP collector.
+	Insecure bool
+	// NodeName is recorded as the service instance of every span.
+	NodeName string
+	// Writer is where the stdout exporter writes to, defaults to os.Stdout.
+	Writer io.Writer
+}
+
+// NewTracerProvider creates a tracer provider that exports spans as configured.
+// It returns nil when tracing is disabled.
+func NewTracerProvider(ctx context.Context, config TracingConfig) (*sdktrace.TracerProvider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch config.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		w := config.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("ddb"),
+		semconv.ServiceInstanceID(config.NodeName),
+	)
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// SetGlobal installs the tracer provider and the W3C trace context propagator
+// as the process wide defaults used by every instrumented package.
+func SetGlobal(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// NewTracingInterceptor returns an interceptor that creates a span for every
+// RPC and propagates the trace context through the request headers. The span
+// of a streaming RPC lasts until the handler returns, or until the client
+// closes the response. It can be used by both clients and handlers.
+func NewTracingInterceptor() connect.Interceptor {
+	return tracingInterceptor{}
+}
+
+type tracingInterceptor struct{}
+
+func (tracingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		ctx, span := startRPCSpan(ctx, req.Spec(), req.Header())
+		defer span.End()
+		res, err := next(ctx, req)
+		recordRPCError(span, err)
+		return res, err
+	}
+}
+
+func (tracingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		ctx, span := startRPCSpan(ctx, spec, nil)
+		conn := next(ctx, spec)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(conn.RequestHeader()))
+		return &tracingClientConn{StreamingClientConn: conn, span: span}
+	}
+}
+
+func (tracingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx, span := startRPCSpan(ctx, conn.Spec(), conn.RequestHeader())
+		defer span.End()
+		err := next(ctx, conn)
+		recordRPCError(span, err)
+		return err
+	}
+}
+
+// tracingClientConn ends the span of a streaming call once its response is closed.
+type tracingClientConn struct {
+	connect.StreamingClientConn
+	span trace.Span
+	once sync.Once
+}
+
+func (c *tracingClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		recordRPCError(c.span, err)
+	}
+	return err
+}
+
+func (c *tracingClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.once.Do(func() { c.span.End() })
+	return err
+}
+
+// startRPCSpan starts the span of a call to the procedure of the spec. Spans
+// of handlers continue the trace context extracted from the request headers,
+// and spans of unary calls inject it into them. Streaming calls inject it
+// into the headers of their connection instead.
+func startRPCSpan(ctx context.Context, spec connect.Spec, header http.Header) (context.Context, trace.Span) {
+	propagator := otel.GetTextMapPropagator()
+	kind := trace.SpanKindServer
+	if spec.IsClient {
+		kind = trace.SpanKindClient
+	} else {
+		ctx = propagator.Extract(ctx, propagation.HeaderCarrier(header))
+	}
+
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, spec.Procedure,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("connect"),
+			attribute.String("rpc.procedure", spec.Procedure),
+		),
+	)
+	if spec.IsClient && header != nil {
+		propagator.Inject(ctx, propagation.HeaderCarrier(header))
+	}
+	return ctx, span
+}
+
+func recordRPCError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.SetAttributes(attribute.String("rpc.connect.code", connect.CodeOf(err).String()))
+}
@@ -0,0 +1,150 @@
+package telemetry_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/danielfsousa/ddb"
+	ddbv1 "github.com/danielfsousa/ddb/gen/ddb/v1"
+	"github.com/danielfsousa/ddb/gen/ddb/v1/ddbv1connect"
+	"github.com/danielfsousa/ddb/internal/server"
+	"github.com/danielfsousa/ddb/internal/telemetry"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		_, _ = io.Copy(io.Discard, r.Body)
+		received.Add(1)
+	}))
+	defer collector.Close()
+
+	tp, err := telemetry.NewTracerProvider(context.Background(), telemetry.TracingConfig{
+		Exporter: telemetry.ExporterOTLP,
+		Endpoint: strings.TrimPrefix(collector.URL, "http://"),
+		Insecure: true,
+		NodeName: "node-0",
+	})
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "test")
+	span.End()
+
+	err = tp.Shutdown(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), received.Load())
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out strings.Builder
+	tp, err := telemetry.NewTracerProvider(context.Background(), telemetry.TracingConfig{
+		Exporter: telemetry.ExporterStdout,
+		Writer:   &out,
+	})
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "stdout-span")
+	span.End()
+
+	err = tp.Shutdown(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "stdout-span")
+}
+
+func TestDisabledTracing(t *testing.T) {
+	tp, err := telemetry.NewTracerProvider(context.Background(), telemetry.TracingConfig{})
+	require.NoError(t, err)
+	require.Nil(t, tp)
+
+	_, err = telemetry.NewTracerProvider(context.Background(), telemetry.TracingConfig{Exporter: "zipkin"})
+	require.Error(t, err)
+}
+
+// propagationExporter collects the spans of TestTracingPropagation. It is
+// shared by its runs, since the tracers of instrumented packages keep using
+// the first provider installed globally.
+var propagationExporter = tracetest.NewInMemoryExporter()
+
+func TestTracingPropagation(t *testing.T) {
+	exporter := propagationExporter
+	exporter.Reset()
+	prev, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		otel.SetTextMapPropagator(prevPropagator)
+		exporter.Reset()
+	})
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	telemetry.SetGlobal(tp)
+
+	db, err := ddb.Open(t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	interceptors := connect.WithInterceptors(telemetry.NewTracingInterceptor())
+	s := server.New(&server.Config{})
+	s.SetDdb(db)
+	path, handler := ddbv1connect.NewDdbServiceHandler(s, interceptors)
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := ddbv1connect.NewDdbServiceClient(http.DefaultClient, srv.URL, interceptors)
+	_, err = client.Set(context.Background(), connect.NewRequest(&ddbv1.SetRequest{Key: "foo", Value: []byte("bar")}))
+	require.NoError(t, err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range exporter.GetSpans().Snapshots() {
+		spans[s.Name()+"/"+s.SpanKind().String()] = s
+	}
+	clientSpan := spans["/ddb.v1.DdbService/Set/client"]
+	serverSpan := spans["/ddb.v1.DdbService/Set/server"]
+	setSpan := spans["bitcask.Set/internal"]
+	appendSpan := spans["store.Append/internal"]
+	for _, s := range []sdktrace.ReadOnlySpan{clientSpan, serverSpan, setSpan, appendSpan} {
+		require.NotNil(t, s)
+		require.Equal(t, clientSpan.SpanContext().TraceID(), s.SpanContext().TraceID())
+	}
+	requireChild(t, clientSpan, serverSpan)
+	requireChild(t, serverSpan, setSpan)
+	requireChild(t, setSpan, appendSpan)
+	require.True(t, serverSpan.Parent().IsRemote())
+
+	stream, err := client.GetStream(context.Background(), connect.NewRequest(&ddbv1.GetStreamRequest{Key: "foo"}))
+	require.NoError(t, err)
+	for stream.Receive() {
+	}
+	require.NoError(t, stream.Err())
+	require.NoError(t, stream.Close())
+
+	spans = map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range exporter.GetSpans().Snapshots() {
+		spans[s.Name()+"/"+s.SpanKind().String()] = s
+	}
+	clientSpan = spans["/ddb.v1.DdbService/GetStream/client"]
+	serverSpan = spans["/ddb.v1.DdbService/GetStream/server"]
+	require.NotNil(t, clientSpan)
+	require.NotNil(t, serverSpan)
+	requireChild(t, clientSpan, serverSpan)
+	require.True(t, serverSpan.Parent().IsRemote())
+}
+
+func requireChild(t *testing.T, parent, child sdktrace.ReadOnlySpan) {
+	t.Helper()
+	require.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+	require.NotEqual(t, trace.SpanID{}, child.Parent().SpanID())
+}
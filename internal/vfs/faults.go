@@ -0,0 +1,177 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// Op is an operation of a file system or of its files.
+type Op string
+
+const (
+	OpOpen     Op = "open"
+	OpRead     Op = "read"
+	OpWrite    Op = "write"
+	OpSync     Op = "sync"
+	OpTruncate Op = "truncate"
+	OpClose    Op = "close"
+	OpStat     Op = "stat"
+	OpReadDir  Op = "readdir"
+	OpRename   Op = "rename"
+	OpRemove   Op = "remove"
+	OpMkdir    Op = "mkdir"
+	OpLock     Op = "lock"
+)
+
+// ErrInjected is a convenient error for faults to fail operations with.
+var ErrInjected = errors.New("injected fault")
+
+// Fault is called before every operation of a file system returned by
+// WithFaults, with the name of the file it operates on. The operation fails
+// with the error it returns, if not nil. It may sleep to delay the operation.
+type Fault func(op Op, name string) error
+
+// WithFaults returns a file system that injects faults in the operations of
+// fsys and of the files it opens. Failed operations return a *fs.PathError
+// wrapping the error of the fault.
+func WithFaults(fsys FS, fault Fault) FS {
+	return &faultFS{fs: fsys, fault: fault}
+}
+
+type faultFS struct {
+	fs    FS
+	fault Fault
+}
+
+func (f *faultFS) inject(op Op, name string) error {
+	if err := f.fault(op, name); err != nil {
+		return &fs.PathError{Op: string(op), Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *faultFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if err := f.inject(OpOpen, name); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f}, nil
+}
+
+func (f *faultFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := f.inject(OpReadDir, name); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadDir(name)
+}
+
+func (f *faultFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.inject(OpStat, name); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(name)
+}
+
+func (f *faultFS) Rename(oldpath, newpath string) error {
+	if err := f.inject(OpRename, oldpath); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldpath, newpath)
+}
+
+func (f *faultFS) Remove(name string) error {
+	if err := f.inject(OpRemove, name); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *faultFS) RemoveAll(name string) error {
+	if err := f.inject(OpRemove, name); err != nil {
+		return err
+	}
+	return f.fs.RemoveAll(name)
+}
+
+func (f *faultFS) Mkdir(name string, perm fs.FileMode) error {
+	if err := f.inject(OpMkdir, name); err != nil {
+		return err
+	}
+	return f.fs.Mkdir(name, perm)
+}
+
+func (f *faultFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := f.inject(OpMkdir, name); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(name, perm)
+}
+
+func (f *faultFS) Lock(name string) (io.Closer, error) {
+	if err := f.inject(OpLock, name); err != nil {
+		return nil, err
+	}
+	return f.fs.Lock(name)
+}
+
+// faultFile is a file opened by a faultFS.
+type faultFile struct {
+	File
+	fs *faultFS
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.fs.inject(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.inject(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fs.inject(OpWrite, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Stat() (fs.FileInfo, error) {
+	if err := f.fs.inject(OpStat, f.Name()); err != nil {
+		return nil, err
+	}
+	return f.File.Stat()
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.inject(OpSync, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fs.inject(OpTruncate, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
+
+// Close closes the file even if it fails, like files of the os package, so
+// no file is leaked by injected faults.
+func (f *faultFile) Close() error {
+	err := f.fs.inject(OpClose, f.Name())
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package vfs
+
+import (
+	"io"
+	"os"
+)
+
+// Lock only creates the file, since locks are not supported on this platform.
+func (osFS) Lock(name string) (io.Closer, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, lockFileMode)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,542 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+	errBadFile  = errors.New("bad file descriptor")
+)
+
+// MemFS is a file system that keeps its files in memory. The root of every
+// path exists, so it can be used with the paths of the operating system.
+// Files removed while open stay readable through the handles that opened
+// them, as on unix.
+//
+// MemFS keeps track of what a disk would have stored: the data of files as
+// of their last sync, and the entries of directories as of their last sync.
+// Crash returns the files left by a crash.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+	locks map[string]bool
+
+	// roots holds the roots of paths, which are never in nodes.
+	roots map[string]*memNode
+	// durable holds the entries of each directory as of its last sync, by
+	// name. Directories that were never synced have no durable entries.
+	durable map[*memNode]map[string]*memNode
+}
+
+var _ FS = (*MemFS)(nil)
+
+// memNode is a file or directory of a MemFS. The data of files is guarded by
+// the lock of the file system.
+type memNode struct {
+	dir     bool
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+	// synced is the data of the file as of its last sync. It may share its
+	// array with data, so the bytes it holds are never modified in place.
+	synced []byte
+}
+
+// NewMemFS returns an empty in-memory file system.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		nodes:   make(map[string]*memNode),
+		locks:   make(map[string]bool),
+		roots:   make(map[string]*memNode),
+		durable: make(map[*memNode]map[string]*memNode),
+	}
+}
+
+// isRoot returns true if name is the root of a path, which always exists.
+func isRoot(name string) bool {
+	return filepath.Dir(name) == name
+}
+
+// checkParent returns an error if the parent of name is not a directory.
+func (m *MemFS) checkParent(op, name string) error {
+	parent := filepath.Dir(name)
+	if isRoot(parent) && parent != name {
+		return nil
+	}
+	n, ok := m.nodes[parent]
+	switch {
+	case !ok:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	case !n.dir:
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+func (m *MemFS) lookup(op, name string) (*memNode, error) {
+	if isRoot(name) {
+		return m.root(name), nil
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	n, err := m.lookup("open", clean)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil && n.dir && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	case err != nil:
+		if err := m.checkParent("open", clean); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: perm, modTime: time.Now()}
+		m.nodes[clean] = n
+	}
+	if flag&os.O_TRUNC != 0 && !n.dir {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{fs: m, name: name, node: n, flag: flag}, nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	n, err := m.lookup("readdir", clean)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	var entries []fs.DirEntry
+	for child, n := range m.nodes {
+		if filepath.Dir(child) == clean && child != clean {
+			entries = append(entries, fs.FileInfoToDirEntry(n.info(child)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	n, err := m.lookup("stat", clean)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(clean), nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, to := filepath.Clean(oldpath), filepath.Clean(newpath)
+	n, err := m.lookup("rename", from)
+	if err != nil {
+		return err
+	}
+	if err := m.checkParent("rename", to); err != nil {
+		return err
+	}
+	if target, ok := m.nodes[to]; ok && target.dir {
+		return &fs.PathError{Op: "rename", Path: newpath, Err: errIsDir}
+	}
+	if from == to {
+		return nil
+	}
+	moved := map[string]*memNode{to: n}
+	for name, child := range m.nodes {
+		if rel, ok := within(from, name); ok {
+			moved[filepath.Join(to, rel)] = child
+			delete(m.nodes, name)
+		}
+	}
+	delete(m.nodes, from)
+	for name, n := range moved {
+		m.nodes[name] = n
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	n, err := m.lookup("remove", clean)
+	if err != nil {
+		return err
+	}
+	if n.dir {
+		for child := range m.nodes {
+			if _, ok := within(clean, child); ok {
+				return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+			}
+		}
+	}
+	delete(m.nodes, clean)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	delete(m.nodes, clean)
+	for child := range m.nodes {
+		if _, ok := within(clean, child); ok {
+			delete(m.nodes, child)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdir(filepath.Clean(name), perm)
+}
+
+func (m *MemFS) mkdir(name string, perm fs.FileMode) error {
+	if _, err := m.lookup("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{dir: true, mode: fs.ModeDir | perm, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(name)
+	if n, err := m.lookup("mkdir", clean); err == nil {
+		if !n.dir {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+	var missing []string
+	for dir := clean; !isRoot(dir); dir = filepath.Dir(dir) {
+		if _, ok := m.nodes[dir]; ok {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := m.mkdir(missing[i], perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE, lockFileMode)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clean := filepath.Clean(name)
+	if m.locks[clean] {
+		return nil, &fs.PathError{Op: "lock", Path: name, Err: ErrLocked}
+	}
+	m.locks[clean] = true
+	return &memLock{fs: m, name: clean}, nil
+}
+
+// memLock is a lock held on a file of a MemFS.
+type memLock struct {
+	fs   *MemFS
+	name string
+	once sync.Once
+}
+
+func (l *memLock) Close() error {
+	l.once.Do(func() {
+		l.fs.mu.Lock()
+		defer l.fs.mu.Unlock()
+		delete(l.fs.locks, l.name)
+	})
+	return nil
+}
+
+// root returns the node of the root of a path, creating it the first time.
+func (m *MemFS) root(name string) *memNode {
+	n, ok := m.roots[name]
+	if !ok {
+		n = &memNode{dir: true, mode: fs.ModeDir | 0o755}
+		m.roots[name] = n
+	}
+	return n
+}
+
+// syncDir makes the current entries of a directory durable.
+func (m *MemFS) syncDir(name string, dir *memNode) {
+	entries := make(map[string]*memNode)
+	for child, n := range m.nodes {
+		if filepath.Dir(child) == name && child != name {
+			entries[filepath.Base(child)] = n
+		}
+	}
+	m.durable[dir] = entries
+}
+
+// Crash returns a file system with the files a crash of the machine would
+// leave: the durable entries of directories, holding the data files had when
+// they were last synced. If torn is not nil, it is called with the name of
+// each file that had data appended since it was last synced and the size of
+// that data, and returns how much of it reached the disk anyway. The file
+// system keeps running, and no file of the returned one is locked.
+func (m *MemFS) Crash(torn func(name string, unsynced int) int) *MemFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	crashed := NewMemFS()
+	for name, n := range m.roots {
+		m.restore(crashed, name, n, crashed.root(name), torn)
+	}
+	return crashed
+}
+
+// restore copies the durable entries of the directory from to the directory
+// to of the crashed file system, recursively.
+func (m *MemFS) restore(crashed *MemFS, name string, from, to *memNode, torn func(name string, unsynced int) int) {
+	entries, ok := m.durable[from]
+	if !ok {
+		return
+	}
+	restored := make(map[string]*memNode, len(entries))
+	for base, n := range entries {
+		child := filepath.Join(name, base)
+		r := &memNode{dir: n.dir, mode: n.mode, modTime: n.modTime}
+		if !n.dir {
+			r.data = n.synced[:len(n.synced):len(n.synced)]
+			appended := len(n.data) - len(n.synced)
+			if torn != nil && appended > 0 && bytes.Equal(n.data[:len(n.synced)], n.synced) {
+				kept := torn(child, appended)
+				r.data = append(r.data, n.data[len(n.synced):len(n.synced)+kept]...)
+			}
+			r.synced = r.data
+		}
+		crashed.nodes[child] = r
+		restored[base] = r
+		if n.dir {
+			m.restore(crashed, child, n, r, torn)
+		}
+	}
+	crashed.durable[to] = restored
+}
+
+// within returns the path of name relative to dir, if name is inside dir.
+func within(dir, name string) (string, bool) {
+	prefix := dir + string(filepath.Separator)
+	switch {
+	case dir == ".":
+		prefix = ""
+	case isRoot(dir):
+		prefix = dir
+	}
+	if !strings.HasPrefix(name, prefix) || name == dir {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memInfo{name: filepath.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime, dir: n.dir}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	dir     bool
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.dir }
+func (i *memInfo) Sys() any           { return nil }
+
+// memFile is an open file of a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	node   *memNode
+	flag   int
+	offset int64
+	closed bool
+}
+
+// check returns an error if the file is closed, or was not opened for writing
+// if write is true, or for reading otherwise.
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.node.dir && op != "sync" && op != "stat" {
+		return &fs.PathError{Op: op, Path: f.name, Err: errIsDir}
+	}
+	readOnly := f.flag&(os.O_WRONLY|os.O_RDWR) == 0
+	if write && readOnly || !write && f.flag&os.O_WRONLY != 0 {
+		return &fs.PathError{Op: op, Path: f.name, Err: errBadFile}
+	}
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if f.offset < int64(len(f.node.synced)) {
+		// the synced data is kept as it was
+		f.node.data = append([]byte(nil), f.node.data...)
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.node.dir {
+		f.fs.syncDir(filepath.Clean(f.name), f.node)
+	} else {
+		f.node.synced = f.node.data
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
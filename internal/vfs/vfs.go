@@ -0,0 +1,122 @@
+// Package vfs abstracts the file system storage engines keep their files in,
+// so they can run on the operating system's file system, in memory, or on a
+// file system that injects faults, without changing how they use files.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ErrLocked is the error returned when locking a file that is already locked.
+var ErrLocked = errors.New("file is locked")
+
+// FS is a file system. Names are paths in the syntax of the operating system,
+// as with package os.
+type FS interface {
+	// OpenFile opens the named file with the flags of os.OpenFile.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// ReadDir returns the entries of the named directory, sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Rename moves oldpath to newpath, replacing newpath if it is a file.
+	Rename(oldpath, newpath string) error
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+	// RemoveAll removes the named file or directory and everything it
+	// contains. It returns nil if name does not exist.
+	RemoveAll(name string) error
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	// Lock creates the named file if it does not exist and locks it, so no
+	// other process or caller can lock it until the returned io.Closer is
+	// closed. It fails with ErrLocked if the file is already locked.
+	Lock(name string) (io.Closer, error)
+}
+
+// File is an open file of a FS. Directories are opened read-only, to be
+// synced after their entries change.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	// Name returns the name the file was opened with.
+	Name() string
+	Stat() (fs.FileInfo, error)
+	// Sync commits the content of the file to stable storage.
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OS is the operating system's file system. Its files are *os.File.
+var OS FS = osFS{}
+
+// lockFileMode is the mode of the files created by Lock.
+const lockFileMode = 0o644
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Open opens the named file for reading.
+func Open(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// ReadFile returns the content of the named file.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := Open(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// SyncDir syncs the named directory, committing the files created, renamed
+// or removed in it.
+func SyncDir(fsys FS, dir string) error {
+	f, err := Open(fsys, dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,371 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	for name, newFS := range map[string]func() FS{
+		"os":     func() FS { return OS },
+		"memory": func() FS { return NewMemFS() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			tests := map[string]func(t *testing.T, fsys FS, dir string){
+				"write and read files back":  testWriteRead,
+				"append to files":            testAppend,
+				"truncate files":             testTruncate,
+				"list directories in order":  testReadDir,
+				"rename and remove files":    testRenameRemove,
+				"remove directories":         testRemoveAll,
+				"report missing files":       testMissing,
+				"refuse writes to read-only": testReadOnly,
+				"lock files exclusively":     testLock,
+			}
+			for scenario, fn := range tests {
+				t.Run(scenario, func(t *testing.T) {
+					fsys := newFS()
+					dir := t.TempDir()
+					require.NoError(t, fsys.MkdirAll(dir, 0o755))
+					fn(t, fsys, dir)
+				})
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, fsys FS, name, data string) {
+	t.Helper()
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+}
+
+func requireContent(t *testing.T, fsys FS, name, want string) {
+	t.Helper()
+	got, err := ReadFile(fsys, name)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+}
+
+func testWriteRead(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello world")
+	requireContent(t, fsys, name, "hello world")
+
+	f, err := Open(fsys, name)
+	require.NoError(t, err)
+	defer f.Close()
+	require.Equal(t, name, f.Name())
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(11), fi.Size())
+	require.Equal(t, "file", fi.Name())
+
+	b := make([]byte, 5)
+	_, err = f.ReadAt(b, 6)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(b))
+	n, err := f.ReadAt(b, 8)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 3, n)
+	n, err = f.ReadAt(nil, 20)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	writeFile(t, fsys, name, "bye")
+	requireContent(t, fsys, name, "bye")
+	require.NoError(t, SyncDir(fsys, dir))
+}
+
+func testAppend(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello")
+	f, err := fsys.OpenFile(name, os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	requireContent(t, fsys, name, "hello world")
+}
+
+func testTruncate(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello world")
+	f, err := fsys.OpenFile(name, os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(5))
+	_, err = f.Write([]byte("!"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	requireContent(t, fsys, name, "hello!")
+}
+
+func testReadDir(t *testing.T, fsys FS, dir string) {
+	for _, name := range []string{"b", "c", "a"} {
+		writeFile(t, fsys, filepath.Join(dir, name), name)
+	}
+	require.NoError(t, fsys.Mkdir(filepath.Join(dir, "d"), 0o755))
+	writeFile(t, fsys, filepath.Join(dir, "d", "nested"), "nested")
+
+	entries, err := fsys.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"a", "b", "c", "d"}, names)
+	require.True(t, entries[3].IsDir())
+	require.Error(t, fsys.Mkdir(filepath.Join(dir, "d"), 0o755))
+}
+
+func testRenameRemove(t *testing.T, fsys FS, dir string) {
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	writeFile(t, fsys, from, "new")
+	writeFile(t, fsys, to, "old")
+	require.NoError(t, fsys.Rename(from, to))
+	requireContent(t, fsys, to, "new")
+	_, err := fsys.Stat(from)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, fsys.Remove(to))
+	_, err = fsys.Stat(to)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func testRemoveAll(t *testing.T, fsys FS, dir string) {
+	sub := filepath.Join(dir, "sub")
+	require.NoError(t, fsys.MkdirAll(filepath.Join(sub, "nested"), 0o755))
+	writeFile(t, fsys, filepath.Join(sub, "nested", "file"), "data")
+	require.Error(t, fsys.Remove(sub))
+
+	require.NoError(t, fsys.RemoveAll(sub))
+	_, err := fsys.Stat(filepath.Join(sub, "nested", "file"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, fsys.RemoveAll(sub))
+	entries, err := fsys.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func testMissing(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "missing")
+	_, err := Open(fsys, name)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fsys.Stat(name)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, fsys.Remove(name), os.ErrNotExist)
+	_, err = fsys.ReadDir(name)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fsys.OpenFile(filepath.Join(name, "file"), os.O_WRONLY|os.O_CREATE, 0o644)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func testReadOnly(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "data")
+	f, err := Open(fsys, name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("more"))
+	require.Error(t, err)
+	require.NoError(t, f.Close())
+	require.Error(t, f.Close())
+}
+
+func testLock(t *testing.T, fsys FS, dir string) {
+	name := filepath.Join(dir, "LOCK")
+	lock, err := fsys.Lock(name)
+	require.NoError(t, err)
+	_, err = fsys.Lock(name)
+	require.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, lock.Close())
+	lock, err = fsys.Lock(name)
+	require.NoError(t, err)
+	require.NoError(t, lock.Close())
+	_, err = fsys.Stat(name)
+	require.NoError(t, err)
+}
+
+func TestFaults(t *testing.T) {
+	dir := t.TempDir()
+	var ops []Op
+	fsys := WithFaults(NewMemFS(), func(op Op, name string) error {
+		ops = append(ops, op)
+		if op == OpSync && filepath.Base(name) == "faulty" {
+			return ErrInjected
+		}
+		if op == OpRead {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	})
+	require.NoError(t, fsys.MkdirAll(dir, 0o755))
+
+	writeFile(t, fsys, filepath.Join(dir, "healthy"), "data")
+	require.Equal(t, []Op{OpMkdir, OpOpen, OpWrite, OpSync, OpClose}, ops)
+
+	f, err := fsys.OpenFile(filepath.Join(dir, "faulty"), os.O_WRONLY|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("data"))
+	require.NoError(t, err)
+	err = f.Sync()
+	require.ErrorIs(t, err, ErrInjected)
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, f.Name(), pathErr.Path)
+	require.NoError(t, f.Close())
+
+	start := time.Now()
+	requireContent(t, fsys, filepath.Join(dir, "faulty"), "data")
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestCrash(t *testing.T) {
+	tests := map[string]func(t *testing.T, fsys *MemFS, dir string){
+		"drop data that was not synced":          testCrashData,
+		"tear data appended since the last sync": testCrashTorn,
+		"keep synced data overwritten since":     testCrashOverwrite,
+		"drop entries of directories not synced": testCrashEntries,
+		"restore directories created and synced": testCrashDirs,
+		"release locks and keep the file system": testCrashLocks,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			fsys := NewMemFS()
+			dir := t.TempDir()
+			require.NoError(t, fsys.MkdirAll(dir, 0o755))
+			for d := dir; ; d = filepath.Dir(d) {
+				require.NoError(t, SyncDir(fsys, d))
+				if isRoot(d) {
+					break
+				}
+			}
+			fn(t, fsys, dir)
+		})
+	}
+}
+
+// appendFile appends data to the named file, without syncing it.
+func appendFile(t *testing.T, fsys FS, name, data string) {
+	t.Helper()
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func testCrashData(t *testing.T, fsys *MemFS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello")
+	require.NoError(t, SyncDir(fsys, dir))
+	appendFile(t, fsys, name, " world")
+
+	requireContent(t, fsys.Crash(nil), name, "hello")
+	requireContent(t, fsys, name, "hello world")
+}
+
+func testCrashTorn(t *testing.T, fsys *MemFS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello")
+	require.NoError(t, SyncDir(fsys, dir))
+	appendFile(t, fsys, name, " world")
+
+	crashed := fsys.Crash(func(torn string, unsynced int) int {
+		require.Equal(t, name, torn)
+		require.Equal(t, 6, unsynced)
+		return 3
+	})
+	requireContent(t, crashed, name, "hello wo")
+}
+
+func testCrashOverwrite(t *testing.T, fsys *MemFS, dir string) {
+	name := filepath.Join(dir, "file")
+	writeFile(t, fsys, name, "hello")
+	require.NoError(t, SyncDir(fsys, dir))
+
+	f, err := fsys.OpenFile(name, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("jello world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	requireContent(t, fsys.Crash(func(string, int) int { return 6 }), name, "hello")
+
+	f, err = fsys.OpenFile(name, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(2))
+	require.NoError(t, f.Close())
+	requireContent(t, fsys.Crash(nil), name, "hello")
+	requireContent(t, fsys, name, "je")
+}
+
+func testCrashEntries(t *testing.T, fsys *MemFS, dir string) {
+	kept, created := filepath.Join(dir, "kept"), filepath.Join(dir, "created")
+	writeFile(t, fsys, kept, "kept")
+	require.NoError(t, SyncDir(fsys, dir))
+	writeFile(t, fsys, created, "created")
+
+	crashed := fsys.Crash(nil)
+	requireContent(t, crashed, kept, "kept")
+	_, err := crashed.Stat(created)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	renamed := filepath.Join(dir, "renamed")
+	require.NoError(t, fsys.Rename(kept, renamed))
+	require.NoError(t, fsys.Remove(created))
+	crashed = fsys.Crash(nil)
+	requireContent(t, crashed, kept, "kept")
+	_, err = crashed.Stat(renamed)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, SyncDir(fsys, dir))
+	crashed = fsys.Crash(nil)
+	requireContent(t, crashed, renamed, "kept")
+	for _, name := range []string{kept, created} {
+		_, err = crashed.Stat(name)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func testCrashDirs(t *testing.T, fsys *MemFS, dir string) {
+	sub := filepath.Join(dir, "sub")
+	require.NoError(t, fsys.Mkdir(sub, 0o755))
+	name := filepath.Join(sub, "file")
+	writeFile(t, fsys, name, "data")
+	require.NoError(t, SyncDir(fsys, sub))
+
+	// the directory itself is not in its parent yet
+	_, err := fsys.Crash(nil).Stat(sub)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, SyncDir(fsys, dir))
+	crashed := fsys.Crash(nil)
+	requireContent(t, crashed, name, "data")
+	// the crashed file system tracks syncs too
+	appendFile(t, crashed, name, " lost")
+	requireContent(t, crashed.Crash(nil), name, "data")
+}
+
+func testCrashLocks(t *testing.T, fsys *MemFS, dir string) {
+	name := filepath.Join(dir, "LOCK")
+	lock, err := fsys.Lock(name)
+	require.NoError(t, err)
+	defer lock.Close()
+	require.NoError(t, SyncDir(fsys, dir))
+
+	crashed := fsys.Crash(nil)
+	crashedLock, err := crashed.Lock(name)
+	require.NoError(t, err)
+	require.NoError(t, crashedLock.Close())
+	_, err = fsys.Lock(name)
+	require.ErrorIs(t, err, ErrLocked)
+}
@@ -1,29 +1,63 @@
 package ddb
 
-import "github.com/danielfsousa/ddb/internal/config"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/danielfsousa/ddb/internal/config"
+)
 
 // Option is a function that takes a config and modifies it.
 type Option func(*config.Config) error
 
-func withConfig(src *config.Config) Option {
-	return func(dst *config.Config) error {
-		*dst = *src
+// WithBackend sets the storage engine records are stored with. A database
+// must be opened with the backend it was created with, otherwise Open
+// returns ErrWrongBackend.
+func WithBackend(b Backend) Option {
+	return func(cfg *config.Config) error {
+		if _, err := ParseBackend(string(b)); err != nil {
+			return err
+		}
+		cfg.Backend = b
 		return nil
 	}
 }
 
 // WithMaxKeySize sets the maximum key size.
 func WithMaxKeySize(size uint64) Option {
-	return withConfig(&config.Config{
-		MaxKeySize: size,
-	})
+	return func(cfg *config.Config) error {
+		cfg.MaxKeySize = size
+		return nil
+	}
 }
 
 // WithMaxValueSize sets the maximum value size.
 func WithMaxValueSize(size uint64) Option {
-	return withConfig(&config.Config{
-		MaxValueSize: size,
-	})
+	return func(cfg *config.Config) error {
+		cfg.MaxValueSize = size
+		return nil
+	}
+}
+
+// WithChunkSize sets the size of the chunks values are split into. Values
+// larger than it are stored as separate chunk records and reassembled when read.
+func WithChunkSize(size uint64) Option {
+	return func(cfg *config.Config) error {
+		if size == 0 {
+			return errors.New("chunk size must be positive")
+		}
+		cfg.ChunkSize = size
+		return nil
+	}
+}
+
+// WithCacheSize caches the values of recently read keys in memory, up to
+// the given size in bytes. Large values split into chunks are not cached.
+func WithCacheSize(size uint64) Option {
+	return func(cfg *config.Config) error {
+		cfg.CacheSize = size
+		return nil
+	}
 }
 
 // WithMaxSegmentDataSize sets the maximum datafile size option
@@ -33,3 +67,76 @@ func WithMaxSegmentDataSize(size uint64) Option {
 		return nil
 	}
 }
+
+// WithDurability sets when writes are synced to disk. See SyncNever,
+// SyncEveryWrite and SyncInterval.
+func WithDurability(d Durability) Option {
+	return func(cfg *config.Config) error {
+		if d.Mode == config.SyncInterval && d.Interval <= 0 {
+			return fmt.Errorf("sync interval must be positive, got %s", d.Interval)
+		}
+		cfg.Durability = d
+		return nil
+	}
+}
+
+// WithRepair makes Open truncate corrupted segments instead of failing. The
+// tail of the active segment left incomplete by a crash is always truncated.
+func WithRepair() Option {
+	return func(cfg *config.Config) error {
+		cfg.Repair = true
+		return nil
+	}
+}
+
+// WithKeyHashing keeps only a 64 bits hash of each key in memory instead of
+// the key itself, which shrinks the index of databases with long keys at the
+// cost of a disk read to confirm each key found.
+func WithKeyHashing() Option {
+	return func(cfg *config.Config) error {
+		cfg.HashKeys = true
+		return nil
+	}
+}
+
+// WithOrderedKeys keeps the keys sorted in memory, so Scan pages through
+// them without collecting and sorting all keys first, at the cost of memory
+// for a copy of every key.
+func WithOrderedKeys() Option {
+	return func(cfg *config.Config) error {
+		cfg.OrderedKeys = true
+		return nil
+	}
+}
+
+// WithCompression sets the codec records are compressed with. Records
+// written with other codecs stay readable, and are compressed with this one
+// when merged.
+func WithCompression(c Compression) Option {
+	return func(cfg *config.Config) error {
+		cfg.Compression.Default = c
+		return nil
+	}
+}
+
+// WithNamespaceCompression sets the codec of the records whose key starts
+// with prefix, overriding WithCompression. The longest matching prefix wins.
+func WithNamespaceCompression(prefix string, c Compression) Option {
+	return func(cfg *config.Config) error {
+		if cfg.Compression.Namespaces == nil {
+			cfg.Compression.Namespaces = make(map[string]Compression)
+		}
+		cfg.Compression.Namespaces[prefix] = c
+		return nil
+	}
+}
+
+// WithEncryption encrypts segments and hint files with the current key of the
+// provider. Segments encrypted with older keys stay readable as long as the
+// provider has their keys, and are encrypted with the current key when merged.
+func WithEncryption(p KeyProvider) Option {
+	return func(cfg *config.Config) error {
+		cfg.Keys = p
+		return nil
+	}
+}
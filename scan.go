@@ -0,0 +1,123 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/danielfsousa/ddb/internal/backend"
+)
+
+// errScanDone stops a scan once it returned as many keys as asked.
+var errScanDone = errors.New("scan done")
+
+// ScanOptions selects the keys returned by Scan.
+type ScanOptions struct {
+	// Prefix only returns the keys starting with it.
+	Prefix string
+	// Start is the lowest key returned.
+	Start string
+	// End is the key the scan stops before. It is no bound if empty.
+	End string
+	// Reverse returns the keys in descending order.
+	Reverse bool
+	// Limit is the maximum number of keys returned, all of them if zero.
+	Limit int
+}
+
+// Scan returns the keys of the database in order, deleted keys excluded.
+// Keys are paged through by scanning again from the last key returned
+// followed by a zero byte, or up to the last key returned in reverse.
+//
+// The database only keeps its keys sorted with WithOrderedKeys. Otherwise,
+// all keys are collected and sorted on every scan.
+func (d *Ddb) Scan(ctx context.Context, opts ScanOptions) ([]string, error) {
+	// reserved keys sort before all others
+	if start := prefixEnd(reservedPrefix); opts.Start < start {
+		opts.Start = start
+	}
+	var keys []string
+	err := d.rangeKeys(opts.Prefix, opts.Start, opts.End, opts.Reverse, func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if meta, exists := d.backend.GetMetadata(key); !exists || meta.DeletedAt != nil {
+			return nil
+		}
+		keys = append(keys, key)
+		if len(keys) == opts.Limit {
+			return errScanDone
+		}
+		return nil
+	})
+	if errors.Is(err, errScanDone) {
+		err = nil
+	}
+	return keys, err
+}
+
+// FirstKey returns the lowest key of the database, or ErrKeyNotFound if it is empty.
+func (d *Ddb) FirstKey(ctx context.Context) (string, error) {
+	return d.edgeKey(ctx, false)
+}
+
+// LastKey returns the highest key of the database, or ErrKeyNotFound if it is empty.
+func (d *Ddb) LastKey(ctx context.Context) (string, error) {
+	return d.edgeKey(ctx, true)
+}
+
+func (d *Ddb) edgeKey(ctx context.Context, last bool) (string, error) {
+	keys, err := d.Scan(ctx, ScanOptions{Reverse: last, Limit: 1})
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", ErrKeyNotFound
+	}
+	return keys[0], nil
+}
+
+// rangeKeys calls fn for the keys of the backend starting with prefix, from
+// start, included, to end, excluded, in order until it returns an error.
+// Deleted keys are included.
+func (d *Ddb) rangeKeys(prefix, start, end string, reverse bool, fn func(key string) error) error {
+	if start < prefix {
+		start = prefix
+	}
+	if upper := prefixEnd(prefix); upper != "" && (end == "" || upper < end) {
+		end = upper
+	}
+	if end != "" && start >= end {
+		return nil
+	}
+	if ranger, ok := d.backend.(backend.Ranger); ok {
+		return ranger.RangeKeys(start, end, reverse, fn)
+	}
+
+	keys := d.backend.Keys()
+	for i := range keys {
+		key := keys[i]
+		if reverse {
+			key = keys[len(keys)-1-i]
+		}
+		if key < start || (end != "" && key >= end) {
+			continue
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the lowest key greater than all the keys starting with
+// prefix, or an empty string if there is none.
+func prefixEnd(prefix string) string {
+	prefix = strings.TrimRight(prefix, "\xff")
+	if prefix == "" {
+		return ""
+	}
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return string(end)
+}
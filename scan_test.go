@@ -0,0 +1,148 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	tests := map[string]func(t *testing.T, db *Ddb){
+		"returns live keys in order":        testScanOrder,
+		"selects keys by prefix and bounds": testScanBounds,
+		"pages through keys both ways":      testScanPages,
+		"finds the first and last keys":     testScanEdges,
+		"skips the chunks of large values":  testScanChunks,
+	}
+	for scenario, fn := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			for name, options := range map[string][]Option{
+				"sorted":  {WithChunkSize(100)},
+				"ordered": {WithChunkSize(100), WithOrderedKeys()},
+				"lsm":     {WithChunkSize(100), WithBackend(BackendLSM)},
+				"memory":  {WithChunkSize(100), WithBackend(BackendMemory)},
+			} {
+				t.Run(name, func(t *testing.T) {
+					db, err := Open(t.TempDir(), options...)
+					require.NoError(t, err)
+					defer db.Close()
+					fn(t, db)
+				})
+			}
+		})
+	}
+}
+
+// setKeys sets the keys user-00 to user-(n-1) and item-00 to item-(n-1).
+func setKeys(t *testing.T, db *Ddb, n int) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Set(ctx, fmt.Sprintf("user-%02d", i), []byte("v")))
+		require.NoError(t, db.Set(ctx, fmt.Sprintf("item-%02d", i), []byte("v")))
+	}
+}
+
+func testScanOrder(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	setKeys(t, db, 3)
+	require.NoError(t, db.Delete(ctx, "user-01"))
+
+	keys, err := db.Scan(ctx, ScanOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"item-00", "item-01", "item-02", "user-00", "user-02"}, keys)
+
+	keys, err = db.Scan(ctx, ScanOptions{Reverse: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-02", "user-00", "item-02", "item-01", "item-00"}, keys)
+}
+
+func testScanBounds(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	setKeys(t, db, 10)
+
+	keys, err := db.Scan(ctx, ScanOptions{Prefix: "user-0", Start: "user-03", End: "user-06"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-03", "user-04", "user-05"}, keys)
+
+	keys, err = db.Scan(ctx, ScanOptions{Prefix: "item-", Start: "a", End: "z", Reverse: true, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"item-09", "item-08"}, keys)
+
+	keys, err = db.Scan(ctx, ScanOptions{Prefix: "user-", End: "item-05"})
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	keys, err = db.Scan(ctx, ScanOptions{Prefix: "user-\xff"})
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func testScanPages(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	setKeys(t, db, 10)
+
+	var forward []string
+	opts := ScanOptions{Prefix: "user-", Limit: 3}
+	for {
+		page, err := db.Scan(ctx, opts)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(page), 3)
+		forward = append(forward, page...)
+		opts.Start = page[len(page)-1] + "\x00"
+	}
+	require.Len(t, forward, 10)
+	require.Equal(t, "user-00", forward[0])
+	require.Equal(t, "user-09", forward[9])
+
+	var backward []string
+	opts = ScanOptions{Prefix: "user-", Limit: 4, Reverse: true}
+	for {
+		page, err := db.Scan(ctx, opts)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		backward = append(backward, page...)
+		opts.End = page[len(page)-1]
+	}
+	require.Len(t, backward, 10)
+	for i := range forward {
+		require.Equal(t, forward[i], backward[len(backward)-1-i])
+	}
+}
+
+func testScanEdges(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	_, err := db.FirstKey(ctx)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = db.LastKey(ctx)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	setKeys(t, db, 5)
+	require.NoError(t, db.Delete(ctx, "user-04"))
+	first, err := db.FirstKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "item-00", first)
+	last, err := db.LastKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "user-03", last)
+}
+
+func testScanChunks(t *testing.T, db *Ddb) {
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, "large", blob(500, 1)))
+	require.NoError(t, db.Set(ctx, "small", []byte("v")))
+
+	keys, err := db.Scan(ctx, ScanOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"large", "small"}, keys)
+	first, err := db.FirstKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "large", first)
+}